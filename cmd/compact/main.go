@@ -0,0 +1,120 @@
+package main
+
+// apply a retention policy and rewrite the database into a new file to reclaim
+// the space of deleted records, bolt never shrinks its file on its own. the
+// segment store frees space as soon as a retention removes whole segments.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+const compactBatchSize = 50000
+
+func main() {
+	var db_path string
+	var output string
+	var retentionValue string
+	var replace bool
+
+	flag.StringVar(&db_path, "db", "orderbooks.db", "database file, must not be open by a recorder")
+	flag.StringVar(&output, "o", "", "compacted database file, defaults to <db>.compact")
+	flag.StringVar(&retentionValue, "retention", "", "delete records older than this first, per bucket with 168h,Binance-BTC-USDT=24h")
+	flag.BoolVar(&replace, "replace", false, "replace the database with the compacted file")
+	flag.Parse()
+
+	if output == "" {
+		output = db_path + ".compact"
+	}
+
+	retention, err := util.ParseRetention(retentionValue)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	src, err := bolt.Open(db_path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: !retention.Enabled()})
+	if err != nil {
+		log.Fatalln("open", db_path, err, "(is a recorder still running?)")
+	}
+
+	if retention.Enabled() {
+		if err := store.CheckKeys(src); err != nil {
+			log.Fatalln(err)
+		}
+		n, err := retention.Prune(store.NewBolt(src), time.Now())
+		if err != nil {
+			log.Fatalln("Retention Error", err)
+		}
+		fmt.Println("retention removed", n, "records")
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		log.Fatalln(output, "already exists")
+	}
+	dst, err := bolt.Open(output, 0600, nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := compact(dst, src); err != nil {
+		os.Remove(output)
+		log.Fatalln("compact", err)
+	}
+	src.Close()
+	dst.Close()
+
+	before, _ := os.Stat(db_path)
+	after, _ := os.Stat(output)
+	fmt.Printf("compacted %s %d bytes -> %s %d bytes\n", db_path, before.Size(), output, after.Size())
+
+	if replace {
+		if err := os.Rename(output, db_path); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println("replaced", db_path)
+	}
+}
+
+// compact copies all buckets of src into dst, committing every compactBatchSize records.
+func compact(dst, src *bolt.DB) error {
+	return src.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			key, value := c.First()
+			count := 0
+			for {
+				err := dst.Update(func(dtx *bolt.Tx) error {
+					db, err := dtx.CreateBucketIfNotExists(name)
+					if err != nil {
+						return err
+					}
+					// keys are appended in order, pack pages full
+					db.FillPercent = 1.0
+					for n := 0; key != nil && n < compactBatchSize; n++ {
+						if err := db.Put(key, value); err != nil {
+							return err
+						}
+						key, value = c.Next()
+						count += 1
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if key == nil {
+					break
+				}
+			}
+			fmt.Println(string(name), count, "records")
+			return nil
+		})
+	})
+}
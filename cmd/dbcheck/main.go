@@ -0,0 +1,260 @@
+package main
+
+// inspect a recording database: packet counts, time ranges, gaps, sequence
+// discontinuities, undecodable records and crossed books per bucket
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+type Gap struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Seconds float64   `json:"seconds"`
+}
+
+type SequenceGap struct {
+	Time     time.Time `json:"time"`
+	Expected uint64    `json:"expected"`
+	First    uint64    `json:"first"`
+	Last     uint64    `json:"last"`
+}
+
+type BadRecord struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+type CrossedBook struct {
+	Time    time.Time `json:"time"`
+	BestBid float64   `json:"best_bid"`
+	BestAsk float64   `json:"best_ask"`
+}
+
+type BucketReport struct {
+	Name                string        `json:"name"`
+	Checkpoint          string        `json:"checkpoint,omitempty"`
+	Records             int           `json:"records"`
+	Sync                int           `json:"sync"`
+	Diff                int           `json:"diff"`
+	Trade               int           `json:"trade"`
+	GuessedSide         int           `json:"trade_guessed_side"`
+	Unknown             int           `json:"unknown"`
+	First               time.Time     `json:"first"`
+	Last                time.Time     `json:"last"`
+	Gaps                []Gap         `json:"gaps"`
+	GapCount            int           `json:"gaps_total"`
+	SequenceGaps        []SequenceGap `json:"sequence_gaps"`
+	SequenceGapCount    int           `json:"sequence_gaps_total"`
+	Undecodable         []BadRecord   `json:"undecodable"`
+	UndecodableCount    int           `json:"undecodable_total"`
+	CrossedBooks        []CrossedBook `json:"crossed_books"`
+	CrossedBookCount    int           `json:"crossed_books_total"`
+	DiffBeforeSyncCount int           `json:"diff_before_sync_total"`
+}
+
+type Report struct {
+	Path    string          `json:"path"`
+	Gap     float64         `json:"gap_seconds"`
+	Buckets []*BucketReport `json:"buckets"`
+}
+
+func (r *BucketReport) OK() bool {
+	return r.GapCount == 0 && r.SequenceGapCount == 0 && r.UndecodableCount == 0 && r.CrossedBookCount == 0
+}
+
+func main() {
+	var db_path string
+	var storeBackend string
+	var bucket string
+	var gap time.Duration
+	var limit int
+	var jsonOutput bool
+
+	flag.StringVar(&db_path, "db", "orderbooks.db", "database file")
+	flag.StringVar(&storeBackend, "store", "bolt", "storage backend ("+strings.Join(store.Backends, ", ")+"), segment uses -db as directory")
+	flag.StringVar(&bucket, "product", "", "only check this bucket, for example Binance-BTC-USDT")
+	flag.DurationVar(&gap, "gap", 10*time.Second, "report gaps between records longer than this")
+	flag.IntVar(&limit, "limit", 100, "max listed issues per kind and bucket")
+	flag.BoolVar(&jsonOutput, "json", false, "print a JSON report")
+	flag.Parse()
+
+	db, err := util.OpenStore(storeBackend, db_path, true)
+	if err != nil {
+		log.Fatalln("OpenStore Error", err)
+	}
+	defer db.Close()
+
+	buckets := []string{bucket}
+	if bucket == "" {
+		if buckets, err = util.ListBuckets(db); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	report := &Report{Path: db_path, Gap: gap.Seconds()}
+	for _, name := range buckets {
+		r, err := checkBucket(db, name, gap, limit)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		r.Checkpoint = util.CheckpointPolicyOf(db, name)
+		report.Buckets = append(report.Buckets, r)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		printReport(report)
+	}
+
+	for _, r := range report.Buckets {
+		if !r.OK() {
+			os.Exit(1)
+		}
+	}
+}
+
+func checkBucket(db store.Store, name string, gap time.Duration, limit int) (*BucketReport, error) {
+	r := &BucketReport{
+		Name:         name,
+		Gaps:         []Gap{},
+		SequenceGaps: []SequenceGap{},
+		Undecodable:  []BadRecord{},
+		CrossedBooks: []CrossedBook{},
+	}
+	book := orderbook.New(name)
+	var synced bool
+	var expected uint64
+	var last time.Time
+
+	c, err := db.Cursor(name)
+	if err != nil {
+		return r, fmt.Errorf("bucket %s %s", name, err)
+	}
+	defer c.Close()
+
+	for event, ok := c.First(); ok; event, ok = c.Next() {
+		r.Records += 1
+		t, buf := event.Time, event.Data
+
+		if r.First.IsZero() {
+			r.First = t
+		}
+		if !last.IsZero() && t.Sub(last) > gap {
+			r.GapCount += 1
+			if len(r.Gaps) < limit {
+				r.Gaps = append(r.Gaps, Gap{From: last, To: t, Seconds: t.Sub(last).Seconds()})
+			}
+		}
+		last = t
+		r.Last = t
+
+		pkt, err := orderbook.DecodePacket(buf)
+		if err != nil {
+			r.UndecodableCount += 1
+			if len(r.Undecodable) < limit {
+				r.Undecodable = append(r.Undecodable, BadRecord{Key: fmt.Sprint(t.UnixNano()), Error: err.Error()})
+			}
+			continue
+		}
+
+		switch pkt.Type {
+		case orderbook.SyncPacket:
+			r.Sync += 1
+			synced = true
+			expected = pkt.Sequence + 1
+		case orderbook.DiffPacket:
+			r.Diff += 1
+			if !synced {
+				r.DiffBeforeSyncCount += 1
+				continue
+			}
+			if pkt.First != expected {
+				r.SequenceGapCount += 1
+				if len(r.SequenceGaps) < limit {
+					r.SequenceGaps = append(r.SequenceGaps, SequenceGap{Time: t, Expected: expected, First: pkt.First, Last: pkt.Last})
+				}
+			}
+			expected = pkt.Last + 1
+		case orderbook.TradePacket:
+			r.Trade += 1
+			if !pkt.Aggressor {
+				r.GuessedSide += 1
+			}
+			continue
+		default:
+			r.Unknown += 1
+			continue
+		}
+
+		// gaps are reported above, keep UpdateSync from printing its resync notice
+		book.Synced = false
+		book.Apply(t, pkt)
+		if r.Records%1000 == 0 {
+			book.ResetStats()
+		}
+
+		if bid, ask := book.BestBid(), book.BestAsk(); bid != nil && ask != nil && bid.Price >= ask.Price {
+			r.CrossedBookCount += 1
+			if len(r.CrossedBooks) < limit {
+				r.CrossedBooks = append(r.CrossedBooks, CrossedBook{Time: t, BestBid: book.PriceScale.Float(bid.Price), BestAsk: book.PriceScale.Float(ask.Price)})
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func printReport(report *Report) {
+	format := "2006-01-02 15:04:05.000"
+	for _, r := range report.Buckets {
+		status := "OK"
+		if !r.OK() {
+			status = "PROBLEMS"
+		}
+		fmt.Printf("%s %s\n", r.Name, status)
+		if r.Checkpoint != "" {
+			fmt.Printf("  checkpoint policy %s\n", r.Checkpoint)
+		}
+		fmt.Printf("  records %d (sync %d, diff %d, trade %d, unknown %d)\n", r.Records, r.Sync, r.Diff, r.Trade, r.Unknown)
+		if r.GuessedSide > 0 {
+			fmt.Printf("  %d trades without exchange side, guessed from the book\n", r.GuessedSide)
+		}
+		if r.Records > 0 {
+			fmt.Printf("  range %s - %s (%s)\n", r.First.Format(format), r.Last.Format(format), r.Last.Sub(r.First))
+		}
+		for _, gap := range r.Gaps {
+			fmt.Printf("  gap %s - %s (%.1fs)\n", gap.From.Format(format), gap.To.Format(format), gap.Seconds)
+		}
+		for _, gap := range r.SequenceGaps {
+			fmt.Printf("  sequence gap %s expected %d got %d-%d\n", gap.Time.Format(format), gap.Expected, gap.First, gap.Last)
+		}
+		for _, bad := range r.Undecodable {
+			fmt.Printf("  undecodable %s: %s\n", bad.Key, bad.Error)
+		}
+		for _, crossed := range r.CrossedBooks {
+			fmt.Printf("  crossed book %s bid %v >= ask %v\n", crossed.Time.Format(format), crossed.BestBid, crossed.BestAsk)
+		}
+		if r.GapCount > len(r.Gaps) || r.SequenceGapCount > len(r.SequenceGaps) || r.UndecodableCount > len(r.Undecodable) || r.CrossedBookCount > len(r.CrossedBooks) {
+			fmt.Printf("  totals: gaps %d, sequence gaps %d, undecodable %d, crossed books %d\n", r.GapCount, r.SequenceGapCount, r.UndecodableCount, r.CrossedBookCount)
+		}
+		if r.DiffBeforeSyncCount > 0 {
+			fmt.Printf("  %d diffs before the first sync\n", r.DiffBeforeSyncCount)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	exchange "github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// record is a packet of the test bucket at a second after the start.
+type record struct {
+	second int
+	data   []byte
+}
+
+func TestCheckBucket(t *testing.T) {
+	book := exchange.New("BTC-USDT")
+	book.PriceScale, book.SizeScale = 2, 8
+	book.UpdateBidLevel(time.Time{}, 10000, 100000000)
+	book.UpdateAskLevel(time.Time{}, 10100, 100000000)
+	sync := exchange.PackSync(book)
+	book.ResetDiff()
+	diff := func(first, last uint64) []byte { return exchange.PackDiff(book, first, last) }
+	book.AddTakerTrade(time.Time{}, exchange.TakerSide(true), 10100, 1000000, 1, time.Time{})
+	trade := exchange.PackTrade(book, book.Trades[0])
+
+	start := time.Unix(1550671200, 0)
+	cases := []struct {
+		name         string
+		records      []record
+		ok           bool
+		gaps         int
+		listed       int
+		sequenceGaps int
+		undecodable  int
+	}{
+		{"clean", []record{{0, sync}, {1, diff(1, 1)}, {2, trade}, {12, diff(2, 3)}}, true, 0, 0, 0, 0},
+		// gaps longer than 10s, only the first is listed
+		{"gaps", []record{{0, sync}, {11, diff(1, 1)}, {12, diff(2, 2)}, {30, diff(3, 3)}}, false, 2, 1, 0, 0},
+		{"sequence gap", []record{{0, sync}, {1, diff(1, 1)}, {2, diff(3, 3)}, {3, diff(4, 4)}}, false, 0, 0, 1, 0},
+		{"undecodable", []record{{0, sync}, {1, diff(1, 1)[:20]}, {2, diff(2, 2)}}, false, 0, 0, 1, 1},
+		// a new sync restarts the sequence
+		{"resync", []record{{0, sync}, {1, diff(1, 1)}, {2, sync}, {3, diff(1, 1)}}, true, 0, 0, 0, 0},
+	}
+	for _, c := range cases {
+		st := store.NewMemory()
+		for _, r := range c.records {
+			if err := st.Append("Binance-BTC-USDT", store.Event{Time: start.Add(time.Duration(r.second) * time.Second), Data: r.data}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r, err := checkBucket(st, "Binance-BTC-USDT", 10*time.Second, 1)
+		st.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if r.OK() != c.ok {
+			t.Fatalf("%s: ok %t, want %t, report %+v", c.name, r.OK(), c.ok, r)
+		}
+		if r.GapCount != c.gaps || len(r.Gaps) != c.listed || r.SequenceGapCount != c.sequenceGaps || r.UndecodableCount != c.undecodable {
+			t.Fatalf("%s: %d gaps (%d listed), %d sequence gaps, %d undecodable, want %d (%d), %d, %d",
+				c.name, r.GapCount, len(r.Gaps), r.SequenceGapCount, r.UndecodableCount, c.gaps, c.listed, c.sequenceGaps, c.undecodable)
+		}
+		if r.Records != len(c.records) || !r.First.Equal(start) {
+			t.Fatalf("%s: %d records from %s", c.name, r.Records, r.First)
+		}
+	}
+}
+
+func TestCheckBucketGap(t *testing.T) {
+	st := store.NewMemory()
+	defer st.Close()
+	book := exchange.New("BTC-USDT")
+	book.PriceScale, book.SizeScale = 2, 8
+	start := time.Unix(1550671200, 0)
+	gapStart, gapEnd := start.Add(time.Second), start.Add(31500*time.Millisecond)
+	events := []store.Event{
+		{Time: start, Data: exchange.PackSync(book)},
+		{Time: gapStart, Data: exchange.PackDiff(book, 1, 1)},
+		{Time: gapEnd, Data: exchange.PackDiff(book, 2, 2)},
+	}
+	if err := st.Append("Binance-BTC-USDT", events...); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := checkBucket(st, "Binance-BTC-USDT", 30*time.Second, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Gap{From: gapStart, To: gapEnd, Seconds: 30.5}
+	if r.OK() || r.GapCount != 1 || len(r.Gaps) != 1 || !r.Gaps[0].From.Equal(want.From) || !r.Gaps[0].To.Equal(want.To) || r.Gaps[0].Seconds != want.Seconds {
+		t.Fatalf("gaps %+v total %d, want %+v", r.Gaps, r.GapCount, want)
+	}
+
+	// a gap of exactly -gap is no gap
+	if r, err = checkBucket(st, "Binance-BTC-USDT", 30500*time.Millisecond, 100); err != nil || !r.OK() {
+		t.Fatalf("report %+v %v, want ok", r, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+// report the stored size of each bucket and how well its packets compress
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+type TypeStats struct {
+	Records    int
+	Compressed int
+	Stored     int64
+	Raw        int64
+}
+
+func (s *TypeStats) Add(o *TypeStats) {
+	s.Records += o.Records
+	s.Compressed += o.Compressed
+	s.Stored += o.Stored
+	s.Raw += o.Raw
+}
+
+func (s *TypeStats) Ratio() float64 {
+	if s.Stored == 0 {
+		return 0
+	}
+	return float64(s.Raw) / float64(s.Stored)
+}
+
+type BucketStats struct {
+	Name        string
+	Types       map[uint8]*TypeStats
+	Total       TypeStats
+	Undecodable int
+	Decode      time.Duration
+}
+
+var typeNames = map[uint8]string{
+	orderbook.SyncPacket:  "sync",
+	orderbook.DiffPacket:  "diff",
+	orderbook.TradePacket: "trade",
+}
+
+func main() {
+	var db_path string
+	var storeBackend string
+	var bucket string
+
+	flag.StringVar(&db_path, "db", "orderbooks.db", "database file")
+	flag.StringVar(&storeBackend, "store", "bolt", "storage backend ("+strings.Join(store.Backends, ", ")+"), segment uses -db as directory")
+	flag.StringVar(&bucket, "product", "", "only this bucket, for example Binance-BTC-USDT")
+	flag.Parse()
+
+	db, err := util.OpenStore(storeBackend, db_path, true)
+	if err != nil {
+		log.Fatalln("OpenStore Error", err)
+	}
+	defer db.Close()
+
+	buckets := []string{bucket}
+	if bucket == "" {
+		if buckets, err = util.ListBuckets(db); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var total TypeStats
+	for _, name := range buckets {
+		s, err := bucketStats(db, name)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printStats(s)
+		total.Add(&s.Total)
+	}
+	if len(buckets) > 1 {
+		fmt.Printf("total %s\n", formatStats(&total))
+	}
+}
+
+func bucketStats(db store.Store, name string) (*BucketStats, error) {
+	s := &BucketStats{Name: name, Types: map[uint8]*TypeStats{}}
+
+	c, err := db.Cursor(name)
+	if err != nil {
+		return nil, fmt.Errorf("bucket %s %s", name, err)
+	}
+	defer c.Close()
+
+	for event, ok := c.First(); ok; event, ok = c.Next() {
+		start := time.Now()
+		_, err := orderbook.DecodePacket(event.Data)
+		s.Decode += time.Since(start)
+		if err != nil {
+			s.Undecodable += 1
+			continue
+		}
+		version, packetType, payload, _ := orderbook.UnpackPacket(event.Data)
+
+		t, found := s.Types[packetType]
+		if !found {
+			t = &TypeStats{}
+			s.Types[packetType] = t
+		}
+		t.Records += 1
+		t.Stored += int64(len(event.Data))
+		if orderbook.CompressedVersion(version) {
+			t.Compressed += 1
+			t.Raw += int64(orderbook.PacketOverhead + len(payload))
+		} else {
+			t.Raw += int64(len(event.Data))
+		}
+	}
+
+	for _, t := range s.Types {
+		s.Total.Add(t)
+	}
+	return s, nil
+}
+
+func formatStats(s *TypeStats) string {
+	return fmt.Sprintf("%d records (%d compressed) %s stored, %s raw, ratio %.2f",
+		s.Records, s.Compressed, formatBytes(s.Stored), formatBytes(s.Raw), s.Ratio())
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func printStats(s *BucketStats) {
+	fmt.Printf("%s %s\n", s.Name, formatStats(&s.Total))
+	for _, packetType := range []uint8{orderbook.SyncPacket, orderbook.DiffPacket, orderbook.TradePacket} {
+		if t, ok := s.Types[packetType]; ok {
+			fmt.Printf("  %-5s %s\n", typeNames[packetType], formatStats(t))
+		}
+	}
+	if s.Undecodable > 0 {
+		fmt.Printf("  %d undecodable records\n", s.Undecodable)
+	}
+	if s.Total.Records > 0 {
+		fmt.Printf("  decode %s, %.0f records/s\n", s.Decode.Round(time.Millisecond), float64(s.Total.Records)/s.Decode.Seconds())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	exchange "github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func TestBucketStats(t *testing.T) {
+	book := exchange.New("BTC-USDT")
+	book.PriceScale, book.SizeScale = 2, 8
+	now := time.Unix(1550671200, 0)
+	for i := 0; i < 50; i++ {
+		book.UpdateBidLevel(now, fixed.Value(10000-i), 100000000)
+		book.UpdateAskLevel(now, fixed.Value(10001+i), 100000000)
+	}
+	sync := exchange.PackSync(book)
+	compressedSync := orderbook.CompressPacket(sync)
+	if len(compressedSync) >= len(sync) {
+		t.Fatalf("sync of %d bytes not compressed", len(sync))
+	}
+	book.ResetDiff()
+	book.UpdateBidLevel(now, 10000, 200000000)
+	diff := exchange.PackDiff(book, 1, 1)
+	book.AddTakerTrade(now, exchange.TakerSide(true), 10001, 1000000, 1, now)
+	trade := exchange.PackTrade(book, book.Trades[0])
+
+	st := store.NewMemory()
+	defer st.Close()
+	records := [][]byte{compressedSync, diff, sync, trade, trade[:len(trade)-1]}
+	for i, data := range records {
+		if err := st.Append("Binance-BTC-USDT", store.Event{Time: now.Add(time.Duration(i) * time.Second), Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := bucketStats(st, "Binance-BTC-USDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		got  *TypeStats
+		want TypeStats
+	}{
+		{"sync", s.Types[orderbook.SyncPacket], TypeStats{Records: 2, Compressed: 1, Stored: int64(len(compressedSync) + len(sync)), Raw: int64(2 * len(sync))}},
+		{"diff", s.Types[orderbook.DiffPacket], TypeStats{Records: 1, Stored: int64(len(diff)), Raw: int64(len(diff))}},
+		{"trade", s.Types[orderbook.TradePacket], TypeStats{Records: 1, Stored: int64(len(trade)), Raw: int64(len(trade))}},
+		{"total", &s.Total, TypeStats{Records: 4, Compressed: 1, Stored: int64(len(compressedSync) + len(sync) + len(diff) + len(trade)), Raw: int64(2*len(sync) + len(diff) + len(trade))}},
+	}
+	for _, c := range cases {
+		if c.got == nil || *c.got != c.want {
+			t.Fatalf("%s: %+v, want %+v", c.name, c.got, c.want)
+		}
+	}
+	if s.Undecodable != 1 {
+		t.Fatalf("%d undecodable records, want 1", s.Undecodable)
+	}
+	if ratio := s.Types[orderbook.SyncPacket].Ratio(); ratio <= 1 {
+		t.Fatalf("sync ratio %.2f", ratio)
+	}
+	if ratio := (&TypeStats{}).Ratio(); ratio != 0 {
+		t.Fatalf("ratio without records %.2f", ratio)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KB"},
+		{1536, "1.5KB"},
+		{5 << 20, "5.0MB"},
+		{3 << 30, "3.0GB"},
+	}
+	for _, c := range cases {
+		if got := formatBytes(c.n); got != c.want {
+			t.Fatalf("%d: %s, want %s", c.n, got, c.want)
+		}
+	}
+}
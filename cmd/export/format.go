@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+type Writer interface {
+	Event(t time.Time, pkt *orderbook.Packet) error
+	Snapshot(t time.Time, bids, asks []orderbook.OrderState) error
+	Flow(t time.Time, flow orderbook.TradeFlow, cvd float64) error
+	Flush() error
+}
+
+func packetTypeName(packetType uint8) string {
+	switch packetType {
+	case orderbook.SyncPacket:
+		return "sync"
+	case orderbook.DiffPacket:
+		return "diff"
+	case orderbook.TradePacket:
+		return "trade"
+	}
+	return "unknown"
+}
+
+func sideName(side uint8) string {
+	if orderbook.Side(side) == orderbook.AskSide {
+		return "ask"
+	}
+	return "bid"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatValue renders v exactly, without trailing zeros.
+func formatValue(scale fixed.Scale, v fixed.Value) string {
+	s := scale.Format(v)
+	if strings.IndexByte(s, '.') != -1 {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+type CSVWriter struct {
+	w      *csv.Writer
+	depth  int
+	header bool
+}
+
+func NewCSVWriter(w io.Writer, depth int) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w), depth: depth}
+}
+
+func (c *CSVWriter) writeHeader(header []string) error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write(header)
+}
+
+func (c *CSVWriter) Event(t time.Time, pkt *orderbook.Packet) error {
+	if err := c.writeHeader([]string{"time", "unix_nano", "type", "sequence", "side", "price", "size"}); err != nil {
+		return err
+	}
+
+	row := func(side string, price, size fixed.Value) error {
+		return c.w.Write([]string{
+			t.UTC().Format(time.RFC3339Nano),
+			strconv.FormatInt(t.UnixNano(), 10),
+			packetTypeName(pkt.Type),
+			strconv.FormatUint(pkt.Sequence, 10),
+			side,
+			formatValue(pkt.PriceScale, price),
+			formatValue(pkt.SizeScale, size),
+		})
+	}
+
+	if pkt.Type == orderbook.TradePacket {
+		return row(sideName(pkt.Side), pkt.Price, pkt.Size)
+	}
+
+	for _, level := range pkt.Bid {
+		if err := row("bid", level.Price, level.Size); err != nil {
+			return err
+		}
+	}
+	for _, level := range pkt.Ask {
+		if err := row("ask", level.Price, level.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CSVWriter) Snapshot(t time.Time, bids, asks []orderbook.OrderState) error {
+	if !c.header {
+		header := []string{"time", "unix_nano"}
+		for _, side := range []string{"bid", "ask"} {
+			for i := 1; i <= c.depth; i++ {
+				header = append(header, fmt.Sprintf("%s_price_%d", side, i), fmt.Sprintf("%s_size_%d", side, i))
+			}
+		}
+		if err := c.writeHeader(header); err != nil {
+			return err
+		}
+	}
+
+	row := []string{t.UTC().Format(time.RFC3339Nano), strconv.FormatInt(t.UnixNano(), 10)}
+	for _, levels := range [][]orderbook.OrderState{bids, asks} {
+		for i := 0; i < c.depth; i++ {
+			if i < len(levels) {
+				row = append(row, formatFloat(levels[i].Price), formatFloat(levels[i].Size))
+			} else {
+				row = append(row, "", "")
+			}
+		}
+	}
+	return c.w.Write(row)
+}
+
+func (c *CSVWriter) Flow(t time.Time, flow orderbook.TradeFlow, cvd float64) error {
+	if err := c.writeHeader([]string{"time", "unix_nano", "buy_volume", "sell_volume", "delta", "cvd", "trades", "vwap"}); err != nil {
+		return err
+	}
+	return c.w.Write([]string{
+		t.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(t.UnixNano(), 10),
+		formatFloat(flow.BuyVolume),
+		formatFloat(flow.SellVolume),
+		formatFloat(flow.Delta()),
+		formatFloat(cvd),
+		strconv.Itoa(flow.Count()),
+		formatFloat(flow.VWAP()),
+	})
+}
+
+func (c *CSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type JSONWriter struct {
+	enc *json.Encoder
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{enc: json.NewEncoder(w)}
+}
+
+type jsonEvent struct {
+	Time     string       `json:"time"`
+	UnixNano int64        `json:"unix_nano"`
+	Type     string       `json:"type"`
+	Sequence uint64       `json:"sequence"`
+	First    uint64       `json:"first,omitempty"`
+	Last     uint64       `json:"last,omitempty"`
+	Side     string       `json:"side,omitempty"`
+	Price    float64      `json:"price,omitempty"`
+	Size     float64      `json:"size,omitempty"`
+	Bids     [][2]float64 `json:"bids,omitempty"`
+	Asks     [][2]float64 `json:"asks,omitempty"`
+	// trade details
+	TradeID      uint64 `json:"trade_id,omitempty"`
+	ExchangeTime string `json:"exchange_time,omitempty"`
+	Aggressor    bool   `json:"aggressor,omitempty"`
+}
+
+type jsonSnapshot struct {
+	Time     string       `json:"time"`
+	UnixNano int64        `json:"unix_nano"`
+	Bids     [][2]float64 `json:"bids"`
+	Asks     [][2]float64 `json:"asks"`
+}
+
+type jsonFlow struct {
+	Time       string  `json:"time"`
+	UnixNano   int64   `json:"unix_nano"`
+	BuyVolume  float64 `json:"buy_volume"`
+	SellVolume float64 `json:"sell_volume"`
+	Delta      float64 `json:"delta"`
+	CVD        float64 `json:"cvd"`
+	Trades     int     `json:"trades"`
+	VWAP       float64 `json:"vwap"`
+}
+
+func (j *JSONWriter) Event(t time.Time, pkt *orderbook.Packet) error {
+	e := jsonEvent{
+		Time:     t.UTC().Format(time.RFC3339Nano),
+		UnixNano: t.UnixNano(),
+		Type:     packetTypeName(pkt.Type),
+		Sequence: pkt.Sequence,
+		First:    pkt.First,
+		Last:     pkt.Last,
+	}
+	if pkt.Type == orderbook.TradePacket {
+		e.Side = sideName(pkt.Side)
+		e.Price = pkt.PriceScale.Float(pkt.Price)
+		e.Size = pkt.SizeScale.Float(pkt.Size)
+		e.TradeID = pkt.TradeID
+		if !pkt.ExchangeTime.IsZero() {
+			e.ExchangeTime = pkt.ExchangeTime.UTC().Format(time.RFC3339Nano)
+		}
+		e.Aggressor = pkt.Aggressor
+	} else {
+		for _, level := range pkt.Bid {
+			e.Bids = append(e.Bids, [2]float64{pkt.PriceScale.Float(level.Price), pkt.SizeScale.Float(level.Size)})
+		}
+		for _, level := range pkt.Ask {
+			e.Asks = append(e.Asks, [2]float64{pkt.PriceScale.Float(level.Price), pkt.SizeScale.Float(level.Size)})
+		}
+	}
+	return j.enc.Encode(e)
+}
+
+func (j *JSONWriter) Snapshot(t time.Time, bids, asks []orderbook.OrderState) error {
+	s := jsonSnapshot{
+		Time:     t.UTC().Format(time.RFC3339Nano),
+		UnixNano: t.UnixNano(),
+		Bids:     make([][2]float64, 0, len(bids)),
+		Asks:     make([][2]float64, 0, len(asks)),
+	}
+	for _, level := range bids {
+		s.Bids = append(s.Bids, [2]float64{level.Price, level.Size})
+	}
+	for _, level := range asks {
+		s.Asks = append(s.Asks, [2]float64{level.Price, level.Size})
+	}
+	return j.enc.Encode(s)
+}
+
+func (j *JSONWriter) Flow(t time.Time, flow orderbook.TradeFlow, cvd float64) error {
+	return j.enc.Encode(jsonFlow{
+		Time:       t.UTC().Format(time.RFC3339Nano),
+		UnixNano:   t.UnixNano(),
+		BuyVolume:  flow.BuyVolume,
+		SellVolume: flow.SellVolume,
+		Delta:      flow.Delta(),
+		CVD:        cvd,
+		Trades:     flow.Count(),
+		VWAP:       flow.VWAP(),
+	})
+}
+
+func (j *JSONWriter) Flush() error {
+	return nil
+}
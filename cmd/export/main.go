@@ -0,0 +1,281 @@
+package main
+
+// export recorded events, trades, periodic L2 snapshots or trade flow of a product bucket as CSV, JSON Lines or Parquet
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func main() {
+	var db_path string
+	var storeBackend string
+	var bucket string
+	var fromValue string
+	var toValue string
+	var mode string
+	var format string
+	var output string
+	var interval time.Duration
+	var depth int
+	var clockValue string
+
+	flag.StringVar(&db_path, "db", "orderbooks.db", "database file")
+	flag.StringVar(&storeBackend, "store", "bolt", "storage backend ("+strings.Join(store.Backends, ", ")+"), segment uses -db as directory")
+	flag.StringVar(&bucket, "product", "", "product bucket, for example Binance-BTC-USDT (empty lists buckets)")
+	flag.StringVar(&fromValue, "from", "", "start time (2006-01-02 15:04:05)")
+	flag.StringVar(&toValue, "to", "", "end time, defaults to the end of the recording")
+	flag.StringVar(&mode, "mode", "events", "events, trades, snapshots or flow")
+	flag.StringVar(&format, "format", "csv", "csv, jsonl or parquet (trades, top-of-book snapshots and flow)")
+	flag.StringVar(&output, "o", "", "output file, defaults to stdout")
+	flag.DurationVar(&interval, "interval", time.Second, "snapshot and flow interval")
+	flag.IntVar(&depth, "depth", 10, "snapshot levels per side")
+	flag.StringVar(&clockValue, "clock", "local", "timestamps of the exported rows ("+strings.Join(util.TimeSources, ", ")+"), exchange uses the exchange timestamps where recorded")
+	flag.Parse()
+
+	clock, err := util.ParseTimeSource(clockValue)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// fail before the output file is created
+	switch mode {
+	case "events", "trades", "snapshots", "flow":
+	default:
+		log.Fatalln("unknown mode", mode)
+	}
+	switch format {
+	case "csv", "jsonl":
+	case "parquet":
+		if mode == "events" {
+			log.Fatalln("parquet export supports -mode trades, snapshots or flow")
+		}
+	default:
+		log.Fatalln("unknown format", format)
+	}
+
+	db, err := util.OpenStore(storeBackend, db_path, true)
+	if err != nil {
+		log.Fatalln("OpenStore Error", err)
+	}
+	defer db.Close()
+
+	if bucket == "" {
+		buckets, err := util.ListBuckets(db)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, name := range buckets {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	var from, to time.Time
+	if fromValue != "" {
+		if from, err = util.ParseTime(fromValue); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if toValue != "" {
+		if to, err = util.ParseTime(toValue); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer file.Close()
+		out = file
+	}
+	buf := bufio.NewWriter(out)
+	defer buf.Flush()
+
+	var w Writer
+	switch format {
+	case "csv":
+		w = NewCSVWriter(buf, depth)
+	case "jsonl":
+		w = NewJSONWriter(buf)
+	case "parquet":
+		columns := tradeColumns
+		switch mode {
+		case "snapshots":
+			columns = topOfBookColumns
+		case "flow":
+			columns = flowColumns
+		}
+		if w, err = NewParquetWriter(buf, product_info.ParseDatabaseKey(bucket), columns); err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		log.Fatalln("unknown format", format)
+	}
+
+	switch mode {
+	case "events":
+		err = exportEvents(db, bucket, from, to, clock, false, w)
+	case "trades":
+		err = exportEvents(db, bucket, from, to, clock, true, w)
+	case "snapshots":
+		err = exportSnapshots(db, bucket, from, to, clock, interval, depth, w)
+	case "flow":
+		err = exportFlow(db, bucket, from, to, clock, interval, w)
+	default:
+		log.Fatalln("unknown mode", mode)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func exportEvents(db store.Store, bucket string, from, to time.Time, clock util.TimeSource, tradesOnly bool, w Writer) error {
+	book := orderbook.New(bucket)
+	count := 0
+	return util.ReplayBucket(db, bucket, from, to, book, func(t time.Time, pkt *orderbook.Packet, err error) error {
+		if err != nil {
+			log.Println(bucket, "skip undecodable record", t, err)
+			return nil
+		}
+		count += 1
+		if count%1000 == 0 {
+			book.ResetStats()
+		}
+		if tradesOnly && pkt.Type != orderbook.TradePacket {
+			return nil
+		}
+		return w.Event(clock.EventTime(t, pkt), pkt)
+	})
+}
+
+func exportSnapshots(db store.Store, bucket string, from, to time.Time, clock util.TimeSource, interval time.Duration, depth int, w Writer) error {
+	book := orderbook.New(bucket)
+	var next time.Time
+	if !from.IsZero() {
+		next = from
+	}
+
+	snapshot := func(until time.Time) error {
+		if !next.Before(until) {
+			return nil
+		}
+		for next.Before(until) {
+			if !book.Empty() {
+				bids, asks := topLevels(book, depth)
+				if err := w.Snapshot(next, bids, asks); err != nil {
+					return err
+				}
+			}
+			next = next.Add(interval)
+		}
+		// drop removed levels
+		book.ResetStats()
+		return nil
+	}
+
+	err := util.ReplayBucket(db, bucket, from, to, book, func(t time.Time, pkt *orderbook.Packet, err error) error {
+		if err != nil {
+			log.Println(bucket, "skip undecodable record", t, err)
+			return nil
+		}
+		t = clock.EventTime(t, pkt)
+		if next.IsZero() {
+			next = t.Truncate(interval)
+		}
+		// records are applied after this callback, so the book is the state up to t
+		return snapshot(t)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !to.IsZero() {
+		return snapshot(to.Add(time.Nanosecond))
+	}
+	return nil
+}
+
+// exportFlow writes the buy and sell volume, trade count, VWAP and cumulative
+// volume delta of every interval.
+func exportFlow(db store.Store, bucket string, from, to time.Time, clock util.TimeSource, interval time.Duration, w Writer) error {
+	book := orderbook.New(bucket)
+	var next, last time.Time
+	var cvd float64
+
+	// flush writes the intervals that ended before until
+	flush := func(until time.Time) error {
+		for !next.Add(interval).After(until) {
+			flow := book.TradeFlow()
+			cvd += flow.Delta()
+			if err := w.Flow(next, flow, cvd); err != nil {
+				return err
+			}
+			book.ResetStats()
+			next = next.Add(interval)
+		}
+		return nil
+	}
+
+	err := util.ReplayBucket(db, bucket, from, to, book, func(t time.Time, pkt *orderbook.Packet, err error) error {
+		if err != nil {
+			log.Println(bucket, "skip undecodable record", t, err)
+			return nil
+		}
+		t = clock.EventTime(t, pkt)
+		if next.IsZero() {
+			next = from
+			if next.IsZero() {
+				next = t.Truncate(interval)
+			}
+			// trades replayed between the sync and from belong to no interval
+			book.ResetStats()
+		}
+		last = t
+		// records are applied after this callback, so the flow is the one up to t
+		return flush(t)
+	})
+	if err != nil || next.IsZero() {
+		return err
+	}
+
+	if to.IsZero() {
+		// close the interval of the last record
+		to = last.Truncate(interval).Add(interval)
+	}
+	return flush(to)
+}
+
+// topLevels returns the best depth bids (highest first) and asks (lowest first).
+func topLevels(book *orderbook.Book, depth int) ([]orderbook.OrderState, []orderbook.OrderState) {
+	stats := book.StateAsStats()
+
+	bids := make([]orderbook.OrderState, 0, depth)
+	for i := len(stats.Bid) - 1; i >= 0 && len(bids) < depth; i-- {
+		bids = append(bids, stats.Bid[i])
+	}
+
+	asks := stats.Ask
+	if len(asks) > depth {
+		asks = asks[:depth]
+	}
+
+	return bids, asks
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	exchange "github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+const testBucket = "Binance-BTC-USDT"
+
+var testStart = time.Unix(1550671200, 0)
+
+// at returns the time ms milliseconds after testStart.
+func at(ms int) time.Time {
+	return testStart.Add(time.Duration(ms) * time.Millisecond)
+}
+
+// recording builds the records of a product bucket at scales 2/8, starting
+// with a sync of the given levels.
+type recording struct {
+	book   *exchange.Book
+	events []store.Event
+	seq    uint64
+}
+
+func newRecording(ms int, bids, asks [][2]fixed.Value) *recording {
+	r := &recording{book: exchange.New("BTC-USDT")}
+	r.book.PriceScale, r.book.SizeScale = 2, 8
+	for _, level := range bids {
+		r.book.UpdateBidLevel(at(ms), level[0], level[1])
+	}
+	for _, level := range asks {
+		r.book.UpdateAskLevel(at(ms), level[0], level[1])
+	}
+	r.events = append(r.events, store.Event{Time: at(ms), Data: exchange.PackSync(r.book)})
+	return r
+}
+
+func (r *recording) trade(ms int, buy bool, price, size fixed.Value) {
+	r.seq += 1
+	r.book.AddTakerTrade(at(ms), exchange.TakerSide(buy), price, size, r.seq, at(ms))
+	r.events = append(r.events, store.Event{Time: at(ms), Data: exchange.PackTrade(r.book, r.book.Trades[len(r.book.Trades)-1])})
+}
+
+func (r *recording) diff(ms int, bids, asks [][2]fixed.Value) {
+	r.seq += 1
+	r.book.ResetDiff()
+	for _, level := range bids {
+		r.book.UpdateBidLevel(at(ms), level[0], level[1])
+	}
+	for _, level := range asks {
+		r.book.UpdateAskLevel(at(ms), level[0], level[1])
+	}
+	r.events = append(r.events, store.Event{Time: at(ms), Data: exchange.PackDiff(r.book, r.seq, r.seq)})
+}
+
+func (r *recording) store(t *testing.T) store.Store {
+	t.Helper()
+	st := store.NewMemory()
+	if err := st.Append(testBucket, r.events...); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// jsonRows decodes the JSON Lines of an export into values returned by row.
+func jsonRows(t *testing.T, data []byte, row func() interface{}) []interface{} {
+	t.Helper()
+	rows := []interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		v := row()
+		if err := dec.Decode(v); err != nil {
+			t.Fatalf("%s: %s", data, err)
+		}
+		rows = append(rows, v)
+	}
+	return rows
+}
+
+func flowRow(ms int, buy, sell, cvd float64, trades int, vwap float64) *jsonFlow {
+	return &jsonFlow{
+		Time:       at(ms).UTC().Format(time.RFC3339Nano),
+		UnixNano:   at(ms).UnixNano(),
+		BuyVolume:  buy,
+		SellVolume: sell,
+		Delta:      buy - sell,
+		CVD:        cvd,
+		Trades:     trades,
+		VWAP:       vwap,
+	}
+}
+
+func TestExportFlow(t *testing.T) {
+	r := newRecording(100, [][2]fixed.Value{{10000, 100000000}}, [][2]fixed.Value{{10100, 100000000}})
+	r.trade(500, true, 10100, 200000000)
+	r.trade(700, false, 10000, 50000000)
+	r.trade(1200, true, 10100, 100000000)
+	// a trade at the start of an interval belongs to it
+	r.trade(2000, false, 10000, 100000000)
+	r.trade(3500, false, 10000, 400000000)
+	r.diff(3900, [][2]fixed.Value{{9900, 300000000}}, nil)
+	st := r.store(t)
+	defer st.Close()
+
+	cases := []struct {
+		name     string
+		from, to time.Time
+		rows     []interface{}
+	}{
+		{"whole recording", time.Time{}, time.Time{}, []interface{}{
+			flowRow(0, 2, 0.5, 1.5, 2, (202+50)/2.5),
+			flowRow(1000, 1, 0, 2.5, 1, 101),
+			flowRow(2000, 0, 1, 1.5, 1, 100),
+			flowRow(3000, 0, 4, -2.5, 1, 100),
+		}},
+		// the trades before from are replayed but belong to no interval,
+		// the CVD starts at from
+		{"from and to", at(1000), at(3000), []interface{}{
+			flowRow(1000, 1, 0, 1, 1, 101),
+			flowRow(2000, 0, 1, 0, 1, 100),
+		}},
+		{"without trades", at(3000), at(5000), []interface{}{
+			flowRow(3000, 0, 4, -4, 1, 100),
+			flowRow(4000, 0, 0, -4, 0, 0),
+		}},
+		{"after the recording", at(4000), at(6000), []interface{}{}},
+	}
+	for _, c := range cases {
+		buf := new(bytes.Buffer)
+		if err := exportFlow(st, testBucket, c.from, c.to, util.LocalTime, time.Second, NewJSONWriter(buf)); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		rows := jsonRows(t, buf.Bytes(), func() interface{} { return &jsonFlow{} })
+		if !reflect.DeepEqual(rows, c.rows) {
+			t.Fatalf("%s: rows\n%s", c.name, buf.Bytes())
+		}
+	}
+}
+
+// snapshotRecording is a book of three bids and two asks that changes at
+// 1s, 2.5s and 3s after testStart.
+func snapshotRecording(t *testing.T) store.Store {
+	t.Helper()
+	r := newRecording(100, [][2]fixed.Value{{10000, 100000000}, {9900, 200000000}, {9800, 300000000}}, [][2]fixed.Value{{10100, 100000000}, {10200, 200000000}})
+	r.diff(1000, [][2]fixed.Value{{10000, 500000000}}, nil)
+	r.diff(2500, nil, [][2]fixed.Value{{10100, 0}})
+	r.diff(3000, [][2]fixed.Value{{9950, 100000000}}, nil)
+	return r.store(t)
+}
+
+func snapshotRow(ms int, bids, asks [][2]float64) *jsonSnapshot {
+	return &jsonSnapshot{Time: at(ms).UTC().Format(time.RFC3339Nano), UnixNano: at(ms).UnixNano(), Bids: bids, Asks: asks}
+}
+
+func TestExportSnapshots(t *testing.T) {
+	st := snapshotRecording(t)
+	defer st.Close()
+
+	before := [][2]float64{{101, 1}, {102, 2}}
+	after := [][2]float64{{102, 2}}
+	cases := []struct {
+		name     string
+		from, to time.Time
+		rows     []interface{}
+	}{
+		// a snapshot holds the records up to and including its time, the
+		// book is still empty at the first interval
+		{"whole recording", time.Time{}, time.Time{}, []interface{}{
+			snapshotRow(1000, [][2]float64{{100, 5}, {99, 2}}, before),
+			snapshotRow(2000, [][2]float64{{100, 5}, {99, 2}}, before),
+		}},
+		// to is written once the recording is replayed up to it
+		{"to on a boundary", time.Time{}, at(2000), []interface{}{
+			snapshotRow(1000, [][2]float64{{100, 5}, {99, 2}}, before),
+			snapshotRow(2000, [][2]float64{{100, 5}, {99, 2}}, before),
+		}},
+		{"from and to", at(1000), at(3000), []interface{}{
+			snapshotRow(1000, [][2]float64{{100, 5}, {99, 2}}, before),
+			snapshotRow(2000, [][2]float64{{100, 5}, {99, 2}}, before),
+			snapshotRow(3000, [][2]float64{{100, 5}, {99.5, 1}}, after),
+		}},
+		// intervals start at from, the sync before it is replayed
+		{"from between records", at(1500), time.Time{}, []interface{}{
+			snapshotRow(1500, [][2]float64{{100, 5}, {99, 2}}, before),
+			snapshotRow(2500, [][2]float64{{100, 5}, {99, 2}}, after),
+		}},
+		{"from before the recording", testStart.Add(-time.Second), at(0), []interface{}{}},
+	}
+	for _, c := range cases {
+		buf := new(bytes.Buffer)
+		if err := exportSnapshots(st, testBucket, c.from, c.to, util.LocalTime, time.Second, 2, NewJSONWriter(buf)); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		rows := jsonRows(t, buf.Bytes(), func() interface{} { return &jsonSnapshot{} })
+		if !reflect.DeepEqual(rows, c.rows) {
+			t.Fatalf("%s: rows\n%s", c.name, buf.Bytes())
+		}
+	}
+
+	// CSV rows have depth columns per side, empty without a level
+	buf := new(bytes.Buffer)
+	w := NewCSVWriter(buf, 2)
+	if err := exportSnapshots(st, testBucket, at(3000), at(3000), util.LocalTime, time.Second, 2, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "time,unix_nano,bid_price_1,bid_size_1,bid_price_2,bid_size_2,ask_price_1,ask_size_1,ask_price_2,ask_size_2\n" +
+		"2019-02-20T14:00:03Z,1550671203000000000,100,5,99.5,1,102,2,,\n"
+	if buf.String() != want {
+		t.Fatalf("csv snapshots\n%s\nwant\n%s", buf, want)
+	}
+}
+
+func TestTopLevels(t *testing.T) {
+	r := newRecording(0, [][2]fixed.Value{{9800, 100000000}, {10000, 100000000}, {9900, 100000000}}, [][2]fixed.Value{{10300, 100000000}, {10100, 100000000}, {10200, 100000000}})
+	book := orderbook.New(testBucket)
+	if err := book.Process(at(0), r.events[0].Data); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		depth      int
+		bids, asks []float64
+	}{
+		{1, []float64{100}, []float64{101}},
+		{2, []float64{100, 99}, []float64{101, 102}},
+		{5, []float64{100, 99, 98}, []float64{101, 102, 103}},
+	}
+	for _, c := range cases {
+		bids, asks := topLevels(book, c.depth)
+		prices := func(states []orderbook.OrderState) []float64 {
+			list := []float64{}
+			for _, state := range states {
+				list = append(list, state.Price)
+			}
+			return list
+		}
+		if !reflect.DeepEqual(prices(bids), c.bids) || !reflect.DeepEqual(prices(asks), c.asks) {
+			t.Fatalf("depth %d: bids %v asks %v, want %v %v", c.depth, prices(bids), prices(asks), c.bids, c.asks)
+		}
+	}
+}
+
+func TestExportEvents(t *testing.T) {
+	r := newRecording(100, [][2]fixed.Value{{10000, 100000000}}, [][2]fixed.Value{{10100, 150000000}})
+	r.trade(500, true, 10100, 50000000)
+	r.diff(1000, [][2]fixed.Value{{9950, 200000000}}, [][2]fixed.Value{{10100, 100000000}})
+	r.trade(1500, false, 10000, 25000000)
+	st := r.store(t)
+	defer st.Close()
+
+	cases := []struct {
+		name       string
+		from, to   time.Time
+		tradesOnly bool
+		want       string
+	}{
+		{"events", time.Time{}, time.Time{}, false, "time,unix_nano,type,sequence,side,price,size\n" +
+			"2019-02-20T14:00:00.1Z,1550671200100000000,sync,0,bid,100,1\n" +
+			"2019-02-20T14:00:00.1Z,1550671200100000000,sync,0,ask,101,1.5\n" +
+			"2019-02-20T14:00:00.5Z,1550671200500000000,trade,0,ask,101,0.5\n" +
+			"2019-02-20T14:00:01Z,1550671201000000000,diff,2,bid,99.5,2\n" +
+			"2019-02-20T14:00:01Z,1550671201000000000,diff,2,ask,101,1\n" +
+			"2019-02-20T14:00:01.5Z,1550671201500000000,trade,0,bid,100,0.25\n"},
+		{"trades", time.Time{}, time.Time{}, true, "time,unix_nano,type,sequence,side,price,size\n" +
+			"2019-02-20T14:00:00.5Z,1550671200500000000,trade,0,ask,101,0.5\n" +
+			"2019-02-20T14:00:01.5Z,1550671201500000000,trade,0,bid,100,0.25\n"},
+		// from and to are inclusive
+		{"from and to", at(500), at(1000), false, "time,unix_nano,type,sequence,side,price,size\n" +
+			"2019-02-20T14:00:00.5Z,1550671200500000000,trade,0,ask,101,0.5\n" +
+			"2019-02-20T14:00:01Z,1550671201000000000,diff,2,bid,99.5,2\n" +
+			"2019-02-20T14:00:01Z,1550671201000000000,diff,2,ask,101,1\n"},
+	}
+	for _, c := range cases {
+		buf := new(bytes.Buffer)
+		w := NewCSVWriter(buf, 10)
+		if err := exportEvents(st, testBucket, c.from, c.to, util.LocalTime, c.tradesOnly, w); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.want {
+			t.Fatalf("%s: csv\n%s\nwant\n%s", c.name, buf, c.want)
+		}
+	}
+
+	// JSON Lines events keep the trade details
+	buf := new(bytes.Buffer)
+	if err := exportEvents(st, testBucket, at(500), at(500), util.LocalTime, true, NewJSONWriter(buf)); err != nil {
+		t.Fatal(err)
+	}
+	rows := jsonRows(t, buf.Bytes(), func() interface{} { return &jsonEvent{} })
+	want := []interface{}{&jsonEvent{
+		Time:         "2019-02-20T14:00:00.5Z",
+		UnixNano:     at(500).UnixNano(),
+		Type:         "trade",
+		Side:         "ask",
+		Price:        101,
+		Size:         0.5,
+		TradeID:      1,
+		ExchangeTime: "2019-02-20T14:00:00.5Z",
+		Aggressor:    true,
+	}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("jsonl trades\n%s", buf.Bytes())
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util/parquet"
+)
+
+var tradeColumns = []parquet.Column{
+	{Name: "timestamp", Type: parquet.Timestamp},
+	{Name: "platform", Type: parquet.String},
+	{Name: "product", Type: parquet.String},
+	{Name: "side", Type: parquet.String},
+	{Name: "price", Type: parquet.Double},
+	{Name: "size", Type: parquet.Double},
+}
+
+var topOfBookColumns = []parquet.Column{
+	{Name: "timestamp", Type: parquet.Timestamp},
+	{Name: "platform", Type: parquet.String},
+	{Name: "product", Type: parquet.String},
+	{Name: "bid_price", Type: parquet.Double},
+	{Name: "bid_size", Type: parquet.Double},
+	{Name: "ask_price", Type: parquet.Double},
+	{Name: "ask_size", Type: parquet.Double},
+}
+
+var flowColumns = []parquet.Column{
+	{Name: "timestamp", Type: parquet.Timestamp},
+	{Name: "platform", Type: parquet.String},
+	{Name: "product", Type: parquet.String},
+	{Name: "buy_volume", Type: parquet.Double},
+	{Name: "sell_volume", Type: parquet.Double},
+	{Name: "delta", Type: parquet.Double},
+	{Name: "cvd", Type: parquet.Double},
+	{Name: "trades", Type: parquet.Int64},
+	{Name: "vwap", Type: parquet.Double},
+}
+
+// ParquetWriter writes trades, top-of-book snapshots or trade flow, book diffs have no
+// columnar layout and are rejected.
+type ParquetWriter struct {
+	info product_info.Info
+	w    *parquet.Writer
+}
+
+func NewParquetWriter(out io.Writer, info product_info.Info, columns []parquet.Column) (*ParquetWriter, error) {
+	w, err := parquet.NewWriter(out, columns)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetWriter{info: info, w: w}, nil
+}
+
+func (p *ParquetWriter) Event(t time.Time, pkt *orderbook.Packet) error {
+	if pkt.Type != orderbook.TradePacket {
+		return errors.New("parquet export supports trades, snapshots and flow, not book events")
+	}
+	return p.w.Write(t, p.info.Platform, p.info.ID, sideName(pkt.Side), pkt.PriceScale.Float(pkt.Price), pkt.SizeScale.Float(pkt.Size))
+}
+
+func (p *ParquetWriter) Snapshot(t time.Time, bids, asks []orderbook.OrderState) error {
+	var bid, ask orderbook.OrderState
+	if len(bids) > 0 {
+		bid = bids[0]
+	}
+	if len(asks) > 0 {
+		ask = asks[0]
+	}
+	return p.w.Write(t, p.info.Platform, p.info.ID, bid.Price, bid.Size, ask.Price, ask.Size)
+}
+
+func (p *ParquetWriter) Flow(t time.Time, flow orderbook.TradeFlow, cvd float64) error {
+	return p.w.Write(t, p.info.Platform, p.info.ID, flow.BuyVolume, flow.SellVolume, flow.Delta(), cvd, int64(flow.Count()), flow.VWAP())
+}
+
+// Flush writes the parquet footer.
+func (p *ParquetWriter) Flush() error {
+	return p.w.Close()
+}
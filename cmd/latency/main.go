@@ -0,0 +1,200 @@
+package main
+
+// histogram of the delay between the exchange timestamp and the local receive
+// time of recorded events, per venue
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// upper bounds of the histogram buckets, the last bucket has no bound
+var bounds = []time.Duration{
+	0, // the exchange timestamp is after the receive time, clocks are skewed
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+type Histogram struct {
+	Counts  []int
+	Samples []time.Duration
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{Counts: make([]int, len(bounds)+1)}
+}
+
+func (h *Histogram) Add(d time.Duration) {
+	i := sort.Search(len(bounds), func(i int) bool { return d <= bounds[i] })
+	h.Counts[i] += 1
+	h.Samples = append(h.Samples, d)
+}
+
+// Percentile returns the latency below which p (0-1) of the samples are.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if len(h.Samples) == 0 {
+		return 0
+	}
+	return h.Samples[int(p*float64(len(h.Samples)-1))]
+}
+
+type VenueStats struct {
+	Name string
+	// trades measure a single message, book diffs the last update before the diff was written
+	Trade *Histogram
+	Book  *Histogram
+	// records without exchange timestamp
+	Missing int
+}
+
+func main() {
+	var db_path string
+	var storeBackend string
+	var bucket string
+	var fromValue string
+	var toValue string
+
+	flag.StringVar(&db_path, "db", "orderbooks.db", "database file")
+	flag.StringVar(&storeBackend, "store", "bolt", "storage backend ("+strings.Join(store.Backends, ", ")+"), segment uses -db as directory")
+	flag.StringVar(&bucket, "product", "", "only this bucket, for example Binance-BTC-USDT")
+	flag.StringVar(&fromValue, "from", "", "start time (2006-01-02 15:04:05), defaults to the start of the recording")
+	flag.StringVar(&toValue, "to", "", "end time, defaults to the end of the recording")
+	flag.Parse()
+
+	var from, to time.Time
+	var err error
+	if fromValue != "" {
+		if from, err = util.ParseTime(fromValue); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if toValue != "" {
+		if to, err = util.ParseTime(toValue); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	db, err := util.OpenStore(storeBackend, db_path, true)
+	if err != nil {
+		log.Fatalln("OpenStore Error", err)
+	}
+	defer db.Close()
+
+	buckets := []string{bucket}
+	if bucket == "" {
+		if buckets, err = util.ListBuckets(db); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	venues := map[string]*VenueStats{}
+	names := []string{}
+	for _, name := range buckets {
+		platform := product_info.ParseDatabaseKey(name).Platform
+		v, ok := venues[platform]
+		if !ok {
+			v = &VenueStats{Name: platform, Trade: NewHistogram(), Book: NewHistogram()}
+			venues[platform] = v
+			names = append(names, platform)
+		}
+		if err := collect(db, name, from, to, v); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		printStats(venues[name])
+	}
+}
+
+func collect(db store.Store, bucket string, from, to time.Time, v *VenueStats) error {
+	c, err := db.Cursor(bucket)
+	if err != nil {
+		return fmt.Errorf("bucket %s %s", bucket, err)
+	}
+	defer c.Close()
+
+	for event, ok := c.Seek(from); ok; event, ok = c.Next() {
+		if !to.IsZero() && event.Time.After(to) {
+			break
+		}
+		pkt, err := orderbook.DecodePacket(event.Data)
+		if err != nil {
+			continue
+		}
+		if pkt.ExchangeTime.IsZero() {
+			v.Missing += 1
+			continue
+		}
+		latency := event.Time.Sub(pkt.ExchangeTime)
+		switch pkt.Type {
+		case orderbook.TradePacket:
+			v.Trade.Add(latency)
+		case orderbook.DiffPacket:
+			v.Book.Add(latency)
+		}
+	}
+	return nil
+}
+
+func bucketName(i int) string {
+	switch {
+	case i == 0:
+		return "< 0"
+	case i == len(bounds):
+		return fmt.Sprintf("> %s", bounds[i-1])
+	}
+	return fmt.Sprintf("<= %s", bounds[i])
+}
+
+func printHistogram(name string, h *Histogram) {
+	if len(h.Samples) == 0 {
+		return
+	}
+	sort.Slice(h.Samples, func(i, j int) bool { return h.Samples[i] < h.Samples[j] })
+	fmt.Printf("  %s %d events, p50 %s p90 %s p99 %s max %s\n", name, len(h.Samples),
+		h.Percentile(0.5), h.Percentile(0.9), h.Percentile(0.99), h.Samples[len(h.Samples)-1])
+
+	max := 0
+	for _, count := range h.Counts {
+		if count > max {
+			max = count
+		}
+	}
+	for i, count := range h.Counts {
+		if count == 0 {
+			continue
+		}
+		bar := strings.Repeat("#", (count*40+max-1)/max)
+		fmt.Printf("    %-9s %8d %5.1f%% %s\n", bucketName(i), count, float64(count)*100/float64(len(h.Samples)), bar)
+	}
+}
+
+func printStats(v *VenueStats) {
+	fmt.Println(v.Name)
+	printHistogram("trade", v.Trade)
+	printHistogram("book ", v.Book)
+	if v.Missing > 0 {
+		fmt.Printf("  %d records without exchange timestamp\n", v.Missing)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+// rewrite a database recorded with decimal time keys into a new file with the
+// binary keys of the bolt store
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+const migrateBatchSize = 50000
+
+func main() {
+	var db_path string
+	var output string
+	var replace bool
+
+	flag.StringVar(&db_path, "db", "orderbooks.db", "database file, must not be open by a recorder")
+	flag.StringVar(&output, "o", "", "migrated database file, defaults to <db>.migrated")
+	flag.BoolVar(&replace, "replace", false, "replace the database with the migrated file")
+	flag.Parse()
+
+	if output == "" {
+		output = db_path + ".migrated"
+	}
+
+	src, err := bolt.Open(db_path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		log.Fatalln("open", db_path, err, "(is a recorder still running?)")
+	}
+	if err := store.CheckKeys(src); err == nil {
+		fmt.Println(db_path, "already uses binary keys")
+		return
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		log.Fatalln(output, "already exists")
+	}
+	dst, err := bolt.Open(output, 0600, nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := migrate(dst, src); err != nil {
+		os.Remove(output)
+		log.Fatalln("migrate", err)
+	}
+	src.Close()
+	dst.Close()
+	fmt.Println("migrated", db_path, "->", output)
+
+	if replace {
+		if err := os.Rename(output, db_path); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println("replaced", db_path)
+	}
+}
+
+// migrate copies all buckets of src into dst converting decimal keys,
+// committing every migrateBatchSize records.
+func migrate(dst, src *bolt.DB) error {
+	return src.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			meta := string(name) == store.MetaBucket
+			c := b.Cursor()
+			key, value := c.First()
+			count := 0
+			for {
+				err := dst.Update(func(dtx *bolt.Tx) error {
+					db, err := dtx.CreateBucketIfNotExists(name)
+					if err != nil {
+						return err
+					}
+					db.FillPercent = 1.0
+					for n := 0; key != nil && n < migrateBatchSize; n++ {
+						newKey := key
+						// binary keys are copied unchanged
+						if !meta && len(key) != store.KeySize {
+							t, err := store.ParseLegacyKey(key)
+							if err != nil {
+								return fmt.Errorf("%s %s", name, err)
+							}
+							// decimal keys are unique, no sub-sequence needed
+							newKey = store.PackKey(t, 0)
+						}
+						if err := db.Put(newKey, value); err != nil {
+							return err
+						}
+						key, value = c.Next()
+						count += 1
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if key == nil {
+					break
+				}
+			}
+			fmt.Println(string(name), count, "records")
+			return nil
+		})
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func TestMigrate(t *testing.T) {
+	src, err := bolt.Open(filepath.Join(t.TempDir(), "legacy.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	// decimal keys sort by digits, 999 before 1000 only after the migration
+	times := []int64{999, 1000, 1550671200000000000, 1550671200000000001, 1550671201000000000}
+	err = src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("GDAX-BTC-USD"))
+		if err != nil {
+			return err
+		}
+		for i, nano := range times {
+			if err := b.Put([]byte(strconv.FormatInt(nano, 10)), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucket([]byte(store.MetaBucket))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte("version"), []byte("1"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckKeys(src); err != store.ErrLegacyKeys {
+		t.Fatalf("legacy database: %v, want ErrLegacyKeys", err)
+	}
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "migrated.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckKeys(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	st := store.NewBolt(dst)
+	defer st.Close()
+	c, err := st.Cursor("GDAX-BTC-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for event, ok := c.First(); ok; event, ok = c.Next() {
+		if n >= len(times) || !event.Time.Equal(time.Unix(0, times[n])) || event.Data[0] != byte(n) {
+			t.Fatalf("event %d at %s %v, want %s %d", n, event.Time, event.Data, time.Unix(0, times[n]), n)
+		}
+		n += 1
+	}
+	c.Close()
+	if n != len(times) {
+		t.Fatalf("%d events, want %d", n, len(times))
+	}
+	if version, err := st.Meta("version"); err != nil || version != "1" {
+		t.Fatalf("meta version %q %v, want 1", version, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+// headless recorder, writes order books to the database without opening a window
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges"
+	_ "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitfinex/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitstamp/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/coinbase/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/gdax/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/kraken/websocket"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func main() {
+	var interval time.Duration
+
+	options := exchanges.AddFlags(flag.CommandLine, "coinbase-bitstamp-binance")
+	flag.DurationVar(&interval, "interval", 10*time.Second, "status report interval")
+	flag.Parse()
+
+	cfg, err := options.Config()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	db, err := util.OpenStore(options.Store, options.DB, false)
+	if err != nil {
+		fmt.Println("OpenStore Error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := exchanges.SignalContext()
+	defer stop()
+
+	feeds, err := exchanges.StartAll(ctx, db, cfg)
+	if err != nil {
+		fmt.Println(err)
+		db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Println("recording to", options.DB)
+
+	ticker := time.NewTicker(interval)
+	last := map[string]uint64{}
+	lastReport := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("waiting for feeds to shut down")
+			feeds.Wait()
+			db.Close()
+			return
+		case now := <-ticker.C:
+			report(feeds.Clients, last, now.Sub(lastReport))
+			lastReport = now
+		}
+	}
+}
+
+func report(clients []exchanges.Exchange, last map[string]uint64, elapsed time.Duration) {
+	fmt.Println(time.Now().Format("2006-01-02 15:04:05"))
+	for _, ws := range clients {
+		status := ws.Status()
+		connection := "disconnected"
+		if status.Connected {
+			connection = "connected " + time.Since(status.ConnectedAt).Round(time.Second).String()
+		}
+		fmt.Printf("  %s %s\n", status.Platform, connection)
+
+		for _, book := range status.Books {
+			state := "unsynced"
+			if book.Synced {
+				state = "synced"
+			}
+			rate := float64(book.Messages-last[book.DatabaseKey]) / elapsed.Seconds()
+			last[book.DatabaseKey] = book.Messages
+			fmt.Printf("    %-22s %-8s seq %-14d %8.1f msg/s %10d total\n", book.DatabaseKey, state, book.Sequence, rate, book.Messages)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+// rebuild a recording from a raw capture, for example after fixing a client bug
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges"
+	_ "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitfinex/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitstamp/websocket"
+	"github.com/lian/gdax-bookmap/exchanges/capture"
+	_ "github.com/lian/gdax-bookmap/exchanges/coinbase/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/gdax/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/kraken/websocket"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func main() {
+	var capturePath string
+	var db_path string
+	var storeBackend string
+	var checkpointValue string
+	var compress bool
+
+	flag.StringVar(&capturePath, "capture", "", "capture log written by the recorder with -capture")
+	flag.StringVar(&db_path, "db", "reingest.db", "database file to rebuild, it must not have recorded events")
+	flag.StringVar(&storeBackend, "store", "bolt", "storage backend ("+strings.Join(store.Backends, ", ")+"), segment uses -db as directory")
+	flag.StringVar(&checkpointValue, "checkpoint", util.DefaultCheckpointPolicy.String(), "when to write a full sync, per exchange with time=60s;binance:diffs=300,bytes=65536")
+	flag.BoolVar(&compress, "compress", false, "store packets flate compressed")
+	flag.Parse()
+
+	if capturePath == "" {
+		log.Fatalln("-capture is required")
+	}
+
+	checkpoint, checkpoints, err := util.ParseCheckpointPolicies(checkpointValue)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	r, err := capture.Open(capturePath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer r.Close()
+
+	db, err := util.OpenStore(storeBackend, db_path, false)
+	if err != nil {
+		log.Fatalln("OpenStore Error", err)
+	}
+	defer db.Close()
+
+	// events of another run would interleave with the rebuilt ones
+	buckets, err := util.ListBuckets(db)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, bucket := range buckets {
+		if recorded(db, bucket) {
+			log.Fatalln(db_path, "already has events in", bucket+", reingest into a new database")
+		}
+	}
+
+	frames, err := exchanges.Reingest(r, db, checkpoint, checkpoints, compress)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println("reingested", frames, "frames into", db_path)
+}
+
+func recorded(db store.Store, bucket string) bool {
+	c, err := db.Cursor(bucket)
+	if err != nil {
+		return false
+	}
+	defer c.Close()
+	_, ok := c.First()
+	return ok
+}
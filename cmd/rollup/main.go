@@ -0,0 +1,58 @@
+package main
+
+// build or extend the rollup buckets of an existing recording
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func main() {
+	var db_path string
+	var storeBackend string
+	var bucket string
+	var rollupValue string
+	var band float64
+
+	flag.StringVar(&db_path, "db", "orderbooks.db", "database file, must not be open by a recorder")
+	flag.StringVar(&storeBackend, "store", "bolt", "storage backend ("+strings.Join(store.Backends, ", ")+"), segment uses -db as directory")
+	flag.StringVar(&bucket, "product", "", "only this bucket, for example Binance-BTC-USDT")
+	flag.StringVar(&rollupValue, "rollups", "1,8,64", "rollup tiers in seconds")
+	flag.Float64Var(&band, "band", 0, "keep only levels within this fraction of the center price, 0 keeps all levels")
+	flag.Parse()
+
+	tiers, err := util.ParseRollupTiers(rollupValue)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if band < 0 {
+		log.Fatalln("invalid -band", band)
+	}
+
+	db, err := util.OpenStore(storeBackend, db_path, false)
+	if err != nil {
+		log.Fatalln("OpenStore Error", err)
+	}
+	defer db.Close()
+
+	buckets := []string{bucket}
+	if bucket == "" {
+		if buckets, err = util.ListBuckets(db); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	for _, name := range buckets {
+		start := time.Now()
+		if err := util.BuildRollups(db, name, tiers, band); err != nil {
+			log.Fatalln(name, err)
+		}
+		fmt.Println(name, "rollups", tiers, "built in", time.Since(start).Round(time.Millisecond))
+	}
+}
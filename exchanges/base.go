@@ -0,0 +1,232 @@
+package exchanges
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lian/gdax-bookmap/exchanges/capture"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Conn is the venue specific part of a client that Base.Serve drives.
+type Conn interface {
+	// Connect dials Socket and subscribes to the products.
+	Connect() error
+	// HandleFrame handles a websocket text frame received at now.
+	HandleFrame(now time.Time, message []byte)
+	// Flush stores pending diffs at now and writes all buffered records.
+	Flush(now time.Time)
+	// Publish copies the state of the books to Status with SetBookStatus.
+	Publish()
+}
+
+// Base is embedded by the websocket clients. It holds the batches the books
+// are written with, the recording options and the status the client
+// goroutine publishes for Status.
+type Base struct {
+	Socket       *websocket.Conn
+	Products     []string
+	ProductInfos []*product_info.Info
+	DB           store.Store
+	BatchWrite   map[string]*util.BookBatchWrite
+	Checkpoint   util.CheckpointPolicy
+	Compress     bool
+	Messages     map[string]*uint64
+	Capture      *capture.Writer
+	Fetcher      capture.Fetcher
+
+	platform string
+
+	// guards the fields below, they are read by Status from other goroutines
+	mu          sync.Mutex
+	cancel      context.CancelFunc
+	connected   bool
+	connectedAt time.Time
+	books       map[string]BookStatus
+}
+
+// Init prepares b for a client of platform writing to db, nil records nothing.
+func (b *Base) Init(platform string, db store.Store) {
+	b.platform = platform
+	b.DB = db
+	b.Products = []string{}
+	b.ProductInfos = []*product_info.Info{}
+	b.BatchWrite = map[string]*util.BookBatchWrite{}
+	b.Checkpoint = util.DefaultCheckpointPolicy
+	b.Messages = map[string]*uint64{}
+	b.Fetcher = capture.HTTP{}
+	b.books = map[string]BookStatus{}
+}
+
+// AddProductInfo adds the batch and message counter of product name.
+func (b *Base) AddProductInfo(name string, info product_info.Info) {
+	b.Products = append(b.Products, name)
+	b.ProductInfos = append(b.ProductInfos, &info)
+	b.BatchWrite[name] = util.NewBookBatchWrite(b.Checkpoint)
+	b.BatchWrite[name].Compress = b.Compress
+	b.Messages[name] = new(uint64)
+}
+
+func (b *Base) Name() string {
+	return b.platform
+}
+
+func (b *Base) Infos() []*product_info.Info {
+	return b.ProductInfos
+}
+
+// SetCheckpointPolicy changes when sync packets are written, call it before Run.
+func (b *Base) SetCheckpointPolicy(policy util.CheckpointPolicy) {
+	b.Checkpoint = policy
+	for _, batch := range b.BatchWrite {
+		batch.Policy = policy
+	}
+}
+
+// SetCompression enables compressed packets for all products, call it before Run.
+func (b *Base) SetCompression(enabled bool) {
+	b.Compress = enabled
+	for _, batch := range b.BatchWrite {
+		batch.Compress = enabled
+	}
+}
+
+// SetCapture logs every websocket frame and REST response to w, call it before Run.
+func (b *Base) SetCapture(w *capture.Writer) {
+	b.Capture = w
+	b.Fetcher = capture.HTTP{Log: w}
+	w.Products(time.Now(), b.platform, b.Products, b.ProductInfos)
+}
+
+// SetFetcher replaces where REST responses come from, a capture.Reader replays them.
+func (b *Base) SetFetcher(f capture.Fetcher) {
+	b.Fetcher = f
+}
+
+// CountMessage counts a message of the book of product id.
+func (b *Base) CountMessage(id string) {
+	atomic.AddUint64(b.Messages[id], 1)
+}
+
+// SetConnected records a new connection at now for Status.
+func (b *Base) SetConnected(now time.Time) {
+	b.mu.Lock()
+	b.connected = true
+	b.connectedAt = now
+	b.mu.Unlock()
+}
+
+// SetBookStatus records the state of the book of product id for Status.
+func (b *Base) SetBookStatus(id string, synced bool, sequence uint64) {
+	b.mu.Lock()
+	b.books[id] = BookStatus{Synced: synced, Sequence: sequence}
+	b.mu.Unlock()
+}
+
+// Status returns the state last published by the client goroutine, it is
+// safe to call while the client runs.
+func (b *Base) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := Status{
+		Platform:    b.platform,
+		Connected:   b.connected,
+		ConnectedAt: b.connectedAt,
+	}
+	for i, name := range b.Products {
+		book := b.books[name]
+		book.ID = name
+		book.DatabaseKey = b.ProductInfos[i].DatabaseKey
+		book.Messages = atomic.LoadUint64(b.Messages[name])
+		status.Books = append(status.Books, book)
+	}
+	return status
+}
+
+// Serve runs c until ctx is cancelled or Stop is called, reconnecting after
+// the connection is lost, and flushes the books before it returns.
+func (b *Base) Serve(ctx context.Context, c Conn) {
+	b.mu.Lock()
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.mu.Unlock()
+
+	if b.DB != nil {
+		for _, info := range b.ProductInfos {
+			if err := util.RecordCheckpointPolicy(b.DB, info.DatabaseKey, b.Checkpoint); err != nil {
+				fmt.Println("RecordCheckpointPolicy Error", err)
+			}
+			if err := util.RecordProductInfo(b.DB, *info); err != nil {
+				fmt.Println("RecordProductInfo Error", err)
+			}
+		}
+	}
+	for ctx.Err() == nil {
+		b.serve(ctx, c)
+	}
+	c.Flush(time.Now())
+}
+
+func (b *Base) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+func (b *Base) serve(ctx context.Context, c Conn) {
+	if err := c.Connect(); err != nil {
+		fmt.Println("failed to connect", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(1000 * time.Millisecond):
+		}
+		return
+	}
+	c.Publish()
+	defer b.disconnect()
+
+	// unblock ReadMessage when the context is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	socket := b.Socket
+	go func() {
+		select {
+		case <-ctx.Done():
+			socket.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		msgType, message, err := socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		now := time.Now()
+		b.Capture.Frame(now, message)
+		c.HandleFrame(now, message)
+		c.Publish()
+	}
+}
+
+func (b *Base) disconnect() {
+	b.Socket.Close()
+	b.mu.Lock()
+	b.connected = false
+	b.mu.Unlock()
+}
@@ -8,31 +8,33 @@ import (
 	"strconv"
 
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
-	"github.com/lian/gdax-bookmap/util"
 )
 
-var CachedInfo map[string]product_info.Info
-
-func init() {
-	FetchAllProductInfo()
-}
+// APIURL is the REST base url of the venue.
+var APIURL = "https://api.binance.com"
 
-func FetchAllProductInfo() {
-	CachedInfo = map[string]product_info.Info{}
+// CachedInfo holds the products of the venue once they have been fetched.
+var CachedInfo map[string]product_info.Info
 
-	res, err := http.Get("https://api.binance.com/api/v1/exchangeInfo")
+// FetchAllProductInfo loads the products of the venue into CachedInfo.
+func FetchAllProductInfo() error {
+	res, err := http.Get(APIURL + "/api/v1/exchangeInfo")
 	if err != nil {
-		fmt.Println("InitProduct error", err)
+		return err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
-
 	if err != nil {
-		fmt.Println("InitProduct error", err)
+		return err
 	}
 
 	var data map[string]interface{}
-	json.Unmarshal(body, &data)
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	infos := map[string]product_info.Info{}
 
 	if symbols, ok := data["symbols"].([]interface{}); ok {
 		for _, p := range symbols {
@@ -53,26 +55,42 @@ func FetchAllProductInfo() {
 			}
 
 			if filters, ok := i["filters"].([]interface{}); ok {
+				var found bool
 				for _, f := range filters {
 					fi := f.(map[string]interface{})
-					if fi["filterType"].(string) == "PRICE_FILTER" {
+					switch fi["filterType"].(string) {
+					case "PRICE_FILTER":
 						t, _ := strconv.ParseFloat(fi["minPrice"].(string), 64)
 						info.BaseMinSize = t
 						t, _ = strconv.ParseFloat(fi["maxPrice"].(string), 64)
 						info.BaseMaxSize = t
-						info.QuoteIncrement = info.BaseMinSize
-						info.FloatFormat = fmt.Sprintf("%%.%df", util.NumDecPlaces(float64(info.QuoteIncrement)))
-						CachedInfo[info.DisplayName] = info
-						break
+						t, _ = strconv.ParseFloat(fi["tickSize"].(string), 64)
+						info.QuoteIncrement = t
+						found = true
+					case "LOT_SIZE":
+						t, _ := strconv.ParseFloat(fi["stepSize"].(string), 64)
+						info.BaseIncrement = t
 					}
 				}
+				if found {
+					infos[info.DisplayName] = info
+				}
 			}
 
 		}
 	}
+
+	CachedInfo = infos
+	return nil
 }
 
 func FetchProductInfo(id string) product_info.Info {
+	if CachedInfo == nil {
+		if err := FetchAllProductInfo(); err != nil {
+			fmt.Println("InitProduct error", err)
+			return product_info.Info{}
+		}
+	}
 	if info, ok := CachedInfo[id]; ok {
 		return info
 	}
@@ -4,56 +4,39 @@ package websocket
 // https://github.com/binance-exchange/binance-official-api-docs/blob/master/rest-api.md
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
-	book_info "github.com/lian/gdax-bookmap/exchanges/binance/product_info"
+	"github.com/lian/gdax-bookmap/exchanges"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+// WebsocketURL is the stream endpoint, the stream names are appended as query.
+var WebsocketURL = "wss://stream2.binance.com:9443"
+
 type Client struct {
-	Socket      *websocket.Conn
-	Products    []string
-	Books       map[string]*orderbook.Book
-	ConnectedAt time.Time
-	DB          *bolt.DB
-	dbEnabled   bool
-	BatchWrite  map[string]*util.BookBatchWrite
-	Infos       []*product_info.Info
+	exchanges.Base
+	Books map[string]*orderbook.Book
 }
 
-func New(db *bolt.DB, products []string) *Client {
+func New(db store.Store, infos []*product_info.Info) *Client {
 	c := &Client{
-		Products:   []string{},
-		Books:      map[string]*orderbook.Book{},
-		BatchWrite: map[string]*util.BookBatchWrite{},
-		DB:         db,
-		Infos:      []*product_info.Info{},
-	}
-	if c.DB != nil {
-		c.dbEnabled = true
+		Books: map[string]*orderbook.Book{},
 	}
+	c.Init("Binance", db)
 
 	// https://api.binance.com/api/v1/exchangeInfo
 
-	for _, name := range products {
-		c.AddProduct(name)
-	}
-
-	if c.dbEnabled {
-		buckets := []string{}
-		for _, info := range c.Infos {
-			buckets = append(buckets, info.DatabaseKey)
-		}
-		util.CreateBucketsDB(c.DB, buckets)
+	for _, info := range infos {
+		c.AddProduct(*info)
 	}
 
 	return c
@@ -65,12 +48,10 @@ func streamNames(name string) (string, string) {
 	return id + "@depth", id + "@aggTrade"
 }
 
-func (c *Client) AddProduct(name string) {
-	c.Products = append(c.Products, name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
+func (c *Client) AddProduct(info product_info.Info) {
+	name := info.DisplayName
 	book := orderbook.New(name)
-	info := book_info.FetchProductInfo(name)
-	c.Infos = append(c.Infos, &info)
+	c.AddProductInfo(name, info)
 	book.SetProductInfo(info)
 	diff_channel, trades_channel := streamNames(info.ID)
 	c.Books[diff_channel] = book
@@ -83,7 +64,7 @@ func (c *Client) Connect() error {
 		streams = append(streams, channel)
 	}
 	//url := "wss://stream.binance.com:9443/stream?streams=" + strings.Join(streams, "/")
-	url := "wss://stream2.binance.com:9443/stream?streams=" + strings.Join(streams, "/")
+	url := WebsocketURL + "/stream?streams=" + strings.Join(streams, "/")
 
 	fmt.Println("connect to websocket", url)
 	s, _, err := websocket.DefaultDialer.Dial(url, nil)
@@ -93,11 +74,19 @@ func (c *Client) Connect() error {
 	}
 
 	c.Socket = s
-	c.ConnectedAt = time.Now()
+	now := time.Now()
+	c.Capture.Connect(now, url)
+	c.HandleConnect(now)
 
 	return nil
 }
 
+// HandleConnect applies a new connection at now, the books keep their
+// sequence and resync on the first gap.
+func (c *Client) HandleConnect(now time.Time) {
+	c.SetConnected(now)
+}
+
 type PacketHeader struct {
 	Stream string          `json:"stream"`
 	Data   json.RawMessage `json:"data"`
@@ -117,9 +106,9 @@ type PacketAggTrade struct {
 	//EventType        string `json:"e"`
 	//EventTime        int    `json:"E"`
 	//Symbol           string `json:"s"`
-	//AggregateTradeID int    `json:"a"`
-	//TradeTime        int    `json:"T"`
-	//BuyMaker      bool   `json:"m"`
+	AggregateTradeID uint64 `json:"a"`
+	TradeTime        int64  `json:"T"`
+	BuyerMaker       bool   `json:"m"`
 	//Ignore        bool   `json:"M"`
 	Price         string `json:"p"`
 	Quantity      string `json:"q"`
@@ -150,7 +139,9 @@ func (c *Client) UpdateSync(book *orderbook.Book, first, last uint64) error {
 	return nil
 }
 
-func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
+func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage, now time.Time) {
+	c.CountMessage(book.ID)
+
 	var tmp map[string]interface{}
 	if err := json.Unmarshal(raw, &tmp); err != nil {
 		log.Println("PacketEventType-parse:", err)
@@ -186,18 +177,25 @@ func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
 			fmt.Println(err)
 			return
 		}
+		book.ExchangeTime = eventTime
 
 		for _, d := range depthUpdate.Bids {
 			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
+			price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+			if err != nil {
+				fmt.Println("skip level", err)
+				continue
+			}
 			book.UpdateBidLevel(eventTime, price, size)
 		}
 
 		for _, d := range depthUpdate.Asks {
 			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
+			price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+			if err != nil {
+				fmt.Println("skip level", err)
+				continue
+			}
 			book.UpdateAskLevel(eventTime, price, size)
 		}
 
@@ -208,11 +206,16 @@ func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
 			return
 		}
 
-		price, _ := strconv.ParseFloat(data.Price, 64)
-		size, _ := strconv.ParseFloat(data.Quantity, 64)
+		price, size, err := book.ParseLevel(data.Price, data.Quantity)
+		if err != nil {
+			fmt.Println("skip trade", err)
+			return
+		}
 
-		side := book.GetSide(price)
-		book.AddTrade(eventTime, side, price, size)
+		// m is set when the buyer was the maker, the taker sold then
+		side := orderbook.TakerSide(!data.BuyerMaker)
+		tradeTime := time.Unix(0, data.TradeTime*int64(time.Millisecond))
+		book.AddTakerTrade(eventTime, side, price, size, data.AggregateTradeID, tradeTime)
 		trade = book.Trades[len(book.Trades)-1]
 
 	default:
@@ -220,11 +223,10 @@ func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
 		return
 	}
 
-	if c.dbEnabled {
+	if c.DB != nil {
 		batch := c.BatchWrite[book.ID]
-		now := time.Now()
 		if trade != nil {
-			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(trade))
+			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(book, trade))
 		}
 
 		if batch.NextSync(now) {
@@ -243,7 +245,7 @@ func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now
 	book.FixBookLevels() // TODO fix/remove
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
-		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		pkt := orderbook.PackDiff(book, batch.LastDiffSeq, book.Sequence)
 		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
@@ -257,50 +259,45 @@ func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
-func (c *Client) Run() {
-	for {
-		c.run()
-	}
+func (c *Client) Run(ctx context.Context) {
+	c.Serve(ctx, c)
 }
 
-func (c *Client) run() {
-	if err := c.Connect(); err != nil {
-		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+// Flush stores pending book diffs at now and writes all buffered chunks to the database.
+func (c *Client) Flush(now time.Time) {
+	if c.DB == nil {
 		return
 	}
-
-	defer c.Socket.Close()
-
-	for {
-		msgType, message, err := c.Socket.ReadMessage()
-		if err != nil {
-			log.Println("read:", err)
-			return
+	for _, book := range c.Books {
+		batch := c.BatchWrite[book.ID]
+		if book.Synced {
+			c.WriteDiff(batch, book, now)
 		}
-
-		if msgType != websocket.TextMessage {
-			continue
-		}
-
-		var pkt PacketHeader
-		if err := json.Unmarshal(message, &pkt); err != nil {
-			log.Println("PacketHeader-parse:", err)
-			continue
+		if err := batch.Flush(c.DB, book.ProductInfo.DatabaseKey); err != nil {
+			fmt.Println("Flush DB Error", book.ID, err)
 		}
+	}
+}
 
-		var book *orderbook.Book
-		var ok bool
-		if book, ok = c.Books[pkt.Stream]; !ok {
-			log.Println("book not found", pkt.Stream)
-			continue
-		}
+// HandleFrame routes a websocket text frame received at now to its book.
+func (c *Client) HandleFrame(now time.Time, message []byte) {
+	var pkt PacketHeader
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		log.Println("PacketHeader-parse:", err)
+		return
+	}
 
-		if book.Sequence == 0 {
-			c.SyncBook(book)
-			continue
-		}
+	var book *orderbook.Book
+	var ok bool
+	if book, ok = c.Books[pkt.Stream]; !ok {
+		log.Println("book not found", pkt.Stream)
+		return
+	}
 
-		c.HandleMessage(book, pkt.Data)
+	if book.Sequence == 0 {
+		c.SyncBook(book)
+		return
 	}
+
+	c.HandleMessage(book, pkt.Data, now)
 }
@@ -3,12 +3,9 @@ package websocket
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strconv"
 	"strings"
-	"time"
 
+	book_info "github.com/lian/gdax-bookmap/exchanges/binance/product_info"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
 )
 
@@ -16,12 +13,8 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 	fmt.Println("sync", book.ID)
 
 	//url := fmt.Sprintf("https://www.binance.com/api/v1/depth?symbol=%s&limit=1000", strings.ToUpper(book.ProductInfo.ID))
-	url := fmt.Sprintf("https://api.binance.com/api/v1/depth?symbol=%s&limit=1000", strings.ToUpper(book.ProductInfo.ID))
-	res, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	body, err := ioutil.ReadAll(res.Body)
+	url := fmt.Sprintf("%s/api/v1/depth?symbol=%s&limit=1000", book_info.APIURL, strings.ToUpper(book.ProductInfo.ID))
+	body, now, err := c.Fetcher.Fetch(url)
 	if err != nil {
 		return err
 	}
@@ -36,29 +29,32 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 		book.Clear()
 		book.Sequence = uint64(seq.(float64))
 
-		t := time.Now()
-
 		if bids, ok := data["bids"].([]interface{}); ok {
 			for i := len(bids) - 1; i >= 0; i-- {
 				data := bids[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				quantity, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateBidLevel(t, price, quantity)
+				price, quantity, err := book.ParseLevel(data[0].(string), data[1].(string))
+				if err != nil {
+					fmt.Println("skip level", err)
+					continue
+				}
+				book.UpdateBidLevel(now, price, quantity)
 			}
 		}
 
 		if asks, ok := data["asks"].([]interface{}); ok {
 			for i := len(asks) - 1; i >= 0; i-- {
 				data := asks[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				quantity, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateAskLevel(t, price, quantity)
+				price, quantity, err := book.ParseLevel(data[0].(string), data[1].(string))
+				if err != nil {
+					fmt.Println("skip level", err)
+					continue
+				}
+				book.UpdateAskLevel(now, price, quantity)
 			}
 		}
 
-		if c.dbEnabled {
+		if c.DB != nil {
 			batch := c.BatchWrite[book.ID]
-			now := time.Now()
 			fmt.Println("STORE INIT SYNC", book.ID, book.Sequence, batch.Count)
 			c.WriteSync(batch, book, now)
 		}
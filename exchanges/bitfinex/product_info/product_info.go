@@ -11,28 +11,31 @@ import (
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 )
 
-var CachedInfo map[string]product_info.Info
-
-func init() {
-	FetchAllProductInfo()
-}
+// APIURL is the REST base url of the venue.
+var APIURL = "https://api.bitfinex.com"
 
-func FetchAllProductInfo() {
-	CachedInfo = map[string]product_info.Info{}
+// CachedInfo holds the products of the venue once they have been fetched.
+var CachedInfo map[string]product_info.Info
 
-	res, err := http.Get("https://api.bitfinex.com/v1/symbols_details")
+// FetchAllProductInfo loads the products of the venue into CachedInfo.
+func FetchAllProductInfo() error {
+	res, err := http.Get(APIURL + "/v1/symbols_details")
 	if err != nil {
-		fmt.Println("InitProduct error", err)
+		return err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
-
 	if err != nil {
-		fmt.Println("InitProduct error", err)
+		return err
 	}
 
 	var data []interface{}
-	json.Unmarshal(body, &data)
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	infos := map[string]product_info.Info{}
 
 	for _, d := range data {
 		i := d.(map[string]interface{})
@@ -61,19 +64,28 @@ func FetchAllProductInfo() {
 		t, _ = strconv.ParseFloat(i["maximum_order_size"].(string), 64)
 		info.BaseMaxSize = t
 
-		info.QuoteIncrement = info.BaseMinSize
-		//info.FloatFormat = fmt.Sprintf("%%.%df", util.NumDecPlaces(float64(info.QuoteIncrement)))
+		// prices have 5 significant digits, there is no fixed tick size
 		if info.QuoteCurrency == "USD" || info.QuoteCurrency == "EUR" {
-			info.FloatFormat = "%.2f"
+			info.QuoteIncrement = 0.01
 		} else {
-			info.FloatFormat = "%.5f"
+			info.QuoteIncrement = 0.00000001
 		}
+		info.BaseIncrement = 0.00000001
 
-		CachedInfo[info.DisplayName] = info
+		infos[info.DisplayName] = info
 	}
+
+	CachedInfo = infos
+	return nil
 }
 
 func FetchProductInfo(id string) product_info.Info {
+	if CachedInfo == nil {
+		if err := FetchAllProductInfo(); err != nil {
+			fmt.Println("InitProduct error", err)
+			return product_info.Info{}
+		}
+	}
 	if info, ok := CachedInfo[id]; ok {
 		return info
 	}
@@ -4,73 +4,56 @@ package websocket
 // api version 2: https://docs.bitfinex.com/v2/reference#ws-public-order-books
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
-	book_info "github.com/lian/gdax-bookmap/exchanges/bitfinex/product_info"
+	"github.com/lian/gdax-bookmap/exchanges"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+// WebsocketURL is the v2 websocket endpoint.
+var WebsocketURL = "wss://api.bitfinex.com/ws/2"
+
 type Client struct {
-	Platform      string
-	Socket        *websocket.Conn
-	Products      []string
+	exchanges.Base
 	Books         map[string]*orderbook.Book
-	ConnectedAt   time.Time
-	DB            *bolt.DB
-	dbEnabled     bool
-	BatchWrite    map[string]*util.BookBatchWrite
-	Infos         []*product_info.Info
 	Subscriptions map[int]SubscriptionInfo
 }
 
-func New(db *bolt.DB, products []string) *Client {
+func New(db store.Store, infos []*product_info.Info) *Client {
 	c := &Client{
-		Platform:      "Bitfinex",
-		Products:      []string{},
 		Books:         map[string]*orderbook.Book{},
-		BatchWrite:    map[string]*util.BookBatchWrite{},
-		DB:            db,
-		Infos:         []*product_info.Info{},
 		Subscriptions: map[int]SubscriptionInfo{},
 	}
-	if c.DB != nil {
-		c.dbEnabled = true
-	}
+	c.Init("Bitfinex", db)
 
-	for _, name := range products {
-		c.AddProduct(name)
-	}
-
-	if c.dbEnabled {
-		buckets := []string{}
-		for _, info := range c.Infos {
-			buckets = append(buckets, info.DatabaseKey)
-		}
-		util.CreateBucketsDB(c.DB, buckets)
+	for _, info := range infos {
+		c.AddProduct(*info)
 	}
 
 	return c
 }
 
-func (c *Client) AddProduct(name string) {
-	c.Products = append(c.Products, name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
+func (c *Client) AddProduct(info product_info.Info) {
+	name := info.DisplayName
 	book := orderbook.New(name)
-	info := book_info.FetchProductInfo(name)
-	c.Infos = append(c.Infos, &info)
+	c.AddProductInfo(name, info)
 	book.SetProductInfo(info)
 	id := fmt.Sprintf("t%s%s", info.BaseCurrency, info.QuoteCurrency)
 	c.Books[id] = book
 }
 
+// timestampFlag makes bitfinex append the event time in milliseconds to every message
+const timestampFlag = 32768
+
 type WebsocketHandshake struct {
 	Event   string  `json:"event"`
 	Code    int64   `json:"code"`
@@ -93,7 +76,7 @@ func (c *Client) Subscribe(channel string, params map[string]string) {
 
 func (c *Client) Connect() error {
 	//url := "wss://api.bitfinex.com/ws"
-	url := "wss://api.bitfinex.com/ws/2"
+	url := WebsocketURL
 	fmt.Println("connect to websocket", url)
 	s, _, err := websocket.DefaultDialer.Dial(url, nil)
 
@@ -105,6 +88,7 @@ func (c *Client) Connect() error {
 	if err != nil {
 		return err
 	}
+	now := time.Now()
 
 	if msgType != websocket.TextMessage {
 		return fmt.Errorf("invalid websocket message")
@@ -116,13 +100,17 @@ func (c *Client) Connect() error {
 	}
 
 	if hs.Event == "info" {
-		log.Println(c.Platform, "Connected")
+		log.Println(c.Name(), "Connected")
 	} else {
 		return fmt.Errorf("no handshake")
 	}
 
 	c.Socket = s
-	c.ConnectedAt = time.Now()
+	c.Capture.Connect(now, url)
+	c.Capture.Frame(now, resp)
+	c.HandleConnect(now)
+
+	c.Socket.WriteJSON(map[string]interface{}{"event": "conf", "flags": timestampFlag})
 
 	for _, channel := range []string{"book", "trades"} {
 		for symbol, _ := range c.Books {
@@ -140,11 +128,30 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// HandleConnect applies a new connection at now, channel ids are assigned
+// again by the subscriptions.
+func (c *Client) HandleConnect(now time.Time) {
+	c.SetConnected(now)
+	c.Subscriptions = map[int]SubscriptionInfo{}
+}
+
+// messageTime returns the timestamp added by timestampFlag at index i of a message.
+func messageTime(data []interface{}, i int) (time.Time, bool) {
+	if len(data) <= i {
+		return time.Time{}, false
+	}
+	ms, ok := data[i].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)), true
+}
+
 func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
 	book.FixBookLevels() // TODO fix/remove
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
-		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		pkt := orderbook.PackDiff(book, batch.LastDiffSeq, book.Sequence)
 		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
@@ -158,9 +165,23 @@ func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
-func (c *Client) Run() {
-	for {
-		c.run()
+func (c *Client) Run(ctx context.Context) {
+	c.Serve(ctx, c)
+}
+
+// Flush stores pending book diffs at now and writes all buffered chunks to the database.
+func (c *Client) Flush(now time.Time) {
+	if c.DB == nil {
+		return
+	}
+	for _, book := range c.Books {
+		batch := c.BatchWrite[book.ID]
+		if book.Synced {
+			c.WriteDiff(batch, book, now)
+		}
+		if err := batch.Flush(c.DB, book.ProductInfo.DatabaseKey); err != nil {
+			fmt.Println("Flush DB Error", book.ID, err)
+		}
 	}
 }
 
@@ -171,171 +192,166 @@ type SubscriptionInfo struct {
 
 func (c *Client) AddSubscriptionChannel(chanID int, channel, symbol string) {
 	c.Subscriptions[chanID] = SubscriptionInfo{Symbol: symbol, Channel: channel}
-	log.Printf("%s Subscribed to Channel: %s Symbol: %s ChannelID: %d\n", c.Platform, channel, symbol, chanID)
+	log.Printf("%s Subscribed to Channel: %s Symbol: %s ChannelID: %d\n", c.Name(), channel, symbol, chanID)
 }
 
-func (c *Client) run() {
-	if err := c.Connect(); err != nil {
-		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+// HandleFrame handles a websocket text frame received at now.
+func (c *Client) HandleFrame(now time.Time, message []byte) {
+	var pkt interface{}
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		log.Println("PacketHeader-parse:", err)
 		return
 	}
 
-	defer c.Socket.Close()
+	if eventData, ok := pkt.(map[string]interface{}); ok {
+		if event, ok := eventData["event"].(string); ok {
+			switch event {
+			case "subscribed":
+				c.AddSubscriptionChannel(int(eventData["chanId"].(float64)), eventData["channel"].(string), eventData["symbol"].(string))
+			case "info":
+				// the handshake, logged by Connect
+			case "conf":
+				log.Println(c.Name(), "conf", eventData["status"])
+			default:
+				fmt.Println("unkown event", eventData)
+			}
+		}
+		return
+	}
 
-	for {
-		msgType, message, err := c.Socket.ReadMessage()
-		if err != nil {
-			log.Println("read:", err)
+	if data, ok := pkt.([]interface{}); ok {
+		if len(data) == 0 {
 			return
 		}
 
-		if msgType != websocket.TextMessage {
-			continue
-		}
+		chanInfo, ok := c.Subscriptions[int(data[0].(float64))]
 
-		var pkt interface{}
-		if err := json.Unmarshal(message, &pkt); err != nil {
-			log.Println("PacketHeader-parse:", err)
-			continue
+		if !ok {
+			log.Println("Unable to locate chanID", int(data[0].(float64)))
+			return
 		}
 
-		if eventData, ok := pkt.(map[string]interface{}); ok {
-			if event, ok := eventData["event"].(string); ok {
-				switch event {
-				case "subscribed":
-					c.AddSubscriptionChannel(int(eventData["chanId"].(float64)), eventData["channel"].(string), eventData["symbol"].(string))
-				default:
-					fmt.Println("unkown event", eventData)
-				}
+		if len(data) >= 2 {
+			if text, ok := data[1].(string); ok && text == "hb" {
+				// received heartbeat
+				return
 			}
-			continue
 		}
 
-		if data, ok := pkt.([]interface{}); ok {
-			if len(data) == 0 {
-				continue
-			}
+		book := c.Books[chanInfo.Symbol]
+		c.CountMessage(book.ID)
+		//fmt.Println(book.ProductInfo.DatabaseKey, chanInfo.Channel, data)
 
-			chanInfo, ok := c.Subscriptions[int(data[0].(float64))]
+		var trade *orderbook.Trade
 
-			if !ok {
-				log.Println("Unable to locate chanID", int(data[0].(float64)))
-				continue
-			}
+		//fmt.Println(chanInfo.Channel, data)
 
-			if len(data) == 2 {
-				if text, ok := data[1].(string); ok && text == "hb" {
-					// received heartbeat
-					continue
-				}
+		switch chanInfo.Channel {
+		case "book":
+			if len(data) != 2 && len(data) != 3 {
+				fmt.Println("wrong book packet length", chanInfo)
+			}
+			if t, ok := messageTime(data, 2); ok {
+				book.ExchangeTime = t
 			}
 
-			book := c.Books[chanInfo.Symbol]
-			//fmt.Println(book.ProductInfo.DatabaseKey, chanInfo.Channel, data)
-			now := time.Now()
-
-			var trade *orderbook.Trade
+			list := data[1].([]interface{})
 
-			//fmt.Println(chanInfo.Channel, data)
+			if _, ok := list[0].(float64); ok {
+				// update
 
-			switch chanInfo.Channel {
-			case "book":
-				if len(data) != 2 {
-					fmt.Println("wrong book packet length", chanInfo)
+				price, count, amount := list[0].(float64), list[1].(float64), list[2].(float64)
+				ask := amount < 0
+				amount = math.Abs(amount)
+				if count == 0 {
+					amount = 0
 				}
-
-				list := data[1].([]interface{})
-
-				if _, ok := list[0].(float64); ok {
-					// update
-
-					price, count, amount := list[0].(float64), list[1].(float64), list[2].(float64)
-					if amount < 0 {
-						// ask
-						amount = math.Abs(amount)
-						if count == 0 {
-							amount = 0
-						}
-						book.UpdateAskLevel(now, price, amount)
-					} else {
-						// bid
-						if count == 0 {
-							amount = 0
-						}
-						book.UpdateBidLevel(now, price, amount)
-					}
-				} else {
-					// snapshot
-
-					book.Clear()
-					//book.Sequence = uint64(now.Unix())
-					book.Sequence = uint64(0)
-
-					for _, item := range list {
-						values := item.([]interface{})
-						price, count, amount := values[0].(float64), values[1].(float64), values[2].(float64)
-
-						if amount < 0 {
-							// ask
-							amount = math.Abs(amount)
-							if count == 0 {
-								amount = 0
-							}
-							book.UpdateAskLevel(now, price, amount)
-						} else {
-							// bid
-							if count == 0 {
-								amount = 0
-							}
-							book.UpdateBidLevel(now, price, amount)
-						}
-					}
+				p, size, err := book.FloatLevel(price, amount)
+				if err != nil {
+					fmt.Println("skip level", err)
+					return
 				}
-			case "trades":
-				if len(data) != 3 {
-					// skip snapshot
-					//fmt.Println("wrong trades packet length", chanInfo, data)
+				if ask {
+					book.UpdateAskLevel(now, p, size)
+				} else {
+					book.UpdateBidLevel(now, p, size)
 				}
-
-				if pktType, ok := data[1].(string); ok && pktType == "te" {
-					values := data[2].([]interface{})
-					amount, price := values[2].(float64), values[3].(float64)
-					if amount < 0 {
-						// sell
-						amount = math.Abs(amount)
-						book.AddTrade(now, uint8(orderbook.BidSide), price, amount)
+			} else {
+				// snapshot
+
+				book.Clear()
+				//book.Sequence = uint64(now.Unix())
+				book.Sequence = uint64(0)
+				book.Synced = true
+
+				for _, item := range list {
+					values := item.([]interface{})
+					price, count, amount := values[0].(float64), values[1].(float64), values[2].(float64)
+					ask := amount < 0
+					amount = math.Abs(amount)
+					if count == 0 {
+						amount = 0
+					}
+					p, size, err := book.FloatLevel(price, amount)
+					if err != nil {
+						fmt.Println("skip level", err)
+						continue
+					}
+					if ask {
+						book.UpdateAskLevel(now, p, size)
 					} else {
-						// buy
-						book.AddTrade(now, uint8(orderbook.AskSide), price, amount)
+						book.UpdateBidLevel(now, p, size)
 					}
-					trade = book.Trades[len(book.Trades)-1]
 				}
 
-			default:
-				fmt.Println("unkown channel", chanInfo)
+				if c.DB != nil {
+					// levels missing from a resync snapshot are only dropped by a sync packet
+					c.WriteSync(c.BatchWrite[book.ID], book, now)
+				}
+			}
+		case "trades":
+			if len(data) != 3 {
+				// skip snapshot
+				//fmt.Println("wrong trades packet length", chanInfo, data)
 			}
 
-			book.Sequence += 1
-
-			if c.dbEnabled {
-				batch := c.BatchWrite[book.ID]
-				now := time.Now()
-				if trade != nil {
-					batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(trade))
+			if pktType, ok := data[1].(string); ok && pktType == "te" {
+				// [ID, MTS, AMOUNT, PRICE], a negative amount is a taker sell
+				values := data[2].([]interface{})
+				id, mts := values[0].(float64), values[1].(float64)
+				amount, price := values[2].(float64), values[3].(float64)
+				tradeTime := time.Unix(0, int64(mts)*int64(time.Millisecond))
+				side := orderbook.TakerSide(amount >= 0)
+				p, size, err := book.FloatLevel(price, math.Abs(amount))
+				if err != nil {
+					fmt.Println("skip trade", err)
+					return
 				}
+				book.AddTakerTrade(now, side, p, size, uint64(id), tradeTime)
+				trade = book.Trades[len(book.Trades)-1]
+			}
 
-				if batch.NextSync(now) {
-					fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
-					c.WriteSync(batch, book, now)
-				} else {
-					if batch.NextDiff(now) {
-						//fmt.Println("STORE DIFF", book.ProductInfo.DatabaseKey, batch.Count)
-						c.WriteDiff(batch, book, now)
-					}
-				}
+		default:
+			fmt.Println("unkown channel", chanInfo)
+		}
+
+		book.Sequence += 1
+
+		if c.DB != nil {
+			batch := c.BatchWrite[book.ID]
+			if trade != nil {
+				batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(book, trade))
 			}
 
+			if batch.NextSync(now) {
+				fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+				c.WriteSync(batch, book, now)
+			} else {
+				if batch.NextDiff(now) {
+					//fmt.Println("STORE DIFF", book.ProductInfo.DatabaseKey, batch.Count)
+					c.WriteDiff(batch, book, now)
+				}
+			}
 		}
 	}
 }
@@ -1,12 +1,12 @@
 package product_info
 
 import (
-	"fmt"
-
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
-	"github.com/lian/gdax-bookmap/util"
 )
 
+// APIURL is the REST base url of the venue.
+var APIURL = "https://www.bitstamp.net"
+
 var CachedInfo map[string]product_info.Info
 
 func init() {
@@ -21,7 +21,7 @@ func init() {
 			BaseMinSize:    0,
 			BaseMaxSize:    0,
 			QuoteIncrement: 0.01,
-			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(0.01)),
+			BaseIncrement:  0.00000001,
 		},
 		"ETH-USD": product_info.Info{
 			Platform:       "Bitstamp",
@@ -33,7 +33,7 @@ func init() {
 			BaseMinSize:    0,
 			BaseMaxSize:    0,
 			QuoteIncrement: 0.01,
-			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(0.01)),
+			BaseIncrement:  0.00000001,
 		},
 		"LTC-USD": product_info.Info{
 			Platform:       "Bitstamp",
@@ -45,7 +45,7 @@ func init() {
 			BaseMinSize:    0,
 			BaseMaxSize:    0,
 			QuoteIncrement: 0.01,
-			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(0.01)),
+			BaseIncrement:  0.00000001,
 		},
 		"XRP-USD": product_info.Info{
 			Platform:       "Bitstamp",
@@ -57,7 +57,7 @@ func init() {
 			BaseMinSize:    0,
 			BaseMaxSize:    0,
 			QuoteIncrement: 0.01,
-			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(0.01)),
+			BaseIncrement:  0.00000001,
 		},
 		"BCH-USD": product_info.Info{
 			Platform:       "Bitstamp",
@@ -69,7 +69,7 @@ func init() {
 			BaseMinSize:    0,
 			BaseMaxSize:    0,
 			QuoteIncrement: 0.01,
-			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(0.01)),
+			BaseIncrement:  0.00000001,
 		},
 		"BCH-EUR": product_info.Info{
 			Platform:       "Bitstamp",
@@ -81,7 +81,7 @@ func init() {
 			BaseMinSize:    0,
 			BaseMaxSize:    0,
 			QuoteIncrement: 0.01,
-			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(0.01)),
+			BaseIncrement:  0.00000001,
 		},
 	}
 	// btcusd, btceur, eurusd, xrpusd, xrpeur, xrpbtc, ltcusd, ltceur, ltcbtc, ethusd, etheur, ethbtc, bchusd, bcheur, bchbtc
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -8,62 +9,40 @@ import (
 	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
 
-	book_info "github.com/lian/gdax-bookmap/exchanges/bitstamp/product_info"
+	"github.com/lian/gdax-bookmap/exchanges"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+// WebsocketURL is the pusher app endpoint bitstamp streams through.
+var WebsocketURL = "wss://ws.pusherapp.com/app/de504dc5763aeef9ff52?protocol=7&client=js&version=2.1.6&flash=false"
+
 type Client struct {
-	Products    []string
-	Books       map[string]*orderbook.Book
-	Socket      *websocket.Conn
-	DB          *bolt.DB
-	dbEnabled   bool
-	LastSync    time.Time
-	LastDiff    time.Time
-	LastDiffSeq uint64
-	BatchWrite  map[string]*util.BookBatchWrite
-	Infos       []*product_info.Info
+	exchanges.Base
+	Books map[string]*orderbook.Book
 }
 
-func New(db *bolt.DB, products []string) *Client {
+func New(db store.Store, infos []*product_info.Info) *Client {
 	c := &Client{
-		Products:   []string{},
-		Books:      map[string]*orderbook.Book{},
-		BatchWrite: map[string]*util.BookBatchWrite{},
-		DB:         db,
-		Infos:      []*product_info.Info{},
-	}
-
-	if c.DB != nil {
-		c.dbEnabled = true
+		Books: map[string]*orderbook.Book{},
 	}
+	c.Init("Bitstamp", db)
 
-	for _, name := range products {
-		c.AddProduct(name)
-	}
-
-	if c.dbEnabled {
-		buckets := []string{}
-		for _, info := range c.Infos {
-			buckets = append(buckets, info.DatabaseKey)
-		}
-		util.CreateBucketsDB(db, buckets)
+	for _, info := range infos {
+		c.AddProduct(*info)
 	}
 
 	return c
 }
 
-func (c *Client) AddProduct(name string) {
-	c.Products = append(c.Products, name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
+func (c *Client) AddProduct(info product_info.Info) {
+	name := info.DisplayName
 	book := orderbook.New(name)
-	info := book_info.FetchProductInfo(name)
-	c.Infos = append(c.Infos, &info)
+	c.AddProductInfo(name, info)
 	book.SetProductInfo(info)
 	diff_channel, trades_channel := c.GetChannelNames(book)
 	c.Books[diff_channel] = book
@@ -71,7 +50,7 @@ func (c *Client) AddProduct(name string) {
 }
 
 func (c *Client) Connect() error {
-	url := "wss://ws.pusherapp.com/app/de504dc5763aeef9ff52?protocol=7&client=js&version=2.1.6&flash=false"
+	url := WebsocketURL
 	fmt.Println("connect to websocket", url)
 	s, _, err := websocket.DefaultDialer.Dial(url, nil)
 
@@ -80,6 +59,9 @@ func (c *Client) Connect() error {
 	}
 
 	c.Socket = s
+	now := time.Now()
+	c.Capture.Connect(now, url)
+	c.HandleConnect(now)
 
 	for channel, _ := range c.Books {
 		c.Subscribe(channel)
@@ -88,6 +70,17 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// HandleConnect applies a new connection at now to the books.
+func (c *Client) HandleConnect(now time.Time) {
+	c.SetConnected(now)
+
+	// updates missed while disconnected are only recovered by a new snapshot
+	for _, book := range c.Books {
+		book.Sequence = 0
+		book.Synced = false
+	}
+}
+
 func (c *Client) Subscribe(channel string) {
 	a := map[string]interface{}{"event": "pusher:subscribe", "data": map[string]interface{}{"channel": channel}}
 	c.Socket.WriteJSON(a)
@@ -108,6 +101,23 @@ type Packet struct {
 	Data    string `json:"data"`
 }
 
+type PacketTrade struct {
+	ID             uint64 `json:"id"`
+	Price          string `json:"price_str"`
+	Amount         string `json:"amount_str"`
+	Type           int    `json:"type"` // 0 buy, 1 sell
+	Microtimestamp string `json:"microtimestamp"`
+}
+
+// parseMicrotimestamp reads the unix microseconds of bitstamp events, zero if invalid.
+func parseMicrotimestamp(value string) time.Time {
+	usec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, usec*int64(time.Microsecond))
+}
+
 func (c *Client) UpdateSync(book *orderbook.Book, last uint64) error {
 	seq := book.Sequence
 
@@ -119,8 +129,9 @@ func (c *Client) UpdateSync(book *orderbook.Book, last uint64) error {
 	return nil
 }
 
-func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet) {
-	eventTime := time.Now()
+func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet, now time.Time) {
+	c.CountMessage(book.ID)
+
 	var trade *orderbook.Trade
 
 	switch pkt.Event {
@@ -138,33 +149,44 @@ func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet) {
 			fmt.Println(err)
 			return
 		}
+		if usec, ok := data["microtimestamp"].(string); ok {
+			book.ExchangeTime = parseMicrotimestamp(usec)
+		}
 
 		for _, d := range data["bids"].([]interface{}) {
 			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
-			book.UpdateBidLevel(eventTime, price, size)
+			price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+			if err != nil {
+				fmt.Println("skip level", err)
+				continue
+			}
+			book.UpdateBidLevel(now, price, size)
 		}
 
 		for _, d := range data["asks"].([]interface{}) {
 			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
-			book.UpdateAskLevel(eventTime, price, size)
+			price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+			if err != nil {
+				fmt.Println("skip level", err)
+				continue
+			}
+			book.UpdateAskLevel(now, price, size)
 		}
 
 	case "trade":
-		var data map[string]interface{}
+		var data PacketTrade
 		if err := json.Unmarshal([]byte(pkt.Data), &data); err != nil {
 			log.Println(err)
 			return
 		}
 
-		price, _ := strconv.ParseFloat(data["price_str"].(string), 64)
-		size, _ := strconv.ParseFloat(data["amount_str"].(string), 64)
-		side := book.GetSide(price)
-
-		book.AddTrade(eventTime, side, price, size)
+		price, size, err := book.ParseLevel(data.Price, data.Amount)
+		if err != nil {
+			fmt.Println("skip trade", err)
+			return
+		}
+		side := orderbook.TakerSide(data.Type == 0)
+		book.AddTakerTrade(now, side, price, size, data.ID, parseMicrotimestamp(data.Microtimestamp))
 		trade = book.Trades[len(book.Trades)-1]
 
 	default:
@@ -172,11 +194,10 @@ func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet) {
 		return
 	}
 
-	if c.dbEnabled {
+	if c.DB != nil {
 		batch := c.BatchWrite[book.ID]
-		now := time.Now()
 		if trade != nil {
-			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(trade))
+			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(book, trade))
 		}
 
 		if batch.NextSync(now) {
@@ -195,7 +216,7 @@ func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now
 	book.FixBookLevels() // TODO fix/remove
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
-		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		pkt := orderbook.PackDiff(book, batch.LastDiffSeq, book.Sequence)
 		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
@@ -209,66 +230,68 @@ func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
-func (c *Client) Run() {
-	for {
-		c.run()
-	}
+func (c *Client) Run(ctx context.Context) {
+	c.Serve(ctx, c)
 }
 
-func (c *Client) run() {
-	if err := c.Connect(); err != nil {
-		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+// Flush stores pending book diffs at now and writes all buffered chunks to the database.
+func (c *Client) Flush(now time.Time) {
+	if c.DB == nil {
 		return
 	}
-	defer c.Socket.Close()
-
-	for {
-		msgType, message, err := c.Socket.ReadMessage()
-		if err != nil {
-			log.Println("read:", err)
-			return
+	for _, book := range c.Books {
+		batch := c.BatchWrite[book.ID]
+		if book.Synced {
+			c.WriteDiff(batch, book, now)
 		}
-
-		if msgType != websocket.TextMessage {
-			continue
+		if err := batch.Flush(c.DB, book.ProductInfo.DatabaseKey); err != nil {
+			fmt.Println("Flush DB Error", book.ID, err)
 		}
+	}
+}
 
-		var pkt Packet
-		if err := json.Unmarshal(message, &pkt); err != nil {
-			log.Println("header-parse:", err)
-			continue
-		}
+// HandleFrame routes a websocket text frame received at now to its book.
+func (c *Client) HandleFrame(now time.Time, message []byte) {
+	var pkt Packet
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		log.Println("header-parse:", err)
+		return
+	}
 
-		switch pkt.Event {
-		// pusher stuff
-		case "pusher:connection_established":
-			log.Println("Connected")
-			continue
-		case "pusher_internal:subscription_succeeded":
-			log.Println("Subscribed")
-			continue
-		case "pusher:pong":
-			// ignore
-			continue
-		case "pusher:ping":
+	switch pkt.Event {
+	// pusher stuff
+	case "pusher:connection_established":
+		log.Println("Connected")
+		return
+	case "pusher_internal:subscription_succeeded":
+		log.Println("Subscribed")
+		return
+	case "pusher:pong":
+		// ignore
+		return
+	case "pusher:ping":
+		// there is no socket to answer on when reingesting a capture
+		if c.Socket != nil {
 			c.Socket.WriteJSON(map[string]interface{}{"event": "pusher:pong"})
-			continue
 		}
+		return
+	}
 
-		var ok bool
-		var book *orderbook.Book
+	var ok bool
+	var book *orderbook.Book
 
-		if book, ok = c.Books[pkt.Channel]; !ok {
-			log.Println("book not found", pkt.Channel)
-			continue
-		}
+	if book, ok = c.Books[pkt.Channel]; !ok {
+		log.Println("book not found", pkt.Channel)
+		return
+	}
 
-		if book.Sequence == 0 {
-			c.SyncBook(book)
-			continue
+	if book.Sequence == 0 {
+		// older diffs are ignored by UpdateSync, trades are kept
+		if err := c.SyncBook(book); err != nil {
+			fmt.Println("sync", book.ID, err)
+			return
 		}
-
-		c.HandleMessage(book, pkt)
 	}
+
+	c.HandleMessage(book, pkt, now)
 }
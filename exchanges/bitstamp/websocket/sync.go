@@ -3,12 +3,10 @@ package websocket
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
+	book_info "github.com/lian/gdax-bookmap/exchanges/bitstamp/product_info"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
 )
 
@@ -16,12 +14,8 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 	fmt.Println("sync", book.ID)
 
 	id := strings.ToLower(strings.Replace(book.ProductInfo.ID, "-", "", -1))
-	url := fmt.Sprintf("https://www.bitstamp.net/api/v2/order_book/%s", id)
-	res, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	body, err := ioutil.ReadAll(res.Body)
+	url := fmt.Sprintf("%s/api/v2/order_book/%s", book_info.APIURL, id)
+	body, now, err := c.Fetcher.Fetch(url)
 	if err != nil {
 		return err
 	}
@@ -36,30 +30,34 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 		book.Clear()
 		seq, _ := strconv.ParseInt(data["timestamp"].(string), 10, 64)
 		book.Sequence = uint64(seq)
-
-		t := time.Now()
+		book.Synced = true
 
 		if bids, ok := data["bids"].([]interface{}); ok {
 			for i := len(bids) - 1; i >= 0; i-- {
 				data := bids[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateBidLevel(t, price, size)
+				price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+				if err != nil {
+					fmt.Println("skip level", err)
+					continue
+				}
+				book.UpdateBidLevel(now, price, size)
 			}
 		}
 
 		if asks, ok := data["asks"].([]interface{}); ok {
 			for i := len(asks) - 1; i >= 0; i-- {
 				data := asks[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateAskLevel(t, price, size)
+				price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+				if err != nil {
+					fmt.Println("skip level", err)
+					continue
+				}
+				book.UpdateAskLevel(now, price, size)
 			}
 		}
 
-		if c.dbEnabled {
+		if c.DB != nil {
 			batch := c.BatchWrite[book.ID]
-			now := time.Now()
 			fmt.Println("STORE INIT SYNC", book.ID, book.Sequence, batch.Count)
 			c.WriteSync(batch, book, now)
 		}
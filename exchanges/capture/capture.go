@@ -0,0 +1,337 @@
+// Package capture logs the raw websocket frames and REST responses of a
+// venue with their receive time, so a recording can be rebuilt from them
+// after a client bug is fixed.
+//
+// A log is a gzip stream of entries:
+//
+//	kind   uint8
+//	time   int64  receive time in unix nanoseconds
+//	source uint16 length + bytes, the url of connects and responses
+//	data   uint32 length + bytes
+//
+// Every recorder run appends a new gzip member that starts with a Products
+// entry, gzip readers read the members as one stream.
+package capture
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+type Kind uint8
+
+const (
+	// Products holds the products and product infos of the client as JSON
+	Products Kind = iota + 1
+	// Connect is a new websocket connection to Source
+	Connect
+	// Frame is a websocket text frame
+	Frame
+	// Response is the body of a REST request to Source
+	Response
+	// Failure is the error of a REST request to Source
+	Failure
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Products:
+		return "products"
+	case Connect:
+		return "connect"
+	case Frame:
+		return "frame"
+	case Response:
+		return "response"
+	case Failure:
+		return "failure"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+type Entry struct {
+	Kind   Kind
+	Time   time.Time
+	Source string
+	Data   []byte
+}
+
+// ProductList is the payload of a Products entry.
+type ProductList struct {
+	Platform string
+	Products []string
+	// by product name, the key of the venue's product info cache
+	Infos map[string]product_info.Info
+}
+
+// FlushInterval is how often the gzip stream is flushed to the file, a
+// crash loses the entries since.
+var FlushInterval = time.Second
+
+// Path is the log of platform in dir.
+func Path(dir, platform string) string {
+	return filepath.Join(dir, strings.ToLower(platform)+".capture.gz")
+}
+
+// Writer appends entries to a log. A nil Writer discards them.
+type Writer struct {
+	mu        sync.Mutex
+	file      *os.File
+	gz        *gzip.Writer
+	lastFlush time.Time
+	err       error
+}
+
+// Create opens the log at path for appending, creating it if needed.
+func Create(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{file: file, gz: gzip.NewWriter(file)}, nil
+}
+
+func (w *Writer) Write(e Entry) error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if len(e.Source) > 0xffff {
+		return fmt.Errorf("capture source of %d bytes", len(e.Source))
+	}
+
+	header := make([]byte, 1+8+2)
+	header[0] = uint8(e.Kind)
+	binary.LittleEndian.PutUint64(header[1:], uint64(e.Time.UnixNano()))
+	binary.LittleEndian.PutUint16(header[9:], uint16(len(e.Source)))
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(e.Data)))
+
+	for _, buf := range [][]byte{header, []byte(e.Source), length, e.Data} {
+		if _, err := w.gz.Write(buf); err != nil {
+			w.fail(err)
+			return err
+		}
+	}
+	if e.Time.Sub(w.lastFlush) >= FlushInterval {
+		w.lastFlush = e.Time
+		if err := w.gz.Flush(); err != nil {
+			w.fail(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// fail keeps the first error, later writes return it without logging again.
+func (w *Writer) fail(err error) {
+	fmt.Println("capture error", err)
+	w.err = err
+}
+
+// Products logs the products of a client, infos are in the order of products.
+func (w *Writer) Products(t time.Time, platform string, products []string, infos []*product_info.Info) error {
+	if w == nil {
+		return nil
+	}
+	list := ProductList{Platform: platform, Products: products, Infos: map[string]product_info.Info{}}
+	for i, name := range products {
+		if i < len(infos) {
+			list.Infos[name] = *infos[i]
+		}
+	}
+	buf, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return w.Write(Entry{Kind: Products, Time: t, Source: platform, Data: buf})
+}
+
+func (w *Writer) Connect(t time.Time, url string) error {
+	return w.Write(Entry{Kind: Connect, Time: t, Source: url})
+}
+
+func (w *Writer) Frame(t time.Time, data []byte) error {
+	return w.Write(Entry{Kind: Frame, Time: t, Data: data})
+}
+
+// Close ends the gzip member and closes the file.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.gz.Close()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Fetcher gets REST responses for the clients, it returns the body and the
+// time it was received.
+type Fetcher interface {
+	Fetch(url string) ([]byte, time.Time, error)
+}
+
+// HTTP fetches from the network and logs every response to Log, if set.
+type HTTP struct {
+	Log *Writer
+}
+
+func (h HTTP) Fetch(url string) ([]byte, time.Time, error) {
+	res, err := http.Get(url)
+	var body []byte
+	if err == nil {
+		body, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	now := time.Now()
+	if err != nil {
+		h.Log.Write(Entry{Kind: Failure, Time: now, Source: url, Data: []byte(err.Error())})
+		return nil, now, err
+	}
+	h.Log.Write(Entry{Kind: Response, Time: now, Source: url, Data: body})
+	return body, now, nil
+}
+
+// Reader reads the entries of a log. It is a Fetcher that answers the
+// requests of a replayed client with the next captured response.
+type Reader struct {
+	file    *os.File
+	r       *bufio.Reader
+	pending *Entry
+	err     error
+}
+
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &Reader{file: file, r: bufio.NewReader(gz)}, nil
+}
+
+func (r *Reader) read() (Entry, error) {
+	var e Entry
+	header := make([]byte, 1+8+2)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return e, err
+	}
+	e.Kind = Kind(header[0])
+	e.Time = time.Unix(0, int64(binary.LittleEndian.Uint64(header[1:])))
+
+	source := make([]byte, binary.LittleEndian.Uint16(header[9:]))
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, source); err != nil {
+		return e, io.ErrUnexpectedEOF
+	}
+	if _, err := io.ReadFull(r.r, length); err != nil {
+		return e, io.ErrUnexpectedEOF
+	}
+	e.Source = string(source)
+	e.Data = make([]byte, binary.LittleEndian.Uint32(length))
+	if _, err := io.ReadFull(r.r, e.Data); err != nil {
+		return e, io.ErrUnexpectedEOF
+	}
+	return e, nil
+}
+
+// Peek returns the next entry without consuming it.
+func (r *Reader) Peek() (Entry, error) {
+	if r.pending != nil {
+		return *r.pending, nil
+	}
+	if r.err != nil {
+		return Entry{}, r.err
+	}
+	e, err := r.read()
+	if err != nil {
+		// a log cut off by a crash ends with a partial entry
+		r.err = err
+		return Entry{}, err
+	}
+	r.pending = &e
+	return e, nil
+}
+
+// Next returns the next entry, io.EOF at the end of the log and
+// io.ErrUnexpectedEOF if the log ends within an entry.
+func (r *Reader) Next() (Entry, error) {
+	e, err := r.Peek()
+	r.pending = nil
+	return e, err
+}
+
+// Fetch returns the captured response if the next entry is the response
+// or failure of a request to the same path and query as url.
+func (r *Reader) Fetch(rawurl string) ([]byte, time.Time, error) {
+	e, err := r.Peek()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("no captured response for %s: %s", rawurl, err)
+	}
+	if e.Kind != Response && e.Kind != Failure {
+		return nil, e.Time, fmt.Errorf("no captured response for %s, next is a %s", rawurl, e.Kind)
+	}
+	r.Next()
+	if !sameRequest(e.Source, rawurl) {
+		return nil, e.Time, fmt.Errorf("captured response is for %s, not %s", e.Source, rawurl)
+	}
+	if e.Kind == Failure {
+		return nil, e.Time, errors.New(string(e.Data))
+	}
+	return e.Data, e.Time, nil
+}
+
+// sameRequest compares path and query, the endpoints can differ between
+// capture and replay.
+func sameRequest(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return a == b
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return a == b
+	}
+	return ua.Path == ub.Path && ua.RawQuery == ub.RawQuery
+}
+
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// ProductList decodes the payload of a Products entry.
+func (e Entry) ProductList() (ProductList, error) {
+	var list ProductList
+	if e.Kind != Products {
+		return list, fmt.Errorf("%s entry has no product list", e.Kind)
+	}
+	err := json.Unmarshal(e.Data, &list)
+	return list, err
+}
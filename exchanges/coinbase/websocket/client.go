@@ -3,71 +3,52 @@ package websocket
 // https://docs.pro.coinbase.com/#websocket-feed
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
 	"time"
 
-	book_info "github.com/lian/gdax-bookmap/exchanges/coinbase/product_info"
+	"github.com/lian/gdax-bookmap/exchanges"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+// WebsocketURL is the websocket feed endpoint.
+var WebsocketURL = "wss://ws-feed.pro.coinbase.com"
+
 type Client struct {
-	Socket      *websocket.Conn
-	Products    []string
-	Books       map[string]*orderbook.Book
-	ConnectedAt time.Time
-	DB          *bolt.DB
-	dbEnabled   bool
-	BatchWrite  map[string]*util.BookBatchWrite
-	Infos       []*product_info.Info
+	exchanges.Base
+	Books map[string]*orderbook.Book
 }
 
-func New(db *bolt.DB, products []string) *Client {
+func New(db store.Store, infos []*product_info.Info) *Client {
 	c := &Client{
-		Products:   []string{},
-		Books:      map[string]*orderbook.Book{},
-		BatchWrite: map[string]*util.BookBatchWrite{},
-		DB:         db,
-		Infos:      []*product_info.Info{},
-	}
-	if c.DB != nil {
-		c.dbEnabled = true
-	}
-
-	for _, name := range products {
-		c.AddProduct(name)
+		Books: map[string]*orderbook.Book{},
 	}
+	c.Init("Coinbase", db)
 
-	if c.dbEnabled {
-		buckets := []string{}
-		for _, info := range c.Infos {
-			buckets = append(buckets, info.DatabaseKey)
-		}
-		util.CreateBucketsDB(c.DB, buckets)
+	for _, info := range infos {
+		c.AddProduct(*info)
 	}
 
 	return c
 }
 
-func (c *Client) AddProduct(name string) {
-	c.Products = append(c.Products, name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
+func (c *Client) AddProduct(info product_info.Info) {
+	name := info.ID
 	book := orderbook.New(name)
-	info := book_info.FetchProductInfo(name)
-	c.Infos = append(c.Infos, &info)
+	c.AddProductInfo(name, info)
 	book.SetProductInfo(info)
 	c.Books[name] = book
 }
 
 func (c *Client) Connect() error {
-	url := "wss://ws-feed.pro.coinbase.com"
+	url := WebsocketURL
 
 	fmt.Println("connect to websocket", url)
 	s, _, err := websocket.DefaultDialer.Dial(url, nil)
@@ -77,7 +58,9 @@ func (c *Client) Connect() error {
 	}
 
 	c.Socket = s
-	c.ConnectedAt = time.Now()
+	now := time.Now()
+	c.Capture.Connect(now, url)
+	c.HandleConnect(now)
 
 	buf, _ := json.Marshal(map[string]interface{}{"type": "subscribe", "product_ids": c.Products, "channels": []string{"level2", "heartbeat", "ticker"}})
 	err = c.Socket.WriteMessage(websocket.TextMessage, buf)
@@ -85,6 +68,12 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// HandleConnect applies a new connection at now, the subscription answers
+// with a snapshot of every book.
+func (c *Client) HandleConnect(now time.Time) {
+	c.SetConnected(now)
+}
+
 type PacketHeader struct {
 	Type      string `json:"type"`
 	ProductID string `json:"product_id"`
@@ -97,20 +86,25 @@ type Snapshot struct {
 
 type L2Update struct {
 	Changes [][]string `json:"changes"`
+	Time    time.Time  `json:"time"`
 }
 
 type Ticker struct {
-	Price    float64 `json:"price,string"`
-	Quantity float64 `json:"last_size,string"`
+	Price    string    `json:"price"`
+	Quantity string    `json:"last_size"`
+	Side     string    `json:"side"` // taker side, buy or sell
+	TradeID  uint64    `json:"trade_id"`
+	Time     time.Time `json:"time"`
 }
 
 type Heartbeat struct {
 	Sequence uint64 `json:"sequence"`
 }
 
-func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, message []byte) {
+func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, message []byte, now time.Time) {
+	c.CountMessage(book.ID)
+
 	var trade *orderbook.Trade
-	now := time.Now()
 
 	switch header.Type {
 	case "snapshot":
@@ -121,18 +115,30 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 		}
 
 		book.Clear()
+		book.Synced = true
 
 		for _, data := range s.Bids {
-			price, _ := strconv.ParseFloat(data[0], 64)
-			size, _ := strconv.ParseFloat(data[1], 64)
+			price, size, err := book.ParseLevel(data[0], data[1])
+			if err != nil {
+				fmt.Println("skip level", err)
+				continue
+			}
 			book.UpdateBidLevel(now, price, size)
 		}
 
 		for _, data := range s.Asks {
-			price, _ := strconv.ParseFloat(data[0], 64)
-			size, _ := strconv.ParseFloat(data[1], 64)
+			price, size, err := book.ParseLevel(data[0], data[1])
+			if err != nil {
+				fmt.Println("skip level", err)
+				continue
+			}
 			book.UpdateAskLevel(now, price, size)
 		}
+
+		if c.DB != nil {
+			// levels missing from a resync snapshot are only dropped by a sync packet
+			c.WriteSync(c.BatchWrite[book.ID], book, now)
+		}
 	case "l2update":
 		var s L2Update
 		if err := json.Unmarshal(message, &s); err != nil {
@@ -140,9 +146,14 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 			return
 		}
 
+		book.ExchangeTime = s.Time
+
 		for _, data := range s.Changes {
-			price, _ := strconv.ParseFloat(data[1], 64)
-			size, _ := strconv.ParseFloat(data[2], 64)
+			price, size, err := book.ParseLevel(data[1], data[2])
+			if err != nil {
+				fmt.Println("skip level", err)
+				continue
+			}
 			if data[0] == "buy" {
 				book.UpdateBidLevel(now, price, size)
 			} else {
@@ -157,8 +168,17 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 			return
 		}
 
-		side := book.GetSide(s.Price)
-		book.AddTrade(now, side, s.Price, s.Quantity)
+		price, size, err := book.ParseLevel(s.Price, s.Quantity)
+		if err != nil {
+			fmt.Println("skip trade", err)
+			return
+		}
+		switch s.Side {
+		case "buy", "sell":
+			book.AddTakerTrade(now, orderbook.TakerSide(s.Side == "buy"), price, size, s.TradeID, s.Time)
+		default:
+			book.AddTrade(now, book.GetSide(price), price, size)
+		}
 		trade = book.Trades[len(book.Trades)-1]
 
 	case "heartbeat":
@@ -175,11 +195,11 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 		return
 	}
 
-	if c.dbEnabled {
+	if c.DB != nil {
 		batch := c.BatchWrite[book.ID]
 
 		if trade != nil {
-			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(trade))
+			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(book, trade))
 		}
 
 		if batch.NextSync(now) {
@@ -198,7 +218,7 @@ func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now
 	book.FixBookLevels() // TODO fix/remove
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
-		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		pkt := orderbook.PackDiff(book, batch.LastDiffSeq, book.Sequence)
 		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
@@ -212,49 +232,45 @@ func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
-func (c *Client) Run() {
-	for {
-		c.run()
-	}
+func (c *Client) Run(ctx context.Context) {
+	c.Serve(ctx, c)
 }
 
-func (c *Client) run() {
-	if err := c.Connect(); err != nil {
-		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+// Flush stores pending book diffs at now and writes all buffered chunks to the database.
+func (c *Client) Flush(now time.Time) {
+	if c.DB == nil {
 		return
 	}
-	defer c.Socket.Close()
-
-	for {
-		msgType, message, err := c.Socket.ReadMessage()
-		if err != nil {
-			log.Println("read:", err)
-			return
-		}
-
-		if msgType != websocket.TextMessage {
-			continue
+	for _, book := range c.Books {
+		batch := c.BatchWrite[book.ID]
+		if book.Synced {
+			c.WriteDiff(batch, book, now)
 		}
-
-		var header PacketHeader
-		if err := json.Unmarshal(message, &header); err != nil {
-			log.Println("header-parse:", err)
-			continue
+		if err := batch.Flush(c.DB, book.ProductInfo.DatabaseKey); err != nil {
+			fmt.Println("Flush DB Error", book.ID, err)
 		}
+	}
+}
 
-		if header.Type == "subscriptions" {
-			fmt.Println("Coinbase Websocket subscriptions", message)
-			continue
-		}
+// HandleFrame routes a websocket text frame received at now to its book.
+func (c *Client) HandleFrame(now time.Time, message []byte) {
+	var header PacketHeader
+	if err := json.Unmarshal(message, &header); err != nil {
+		log.Println("header-parse:", err)
+		return
+	}
 
-		var book *orderbook.Book
-		var ok bool
-		if book, ok = c.Books[header.ProductID]; !ok {
-			log.Println("book not found", header.ProductID)
-			continue
-		}
+	if header.Type == "subscriptions" {
+		fmt.Println("Coinbase Websocket subscriptions", message)
+		return
+	}
 
-		c.HandleMessage(book, header, message)
+	var book *orderbook.Book
+	var ok bool
+	if book, ok = c.Books[header.ProductID]; !ok {
+		log.Println("book not found", header.ProductID)
+		return
 	}
+
+	c.HandleMessage(book, header, message, now)
 }
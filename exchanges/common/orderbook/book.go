@@ -1,15 +1,17 @@
 package orderbook
 
 import (
-	"math"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 )
 
 type LevelDiff struct {
-	Price float64
-	Size  float64
+	Price fixed.Value
+	Size  fixed.Value
 }
 
 type BookLevelDiff struct {
@@ -22,178 +24,217 @@ type Side uint8
 const BidSide Side = 0
 const AskSide Side = 1
 
+// TakerSide returns the trade side of a taker buy or sell: buys lift the ask,
+// sells hit the bid.
+func TakerSide(buy bool) uint8 {
+	if buy {
+		return uint8(AskSide)
+	}
+	return uint8(BidSide)
+}
+
 type BookLevel struct {
-	Price float64
-	Size  float64
+	Price fixed.Value
+	Size  fixed.Value
 }
 
 type Trade struct {
-	Price float64
-	Size  float64
+	Price fixed.Value
+	Size  fixed.Value
 	Time  time.Time
 	Side  Side
+	// exchange trade ID and timestamp, zero if the venue has none
+	ID           uint64
+	ExchangeTime time.Time
+	// Side is the taker side reported by the exchange, not guessed from the levels
+	Aggressor bool
 }
 
 type Book struct {
 	ID          string
 	ProductInfo product_info.Info
-	Bid         []*BookLevel
-	Ask         []*BookLevel
-	Trades      []*Trade
-	Sequence    uint64
-	Synced      bool
-	Diff        *BookLevelDiff
+	// sorted by ascending price
+	Bid        []*BookLevel
+	Ask        []*BookLevel
+	Trades     []*Trade
+	Sequence   uint64
+	Synced     bool
+	Diff       *BookLevelDiff
+	PriceScale fixed.Scale
+	SizeScale  fixed.Scale
+	// exchange timestamp of the last level update, zero if the venue has none
+	ExchangeTime time.Time
+	// levels of Bid/Ask and entries of Diff by price
+	bidIndex     map[fixed.Value]*BookLevel
+	askIndex     map[fixed.Value]*BookLevel
+	diffBidIndex map[fixed.Value]*LevelDiff
+	diffAskIndex map[fixed.Value]*LevelDiff
 }
 
 func New(id string) *Book {
 	book := &Book{
-		ID:     id,
-		Bid:    []*BookLevel{},
-		Ask:    []*BookLevel{},
-		Trades: []*Trade{},
+		ID:         id,
+		Trades:     []*Trade{},
+		PriceScale: fixed.LegacyScale,
+		SizeScale:  fixed.LegacyScale,
 	}
-	book.ResetDiff()
+	book.Clear()
 	return book
 }
 
 func (b *Book) SetProductInfo(info product_info.Info) {
 	b.ProductInfo = info
+	b.PriceScale = info.PriceScale()
+	b.SizeScale = info.SizeScale()
 }
 
-func (b *Book) GetSide(price float64) uint8 {
-	for _, level := range b.Bid {
-		if level.Price == price {
-			return uint8(BidSide)
-		}
+// ParsePrice reads an exchange price string in the scale of the product.
+func (b *Book) ParsePrice(value string) (fixed.Value, error) {
+	return b.PriceScale.Parse(value)
+}
+
+// ParseSize reads an exchange size string in the scale of the product.
+func (b *Book) ParseSize(value string) (fixed.Value, error) {
+	return b.SizeScale.Parse(value)
+}
+
+// ParseLevel reads the price and size strings of a level or trade, the error
+// names the product so the caller can log it and skip the level.
+func (b *Book) ParseLevel(price, size string) (fixed.Value, fixed.Value, error) {
+	p, err := b.ParsePrice(price)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s price: %w", b.ID, err)
 	}
-	for _, level := range b.Ask {
-		if level.Price == price {
-			return uint8(AskSide)
-		}
+	s, err := b.ParseSize(size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s size: %w", b.ID, err)
 	}
-	return uint8(BidSide)
+	return p, s, nil
 }
 
-func (b *Book) UpdateBidLevel(t time.Time, price, size float64) {
-	var found bool
-
-	for i, current := range b.Bid {
-		if current.Price == price {
-			if size == 0 {
-				// remove
-				b.Bid[i] = b.Bid[len(b.Bid)-1]
-				b.Bid[len(b.Bid)-1] = nil
-				b.Bid = b.Bid[:len(b.Bid)-1]
-			} else {
-				// update
-				b.Bid[i].Size = size
-			}
-			found = true
-			break
-		}
+// FloatLevel is ParseLevel for exchanges sending prices and sizes as floats.
+func (b *Book) FloatLevel(price, size float64) (fixed.Value, fixed.Value, error) {
+	p, err := b.PriceScale.FromFloat(price)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s price %v: %w", b.ID, price, err)
 	}
-
-	if !found && size != 0 {
-		// add
-		b.Bid = append(b.Bid, &BookLevel{Price: price, Size: size})
+	s, err := b.SizeScale.FromFloat(size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s size %v: %w", b.ID, size, err)
 	}
+	return p, s, nil
+}
 
-	// update diff stats
-	found = false
-	for _, state := range b.Diff.Bid {
-		if state.Price == price {
-			state.Size = size
-			found = true
-			break
-		}
+func (b *Book) GetSide(price fixed.Value) uint8 {
+	if _, ok := b.bidIndex[price]; ok {
+		return uint8(BidSide)
 	}
-	if !found {
-		b.Diff.Bid = append(b.Diff.Bid, &LevelDiff{Price: price, Size: size})
+	if _, ok := b.askIndex[price]; ok {
+		return uint8(AskSide)
 	}
+	return uint8(BidSide)
+}
+
+func (b *Book) UpdateBidLevel(t time.Time, price, size fixed.Value) {
+	b.Bid = updateLevel(b.Bid, b.bidIndex, price, size)
+	b.Diff.Bid = updateDiff(b.Diff.Bid, b.diffBidIndex, price, size)
+}
+
+func (b *Book) UpdateAskLevel(t time.Time, price, size fixed.Value) {
+	b.Ask = updateLevel(b.Ask, b.askIndex, price, size)
+	b.Diff.Ask = updateDiff(b.Diff.Ask, b.diffAskIndex, price, size)
 }
 
-func (b *Book) UpdateAskLevel(t time.Time, price, size float64) {
-	var found bool
+// searchLevel returns the index of price in levels or where it would be inserted.
+func searchLevel(levels []*BookLevel, price fixed.Value) int {
+	return sort.Search(len(levels), func(i int) bool { return levels[i].Price >= price })
+}
 
-	for i, current := range b.Ask {
-		if current.Price == price {
-			if size == 0 {
-				// remove
-				b.Ask[i] = b.Ask[len(b.Ask)-1]
-				b.Ask[len(b.Ask)-1] = nil
-				b.Ask = b.Ask[:len(b.Ask)-1]
-			} else {
-				// update
-				b.Ask[i].Size = size
-			}
-			found = true
-			break
+func updateLevel(levels []*BookLevel, index map[fixed.Value]*BookLevel, price, size fixed.Value) []*BookLevel {
+	if level, ok := index[price]; ok {
+		if size != 0 {
+			// update
+			level.Size = size
+			return levels
 		}
+		// remove
+		delete(index, price)
+		i := searchLevel(levels, price)
+		copy(levels[i:], levels[i+1:])
+		levels[len(levels)-1] = nil
+		return levels[:len(levels)-1]
 	}
 
-	if !found && size != 0 {
+	if size != 0 {
 		// add
-		b.Ask = append(b.Ask, &BookLevel{Price: price, Size: size})
+		level := &BookLevel{Price: price, Size: size}
+		index[price] = level
+		i := searchLevel(levels, price)
+		levels = append(levels, nil)
+		copy(levels[i+1:], levels[i:])
+		levels[i] = level
 	}
+	return levels
+}
 
-	// update diff stats
-	found = false
-	for _, state := range b.Diff.Ask {
-		if state.Price == price {
-			state.Size = size
-			found = true
-			break
-		}
+// updateDiff records the new size of a level in the diff of the next packet.
+func updateDiff(diff []*LevelDiff, index map[fixed.Value]*LevelDiff, price, size fixed.Value) []*LevelDiff {
+	if state, ok := index[price]; ok {
+		state.Size = size
+		return diff
 	}
-	if !found {
-		b.Diff.Ask = append(b.Diff.Ask, &LevelDiff{Price: price, Size: size})
+	state := &LevelDiff{Price: price, Size: size}
+	index[price] = state
+	return append(diff, state)
+}
+
+// BestBid returns the highest bid level, nil if there are no bids.
+func (b *Book) BestBid() *BookLevel {
+	if len(b.Bid) == 0 {
+		return nil
 	}
+	return b.Bid[len(b.Bid)-1]
+}
+
+// BestAsk returns the lowest ask level, nil if there are no asks.
+func (b *Book) BestAsk() *BookLevel {
+	if len(b.Ask) == 0 {
+		return nil
+	}
+	return b.Ask[0]
 }
 
 // :.(
 func (b *Book) FixBookLevels() {
 	now := time.Now()
+	bid, ask := b.BestBid(), b.BestAsk()
 
-	lowestAsk := math.MaxFloat64
-	for _, level := range b.Ask {
-		if level.Price < lowestAsk {
-			lowestAsk = level.Price
+	// bids above the lowest ask
+	if ask != nil {
+		deleteBids := []fixed.Value{}
+		for i := len(b.Bid) - 1; i >= 0 && b.Bid[i].Price > ask.Price; i-- {
+			deleteBids = append(deleteBids, b.Bid[i].Price)
 		}
-	}
-
-	highestBid := 0.0
-	for _, level := range b.Bid {
-		if level.Price > highestBid {
-			highestBid = level.Price
+		for _, price := range deleteBids {
+			b.UpdateBidLevel(now, price, 0)
 		}
 	}
 
-	deleteBids := []float64{}
-	for _, level := range b.Bid {
-		if level.Price > lowestAsk {
-			deleteBids = append(deleteBids, level.Price)
+	// asks below the highest bid
+	if bid != nil {
+		deleteAsks := []fixed.Value{}
+		for i := 0; i < len(b.Ask) && b.Ask[i].Price < bid.Price; i++ {
+			deleteAsks = append(deleteAsks, b.Ask[i].Price)
 		}
-	}
-
-	for _, price := range deleteBids {
-		b.UpdateBidLevel(now, price, 0)
-	}
-
-	deleteAsks := []float64{}
-	for _, level := range b.Ask {
-		if level.Price < highestBid {
-			deleteAsks = append(deleteAsks, level.Price)
+		for _, price := range deleteAsks {
+			b.UpdateAskLevel(now, price, 0)
 		}
 	}
 
-	for _, price := range deleteAsks {
-		b.UpdateAskLevel(now, price, 0)
-	}
-
 	//fmt.Println("FixBookLevels", b.ID, "took", time.Since(now))
 }
 
-func (b *Book) AddTrade(t time.Time, side uint8, price, size float64) {
+func (b *Book) AddTrade(t time.Time, side uint8, price, size fixed.Value) {
 	if len(b.Trades) >= 50 {
 		// remove and free first item
 		copy(b.Trades[0:], b.Trades[1:])
@@ -203,9 +244,20 @@ func (b *Book) AddTrade(t time.Time, side uint8, price, size float64) {
 	b.Trades = append(b.Trades, &Trade{Side: Side(side), Price: price, Size: size, Time: t})
 }
 
+// AddTakerTrade adds a trade whose side comes from the exchange, see TakerSide.
+func (b *Book) AddTakerTrade(t time.Time, side uint8, price, size fixed.Value, id uint64, exchangeTime time.Time) {
+	b.AddTrade(t, side, price, size)
+	trade := b.Trades[len(b.Trades)-1]
+	trade.ID = id
+	trade.ExchangeTime = exchangeTime
+	trade.Aggressor = true
+}
+
 func (b *Book) Clear() {
 	b.Bid = []*BookLevel{}
 	b.Ask = []*BookLevel{}
+	b.bidIndex = map[fixed.Value]*BookLevel{}
+	b.askIndex = map[fixed.Value]*BookLevel{}
 	b.ResetDiff()
 }
 
@@ -215,4 +267,14 @@ func (b *Book) ResetDiff() {
 		Bid: []*LevelDiff{},
 		Ask: []*LevelDiff{},
 	}
+	if b.diffBidIndex == nil {
+		b.diffBidIndex = map[fixed.Value]*LevelDiff{}
+		b.diffAskIndex = map[fixed.Value]*LevelDiff{}
+	}
+	for price := range b.diffBidIndex {
+		delete(b.diffBidIndex, price)
+	}
+	for price := range b.diffAskIndex {
+		delete(b.diffAskIndex, price)
+	}
 }
@@ -0,0 +1,206 @@
+package orderbook
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// replayBook is the part of Book used by the exchange clients.
+type replayBook interface {
+	Clear()
+	GetSide(price fixed.Value) uint8
+	UpdateBidLevel(t time.Time, price, size fixed.Value)
+	UpdateAskLevel(t time.Time, price, size fixed.Value)
+	FixBookLevels()
+	ResetDiff()
+}
+
+// loadRecording returns the packets of the Binance recording the book tests
+// of the orderbook package replay.
+func loadRecording(tb testing.TB) []orderbook.Record {
+	tb.Helper()
+	st, err := store.OpenSegment(filepath.Join("..", "..", "..", "orderbook", "testdata", "binance"), true)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer st.Close()
+	records, err := orderbook.ReadRecords(st, "Binance-BTC-USDT")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return records
+}
+
+func replay(book replayBook, records []orderbook.Record) {
+	for _, record := range records {
+		applyRecord(book, record)
+	}
+}
+
+// applyRecord applies record like a client and stores the diff.
+func applyRecord(book replayBook, record orderbook.Record) {
+	pkt := record.Pkt
+	switch pkt.Type {
+	case orderbook.TradePacket:
+		book.GetSide(pkt.Price)
+		return
+	case orderbook.SyncPacket:
+		book.Clear()
+	}
+	for _, level := range pkt.Bid {
+		book.UpdateBidLevel(record.Time, level.Price, level.Size)
+	}
+	for _, level := range pkt.Ask {
+		book.UpdateAskLevel(record.Time, level.Price, level.Size)
+	}
+	book.FixBookLevels()
+	book.ResetDiff()
+}
+
+// compareLegacy returns an error for the first level of book that differs
+// from legacy, whose levels are not sorted.
+func compareLegacy(book *Book, legacy *legacyBook) error {
+	for _, side := range []struct {
+		name string
+		a, b []*BookLevel
+	}{{"bid", book.Bid, legacy.Bid}, {"ask", book.Ask, legacy.Ask}} {
+		sorted := append([]*BookLevel{}, side.b...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Price < sorted[j].Price })
+		if len(side.a) != len(sorted) {
+			return fmt.Errorf("%s levels %d != %d", side.name, len(side.a), len(sorted))
+		}
+		for i := range side.a {
+			if *side.a[i] != *sorted[i] {
+				return fmt.Errorf("%s level %d %+v != %+v", side.name, i, *side.a[i], *sorted[i])
+			}
+		}
+	}
+	return nil
+}
+
+// compareDiff returns an error if the diffs differ, both keep the order of the updates.
+func compareDiff(book *Book, legacy *legacyBook) error {
+	for _, side := range []struct {
+		name string
+		a, b []*LevelDiff
+	}{{"bid", book.Diff.Bid, legacy.DiffBid}, {"ask", book.Diff.Ask, legacy.DiffAsk}} {
+		if len(side.a) != len(side.b) {
+			return fmt.Errorf("%s diff %d != %d", side.name, len(side.a), len(side.b))
+		}
+		for i := range side.a {
+			if *side.a[i] != *side.b[i] {
+				return fmt.Errorf("%s diff %d %+v != %+v", side.name, i, *side.a[i], *side.b[i])
+			}
+		}
+	}
+	return nil
+}
+
+func TestBookMatchesLegacy(t *testing.T) {
+	now := time.Unix(1550671200, 0)
+	book, legacy := New("BTC-USD"), newLegacyBook()
+
+	steps := []struct {
+		name  string
+		apply func(b replayBook)
+	}{
+		{"snapshot", func(b replayBook) {
+			for price := fixed.Value(95); price < 100; price++ {
+				b.UpdateBidLevel(now, price, 10)
+				b.UpdateAskLevel(now, price+10, 10)
+			}
+		}},
+		{"insert below the lowest bid and above the highest ask", func(b replayBook) {
+			b.UpdateBidLevel(now, 90, 5)
+			b.UpdateAskLevel(now, 115, 5)
+		}},
+		{"insert above the best bid and below the best ask", func(b replayBook) {
+			b.UpdateBidLevel(now, 100, 7)
+			b.UpdateAskLevel(now, 104, 7)
+		}},
+		{"insert in the middle", func(b replayBook) {
+			b.UpdateBidLevel(now, 92, 3)
+			b.UpdateAskLevel(now, 112, 3)
+		}},
+		{"update twice", func(b replayBook) {
+			b.UpdateBidLevel(now, 97, 20)
+			b.UpdateBidLevel(now, 97, 21)
+			b.UpdateAskLevel(now, 107, 2)
+		}},
+		{"remove both ends of each side", func(b replayBook) {
+			b.UpdateBidLevel(now, 90, 0)
+			b.UpdateBidLevel(now, 100, 0)
+			b.UpdateAskLevel(now, 104, 0)
+			b.UpdateAskLevel(now, 115, 0)
+		}},
+		{"remove an unknown level", func(b replayBook) {
+			b.UpdateBidLevel(now, 50, 0)
+			b.UpdateAskLevel(now, 150, 0)
+		}},
+		{"add a removed level again", func(b replayBook) {
+			b.UpdateBidLevel(now, 100, 4)
+			b.UpdateAskLevel(now, 115, 4)
+		}},
+		{"crossed levels are fixed", func(b replayBook) {
+			b.UpdateBidLevel(now, 106, 1)
+			b.UpdateAskLevel(now, 98, 1)
+			b.FixBookLevels()
+		}},
+		{"clear", func(b replayBook) {
+			b.Clear()
+			b.UpdateAskLevel(now, 110, 1)
+		}},
+	}
+	for _, step := range steps {
+		step.apply(book)
+		step.apply(legacy)
+		if err := compareLegacy(book, legacy); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if err := compareDiff(book, legacy); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		for _, price := range []fixed.Value{90, 97, 100, 104, 110, 115} {
+			if a, b := book.GetSide(price), legacy.GetSide(price); a != b {
+				t.Fatalf("%s: side of %d is %d, legacy %d", step.name, price, a, b)
+			}
+		}
+	}
+}
+
+func TestBookReplayMatchesLegacy(t *testing.T) {
+	records := loadRecording(t)
+	book, legacy := New("BTC-USD"), newLegacyBook()
+	for i, record := range records {
+		applyRecord(book, record)
+		applyRecord(legacy, record)
+		if err := compareLegacy(book, legacy); err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+	}
+}
+
+func BenchmarkBookReplayOld(b *testing.B) {
+	records := loadRecording(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		replay(newLegacyBook(), records)
+	}
+}
+
+func BenchmarkBookReplayNew(b *testing.B) {
+	records := loadRecording(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		replay(New("BTC-USD"), records)
+	}
+}
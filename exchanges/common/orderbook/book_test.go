@@ -0,0 +1,48 @@
+package orderbook
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+func TestParseLevel(t *testing.T) {
+	book := New("BTC-USD")
+	book.PriceScale, book.SizeScale = 2, 8
+
+	cases := []struct {
+		name        string
+		price, size string
+		want        [2]fixed.Value
+		err         string
+	}{
+		{"level", "6543.21", "0.5", [2]fixed.Value{654321, 50000000}, ""},
+		{"removed level", "6543.21", "0", [2]fixed.Value{654321, 0}, ""},
+		{"bad price", "abc", "0.5", [2]fixed.Value{}, "BTC-USD price"},
+		{"bad size", "6543.21", "", [2]fixed.Value{}, "BTC-USD size"},
+		{"price overflow", "1e20", "0.5", [2]fixed.Value{}, "BTC-USD price"},
+		{"size overflow", "6543.21", "92233720369", [2]fixed.Value{}, "BTC-USD size"},
+	}
+	for _, c := range cases {
+		price, size, err := book.ParseLevel(c.price, c.size)
+		if c.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Fatalf("%s: error %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil || price != c.want[0] || size != c.want[1] {
+			t.Fatalf("%s: %d %d %v, want %d %d", c.name, price, size, err, c.want[0], c.want[1])
+		}
+	}
+
+	if _, _, err := book.FloatLevel(math.Inf(1), 1); !errors.Is(err, fixed.ErrRange) {
+		t.Fatalf("float level out of range: %v", err)
+	}
+	if price, size, err := book.FloatLevel(6543.21, 0.5); err != nil || price != 654321 || size != 50000000 {
+		t.Fatalf("float level: %d %d %v", price, size, err)
+	}
+}
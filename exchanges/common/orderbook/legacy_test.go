@@ -0,0 +1,126 @@
+package orderbook
+
+// the linear scan book replaced by the price index of Book, kept to compare
+// against and to benchmark
+
+import (
+	"math"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+type legacyBook struct {
+	Bid     []*BookLevel
+	Ask     []*BookLevel
+	DiffBid []*LevelDiff
+	DiffAsk []*LevelDiff
+}
+
+func newLegacyBook() *legacyBook {
+	b := &legacyBook{}
+	b.Clear()
+	return b
+}
+
+func (b *legacyBook) Clear() {
+	b.Bid = []*BookLevel{}
+	b.Ask = []*BookLevel{}
+	b.ResetDiff()
+}
+
+func (b *legacyBook) ResetDiff() {
+	b.DiffBid = []*LevelDiff{}
+	b.DiffAsk = []*LevelDiff{}
+}
+
+func (b *legacyBook) GetSide(price fixed.Value) uint8 {
+	for _, level := range b.Bid {
+		if level.Price == price {
+			return uint8(BidSide)
+		}
+	}
+	for _, level := range b.Ask {
+		if level.Price == price {
+			return uint8(AskSide)
+		}
+	}
+	return uint8(BidSide)
+}
+
+func (b *legacyBook) UpdateBidLevel(t time.Time, price, size fixed.Value) {
+	b.Bid = legacyUpdateLevel(b.Bid, price, size)
+	b.DiffBid = legacyUpdateDiff(b.DiffBid, price, size)
+}
+
+func (b *legacyBook) UpdateAskLevel(t time.Time, price, size fixed.Value) {
+	b.Ask = legacyUpdateLevel(b.Ask, price, size)
+	b.DiffAsk = legacyUpdateDiff(b.DiffAsk, price, size)
+}
+
+// legacyUpdateLevel keeps the levels unsorted, a removal swaps in the last level.
+func legacyUpdateLevel(levels []*BookLevel, price, size fixed.Value) []*BookLevel {
+	for i, current := range levels {
+		if current.Price == price {
+			if size == 0 {
+				levels[i] = levels[len(levels)-1]
+				levels[len(levels)-1] = nil
+				return levels[:len(levels)-1]
+			}
+			current.Size = size
+			return levels
+		}
+	}
+	if size != 0 {
+		levels = append(levels, &BookLevel{Price: price, Size: size})
+	}
+	return levels
+}
+
+func legacyUpdateDiff(diff []*LevelDiff, price, size fixed.Value) []*LevelDiff {
+	for _, state := range diff {
+		if state.Price == price {
+			state.Size = size
+			return diff
+		}
+	}
+	return append(diff, &LevelDiff{Price: price, Size: size})
+}
+
+func (b *legacyBook) FixBookLevels() {
+	now := time.Now()
+
+	lowestAsk := fixed.Value(math.MaxInt64)
+	for _, level := range b.Ask {
+		if level.Price < lowestAsk {
+			lowestAsk = level.Price
+		}
+	}
+
+	highestBid := fixed.Value(0)
+	for _, level := range b.Bid {
+		if level.Price > highestBid {
+			highestBid = level.Price
+		}
+	}
+
+	deleteBids := []fixed.Value{}
+	for _, level := range b.Bid {
+		if level.Price > lowestAsk {
+			deleteBids = append(deleteBids, level.Price)
+		}
+	}
+	for _, price := range deleteBids {
+		b.UpdateBidLevel(now, price, 0)
+	}
+
+	deleteAsks := []fixed.Value{}
+	for _, level := range b.Ask {
+		if level.Price < highestBid {
+			deleteAsks = append(deleteAsks, level.Price)
+		}
+	}
+	for _, price := range deleteAsks {
+		b.UpdateAskLevel(now, price, 0)
+	}
+}
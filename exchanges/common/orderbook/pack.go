@@ -9,7 +9,8 @@ import (
 
 func PackSync(book *Book) []byte {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.SyncPacket)
+	binary.Write(buf, binary.LittleEndian, uint8(book.PriceScale))
+	binary.Write(buf, binary.LittleEndian, uint8(book.SizeScale))
 	binary.Write(buf, binary.LittleEndian, uint64(book.Sequence))
 
 	binary.Write(buf, binary.LittleEndian, uint64(len(book.Bid)))
@@ -24,12 +25,15 @@ func PackSync(book *Book) []byte {
 		binary.Write(buf, binary.LittleEndian, level.Size)  // size
 	}
 
-	return buf.Bytes()
+	db_orderbook.WriteExchangeTime(buf, book.ExchangeTime)
+	return db_orderbook.PackPacket(db_orderbook.SyncPacket, buf.Bytes())
 }
 
-func PackDiff(first, last uint64, diff *BookLevelDiff) []byte {
+func PackDiff(book *Book, first, last uint64) []byte {
+	diff := book.Diff
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.DiffPacket)
+	binary.Write(buf, binary.LittleEndian, uint8(book.PriceScale))
+	binary.Write(buf, binary.LittleEndian, uint8(book.SizeScale))
 	binary.Write(buf, binary.LittleEndian, uint64(first)) // sequence
 	binary.Write(buf, binary.LittleEndian, uint64(first)) // first
 	binary.Write(buf, binary.LittleEndian, uint64(last))  // last
@@ -46,15 +50,18 @@ func PackDiff(first, last uint64, diff *BookLevelDiff) []byte {
 		binary.Write(buf, binary.LittleEndian, state.Size)  // size
 	}
 
-	return buf.Bytes()
+	db_orderbook.WriteExchangeTime(buf, book.ExchangeTime)
+	return db_orderbook.PackPacket(db_orderbook.DiffPacket, buf.Bytes())
 }
 
-func PackTrade(trade *Trade) []byte {
+func PackTrade(book *Book, trade *Trade) []byte {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.TradePacket)
+	binary.Write(buf, binary.LittleEndian, uint8(book.PriceScale))
+	binary.Write(buf, binary.LittleEndian, uint8(book.SizeScale))
 	binary.Write(buf, binary.LittleEndian, uint64(0))         // seq
 	binary.Write(buf, binary.LittleEndian, uint8(trade.Side)) // side
 	binary.Write(buf, binary.LittleEndian, trade.Price)       // price
 	binary.Write(buf, binary.LittleEndian, trade.Size)        // size
-	return buf.Bytes()
+	db_orderbook.WriteTradeInfo(buf, trade.ID, trade.ExchangeTime, trade.Aggressor)
+	return db_orderbook.PackPacket(db_orderbook.TradePacket, buf.Bytes())
 }
@@ -0,0 +1,63 @@
+package exchanges
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Endpoints are the base URLs a platform connects to, empty fields keep the
+// current URL.
+type Endpoints struct {
+	Websocket string
+	API       string
+}
+
+// SetEndpoints points a platform at other servers, like a local fake or a
+// proxy. Call it before the first product lookup or New of the platform.
+func SetEndpoints(name string, e Endpoints) error {
+	p, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown platform %s (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	if e.Websocket != "" {
+		*p.WebsocketURL = e.Websocket
+	}
+	if e.API != "" {
+		*p.APIURL = e.API
+	}
+	return nil
+}
+
+// ParseEndpoints parses per platform endpoints,
+// "binance:ws=ws://127.0.0.1:9000,api=http://127.0.0.1:9000;coinbase:ws=ws://127.0.0.1:9001".
+// Platform names are lowercased.
+func ParseEndpoints(value string) (map[string]Endpoints, error) {
+	endpoints := map[string]Endpoints{}
+	if value == "" {
+		return endpoints, nil
+	}
+	for _, part := range strings.Split(value, ";") {
+		i := strings.Index(part, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid endpoints %q, missing platform", part)
+		}
+		name := strings.ToLower(part[:i])
+		e := endpoints[name]
+		for _, url := range strings.Split(part[i+1:], ",") {
+			kv := strings.SplitN(url, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid endpoint %q", url)
+			}
+			switch kv[0] {
+			case "ws":
+				e.Websocket = kv[1]
+			case "api":
+				e.API = kv[1]
+			default:
+				return nil, fmt.Errorf("invalid endpoint %q: unknown kind %s", url, kv[0])
+			}
+		}
+		endpoints[name] = e
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,159 @@
+package exchanges
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/capture"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Exchange is implemented by every websocket client that records order books.
+type Exchange interface {
+	Name() string
+	Infos() []*product_info.Info
+	Run(ctx context.Context)
+	Stop()
+	Status() Status
+	SetCheckpointPolicy(policy util.CheckpointPolicy)
+	SetCompression(enabled bool)
+	// SetCapture logs every websocket frame and REST response to w, call it before Run.
+	SetCapture(w *capture.Writer)
+}
+
+type BookStatus struct {
+	ID          string
+	DatabaseKey string
+	Synced      bool
+	Sequence    uint64
+	Messages    uint64
+}
+
+type Status struct {
+	Platform    string
+	Connected   bool
+	ConnectedAt time.Time
+	Books       []BookStatus
+}
+
+// Platform describes how to create a client and look up products for a venue.
+// Each websocket package registers itself from init.
+type Platform struct {
+	Name            string
+	DefaultProducts []string
+	// New creates the client of the products looked up by Infos
+	New         func(db store.Store, infos []*product_info.Info) Exchange
+	ProductInfo func(id string) product_info.Info
+	// URLs the client connects to, replaced by SetEndpoints
+	WebsocketURL *string
+	APIURL       *string
+	// product info cache ProductInfo reads, preset by a reingest
+	CachedInfo *map[string]product_info.Info
+}
+
+var platforms = map[string]*Platform{}
+
+func Register(p *Platform) {
+	platforms[strings.ToLower(p.Name)] = p
+}
+
+func Lookup(name string) (*Platform, bool) {
+	p, ok := platforms[strings.ToLower(name)]
+	return p, ok
+}
+
+func Names() []string {
+	names := make([]string, 0, len(platforms))
+	for _, p := range platforms {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the client of a platform for infos looked up by Infos, so
+// product infos are not fetched again.
+func New(name string, db store.Store, infos []*product_info.Info) (Exchange, error) {
+	p, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown platform %s (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return p.New(db, infos), nil
+}
+
+func ProductInfo(name, id string) (product_info.Info, error) {
+	p, ok := Lookup(name)
+	if !ok {
+		return product_info.Info{}, fmt.Errorf("unknown platform %s", name)
+	}
+	info := p.ProductInfo(id)
+	if info.ID == "" {
+		return info, fmt.Errorf("%s product %s not found", p.Name, id)
+	}
+	return info, nil
+}
+
+// Infos looks up the product infos of a platform without starting its client.
+func Infos(name string, products []string) ([]*product_info.Info, error) {
+	p, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown platform %s (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	if len(products) == 0 {
+		products = p.DefaultProducts
+	}
+	infos := make([]*product_info.Info, 0, len(products))
+	for _, id := range products {
+		info, err := ProductInfo(name, id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, &info)
+	}
+	return infos, nil
+}
+
+// StoredInfos returns the product infos of a platform recorded in db, so a
+// replay works offline. Recordings without stored infos fall back to Infos.
+func StoredInfos(db store.Store, name string, products []string) ([]*product_info.Info, error) {
+	p, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown platform %s (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	buckets, err := util.ListBuckets(db)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []*product_info.Info{}
+	recorded := false
+	for _, bucket := range buckets {
+		info, ok := util.ProductInfoOf(db, bucket)
+		if !ok || info.Platform != p.Name {
+			continue
+		}
+		recorded = true
+		if len(products) == 0 || listed(products, info) {
+			infos = append(infos, &info)
+		}
+	}
+	if !recorded {
+		fmt.Println(p.Name, "has no product infos recorded, fetching them")
+		return Infos(name, products)
+	}
+	return infos, nil
+}
+
+func listed(products []string, info product_info.Info) bool {
+	for _, id := range products {
+		if id == info.ID || id == info.DisplayName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package fake
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Binance streams depth updates and aggregated trades on combined streams,
+// the client syncs from the REST depth snapshot. Heartbeats are websocket pings.
+type Binance struct {
+	Base  string
+	Quote string
+}
+
+func (v *Binance) Platform() string { return "Binance" }
+func (v *Binance) Product() string  { return v.Base + "-" + v.Quote }
+func (v *Binance) symbol() string   { return v.Base + v.Quote }
+
+func (v *Binance) WebsocketURL(base string) string {
+	return base
+}
+
+func (v *Binance) Routes(mux *http.ServeMux, book *Book) {
+	mux.HandleFunc("/api/v1/exchangeInfo", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"symbols": []interface{}{map[string]interface{}{
+				"symbol":     v.symbol(),
+				"baseAsset":  v.Base,
+				"quoteAsset": v.Quote,
+				"filters": []interface{}{
+					map[string]interface{}{"filterType": "PRICE_FILTER", "minPrice": "0.01", "maxPrice": "1000000", "tickSize": "0.01"},
+					map[string]interface{}{"filterType": "LOT_SIZE", "stepSize": "0.00000001"},
+				},
+			}},
+		})
+	})
+	mux.HandleFunc("/api/v1/depth", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") != v.symbol() {
+			http.Error(w, `{"code":-1121,"msg":"Invalid symbol."}`, http.StatusBadRequest)
+			return
+		}
+		bids, asks, seq := book.Snapshot()
+		writeJSON(w, map[string]interface{}{"lastUpdateId": seq, "bids": stringLevels(bids), "asks": stringLevels(asks)})
+	})
+}
+
+// Open has nothing to answer, the streams are part of the url.
+func (v *Binance) Open(c *Conn, book *Book) error {
+	return nil
+}
+
+func (v *Binance) stream(name string) string {
+	return strings.ToLower(v.symbol()) + "@" + name
+}
+
+func (v *Binance) Update(c *Conn, seq uint64, bids, asks []Level) error {
+	return c.WriteJSON(map[string]interface{}{
+		"stream": v.stream("depth"),
+		"data": map[string]interface{}{
+			"e": "depthUpdate",
+			"E": millis(time.Now()),
+			"s": v.symbol(),
+			"U": seq,
+			"u": seq,
+			"b": stringLevels(bids),
+			"a": stringLevels(asks),
+		},
+	})
+}
+
+func (v *Binance) Trade(c *Conn, e Event) error {
+	now := millis(time.Now())
+	return c.WriteJSON(map[string]interface{}{
+		"stream": v.stream("aggTrade"),
+		"data": map[string]interface{}{
+			"e": "aggTrade",
+			"E": now,
+			"s": v.symbol(),
+			"a": e.ID,
+			"p": formatFloat(e.Price),
+			"q": formatFloat(e.Size),
+			"f": e.ID,
+			"l": e.ID,
+			"T": now,
+			"m": !e.Buy,
+		},
+	})
+}
+
+func (v *Binance) Heartbeat(c *Conn, book *Book) error {
+	return c.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+}
@@ -0,0 +1,132 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Bitfinex v2 greets with an info event, answers the conf and subscribe
+// events with channel ids and sends the book snapshot on the book channel.
+// Updates carry one level each, messages end with the timestamp the conf
+// flag asks for. The feed has no sequence, a reconnect resyncs.
+type Bitfinex struct {
+	Base  string
+	Quote string
+}
+
+const (
+	bitfinexBookChannel   = 1
+	bitfinexTradesChannel = 2
+)
+
+func (v *Bitfinex) Platform() string { return "Bitfinex" }
+func (v *Bitfinex) Product() string  { return v.Base + "-" + v.Quote }
+func (v *Bitfinex) symbol() string   { return "t" + v.Base + v.Quote }
+
+func (v *Bitfinex) WebsocketURL(base string) string {
+	return base + "/ws/2"
+}
+
+func (v *Bitfinex) Routes(mux *http.ServeMux, book *Book) {
+	mux.HandleFunc("/v1/symbols_details", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, []interface{}{map[string]interface{}{
+			"pair":               strings.ToLower(v.Base + v.Quote),
+			"price_precision":    5,
+			"minimum_order_size": "0.002",
+			"maximum_order_size": "2000.0",
+		}})
+	})
+}
+
+func (v *Bitfinex) Open(c *Conn, book *Book) error {
+	if err := c.WriteJSON(map[string]interface{}{"event": "info", "version": 2}); err != nil {
+		return err
+	}
+	// conf, then book and trades subscriptions
+	for i := 0; i < 3; i++ {
+		msg, err := c.Read()
+		if err != nil {
+			return err
+		}
+		var request map[string]interface{}
+		if err := json.Unmarshal(msg, &request); err != nil {
+			return err
+		}
+		switch request["event"] {
+		case "conf":
+			err = c.WriteJSON(map[string]interface{}{"event": "conf", "status": "OK", "flags": request["flags"]})
+		case "subscribe":
+			if request["symbol"] != v.symbol() {
+				return fmt.Errorf("unknown symbol %s", msg)
+			}
+			chanID := bitfinexBookChannel
+			if request["channel"] == "trades" {
+				chanID = bitfinexTradesChannel
+			}
+			err = c.WriteJSON(map[string]interface{}{"event": "subscribed", "channel": request["channel"], "chanId": chanID, "symbol": v.symbol()})
+		default:
+			return fmt.Errorf("unexpected request %s", msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	bids, asks, _ := book.Snapshot()
+	levels := [][]float64{}
+	for _, level := range bids {
+		levels = append(levels, bitfinexLevel(level, false))
+	}
+	for _, level := range asks {
+		levels = append(levels, bitfinexLevel(level, true))
+	}
+	now := millis(time.Now())
+	if err := c.WriteJSON([]interface{}{bitfinexBookChannel, levels, now}); err != nil {
+		return err
+	}
+	return c.WriteJSON([]interface{}{bitfinexTradesChannel, []interface{}{}, now})
+}
+
+// bitfinexLevel is [price, count, amount], asks have negative amounts and
+// removed levels a count of 0.
+func bitfinexLevel(level Level, ask bool) []float64 {
+	count, amount := 1.0, level.Size
+	if level.Size == 0 {
+		count, amount = 0, 1
+	}
+	if ask {
+		amount = -amount
+	}
+	return []float64{level.Price, count, amount}
+}
+
+func (v *Bitfinex) Update(c *Conn, seq uint64, bids, asks []Level) error {
+	now := millis(time.Now())
+	for _, side := range []struct {
+		levels []Level
+		ask    bool
+	}{{bids, false}, {asks, true}} {
+		for _, level := range side.levels {
+			if err := c.WriteJSON([]interface{}{bitfinexBookChannel, bitfinexLevel(level, side.ask), now}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *Bitfinex) Trade(c *Conn, e Event) error {
+	now := millis(time.Now())
+	amount := e.Size
+	if !e.Buy {
+		amount = -amount
+	}
+	return c.WriteJSON([]interface{}{bitfinexTradesChannel, "te", []interface{}{e.ID, now, amount, e.Price}, now})
+}
+
+func (v *Bitfinex) Heartbeat(c *Conn, book *Book) error {
+	return c.WriteJSON([]interface{}{bitfinexBookChannel, "hb", millis(time.Now())})
+}
@@ -0,0 +1,121 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bitstamp streams through pusher, the payload of every event is a JSON
+// string. The client syncs from the REST order book, the book timestamp is
+// the sequence. Heartbeats are pusher pings the client answers with pongs.
+type Bitstamp struct {
+	Base  string
+	Quote string
+}
+
+func (v *Bitstamp) Platform() string { return "Bitstamp" }
+func (v *Bitstamp) Product() string  { return v.Base + "-" + v.Quote }
+func (v *Bitstamp) pair() string     { return strings.ToLower(v.Base + v.Quote) }
+
+func (v *Bitstamp) WebsocketURL(base string) string {
+	return base + "/app/de504dc5763aeef9ff52?protocol=7&client=js&version=2.1.6&flash=false"
+}
+
+// channels are the diff and trade channels, BTC-USD has no suffix.
+func (v *Bitstamp) channels() (string, string) {
+	if v.Product() == "BTC-USD" {
+		return "diff_order_book", "live_trades"
+	}
+	return "diff_order_book_" + v.pair(), "live_trades_" + v.pair()
+}
+
+func (v *Bitstamp) Routes(mux *http.ServeMux, book *Book) {
+	mux.HandleFunc("/api/v2/order_book/"+v.pair(), func(w http.ResponseWriter, r *http.Request) {
+		bids, asks, seq := book.Snapshot()
+		writeJSON(w, map[string]interface{}{
+			"timestamp":      strconv.FormatUint(seq, 10),
+			"microtimestamp": micros(time.Now()),
+			"bids":           stringLevels(bids),
+			"asks":           stringLevels(asks),
+		})
+	})
+}
+
+func (v *Bitstamp) Open(c *Conn, book *Book) error {
+	if err := v.send(c, "pusher:connection_established", "", map[string]interface{}{"socket_id": "1.1", "activity_timeout": 120}); err != nil {
+		return err
+	}
+	diff, trades := v.channels()
+	for i := 0; i < 2; i++ {
+		msg, err := c.Read()
+		if err != nil {
+			return err
+		}
+		var subscribe struct {
+			Event string `json:"event"`
+			Data  struct {
+				Channel string `json:"channel"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(msg, &subscribe); err != nil {
+			return err
+		}
+		if subscribe.Event != "pusher:subscribe" || (subscribe.Data.Channel != diff && subscribe.Data.Channel != trades) {
+			return fmt.Errorf("unexpected subscription %s", msg)
+		}
+		if err := v.send(c, "pusher_internal:subscription_succeeded", subscribe.Data.Channel, map[string]interface{}{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send writes a pusher event, data is encoded as a JSON string.
+func (v *Bitstamp) send(c *Conn, event, channel string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	msg := map[string]interface{}{"event": event, "data": string(buf)}
+	if channel != "" {
+		msg["channel"] = channel
+	}
+	return c.WriteJSON(msg)
+}
+
+func (v *Bitstamp) Update(c *Conn, seq uint64, bids, asks []Level) error {
+	diff, _ := v.channels()
+	return v.send(c, "data", diff, map[string]interface{}{
+		"timestamp":      strconv.FormatUint(seq, 10),
+		"microtimestamp": micros(time.Now()),
+		"bids":           stringLevels(bids),
+		"asks":           stringLevels(asks),
+	})
+}
+
+func (v *Bitstamp) Trade(c *Conn, e Event) error {
+	_, trades := v.channels()
+	side := 1
+	if e.Buy {
+		side = 0
+	}
+	return v.send(c, "trade", trades, map[string]interface{}{
+		"id":             e.ID,
+		"price_str":      formatFloat(e.Price),
+		"amount_str":     formatFloat(e.Size),
+		"type":           side,
+		"microtimestamp": micros(time.Now()),
+	})
+}
+
+func (v *Bitstamp) Heartbeat(c *Conn, book *Book) error {
+	return c.WriteJSON(map[string]interface{}{"event": "pusher:ping", "data": "{}"})
+}
+
+func micros(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10)
+}
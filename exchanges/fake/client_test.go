@@ -0,0 +1,441 @@
+package fake_test
+
+// drive every websocket client end to end against a local fake of its venue,
+// record into a bolt database and check the replayed book and trades against
+// the fake, then rebuild the recording from its capture
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges"
+	_ "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitfinex/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitstamp/websocket"
+	"github.com/lian/gdax-bookmap/exchanges/capture"
+	_ "github.com/lian/gdax-bookmap/exchanges/coinbase/websocket"
+	"github.com/lian/gdax-bookmap/exchanges/fake"
+	_ "github.com/lian/gdax-bookmap/exchanges/kraken/websocket"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+var (
+	scriptEvents   = flag.Int("events", 400, "scripted events per platform")
+	scriptSeed     = flag.Int64("seed", 1, "seed of the scripts")
+	scriptInterval = flag.Duration("interval", time.Millisecond, "delay between scripted events")
+	scriptTimeout  = flag.Duration("timeout", 30*time.Second, "time limit per platform")
+)
+
+type Scenario struct {
+	Venue fake.Venue
+	// Drops are sequence gaps the client notices and resyncs from by itself,
+	// feeds without sequence only recover from a gap on the next reconnect
+	Drops bool
+	// Resync is the REST path the client syncs the book from, if any
+	Resync string
+	// Pong is the client answer to a heartbeat, if it has to answer
+	Pong string
+	// Checksum feeds verify the top of the book, the drops also change the
+	// best bid so the next update shows the client its book is off
+	Checksum bool
+	// Resubscribe is part of the book subscription a client sends on every
+	// connection and again for a new snapshot after a drop, if it does
+	Resubscribe string
+}
+
+func TestBinance(t *testing.T) {
+	runScenario(t, Scenario{Venue: &fake.Binance{Base: "BTC", Quote: "USDT"}, Drops: true, Resync: "/api/v1/depth", Pong: "pong"})
+}
+
+func TestCoinbase(t *testing.T) {
+	runScenario(t, Scenario{Venue: &fake.Coinbase{Base: "BTC", Quote: "USD"}})
+}
+
+func TestBitstamp(t *testing.T) {
+	runScenario(t, Scenario{Venue: &fake.Bitstamp{Base: "BTC", Quote: "USD"}, Resync: "/api/v2/order_book/btcusd", Pong: `{"event":"pusher:pong"}`})
+}
+
+func TestBitfinex(t *testing.T) {
+	runScenario(t, Scenario{Venue: &fake.Bitfinex{Base: "BTC", Quote: "USD"}})
+}
+
+func TestKraken(t *testing.T) {
+	runScenario(t, Scenario{Venue: &fake.Kraken{Base: "BTC", Quote: "USD"}, Drops: true, Checksum: true, Resubscribe: `"channel":"book"`})
+}
+
+// newScript builds a feed of n events. It starts with an update, since
+// clients that sync over REST drop the first message, and never ends on a
+// gap so every drop is followed by something that recovers from it.
+func newScript(rng *rand.Rand, n int, scenario *Scenario) []fake.Event {
+	script := []fake.Event{randomUpdate(rng)}
+	var tradeID uint64 = 5000
+	for i := 1; i < n; i++ {
+		switch {
+		case i == n/2:
+			script = append(script, fake.Event{Kind: fake.Reconnect})
+		case i == n/3 || i == 2*n/3:
+			// remove and add levels no update touches, only a resync shows them
+			level := 40 + i%2
+			drop := fake.Event{
+				Kind: fake.Drop,
+				Bids: []fake.Level{{Price: bidPrice(level), Size: 0}},
+				Asks: []fake.Level{{Price: askPrice(level + 2), Size: randomSize(rng)}},
+			}
+			if scenario.Checksum {
+				// a size no update sets, followed by an update away from the
+				// top levels that can not hide it
+				drop.Bids = append(drop.Bids, fake.Level{Price: bidPrice(0), Size: float64(1000 + i)})
+				script = append(script, drop, fake.Event{
+					Kind: fake.Update,
+					Asks: []fake.Level{{Price: askPrice(35), Size: randomSize(rng)}},
+				})
+			} else {
+				script = append(script, drop)
+			}
+			if !scenario.Drops {
+				script = append(script, fake.Event{Kind: fake.Reconnect})
+			}
+		}
+
+		switch r := rng.Float64(); {
+		case r < 0.75:
+			script = append(script, randomUpdate(rng))
+		case r < 0.95:
+			tradeID += 1
+			script = append(script, fake.Event{
+				Kind:  fake.Trade,
+				ID:    tradeID,
+				Buy:   rng.Intn(2) == 0,
+				Price: bidPrice(rng.Intn(5)),
+				Size:  randomSize(rng),
+			})
+		default:
+			script = append(script, fake.Event{Kind: fake.Heartbeat})
+		}
+	}
+	return append(script, randomUpdate(rng))
+}
+
+// bids are at 99.99 and below, asks at 100.00 and above, on a 0.05 grid
+func bidPrice(i int) float64 { return float64(9999-5*i) / 100 }
+func askPrice(i int) float64 { return float64(10000+5*i) / 100 }
+
+func randomSize(rng *rand.Rand) float64 {
+	return float64(1+rng.Intn(50000)) / 10000
+}
+
+func initialBook() ([]fake.Level, []fake.Level) {
+	bids, asks := []fake.Level{}, []fake.Level{}
+	for i := 0; i < 20; i++ {
+		bids = append(bids, fake.Level{Price: bidPrice(i), Size: float64(i + 1)})
+		asks = append(asks, fake.Level{Price: askPrice(i), Size: float64(i + 1)})
+	}
+	// removed by the dropped updates
+	bids = append(bids, fake.Level{Price: bidPrice(40), Size: 1}, fake.Level{Price: bidPrice(41), Size: 1})
+	return bids, asks
+}
+
+func randomUpdate(rng *rand.Rand) fake.Event {
+	e := fake.Event{Kind: fake.Update}
+	for i := 0; i < 1+rng.Intn(3); i++ {
+		level := fake.Level{Size: randomSize(rng)}
+		if rng.Intn(4) == 0 {
+			level.Size = 0
+		}
+		if rng.Intn(2) == 0 {
+			level.Price = bidPrice(rng.Intn(30))
+			e.Bids = append(e.Bids, level)
+		} else {
+			level.Price = askPrice(rng.Intn(30))
+			e.Asks = append(e.Asks, level)
+		}
+	}
+	return e
+}
+
+func count(script []fake.Event, kind fake.Kind) int {
+	n := 0
+	for _, e := range script {
+		if e.Kind == kind {
+			n += 1
+		}
+	}
+	return n
+}
+
+func runScenario(t *testing.T, scenario Scenario) {
+	venue := scenario.Venue
+	rng := rand.New(rand.NewSource(*scriptSeed))
+	bids, asks := initialBook()
+	script := newScript(rng, *scriptEvents, &scenario)
+
+	srv := fake.Start(venue, fake.NewBook(1000, bids, asks), script)
+	srv.Interval = *scriptInterval
+	defer srv.Close()
+	if err := exchanges.SetEndpoints(venue.Platform(), srv.Endpoints()); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	db, err := util.OpenStore("bolt", filepath.Join(dir, venue.Platform()+".db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	infos, err := exchanges.Infos(venue.Platform(), []string{venue.Product()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := exchanges.New(venue.Platform(), db, infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := client.Infos()[0]
+	if info.DatabaseKey == "" {
+		t.Fatalf("no product info for %s", venue.Product())
+	}
+	// frequent checkpoints, so the replay starts at a sync written mid feed
+	policy := util.CheckpointPolicy{Diffs: 5}
+	client.SetCheckpointPolicy(policy)
+
+	capturePath := capture.Path(dir, venue.Platform())
+	captureLog, err := capture.Create(capturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCapture(captureLog)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		client.Run(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-srv.Done():
+		settle(client, *scriptTimeout)
+	case <-time.After(*scriptTimeout):
+	}
+	cancel()
+	<-stopped
+	if err := captureLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-srv.Done():
+	default:
+		t.Fatalf("script not played within %s", *scriptTimeout)
+	}
+
+	reconnects, drops := count(script, fake.Reconnect), count(script, fake.Drop)
+	if n := srv.Connections(); n != reconnects+1 {
+		t.Fatalf("%d connections, expected %d", n, reconnects+1)
+	}
+	if scenario.Resync != "" {
+		// feeds without sequence sync on every connection, sequenced feeds
+		// on gaps unless the snapshot before already covers the gap
+		min, max := 1+reconnects, 1+reconnects
+		if scenario.Drops {
+			min, max = 1, 1+drops
+		}
+		if n := srv.Requests(scenario.Resync); n < min || n > max {
+			t.Fatalf("%d book snapshot requests, expected %d to %d", n, min, max)
+		}
+	}
+	if scenario.Resubscribe != "" {
+		subscriptions := 0
+		for _, msg := range srv.Received() {
+			if strings.Contains(msg, `"subscribe"`) && strings.Contains(msg, scenario.Resubscribe) {
+				subscriptions += 1
+			}
+		}
+		if expected := 1 + reconnects + drops; subscriptions != expected {
+			t.Fatalf("%d book subscriptions, expected %d", subscriptions, expected)
+		}
+	}
+	if scenario.Pong != "" {
+		pongs := 0
+		for _, msg := range srv.Received() {
+			if strings.TrimSpace(msg) == scenario.Pong {
+				pongs += 1
+			}
+		}
+		if heartbeats := count(script, fake.Heartbeat); pongs != heartbeats {
+			t.Fatalf("%d heartbeats answered, expected %d", pongs, heartbeats)
+		}
+	}
+
+	records := verify(t, db, info.DatabaseKey, srv.Book, script)
+	frames := reingest(t, db, info.DatabaseKey, capturePath, policy)
+	bids, asks, _ = srv.Book.Snapshot()
+	t.Logf("%d events, %d connections, %d drops, %d trades, %d levels, %d records, %d frames reingested",
+		len(script), srv.Connections(), drops, count(script, fake.Trade), len(bids)+len(asks), records, frames)
+}
+
+// reingest rebuilds the recording from the capture at path and compares it
+// with db. Only the final flush happens at another time, the end of the
+// capture instead of the shutdown of the client.
+func reingest(t *testing.T, db store.Store, bucket, path string, policy util.CheckpointPolicy) int {
+	t.Helper()
+	r, err := capture.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	rebuilt := store.NewMemory()
+	defer rebuilt.Close()
+	frames, err := exchanges.Reingest(r, rebuilt, policy, nil, false)
+	if err != nil {
+		t.Fatalf("reingest: %s", err)
+	}
+
+	recorded, replayed := events(t, db, bucket), events(t, rebuilt, bucket)
+	if len(replayed) != len(recorded) {
+		t.Fatalf("reingest: %d records, recorded %d", len(replayed), len(recorded))
+	}
+	for i, e := range recorded {
+		if !bytes.Equal(replayed[i].Data, e.Data) {
+			t.Fatalf("reingest: record %d at %s differs", i, e.Time)
+		}
+		last := i == len(recorded)-1
+		if !replayed[i].Time.Equal(e.Time) && !(last && replayed[i].Time.Before(e.Time)) {
+			t.Fatalf("reingest: record %d at %s, recorded at %s", i, replayed[i].Time, e.Time)
+		}
+	}
+	return frames
+}
+
+func events(t *testing.T, db store.Store, bucket string) []store.Event {
+	t.Helper()
+	c, err := db.Cursor(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	list := []store.Event{}
+	for e, ok := c.First(); ok; e, ok = c.Next() {
+		list = append(list, e)
+	}
+	return list
+}
+
+// settle waits until the client has handled the messages it received, its
+// message count stops changing.
+func settle(client exchanges.Exchange, timeout time.Duration) {
+	var last uint64
+	changed := time.Now()
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		var n uint64
+		for _, book := range client.Status().Books {
+			n += book.Messages
+		}
+		if n != last {
+			last, changed = n, time.Now()
+		} else if time.Since(changed) > 250*time.Millisecond {
+			return
+		}
+	}
+}
+
+// verify decodes the recording and compares its trades and final book with
+// the script and the book of the fake, it returns the number of records.
+func verify(t *testing.T, db store.Store, bucket string, expected *fake.Book, script []fake.Event) int {
+	t.Helper()
+	trades := []fake.Event{}
+	for _, e := range script {
+		if e.Kind == fake.Trade {
+			trades = append(trades, e)
+		}
+	}
+
+	book := orderbook.New(bucket)
+	records, n := 0, 0
+	err := util.ReplayBucket(db, bucket, time.Time{}, time.Time{}, book, func(ts time.Time, pkt *orderbook.Packet, err error) error {
+		records += 1
+		if err != nil {
+			t.Fatalf("record %s: %s", ts, err)
+		}
+		if pkt.Type != orderbook.TradePacket {
+			return nil
+		}
+		if n >= len(trades) {
+			t.Fatalf("unexpected trade %d", pkt.TradeID)
+		}
+		e := trades[n]
+		n += 1
+		side := orderbook.BidSide
+		if e.Buy {
+			side = orderbook.AskSide
+		}
+		switch {
+		case pkt.TradeID != e.ID:
+			t.Fatalf("trade %d recorded as %d", e.ID, pkt.TradeID)
+		case !pkt.Aggressor || pkt.Side != uint8(side):
+			t.Fatalf("trade %d side %d aggressor %v, expected %d", e.ID, pkt.Side, pkt.Aggressor, side)
+		case !equal(pkt.PriceScale.Float(pkt.Price), e.Price) || !equal(pkt.SizeScale.Float(pkt.Size), e.Size):
+			t.Fatalf("trade %d %s@%s, expected %v@%v", e.ID,
+				pkt.SizeScale.Format(pkt.Size), pkt.PriceScale.Format(pkt.Price), e.Size, e.Price)
+		case pkt.ExchangeTime.IsZero():
+			t.Fatalf("trade %d without exchange time", e.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(trades) {
+		t.Fatalf("%d trades recorded, expected %d", n, len(trades))
+	}
+
+	bids, asks, _ := expected.Snapshot()
+	recordedBids, recordedAsks := levels(book.Bid), levels(book.Ask)
+	for _, side := range []struct {
+		name     string
+		levels   orderbook.BookLevelList
+		expected []fake.Level
+		best     func(i int) int
+	}{
+		{"bid", recordedBids, bids, func(i int) int { return len(recordedBids) - 1 - i }},
+		{"ask", recordedAsks, asks, func(i int) int { return i }},
+	} {
+		if len(side.levels) != len(side.expected) {
+			t.Fatalf("%d %s levels, expected %d", len(side.levels), side.name, len(side.expected))
+		}
+		for i, e := range side.expected {
+			level := side.levels[side.best(i)]
+			price, size := book.PriceScale.Float(level.Price), book.SizeScale.Float(level.Quantity)
+			if !equal(price, e.Price) || !equal(size, e.Size) {
+				t.Fatalf("%s level %d is %v@%v, expected %v@%v", side.name, i, size, price, e.Size, e.Price)
+			}
+		}
+	}
+	return records
+}
+
+// levels drops the removed levels the book keeps for their stats.
+func levels(list orderbook.BookLevelList) orderbook.BookLevelList {
+	active := orderbook.BookLevelList{}
+	for _, level := range list {
+		if level.Quantity > 0 {
+			active = append(active, level)
+		}
+	}
+	return active
+}
+
+func equal(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
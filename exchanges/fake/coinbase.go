@@ -0,0 +1,107 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Coinbase sends a level2 snapshot after the subscription, then l2updates,
+// tickers and heartbeats. The feed has no sequence, a reconnect resyncs.
+type Coinbase struct {
+	Base  string
+	Quote string
+}
+
+func (v *Coinbase) Platform() string { return "Coinbase" }
+func (v *Coinbase) Product() string  { return v.Base + "-" + v.Quote }
+
+func (v *Coinbase) WebsocketURL(base string) string {
+	return base
+}
+
+func (v *Coinbase) Routes(mux *http.ServeMux, book *Book) {
+	mux.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, []interface{}{map[string]interface{}{
+			"id":              v.Product(),
+			"display_name":    v.Base + "/" + v.Quote,
+			"base_currency":   v.Base,
+			"quote_currency":  v.Quote,
+			"base_min_size":   "0.001",
+			"base_max_size":   "10000",
+			"quote_increment": "0.01",
+			"base_increment":  "0.00000001",
+		}})
+	})
+}
+
+func (v *Coinbase) Open(c *Conn, book *Book) error {
+	msg, err := c.Read()
+	if err != nil {
+		return err
+	}
+	var subscribe struct {
+		Type       string   `json:"type"`
+		ProductIDs []string `json:"product_ids"`
+		Channels   []string `json:"channels"`
+	}
+	if err := json.Unmarshal(msg, &subscribe); err != nil {
+		return err
+	}
+	if subscribe.Type != "subscribe" {
+		return fmt.Errorf("expected subscribe, got %s", msg)
+	}
+	if err := c.WriteJSON(map[string]interface{}{"type": "subscriptions", "channels": subscribe.Channels}); err != nil {
+		return err
+	}
+
+	bids, asks, _ := book.Snapshot()
+	return c.WriteJSON(map[string]interface{}{
+		"type":       "snapshot",
+		"product_id": v.Product(),
+		"bids":       stringLevels(bids),
+		"asks":       stringLevels(asks),
+	})
+}
+
+func (v *Coinbase) Update(c *Conn, seq uint64, bids, asks []Level) error {
+	changes := [][]string{}
+	for _, level := range bids {
+		changes = append(changes, []string{"buy", formatFloat(level.Price), formatFloat(level.Size)})
+	}
+	for _, level := range asks {
+		changes = append(changes, []string{"sell", formatFloat(level.Price), formatFloat(level.Size)})
+	}
+	return c.WriteJSON(map[string]interface{}{
+		"type":       "l2update",
+		"product_id": v.Product(),
+		"time":       time.Now().UTC().Format(time.RFC3339Nano),
+		"changes":    changes,
+	})
+}
+
+func (v *Coinbase) Trade(c *Conn, e Event) error {
+	side := "sell"
+	if e.Buy {
+		side = "buy"
+	}
+	return c.WriteJSON(map[string]interface{}{
+		"type":       "ticker",
+		"product_id": v.Product(),
+		"price":      formatFloat(e.Price),
+		"last_size":  formatFloat(e.Size),
+		"side":       side,
+		"trade_id":   e.ID,
+		"time":       time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
+
+func (v *Coinbase) Heartbeat(c *Conn, book *Book) error {
+	return c.WriteJSON(map[string]interface{}{
+		"type":       "heartbeat",
+		"product_id": v.Product(),
+		"sequence":   book.Sequence(),
+		"time":       time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
@@ -0,0 +1,413 @@
+// Package fake serves scripted exchange feeds from local httptest servers, so
+// the websocket clients can be driven end to end without the real venues.
+// Each venue file translates the scripted events into the wire format of
+// that venue, the Server plays them and serves the REST api next to it.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+)
+
+type Level struct {
+	Price float64
+	Size  float64
+}
+
+type Kind int
+
+const (
+	// Update changes book levels, sent with the next sequence number
+	Update Kind = iota
+	// Trade is a taker trade
+	Trade
+	// Heartbeat is the keepalive message of the venue
+	Heartbeat
+	// Drop changes book levels like Update but is never sent, the client
+	// sees a sequence gap
+	Drop
+	// Reconnect closes the connection with a close handshake, the rest of
+	// the script is played on the next one
+	Reconnect
+)
+
+var kindNames = []string{"update", "trade", "heartbeat", "drop", "reconnect"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind" + strconv.Itoa(int(k))
+}
+
+// Event is one step of a scripted feed. Size 0 removes a level.
+type Event struct {
+	Kind Kind
+	Bids []Level
+	Asks []Level
+	// Trade
+	ID    uint64
+	Buy   bool // taker side
+	Price float64
+	Size  float64
+}
+
+// Book is the order book behind a fake feed. Every scripted update is applied
+// to it whether it is sent or dropped, so snapshots always show the true state.
+type Book struct {
+	mu       sync.Mutex
+	bids     map[float64]float64
+	asks     map[float64]float64
+	sequence uint64
+}
+
+func NewBook(sequence uint64, bids, asks []Level) *Book {
+	b := &Book{bids: map[float64]float64{}, asks: map[float64]float64{}, sequence: sequence}
+	b.apply(bids, asks)
+	return b
+}
+
+// apply changes the levels and returns the new sequence.
+func (b *Book) apply(bids, asks []Level) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, side := range []struct {
+		levels []Level
+		book   map[float64]float64
+	}{{bids, b.bids}, {asks, b.asks}} {
+		for _, level := range side.levels {
+			if level.Size == 0 {
+				delete(side.book, level.Price)
+			} else {
+				side.book[level.Price] = level.Size
+			}
+		}
+	}
+	b.sequence += 1
+	return b.sequence
+}
+
+// Snapshot returns the levels best first and the sequence of the last update.
+func (b *Book) Snapshot() ([]Level, []Level, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bids, asks := levels(b.bids), levels(b.asks)
+	sort.Slice(bids, func(i, j int) bool { return bids[i].Price > bids[j].Price })
+	sort.Slice(asks, func(i, j int) bool { return asks[i].Price < asks[j].Price })
+	return bids, asks, b.sequence
+}
+
+// Sequence is the sequence of the last update.
+func (b *Book) Sequence() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sequence
+}
+
+func levels(book map[float64]float64) []Level {
+	list := make([]Level, 0, len(book))
+	for price, size := range book {
+		list = append(list, Level{Price: price, Size: size})
+	}
+	return list
+}
+
+// Venue translates scripted events into the messages of an exchange.
+type Venue interface {
+	// Platform is the registered name of the client the venue fakes
+	Platform() string
+	// Product is the product id the client is started with
+	Product() string
+	// WebsocketURL returns the url the client dials on a server at base (ws://host:port)
+	WebsocketURL(base string) string
+	// Routes adds the REST api of the venue to mux
+	Routes(mux *http.ServeMux, book *Book)
+	// Open answers the subscriptions of a new connection
+	Open(c *Conn, book *Book) error
+	Update(c *Conn, seq uint64, bids, asks []Level) error
+	Trade(c *Conn, e Event) error
+	Heartbeat(c *Conn, book *Book) error
+}
+
+// Answerer is implemented by venues whose clients send requests while the
+// script plays, like a new subscription.
+type Answerer interface {
+	Answer(c *Conn, msg []byte) error
+}
+
+// Conn is a client connection, its messages are read in the background.
+type Conn struct {
+	*websocket.Conn
+	messages chan []byte
+}
+
+// Read returns the next message of the client.
+func (c *Conn) Read() ([]byte, error) {
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			return nil, io.EOF
+		}
+		return msg, nil
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("timeout waiting for a client message")
+	}
+}
+
+// Server plays a script to the websocket connections of a client, one
+// connection after another, and serves the REST api of the venue.
+type Server struct {
+	*httptest.Server
+	Venue Venue
+	Book  *Book
+	// Interval is the delay between events, set it before the client connects
+	Interval time.Duration
+
+	mux         *http.ServeMux
+	mu          sync.Mutex
+	script      []Event
+	next        int
+	connections int
+	requests    map[string]int
+	received    []string
+	done        chan struct{}
+}
+
+var upgrader = websocket.Upgrader{}
+
+func Start(venue Venue, book *Book, script []Event) *Server {
+	s := &Server{
+		Venue:    venue,
+		Book:     book,
+		Interval: time.Millisecond,
+		mux:      http.NewServeMux(),
+		script:   script,
+		requests: map[string]int{},
+		done:     make(chan struct{}),
+	}
+	venue.Routes(s.mux, book)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoints points the platform of the venue at the server.
+func (s *Server) Endpoints() exchanges.Endpoints {
+	return exchanges.Endpoints{
+		Websocket: s.Venue.WebsocketURL("ws" + strings.TrimPrefix(s.URL, "http")),
+		API:       s.URL,
+	}
+}
+
+// Done is closed once the whole script has been played.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Connections is the number of websocket connections so far.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// Requests is the number of REST requests of path so far.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// Received returns all messages of the client, pongs to websocket pings are
+// recorded as "pong".
+func (s *Server) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.received...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebsocket(w, r)
+		return
+	}
+	s.mu.Lock()
+	s.requests[r.URL.Path] += 1
+	s.mu.Unlock()
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("fake", s.Venue.Platform(), "upgrade:", err)
+		return
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.connections += 1
+	s.mu.Unlock()
+
+	c := &Conn{Conn: conn, messages: make(chan []byte, 1024)}
+	conn.SetPongHandler(func(string) error {
+		s.record([]byte("pong"))
+		return nil
+	})
+	closed := make(chan struct{})
+	go s.read(c, closed)
+
+	if err := s.Venue.Open(c, s.Book); err != nil {
+		log.Println("fake", s.Venue.Platform(), "open:", err)
+		return
+	}
+
+	for {
+		if err := s.answer(c, false); err != nil {
+			log.Println("fake", s.Venue.Platform(), "answer:", err)
+			return
+		}
+		e, ok := s.nextEvent()
+		if !ok {
+			// keep the connection until the client goes away
+			if err := s.answer(c, true); err != nil {
+				log.Println("fake", s.Venue.Platform(), "answer:", err)
+			}
+			<-closed
+			return
+		}
+		time.Sleep(s.Interval)
+		if e.Kind == Reconnect {
+			// close handshake, the client still answers what it read before
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "reconnect")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		if err := s.play(c, e); err != nil {
+			log.Println("fake", s.Venue.Platform(), e.Kind, err)
+			return
+		}
+	}
+}
+
+// answer passes the messages of the client to venues that answer them, the
+// waiting ones or, with wait, all until the client goes away.
+func (s *Server) answer(c *Conn, wait bool) error {
+	a, ok := s.Venue.(Answerer)
+	if !ok {
+		return nil
+	}
+	for {
+		var msg []byte
+		if wait {
+			if msg, ok = <-c.messages; !ok {
+				return nil
+			}
+		} else {
+			select {
+			case msg, ok = <-c.messages:
+				if !ok {
+					return nil
+				}
+			default:
+				return nil
+			}
+		}
+		if err := a.Answer(c, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) read(c *Conn, closed chan struct{}) {
+	defer close(closed)
+	defer close(c.messages)
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.record(msg)
+		select {
+		case c.messages <- msg:
+		default:
+		}
+	}
+}
+
+func (s *Server) record(msg []byte) {
+	s.mu.Lock()
+	s.received = append(s.received, string(msg))
+	s.mu.Unlock()
+}
+
+func (s *Server) nextEvent() (Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next >= len(s.script) {
+		if s.next == len(s.script) {
+			s.next += 1
+			close(s.done)
+		}
+		return Event{}, false
+	}
+	e := s.script[s.next]
+	s.next += 1
+	return e, true
+}
+
+func (s *Server) play(c *Conn, e Event) error {
+	switch e.Kind {
+	case Update:
+		seq := s.Book.apply(e.Bids, e.Asks)
+		return s.Venue.Update(c, seq, e.Bids, e.Asks)
+	case Drop:
+		s.Book.apply(e.Bids, e.Asks)
+	case Trade:
+		return s.Venue.Trade(c, e)
+	case Heartbeat:
+		return s.Venue.Heartbeat(c, s.Book)
+	default:
+		return fmt.Errorf("unknown event")
+	}
+	return nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// stringLevels formats levels as [["price", "size"], ...].
+func stringLevels(levels []Level) [][]string {
+	list := make([][]string, 0, len(levels))
+	for _, level := range levels {
+		list = append(list, []string{formatFloat(level.Price), formatFloat(level.Size)})
+	}
+	return list
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("fake write:", err)
+	}
+}
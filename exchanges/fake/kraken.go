@@ -0,0 +1,186 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kraken v2 greets with a status message and answers the book subscription
+// with a snapshot. Every book message carries the CRC32 of the top ten
+// levels of the true book, so a client that missed a dropped update notices
+// on the next one and resubscribes for a new snapshot. The feed has no
+// sequence, heartbeats are messages on the heartbeat channel.
+type Kraken struct {
+	Base  string
+	Quote string
+
+	// the book of the server, book messages are checksummed against it
+	book *Book
+}
+
+const (
+	krakenPriceDecimals = 2
+	krakenQtyDecimals   = 8
+)
+
+func (v *Kraken) Platform() string { return "Kraken" }
+func (v *Kraken) Product() string  { return v.Base + "-" + v.Quote }
+func (v *Kraken) symbol() string   { return v.Base + "/" + v.Quote }
+
+// wsname is the pair name of the REST api, kraken calls bitcoin XBT.
+func (v *Kraken) wsname() string {
+	base := v.Base
+	if base == "BTC" {
+		base = "XBT"
+	}
+	return base + "/" + v.Quote
+}
+
+func (v *Kraken) WebsocketURL(base string) string {
+	return base + "/v2"
+}
+
+func (v *Kraken) Routes(mux *http.ServeMux, book *Book) {
+	v.book = book
+	mux.HandleFunc("/0/public/AssetPairs", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Replace(v.wsname(), "/", "", 1)
+		writeJSON(w, map[string]interface{}{
+			"error": []string{},
+			"result": map[string]interface{}{
+				name: map[string]interface{}{
+					"altname":       name,
+					"wsname":        v.wsname(),
+					"pair_decimals": krakenPriceDecimals,
+					"lot_decimals":  krakenQtyDecimals,
+					"ordermin":      "0.0001",
+					"status":        "online",
+				},
+			},
+		})
+	})
+}
+
+func (v *Kraken) Open(c *Conn, book *Book) error {
+	status := map[string]interface{}{"system": "online", "api_version": "v2", "connection_id": 1, "version": "2.0.0"}
+	if err := c.WriteJSON(map[string]interface{}{"channel": "status", "type": "update", "data": []interface{}{status}}); err != nil {
+		return err
+	}
+	// book and trade subscriptions
+	for i := 0; i < 2; i++ {
+		msg, err := c.Read()
+		if err != nil {
+			return err
+		}
+		if err := v.Answer(c, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Answer replies to a request of the client, a book subscription gets a snapshot.
+func (v *Kraken) Answer(c *Conn, msg []byte) error {
+	var request struct {
+		Method string `json:"method"`
+		Params struct {
+			Channel string   `json:"channel"`
+			Symbol  []string `json:"symbol"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &request); err != nil {
+		return err
+	}
+	if request.Method == "ping" {
+		return c.WriteJSON(map[string]interface{}{"method": "pong"})
+	}
+	if request.Method != "subscribe" && request.Method != "unsubscribe" {
+		return fmt.Errorf("unexpected request %s", msg)
+	}
+	if len(request.Params.Symbol) != 1 || request.Params.Symbol[0] != v.symbol() {
+		return fmt.Errorf("unknown symbol %s", msg)
+	}
+	result := map[string]interface{}{"channel": request.Params.Channel, "symbol": v.symbol()}
+	if err := c.WriteJSON(map[string]interface{}{"method": request.Method, "result": result, "success": true}); err != nil {
+		return err
+	}
+	if request.Method == "subscribe" && request.Params.Channel == "book" {
+		bids, asks, _ := v.book.Snapshot()
+		return v.send(c, "snapshot", bids, asks)
+	}
+	return nil
+}
+
+func krakenLevels(levels []Level) []interface{} {
+	list := make([]interface{}, 0, len(levels))
+	for _, level := range levels {
+		list = append(list, map[string]interface{}{"price": level.Price, "qty": level.Size})
+	}
+	return list
+}
+
+// send writes a book message of kind snapshot or update with the checksum of the true book.
+func (v *Kraken) send(c *Conn, kind string, bids, asks []Level) error {
+	return c.WriteJSON(map[string]interface{}{
+		"channel": "book",
+		"type":    kind,
+		"data": []interface{}{map[string]interface{}{
+			"symbol":    v.symbol(),
+			"bids":      krakenLevels(bids),
+			"asks":      krakenLevels(asks),
+			"checksum":  krakenChecksum(v.book),
+			"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		}},
+	})
+}
+
+// krakenChecksum is the CRC32 of the ten best asks and then the ten best
+// bids, prices and sizes in the precision of the pair without the decimal
+// point and leading zeros.
+func krakenChecksum(book *Book) uint32 {
+	bids, asks, _ := book.Snapshot()
+	var buf strings.Builder
+	for _, side := range [][]Level{asks, bids} {
+		for i := 0; i < len(side) && i < 10; i++ {
+			buf.WriteString(krakenDigits(side[i].Price, krakenPriceDecimals))
+			buf.WriteString(krakenDigits(side[i].Size, krakenQtyDecimals))
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(buf.String()))
+}
+
+func krakenDigits(v float64, decimals int) string {
+	return strings.TrimLeft(strings.Replace(strconv.FormatFloat(v, 'f', decimals, 64), ".", "", 1), "0")
+}
+
+func (v *Kraken) Update(c *Conn, seq uint64, bids, asks []Level) error {
+	return v.send(c, "update", bids, asks)
+}
+
+func (v *Kraken) Trade(c *Conn, e Event) error {
+	side := "sell"
+	if e.Buy {
+		side = "buy"
+	}
+	return c.WriteJSON(map[string]interface{}{
+		"channel": "trade",
+		"type":    "update",
+		"data": []interface{}{map[string]interface{}{
+			"symbol":    v.symbol(),
+			"side":      side,
+			"price":     e.Price,
+			"qty":       e.Size,
+			"ord_type":  "market",
+			"trade_id":  e.ID,
+			"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		}},
+	})
+}
+
+func (v *Kraken) Heartbeat(c *Conn, book *Book) error {
+	return c.WriteJSON(map[string]interface{}{"channel": "heartbeat"})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 )
 
@@ -14,20 +15,22 @@ const AskSide Side = 1
 
 type Order struct {
 	ID    string
-	Size  float64
-	Price float64
+	Size  fixed.Value
+	Price fixed.Value
 	Side  Side
 	Time  time.Time
+	// exchange trade ID of matches
+	TradeID uint64
 }
 
 type BookLevel struct {
-	Price  float64
+	Price  fixed.Value
 	Orders []*Order
 }
 
 type LevelDiff struct {
-	Price float64
-	Size  float64
+	Price fixed.Value
+	Size  fixed.Value
 }
 
 func (bl *BookLevel) Add(order *Order) {
@@ -58,8 +61,8 @@ func (bl *BookLevel) Remove(order *Order) {
 	}
 }
 
-func (bl *BookLevel) Size() float64 {
-	var size float64
+func (bl *BookLevel) Size() fixed.Value {
+	var size fixed.Value
 	for _, o := range bl.Orders {
 		size += o.Size
 	}
@@ -78,27 +81,47 @@ type BookLevelDiff struct {
 type Book struct {
 	ID          string
 	ProductInfo product_info.Info
-	Bid         map[float64]*BookLevel
-	Ask         map[float64]*BookLevel
+	Bid         map[fixed.Value]*BookLevel
+	Ask         map[fixed.Value]*BookLevel
 	OrderMap    map[string]*Order
 	Sequence    uint64
 	Trades      []*Order
 	Diff        *BookLevelDiff
+	PriceScale  fixed.Scale
+	SizeScale   fixed.Scale
+	// exchange timestamp of the last full channel message
+	ExchangeTime time.Time
 }
 
 func New(id string) *Book {
 	b := &Book{
 		ID:          id,
 		ProductInfo: FetchProductInfo(id),
-		Bid:         map[float64]*BookLevel{},
-		Ask:         map[float64]*BookLevel{},
+		Bid:         map[fixed.Value]*BookLevel{},
+		Ask:         map[fixed.Value]*BookLevel{},
 		OrderMap:    map[string]*Order{},
 		Trades:      []*Order{},
 	}
+	b.PriceScale = b.ProductInfo.PriceScale()
+	b.SizeScale = b.ProductInfo.SizeScale()
 	b.ResetDiff()
 	return b
 }
 
+// ParseLevel reads the price and size strings of an order, the error names
+// the product so the caller can log it and skip the order.
+func (b *Book) ParseLevel(price, size string) (fixed.Value, fixed.Value, error) {
+	p, err := b.PriceScale.Parse(price)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s price: %w", b.ID, err)
+	}
+	s, err := b.SizeScale.Parse(size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s size: %w", b.ID, err)
+	}
+	return p, s, nil
+}
+
 func (b *Book) ResetDiff() {
 	b.Diff = nil
 	b.Diff = &BookLevelDiff{
@@ -108,8 +131,8 @@ func (b *Book) ResetDiff() {
 }
 
 func (b *Book) Clear() {
-	b.Bid = map[float64]*BookLevel{}
-	b.Ask = map[float64]*BookLevel{}
+	b.Bid = map[fixed.Value]*BookLevel{}
+	b.Ask = map[fixed.Value]*BookLevel{}
 	b.OrderMap = map[string]*Order{}
 	b.ResetDiff()
 }
@@ -117,8 +140,8 @@ func (b *Book) Clear() {
 func (b *Book) Add(data map[string]interface{}) {
 	order := &Order{
 		ID:    data["id"].(string),
-		Size:  data["size"].(float64),
-		Price: data["price"].(float64),
+		Size:  data["size"].(fixed.Value),
+		Price: data["price"].(fixed.Value),
 	}
 	if data["side"].(string) == "buy" {
 		order.Side = BidSide
@@ -251,8 +274,8 @@ func (b *Book) FindLevel(order *Order) (*BookLevel, bool) {
 
 func (b *Book) Match(data map[string]interface{}, change bool) {
 	match := &Order{
-		Size:  data["size"].(float64),
-		Price: data["price"].(float64),
+		Size:  data["size"].(fixed.Value),
+		Price: data["price"].(fixed.Value),
 	}
 	if data["side"].(string) == "buy" {
 		match.Side = BidSide
@@ -262,6 +285,9 @@ func (b *Book) Match(data map[string]interface{}, change bool) {
 	if _, ok := data["time"]; ok {
 		match.Time, _ = time.Parse("2006-01-02T15:04:05.999999Z07:00", data["time"].(string))
 	}
+	if id, ok := data["trade_id"].(uint64); ok {
+		match.TradeID = id
+	}
 
 	var maker_id, taker_id string
 	if id, ok := data["maker_order_id"]; ok {
@@ -294,34 +320,32 @@ func (b *Book) Match(data map[string]interface{}, change bool) {
 		b.Remove(order.ID)
 	}
 
-	// update diff stats if change
+	// matches shrink the level as well, the diff has to carry them
 	size := level.Size()
-	if change {
-		if match.Side == BidSide {
-			var found bool
-			for _, state := range b.Diff.Bid {
-				if state.Price == order.Price {
-					state.Size = size
-					found = true
-					break
-				}
-			}
-			if !found {
-				b.Diff.Bid = append(b.Diff.Bid, &LevelDiff{Price: order.Price, Size: size})
-			}
-		} else {
-			var found bool
-			for _, state := range b.Diff.Ask {
-				if state.Price == order.Price {
-					state.Size = size
-					found = true
-					break
-				}
+	if match.Side == BidSide {
+		var found bool
+		for _, state := range b.Diff.Bid {
+			if state.Price == order.Price {
+				state.Size = size
+				found = true
+				break
 			}
-			if !found {
-				b.Diff.Ask = append(b.Diff.Ask, &LevelDiff{Price: order.Price, Size: size})
+		}
+		if !found {
+			b.Diff.Bid = append(b.Diff.Bid, &LevelDiff{Price: order.Price, Size: size})
+		}
+	} else {
+		var found bool
+		for _, state := range b.Diff.Ask {
+			if state.Price == order.Price {
+				state.Size = size
+				found = true
+				break
 			}
 		}
+		if !found {
+			b.Diff.Ask = append(b.Diff.Ask, &LevelDiff{Price: order.Price, Size: size})
+		}
 	}
 
 	if !change {
@@ -7,41 +7,51 @@ import (
 	"net/http"
 
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
-	"github.com/lian/gdax-bookmap/util"
 )
 
-var CachedInfo map[string]product_info.Info
-
-func init() {
-	FetchAllProductInfo()
-}
+// APIURL is the REST base url of the venue.
+var APIURL = "https://api.gdax.com"
 
-func FetchAllProductInfo() {
-	CachedInfo = map[string]product_info.Info{}
+// CachedInfo holds the products of the venue once they have been fetched.
+var CachedInfo map[string]product_info.Info
 
-	res, err := http.Get("https://api.gdax.com/products")
+// FetchAllProductInfo loads the products of the venue into CachedInfo.
+func FetchAllProductInfo() error {
+	res, err := http.Get(APIURL + "/products")
 	if err != nil {
-		fmt.Println("InitProduct error", err)
+		return err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
-
 	if err != nil {
-		fmt.Println("InitProduct error", err)
+		return err
 	}
 
 	var data []product_info.Info
-	json.Unmarshal(body, &data)
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	infos := map[string]product_info.Info{}
 
 	for _, product := range data {
 		product.Platform = "GDAX"
 		product.DatabaseKey = fmt.Sprintf("GDAX-%s-%s", product.BaseCurrency, product.QuoteCurrency)
-		product.FloatFormat = fmt.Sprintf("%%.%df", util.NumDecPlaces(float64(product.QuoteIncrement)))
-		CachedInfo[product.ID] = product
+		infos[product.ID] = product
 	}
+
+	CachedInfo = infos
+	return nil
 }
 
 func FetchProductInfo(id string) product_info.Info {
+	if CachedInfo == nil {
+		if err := FetchAllProductInfo(); err != nil {
+			fmt.Println("InitProduct error", err)
+			return product_info.Info{}
+		}
+	}
 	if info, ok := CachedInfo[id]; ok {
 		return info
 	}
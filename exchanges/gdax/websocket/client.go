@@ -1,55 +1,37 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
 
+	"github.com/lian/gdax-bookmap/exchanges"
 	"github.com/lian/gdax-bookmap/exchanges/gdax/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+// WebsocketURL is the full channel websocket feed endpoint.
+var WebsocketURL = "wss://ws-feed.gdax.com"
+
 type Client struct {
-	Products    []string
-	Books       map[string]*orderbook.Book
-	Socket      *websocket.Conn
-	DB          *bolt.DB
-	dbEnabled   bool
-	LastSync    time.Time
-	LastDiff    time.Time
-	LastDiffSeq uint64
-	BatchWrite  map[string]*util.BookBatchWrite
-	Infos       []*product_info.Info
+	exchanges.Base
+	Books map[string]*orderbook.Book
 }
 
-func New(db *bolt.DB, products []string) *Client {
+func New(db store.Store, infos []*product_info.Info) *Client {
 	c := &Client{
-		Products:   []string{},
-		Books:      map[string]*orderbook.Book{},
-		BatchWrite: map[string]*util.BookBatchWrite{},
-		DB:         db,
-		Infos:      []*product_info.Info{},
-	}
-	if c.DB != nil {
-		c.dbEnabled = true
+		Books: map[string]*orderbook.Book{},
 	}
+	c.Init("GDAX", db)
 
-	for _, name := range products {
-		c.AddProduct(name)
-	}
-
-	if c.dbEnabled {
-		buckets := []string{}
-		for _, info := range c.Infos {
-			buckets = append(buckets, info.DatabaseKey)
-		}
-		util.CreateBucketsDB(c.DB, buckets)
+	for _, info := range infos {
+		c.AddProduct(*info)
 	}
 
 	return c
@@ -59,16 +41,14 @@ func (c *Client) GetBook(id string) *orderbook.Book {
 	return c.Books[id]
 }
 
-func (c *Client) AddProduct(name string) {
-	c.Products = append(c.Products, name)
+func (c *Client) AddProduct(info product_info.Info) {
+	name := info.ID
 	c.Books[name] = orderbook.New(name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
-	info := orderbook.FetchProductInfo(name)
-	c.Infos = append(c.Infos, &info)
+	c.AddProductInfo(name, info)
 }
 
 func (c *Client) Connect() error {
-	url := "wss://ws-feed.gdax.com"
+	url := WebsocketURL
 	fmt.Println("connect to websocket", url)
 	s, _, err := websocket.DefaultDialer.Dial(url, nil)
 
@@ -77,6 +57,9 @@ func (c *Client) Connect() error {
 	}
 
 	c.Socket = s
+	now := time.Now()
+	c.Capture.Connect(now, url)
+	c.HandleConnect(now)
 
 	buf, _ := json.Marshal(map[string]interface{}{"type": "subscribe", "product_ids": c.Products})
 	err = c.Socket.WriteMessage(websocket.TextMessage, buf)
@@ -84,13 +67,20 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// HandleConnect applies a new connection at now.
+func (c *Client) HandleConnect(now time.Time) {
+	c.SetConnected(now)
+}
+
 type PacketHeader struct {
 	Type      string `json:"type"`
 	Sequence  uint64 `json:"sequence"`
 	ProductID string `json:"product_id"`
 }
 
-func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, message []byte) {
+func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, message []byte, now time.Time) {
+	c.CountMessage(book.ID)
+
 	var data map[string]interface{}
 	if err := json.Unmarshal(message, &data); err != nil {
 		log.Println("HandleMessage:", err)
@@ -98,12 +88,21 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 
 	var trade *orderbook.Order
 
+	if value, ok := data["time"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			book.ExchangeTime = t
+		}
+	}
+
 	switch header.Type {
 	case "received":
 		// skip
 	case "open":
-		price, _ := strconv.ParseFloat(data["price"].(string), 64)
-		size, _ := strconv.ParseFloat(data["remaining_size"].(string), 64)
+		price, size, err := book.ParseLevel(data["price"].(string), data["remaining_size"].(string))
+		if err != nil {
+			fmt.Println("skip order", err)
+			return
+		}
 
 		book.Add(map[string]interface{}{
 			"id":    data["order_id"].(string),
@@ -115,8 +114,12 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 	case "done":
 		book.Remove(data["order_id"].(string))
 	case "match":
-		price, _ := strconv.ParseFloat(data["price"].(string), 64)
-		size, _ := strconv.ParseFloat(data["size"].(string), 64)
+		price, size, err := book.ParseLevel(data["price"].(string), data["size"].(string))
+		if err != nil {
+			fmt.Println("skip match", err)
+			return
+		}
+		tradeID, _ := data["trade_id"].(float64)
 
 		book.Match(map[string]interface{}{
 			"size":           size,
@@ -125,6 +128,7 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 			"maker_order_id": data["maker_order_id"].(string),
 			"taker_order_id": data["taker_order_id"].(string),
 			"time":           data["time"].(string),
+			"trade_id":       uint64(tradeID),
 		}, false)
 		trade = book.Trades[len(book.Trades)-1]
 
@@ -133,9 +137,16 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 			// if we don't know about the order, it is a change message for a received order
 		} else {
 			// change messages are treated as match messages
-			old_size, _ := strconv.ParseFloat(data["old_size"].(string), 64)
-			new_size, _ := strconv.ParseFloat(data["new_size"].(string), 64)
-			price, _ := strconv.ParseFloat(data["price"].(string), 64)
+			price, old_size, err := book.ParseLevel(data["price"].(string), data["old_size"].(string))
+			if err != nil {
+				fmt.Println("skip change", err)
+				return
+			}
+			new_size, err := book.SizeScale.Parse(data["new_size"].(string))
+			if err != nil {
+				fmt.Println("skip change", book.ID, err)
+				return
+			}
 			size_delta := old_size - new_size
 
 			book.Match(map[string]interface{}{
@@ -148,11 +159,10 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 		}
 	}
 
-	if c.dbEnabled {
+	if c.DB != nil {
 		batch := c.BatchWrite[book.ID]
-		now := time.Now()
 		if trade != nil {
-			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, PackTrade(trade))
+			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, PackTrade(book, trade))
 		}
 
 		if batch.NextSync(now) {
@@ -169,7 +179,7 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
-		pkt := PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		pkt := PackDiff(book, batch.LastDiffSeq, book.Sequence)
 		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
@@ -182,62 +192,58 @@ func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
-func (c *Client) Run() {
-	for {
-		c.run()
-	}
+func (c *Client) Run(ctx context.Context) {
+	c.Serve(ctx, c)
 }
 
-func (c *Client) run() {
-	if err := c.Connect(); err != nil {
-		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+// Flush stores pending book diffs at now and writes all buffered chunks to the database.
+func (c *Client) Flush(now time.Time) {
+	if c.DB == nil {
 		return
 	}
-	defer c.Socket.Close()
-
-	for {
-		msgType, message, err := c.Socket.ReadMessage()
-		if err != nil {
-			log.Println("read:", err)
-			return
+	for _, book := range c.Books {
+		batch := c.BatchWrite[book.ID]
+		if book.Sequence != 0 {
+			c.WriteDiff(batch, book, now)
 		}
-
-		if msgType != websocket.TextMessage {
-			continue
+		if err := batch.Flush(c.DB, book.ProductInfo.DatabaseKey); err != nil {
+			fmt.Println("Flush DB Error", book.ID, err)
 		}
+	}
+}
 
-		var header PacketHeader
-		if err := json.Unmarshal(message, &header); err != nil {
-			log.Println("header-parse:", err)
-			continue
-		}
+// HandleFrame routes a websocket text frame received at now to its book.
+func (c *Client) HandleFrame(now time.Time, message []byte) {
+	var header PacketHeader
+	if err := json.Unmarshal(message, &header); err != nil {
+		log.Println("header-parse:", err)
+		return
+	}
 
-		var book *orderbook.Book
-		var ok bool
-		if book, ok = c.Books[header.ProductID]; !ok {
-			log.Println("book not found", header.ProductID)
-			continue
-		}
+	var book *orderbook.Book
+	var ok bool
+	if book, ok = c.Books[header.ProductID]; !ok {
+		log.Println("book not found", header.ProductID)
+		return
+	}
 
-		if book.Sequence == 0 {
-			c.SyncBook(book)
-			continue
-		}
+	if book.Sequence == 0 {
+		c.SyncBook(book)
+		return
+	}
 
-		if header.Sequence <= book.Sequence {
-			// Ignore old messages
-			continue
-		}
+	if header.Sequence <= book.Sequence {
+		// Ignore old messages
+		return
+	}
 
-		if header.Sequence != (book.Sequence + 1) {
-			// Message lost, resync
-			c.SyncBook(book)
-			continue
-		}
+	if header.Sequence != (book.Sequence + 1) {
+		// Message lost, resync
+		c.SyncBook(book)
+		return
+	}
 
-		book.Sequence = header.Sequence
+	book.Sequence = header.Sequence
 
-		c.HandleMessage(book, header, message)
-	}
+	c.HandleMessage(book, header, message, now)
 }
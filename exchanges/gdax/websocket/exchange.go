@@ -0,0 +1,30 @@
+package websocket
+
+import (
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/gdax/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func init() {
+	exchanges.Register(&exchanges.Platform{
+		Name:            "GDAX",
+		DefaultProducts: []string{"BTC-USD", "ETH-USD", "BCH-USD"},
+		New: func(db store.Store, infos []*product_info.Info) exchanges.Exchange {
+			return New(db, infos)
+		},
+		ProductInfo:  orderbook.FetchProductInfo,
+		WebsocketURL: &WebsocketURL,
+		APIURL:       &orderbook.APIURL,
+		CachedInfo:   &orderbook.CachedInfo,
+	})
+}
+
+// Publish copies the state of the books to Status.
+func (c *Client) Publish() {
+	for _, book := range c.Books {
+		// sequence is only set once the level 3 snapshot has been fetched
+		c.SetBookStatus(book.ID, book.Sequence != 0, book.Sequence)
+	}
+}
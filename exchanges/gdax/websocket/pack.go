@@ -3,14 +3,18 @@ package websocket
 import (
 	"bytes"
 	"encoding/binary"
+	"sort"
 
 	"github.com/lian/gdax-bookmap/exchanges/gdax/orderbook"
 	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
 )
 
-func PackDiff(first, last uint64, diff *orderbook.BookLevelDiff) []byte {
+func PackDiff(book *orderbook.Book, first, last uint64) []byte {
+	diff := book.Diff
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.DiffPacket)
+	binary.Write(buf, binary.LittleEndian, uint8(book.PriceScale))
+	binary.Write(buf, binary.LittleEndian, uint8(book.SizeScale))
 	binary.Write(buf, binary.LittleEndian, uint64(first)) // sequence
 	binary.Write(buf, binary.LittleEndian, uint64(first)) // first
 	binary.Write(buf, binary.LittleEndian, uint64(last))  // last
@@ -27,43 +31,52 @@ func PackDiff(first, last uint64, diff *orderbook.BookLevelDiff) []byte {
 		binary.Write(buf, binary.LittleEndian, state.Size)  // size
 	}
 
-	return buf.Bytes()
+	db_orderbook.WriteExchangeTime(buf, book.ExchangeTime)
+	return db_orderbook.PackPacket(db_orderbook.DiffPacket, buf.Bytes())
 }
 
 func PackSync(book *orderbook.Book) []byte {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.SyncPacket)
+	binary.Write(buf, binary.LittleEndian, uint8(book.PriceScale))
+	binary.Write(buf, binary.LittleEndian, uint8(book.SizeScale))
 	binary.Write(buf, binary.LittleEndian, uint64(book.Sequence))
 
 	binary.Write(buf, binary.LittleEndian, uint64(len(book.Bid)))
-	for _, level := range book.Bid {
-		binary.Write(buf, binary.LittleEndian, level.Price) // price
-		var size float64
-		for _, order := range level.Orders {
-			size += order.Size
-		}
-		binary.Write(buf, binary.LittleEndian, size) // size
+	for _, level := range sortedLevels(book.Bid) {
+		binary.Write(buf, binary.LittleEndian, level.Price)  // price
+		binary.Write(buf, binary.LittleEndian, level.Size()) // size
 	}
 
 	binary.Write(buf, binary.LittleEndian, uint64(len(book.Ask)))
-	for _, level := range book.Ask {
-		binary.Write(buf, binary.LittleEndian, level.Price) // price
-		var size float64
-		for _, order := range level.Orders {
-			size += order.Size
-		}
-		binary.Write(buf, binary.LittleEndian, size) // size
+	for _, level := range sortedLevels(book.Ask) {
+		binary.Write(buf, binary.LittleEndian, level.Price)  // price
+		binary.Write(buf, binary.LittleEndian, level.Size()) // size
 	}
 
-	return buf.Bytes()
+	db_orderbook.WriteExchangeTime(buf, book.ExchangeTime)
+	return db_orderbook.PackPacket(db_orderbook.SyncPacket, buf.Bytes())
 }
 
-func PackTrade(trade *orderbook.Order) []byte {
+// sortedLevels orders levels by ascending price like the other venues write
+// them, so the same book always packs to the same bytes.
+func sortedLevels(levels map[fixed.Value]*orderbook.BookLevel) []*orderbook.BookLevel {
+	sorted := make([]*orderbook.BookLevel, 0, len(levels))
+	for _, level := range levels {
+		sorted = append(sorted, level)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Price < sorted[j].Price })
+	return sorted
+}
+
+func PackTrade(book *orderbook.Book, trade *orderbook.Order) []byte {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.TradePacket)
+	binary.Write(buf, binary.LittleEndian, uint8(book.PriceScale))
+	binary.Write(buf, binary.LittleEndian, uint8(book.SizeScale))
 	binary.Write(buf, binary.LittleEndian, uint64(0))         // seq
 	binary.Write(buf, binary.LittleEndian, uint8(trade.Side)) // side
 	binary.Write(buf, binary.LittleEndian, trade.Price)       // price
 	binary.Write(buf, binary.LittleEndian, trade.Size)        // size
-	return buf.Bytes()
+	// the side of a match is the maker side, which is the side the taker traded on
+	db_orderbook.WriteTradeInfo(buf, trade.TradeID, trade.Time, true)
+	return db_orderbook.PackPacket(db_orderbook.TradePacket, buf.Bytes())
 }
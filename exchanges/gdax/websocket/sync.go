@@ -3,9 +3,6 @@ package websocket
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/lian/gdax-bookmap/exchanges/gdax/orderbook"
@@ -14,7 +11,7 @@ import (
 func (c *Client) SyncBook(book *orderbook.Book) error {
 	fmt.Println("sync", book.ID)
 
-	full, err := FetchRawBook(3, book.ID)
+	full, now, err := c.FetchRawBook(3, book.ID)
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -27,8 +24,11 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 		if bids, ok := full["bids"].([]interface{}); ok {
 			for i := len(bids) - 1; i >= 0; i-- {
 				data := bids[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
+				price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+				if err != nil {
+					fmt.Println("skip order", err)
+					continue
+				}
 				book.Add(map[string]interface{}{
 					"id":    data[2].(string),
 					"side":  "buy",
@@ -40,8 +40,11 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 		if asks, ok := full["asks"].([]interface{}); ok {
 			for i := len(asks) - 1; i >= 0; i-- {
 				data := asks[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
+				price, size, err := book.ParseLevel(data[0].(string), data[1].(string))
+				if err != nil {
+					fmt.Println("skip order", err)
+					continue
+				}
 				book.Add(map[string]interface{}{
 					"id":    data[2].(string),
 					"side":  "sell",
@@ -51,9 +54,8 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 			}
 		}
 
-		if c.dbEnabled {
+		if c.DB != nil {
 			batch := c.BatchWrite[book.ID]
-			now := time.Now()
 			fmt.Println("STORE INIT SYNC", book.ID, batch.Count)
 			c.WriteSync(batch, book, now)
 		}
@@ -62,22 +64,19 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 	return nil
 }
 
-func FetchRawBook(level int, product string) (map[string]interface{}, error) {
-	url := fmt.Sprintf("https://api.gdax.com/products/%s/book?level=%d", product, level)
-	res, err := http.Get(url)
+// FetchRawBook returns the book of product at level and the time it was received.
+func (c *Client) FetchRawBook(level int, product string) (map[string]interface{}, time.Time, error) {
+	url := fmt.Sprintf("%s/products/%s/book?level=%d", orderbook.APIURL, product, level)
+	body, now, err := c.Fetcher.Fetch(url)
 	if err != nil {
-		return nil, err
-	}
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+		return nil, now, err
 	}
 
 	var data map[string]interface{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return nil, err
+		return nil, now, err
 	}
 
-	return data, nil
+	return data, now, nil
 }
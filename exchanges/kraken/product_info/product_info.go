@@ -0,0 +1,103 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// APIURL is the REST base url of the venue.
+var APIURL = "https://api.kraken.com"
+
+// CachedInfo holds the products of the venue once they have been fetched.
+var CachedInfo map[string]product_info.Info
+
+// assets kraken names differently than the other venues
+var assetNames = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+func assetName(name string) string {
+	if renamed, ok := assetNames[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// FetchAllProductInfo loads the products of the venue into CachedInfo.
+func FetchAllProductInfo() error {
+	res, err := http.Get(APIURL + "/0/public/AssetPairs")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			WSName       string `json:"wsname"`
+			PairDecimals int    `json:"pair_decimals"`
+			LotDecimals  int    `json:"lot_decimals"`
+			OrderMin     string `json:"ordermin"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+	if len(data.Error) != 0 {
+		return fmt.Errorf("AssetPairs: %s", strings.Join(data.Error, ", "))
+	}
+
+	infos := map[string]product_info.Info{}
+
+	for _, pair := range data.Result {
+		// wsname is XBT/USD, the v2 websocket symbol BTC/USD
+		assets := strings.Split(pair.WSName, "/")
+		if len(assets) != 2 {
+			continue
+		}
+		base, quote := assetName(assets[0]), assetName(assets[1])
+		minSize, _ := strconv.ParseFloat(pair.OrderMin, 64)
+
+		info := product_info.Info{
+			ID:             fmt.Sprintf("%s/%s", base, quote),
+			DisplayName:    fmt.Sprintf("%s-%s", base, quote),
+			BaseCurrency:   base,
+			QuoteCurrency:  quote,
+			Platform:       "Kraken",
+			DatabaseKey:    fmt.Sprintf("Kraken-%s-%s", base, quote),
+			BaseMinSize:    minSize,
+			QuoteIncrement: math.Pow10(-pair.PairDecimals),
+			BaseIncrement:  math.Pow10(-pair.LotDecimals),
+		}
+		infos[info.DisplayName] = info
+	}
+
+	CachedInfo = infos
+	return nil
+}
+
+func FetchProductInfo(id string) product_info.Info {
+	if CachedInfo == nil {
+		if err := FetchAllProductInfo(); err != nil {
+			fmt.Println("InitProduct error", err)
+			return product_info.Info{}
+		}
+	}
+	if info, ok := CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
@@ -0,0 +1,37 @@
+package websocket
+
+import (
+	"hash/crc32"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+// ChecksumLevels is the number of levels per side kraken's checksum covers.
+const ChecksumLevels = 10
+
+// Checksum is the CRC32 kraken sends with every book message, over the best
+// asks from the lowest up followed by the best bids from the highest down.
+// Prices and sizes are written in the precision of the product without the
+// decimal point and leading zeros.
+func Checksum(book *orderbook.Book) uint32 {
+	var buf strings.Builder
+	for i := 0; i < len(book.Ask) && i < ChecksumLevels; i++ {
+		writeLevel(&buf, book, book.Ask[i])
+	}
+	for i := len(book.Bid) - 1; i >= 0 && i >= len(book.Bid)-ChecksumLevels; i-- {
+		writeLevel(&buf, book, book.Bid[i])
+	}
+	return crc32.ChecksumIEEE([]byte(buf.String()))
+}
+
+func writeLevel(buf *strings.Builder, book *orderbook.Book, level *orderbook.BookLevel) {
+	buf.WriteString(checksumDigits(book.PriceScale, level.Price))
+	buf.WriteString(checksumDigits(book.SizeScale, level.Size))
+}
+
+// checksumDigits writes 0.00500000 as 500000.
+func checksumDigits(s fixed.Scale, v fixed.Value) string {
+	return strings.TrimLeft(strings.Replace(s.Format(v), ".", "", 1), "0")
+}
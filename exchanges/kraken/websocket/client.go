@@ -0,0 +1,338 @@
+package websocket
+
+// api version 2: https://docs.kraken.com/api/docs/websocket-v2/book
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// WebsocketURL is the v2 websocket endpoint.
+var WebsocketURL = "wss://ws.kraken.com/v2"
+
+// Depth is the number of levels per side the book channel keeps, one of
+// 10, 25, 100, 500 or 1000.
+var Depth = 100
+
+type Client struct {
+	exchanges.Base
+	Books map[string]*orderbook.Book
+}
+
+func New(db store.Store, infos []*product_info.Info) *Client {
+	c := &Client{
+		Books: map[string]*orderbook.Book{},
+	}
+	c.Init("Kraken", db)
+
+	for _, info := range infos {
+		c.AddProduct(*info)
+	}
+
+	return c
+}
+
+func (c *Client) AddProduct(info product_info.Info) {
+	name := info.DisplayName
+	book := orderbook.New(name)
+	c.AddProductInfo(name, info)
+	book.SetProductInfo(info)
+	// messages name the product by its symbol, BTC/USD
+	c.Books[info.ID] = book
+}
+
+func (c *Client) symbols() []string {
+	symbols := []string{}
+	for _, info := range c.ProductInfos {
+		symbols = append(symbols, info.ID)
+	}
+	return symbols
+}
+
+func (c *Client) Connect() error {
+	url := WebsocketURL
+	fmt.Println("connect to websocket", url)
+	s, _, err := websocket.DefaultDialer.Dial(url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	now := time.Now()
+	c.Capture.Connect(now, url)
+	c.HandleConnect(now)
+
+	c.Subscribe("book", c.symbols())
+	c.Subscribe("trade", c.symbols())
+
+	return nil
+}
+
+// HandleConnect applies a new connection at now, the book subscription
+// answers with a snapshot of every book.
+func (c *Client) HandleConnect(now time.Time) {
+	c.SetConnected(now)
+
+	for _, book := range c.Books {
+		book.Synced = false
+	}
+}
+
+func (c *Client) Subscribe(channel string, symbols []string) {
+	c.request("subscribe", channel, symbols)
+}
+
+// Resubscribe requests a new snapshot of a book that does not match its checksum.
+func (c *Client) Resubscribe(book *orderbook.Book) {
+	// there is no socket to ask on when reingesting a capture, the capture
+	// has the snapshot the recording asked for
+	if c.Socket == nil {
+		return
+	}
+	c.request("unsubscribe", "book", []string{book.ProductInfo.ID})
+	c.request("subscribe", "book", []string{book.ProductInfo.ID})
+}
+
+func (c *Client) request(method, channel string, symbols []string) {
+	params := map[string]interface{}{"channel": channel, "symbol": symbols}
+	if channel == "book" {
+		params["depth"] = Depth
+		if method == "subscribe" {
+			params["snapshot"] = true
+		}
+	} else if method == "subscribe" {
+		params["snapshot"] = false
+	}
+	c.Socket.WriteJSON(map[string]interface{}{"method": method, "params": params})
+}
+
+type Packet struct {
+	Method  string            `json:"method"`
+	Success bool              `json:"success"`
+	Error   string            `json:"error"`
+	Channel string            `json:"channel"`
+	Type    string            `json:"type"`
+	Data    []json.RawMessage `json:"data"`
+}
+
+type PacketLevel struct {
+	Price json.Number `json:"price"`
+	Qty   json.Number `json:"qty"`
+}
+
+type PacketBook struct {
+	Symbol    string        `json:"symbol"`
+	Bids      []PacketLevel `json:"bids"`
+	Asks      []PacketLevel `json:"asks"`
+	Checksum  uint32        `json:"checksum"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+type PacketTrade struct {
+	Symbol    string      `json:"symbol"`
+	Side      string      `json:"side"` // taker side, buy or sell
+	Price     json.Number `json:"price"`
+	Qty       json.Number `json:"qty"`
+	TradeID   uint64      `json:"trade_id"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// symbolOf reads the symbol of a book or trade entry.
+func symbolOf(data json.RawMessage) string {
+	var entry struct {
+		Symbol string `json:"symbol"`
+	}
+	json.Unmarshal(data, &entry)
+	return entry.Symbol
+}
+
+// UpdateBook applies the levels of a book message. Levels pushed out of the
+// subscribed depth are not removed by kraken, they are dropped here.
+func (c *Client) UpdateBook(book *orderbook.Book, data PacketBook, now time.Time) {
+	for _, level := range data.Bids {
+		price, size, err := book.ParseLevel(level.Price.String(), level.Qty.String())
+		if err != nil {
+			fmt.Println("skip level", err)
+			continue
+		}
+		book.UpdateBidLevel(now, price, size)
+	}
+	for _, level := range data.Asks {
+		price, size, err := book.ParseLevel(level.Price.String(), level.Qty.String())
+		if err != nil {
+			fmt.Println("skip level", err)
+			continue
+		}
+		book.UpdateAskLevel(now, price, size)
+	}
+
+	for len(book.Bid) > Depth {
+		book.UpdateBidLevel(now, book.Bid[0].Price, 0)
+	}
+	for len(book.Ask) > Depth {
+		book.UpdateAskLevel(now, book.Ask[len(book.Ask)-1].Price, 0)
+	}
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet, raw json.RawMessage, now time.Time) {
+	c.CountMessage(book.ID)
+
+	var trade *orderbook.Trade
+
+	switch pkt.Channel {
+	case "book":
+		var data PacketBook
+		if err := json.Unmarshal(raw, &data); err != nil {
+			log.Println(err)
+			return
+		}
+
+		if pkt.Type == "snapshot" {
+			book.Clear()
+			book.Sequence = 0
+			book.Synced = true
+		} else if !book.Synced {
+			// waiting for the snapshot of a resubscription
+			return
+		}
+
+		c.UpdateBook(book, data, now)
+		if !data.Timestamp.IsZero() {
+			book.ExchangeTime = data.Timestamp
+		}
+
+		if sum := Checksum(book); sum != data.Checksum {
+			fmt.Println("checksum mismatch", book.ID, sum, data.Checksum)
+			book.Synced = false
+			c.Resubscribe(book)
+			return
+		}
+
+		if pkt.Type == "snapshot" && c.DB != nil {
+			// levels missing from a resync snapshot are only dropped by a sync packet
+			c.WriteSync(c.BatchWrite[book.ID], book, now)
+		}
+
+	case "trade":
+		var data PacketTrade
+		if err := json.Unmarshal(raw, &data); err != nil {
+			log.Println(err)
+			return
+		}
+
+		price, size, err := book.ParseLevel(data.Price.String(), data.Qty.String())
+		if err != nil {
+			fmt.Println("skip trade", err)
+			return
+		}
+		side := orderbook.TakerSide(data.Side == "buy")
+		book.AddTakerTrade(now, side, price, size, data.TradeID, data.Timestamp)
+		trade = book.Trades[len(book.Trades)-1]
+
+	default:
+		fmt.Println("unkown channel", book.ID, pkt.Channel)
+		return
+	}
+
+	book.Sequence += 1
+
+	if c.DB != nil {
+		batch := c.BatchWrite[book.ID]
+		if trade != nil {
+			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(book, trade))
+		}
+
+		if !book.Synced {
+			// trades before the snapshot, the book is written once it arrives
+			return
+		}
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ID, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+}
+
+func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(book, batch.LastDiffSeq, book.Sequence)
+		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+	batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackSync(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+func (c *Client) Run(ctx context.Context) {
+	c.Serve(ctx, c)
+}
+
+// Flush stores pending book diffs at now and writes all buffered chunks to the database.
+func (c *Client) Flush(now time.Time) {
+	if c.DB == nil {
+		return
+	}
+	for _, book := range c.Books {
+		batch := c.BatchWrite[book.ID]
+		if book.Synced {
+			c.WriteDiff(batch, book, now)
+		}
+		if err := batch.Flush(c.DB, book.ProductInfo.DatabaseKey); err != nil {
+			fmt.Println("Flush DB Error", book.ID, err)
+		}
+	}
+}
+
+// HandleFrame routes a websocket text frame received at now to its books.
+func (c *Client) HandleFrame(now time.Time, message []byte) {
+	var pkt Packet
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		log.Println("header-parse:", err)
+		return
+	}
+
+	if pkt.Method != "" {
+		// answers to subscribe, unsubscribe and ping
+		if !pkt.Success && pkt.Method != "pong" {
+			fmt.Println("Kraken", pkt.Method, "failed", pkt.Error)
+		}
+		return
+	}
+
+	switch pkt.Channel {
+	case "status", "heartbeat":
+		return
+	}
+
+	for _, raw := range pkt.Data {
+		symbol := symbolOf(raw)
+		book, ok := c.Books[symbol]
+		if !ok {
+			log.Println("book not found", symbol)
+			continue
+		}
+		c.HandleMessage(book, pkt, raw, now)
+	}
+}
@@ -0,0 +1,29 @@
+package websocket
+
+import (
+	"github.com/lian/gdax-bookmap/exchanges"
+	book_info "github.com/lian/gdax-bookmap/exchanges/kraken/product_info"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func init() {
+	exchanges.Register(&exchanges.Platform{
+		Name:            "Kraken",
+		DefaultProducts: []string{"BTC-USD", "ETH-USD", "BTC-EUR"},
+		New: func(db store.Store, infos []*product_info.Info) exchanges.Exchange {
+			return New(db, infos)
+		},
+		ProductInfo:  book_info.FetchProductInfo,
+		WebsocketURL: &WebsocketURL,
+		APIURL:       &book_info.APIURL,
+		CachedInfo:   &book_info.CachedInfo,
+	})
+}
+
+// Publish copies the state of the books to Status.
+func (c *Client) Publish() {
+	for _, book := range c.Books {
+		c.SetBookStatus(book.ID, book.Synced, book.Sequence)
+	}
+}
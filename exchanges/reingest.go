@@ -0,0 +1,122 @@
+package exchanges
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/capture"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Replayer is implemented by clients that can be driven by a capture
+// instead of their connection.
+type Replayer interface {
+	Exchange
+	// SetFetcher replaces where REST responses come from.
+	SetFetcher(f capture.Fetcher)
+	// HandleConnect applies a new connection at t to the books.
+	HandleConnect(t time.Time)
+	// HandleFrame handles a websocket text frame received at t.
+	HandleFrame(t time.Time, message []byte)
+	// Flush stores pending diffs at t and writes all buffered records.
+	Flush(t time.Time)
+}
+
+// Reingest feeds a capture through new clients writing to db, with the
+// receive times of the capture as clock. Every Products entry starts a new
+// client like the recorder run that wrote it. It returns the number of
+// frames handled.
+func Reingest(r *capture.Reader, db store.Store, checkpoint util.CheckpointPolicy, checkpoints map[string]util.CheckpointPolicy, compress bool) (int, error) {
+	var client Replayer
+	var last time.Time
+	frames := 0
+
+	finish := func() {
+		if client != nil {
+			client.Flush(last)
+		}
+	}
+
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			fmt.Println("capture ends within an entry, ignoring it")
+			break
+		}
+		if err != nil {
+			finish()
+			return frames, err
+		}
+
+		if e.Kind != capture.Products && client == nil {
+			return frames, fmt.Errorf("capture starts with a %s entry, not a product list", e.Kind)
+		}
+
+		switch e.Kind {
+		case capture.Products:
+			finish()
+			if client, err = newReplayer(e, db, checkpoint, checkpoints, compress); err != nil {
+				return frames, err
+			}
+			client.SetFetcher(r)
+		case capture.Connect:
+			client.HandleConnect(e.Time)
+		case capture.Frame:
+			client.HandleFrame(e.Time, e.Data)
+			frames++
+		case capture.Response, capture.Failure:
+			// the replay took another path than the recording
+			fmt.Println("reingest: unrequested response", e.Source, e.Time)
+		default:
+			return frames, fmt.Errorf("unknown capture entry %s", e.Kind)
+		}
+		last = e.Time
+	}
+
+	finish()
+	return frames, nil
+}
+
+func newReplayer(e capture.Entry, db store.Store, checkpoint util.CheckpointPolicy, checkpoints map[string]util.CheckpointPolicy, compress bool) (Replayer, error) {
+	list, err := e.ProductList()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := Lookup(list.Platform)
+	if !ok {
+		return nil, fmt.Errorf("unknown platform %s", list.Platform)
+	}
+	if p.CachedInfo == nil {
+		return nil, fmt.Errorf("%s has no product info cache to preset", p.Name)
+	}
+	*p.CachedInfo = list.Infos
+	infos, err := Infos(p.Name, list.Products)
+	if err != nil {
+		return nil, err
+	}
+
+	client, ok := p.New(db, infos).(Replayer)
+	if !ok {
+		return nil, fmt.Errorf("%s can not be reingested", p.Name)
+	}
+	if policy, ok := checkpoints[strings.ToLower(p.Name)]; ok {
+		checkpoint = policy
+	}
+	client.SetCheckpointPolicy(checkpoint)
+	client.SetCompression(compress)
+	for _, info := range client.Infos() {
+		if err := util.RecordCheckpointPolicy(db, info.DatabaseKey, checkpoint); err != nil {
+			return nil, err
+		}
+		if err := util.RecordProductInfo(db, *info); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
@@ -0,0 +1,234 @@
+package exchanges
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/capture"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Flags are the recording options the app and cmd/recorder share.
+type Flags struct {
+	DB         string
+	Store      string
+	Platforms  string
+	Products   string
+	Retention  string
+	Rollups    string
+	RollupBand float64
+	Checkpoint string
+	Compress   bool
+	CaptureDir string
+	Endpoints  string
+}
+
+// AddFlags registers the recording options on fs, platforms is the default
+// of -platforms.
+func AddFlags(fs *flag.FlagSet, platforms string) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Platforms, "platforms", platforms, "active platforms ("+strings.Join(Names(), ", ")+")")
+	fs.StringVar(&f.Products, "products", "", "products per platform, like binance:BTC-USDT,ETH-USDT,coinbase:BTC-USD, defaults to each platforms default products")
+	fs.StringVar(&f.DB, "db", "orderbooks.db", "database file")
+	fs.StringVar(&f.Store, "store", "bolt", "storage backend ("+strings.Join(store.Backends, ", ")+"), segment uses -db as directory")
+	fs.StringVar(&f.Retention, "retention", "", "delete records older than this, per bucket with 168h,Binance-BTC-USDT=24h")
+	fs.StringVar(&f.Rollups, "rollups", "1,8,64", "rollup tiers in seconds for fast zoomed out views, empty disables")
+	fs.Float64Var(&f.RollupBand, "rollup-band", 0, "keep only rollup levels within this fraction of the center price, 0 keeps all levels")
+	fs.StringVar(&f.Checkpoint, "checkpoint", util.DefaultCheckpointPolicy.String(), "when to write a full sync, per exchange with time=60s;binance:diffs=300,bytes=65536")
+	fs.BoolVar(&f.Compress, "compress", false, "store packets flate compressed")
+	fs.StringVar(&f.CaptureDir, "capture", "", "directory to log the raw websocket frames and REST responses of each platform to, for cmd/reingest")
+	fs.StringVar(&f.Endpoints, "endpoints", "", "replace platform URLs, like binance:ws=ws://127.0.0.1:9000,api=http://127.0.0.1:9000;coinbase:ws=...")
+	return f
+}
+
+// Config is what StartAll records.
+type Config struct {
+	Platforms []string
+	// products by lowercased platform name, platforms without record their defaults
+	Products    map[string][]string
+	Retention   *util.Retention
+	Rollups     []int
+	RollupBand  float64
+	Checkpoint  util.CheckpointPolicy
+	Checkpoints map[string]util.CheckpointPolicy
+	Compress    bool
+	CaptureDir  string
+}
+
+// Config parses the options and points the platforms at their -endpoints.
+func (f *Flags) Config() (Config, error) {
+	cfg := Config{
+		Platforms:  strings.Split(f.Platforms, "-"),
+		RollupBand: f.RollupBand,
+		Compress:   f.Compress,
+		CaptureDir: f.CaptureDir,
+	}
+	var err error
+
+	if cfg.Retention, err = util.ParseRetention(f.Retention); err != nil {
+		return cfg, err
+	}
+	if cfg.Rollups, err = util.ParseRollupTiers(f.Rollups); err != nil {
+		return cfg, err
+	}
+	if f.RollupBand < 0 {
+		return cfg, fmt.Errorf("invalid -rollup-band %v", f.RollupBand)
+	}
+	if cfg.Checkpoint, cfg.Checkpoints, err = util.ParseCheckpointPolicies(f.Checkpoint); err != nil {
+		return cfg, err
+	}
+
+	endpoints, err := ParseEndpoints(f.Endpoints)
+	if err != nil {
+		return cfg, err
+	}
+	for name, e := range endpoints {
+		if err := SetEndpoints(name, e); err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.Products, err = ParseProducts(f.Products); err != nil {
+		return cfg, err
+	}
+	for name := range cfg.Products {
+		if !cfg.records(name) {
+			return cfg, fmt.Errorf("products for %s, which is not in -platforms %s", name, f.Platforms)
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg Config) records(platform string) bool {
+	for _, name := range cfg.Platforms {
+		if strings.EqualFold(name, platform) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProducts parses products per platform, "binance:BTC-USDT,ETH-USDT,coinbase:BTC-USD".
+// A product without platform belongs to the platform before it. Platform
+// names are lowercased.
+func ParseProducts(value string) (map[string][]string, error) {
+	products := map[string][]string{}
+	if value == "" {
+		return products, nil
+	}
+	platform := ""
+	for _, entry := range strings.Split(value, ",") {
+		if i := strings.Index(entry, ":"); i != -1 {
+			platform = strings.ToLower(entry[:i])
+			entry = entry[i+1:]
+		}
+		if platform == "" {
+			return nil, fmt.Errorf("invalid products %q, %s has no platform", value, entry)
+		}
+		if entry == "" {
+			return nil, fmt.Errorf("invalid products %q, empty product for %s", value, platform)
+		}
+		products[platform] = append(products[platform], entry)
+	}
+	return products, nil
+}
+
+// Feeds are the clients and jobs started by StartAll.
+type Feeds struct {
+	Clients []Exchange
+	wg      sync.WaitGroup
+}
+
+// Wait returns once every client flushed its batches and the jobs stopped.
+func (f *Feeds) Wait() {
+	f.wg.Wait()
+}
+
+// StartAll starts a client for every platform of cfg writing to db, and the
+// retention and rollup jobs, until ctx is done. Unknown platforms and
+// products a platform does not list are rejected before any client starts.
+func StartAll(ctx context.Context, db store.Store, cfg Config) (*Feeds, error) {
+	infos := make([][]*product_info.Info, len(cfg.Platforms))
+	for i, name := range cfg.Platforms {
+		var err error
+		if infos[i], err = Infos(name, cfg.Products[strings.ToLower(name)]); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.CaptureDir != "" {
+		if err := os.MkdirAll(cfg.CaptureDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	feeds := &Feeds{}
+	captures := []*capture.Writer{}
+	for i, name := range cfg.Platforms {
+		ws, err := New(name, db, infos[i])
+		if err != nil {
+			return nil, err
+		}
+		if policy, ok := cfg.Checkpoints[strings.ToLower(name)]; ok {
+			ws.SetCheckpointPolicy(policy)
+		} else {
+			ws.SetCheckpointPolicy(cfg.Checkpoint)
+		}
+		ws.SetCompression(cfg.Compress)
+		var captureLog *capture.Writer
+		if cfg.CaptureDir != "" {
+			if captureLog, err = capture.Create(capture.Path(cfg.CaptureDir, ws.Name())); err != nil {
+				for _, w := range captures {
+					w.Close()
+				}
+				return nil, err
+			}
+			ws.SetCapture(captureLog)
+		}
+		feeds.Clients = append(feeds.Clients, ws)
+		captures = append(captures, captureLog)
+	}
+
+	for i, ws := range feeds.Clients {
+		captureLog := captures[i]
+		feeds.wg.Add(1)
+		go func(ws Exchange) {
+			ws.Run(ctx)
+			captureLog.Close()
+			feeds.wg.Done()
+		}(ws)
+	}
+
+	if cfg.Retention != nil && cfg.Retention.Enabled() {
+		feeds.wg.Add(1)
+		go func() {
+			cfg.Retention.Run(ctx, db, time.Hour)
+			feeds.wg.Done()
+		}()
+	}
+
+	if len(cfg.Rollups) > 0 {
+		feeds.wg.Add(1)
+		go func() {
+			util.RunRollups(ctx, db, cfg.Rollups, cfg.RollupBand, 10*time.Second)
+			feeds.wg.Done()
+		}()
+	}
+
+	return feeds, nil
+}
+
+// SignalContext is cancelled on an interrupt or SIGTERM, so the feeds
+// flush their batches before the process exits.
+func SignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
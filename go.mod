@@ -1,13 +1,22 @@
 module github.com/lian/gdax-bookmap
 
+go 1.27.1
+
 require (
 	github.com/boltdb/bolt v1.3.1
 	github.com/faiface/mainthread v0.0.0-20171120011319-8b78f0a41ae3
-	github.com/go-gl/gl v0.0.0-20181026044259-55b76b7df9d2 // indirect
-	github.com/go-gl/glfw v0.0.0-20190217072633-93b30450e032 // indirect
-	github.com/go-gl/mathgl v0.0.0-20180804195959-cdf14b6b8f8a // indirect
+	github.com/go-gl/gl v0.0.0-20181026044259-55b76b7df9d2
+	github.com/go-gl/glfw v0.0.0-20190217072633-93b30450e032
+	github.com/go-gl/mathgl v0.0.0-20180804195959-cdf14b6b8f8a
 	github.com/gorilla/websocket v1.4.0
 	github.com/lian/gonky v0.0.0-20180128012956-6ece06f9ffaa
 	github.com/llgcode/draw2d v0.0.0-20180825133448-f52c8a71aff0
+)
+
+require (
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/jung-kurt/gofpdf v1.0.0 // indirect
+	github.com/llgcode/ps v0.0.0-20150911083025-f1443b32eedb // indirect
 	github.com/pbnjay/pixfont v0.0.0-20190130005054-401bb7c6aee2 // indirect
+	golang.org/x/image v0.0.0-20180708004352-c73c2afc3b81 // indirect
 )
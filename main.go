@@ -14,13 +14,16 @@ import (
 
 	//_ "net/http/pprof"
 
-	binance_websocket "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
-	bitfinex_websocket "github.com/lian/gdax-bookmap/exchanges/bitfinex/websocket"
-	bitstamp_websocket "github.com/lian/gdax-bookmap/exchanges/bitstamp/websocket"
-	coinbase_websocket "github.com/lian/gdax-bookmap/exchanges/coinbase/websocket"
-	gdax_websocket "github.com/lian/gdax-bookmap/exchanges/gdax/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	_ "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitfinex/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitstamp/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/coinbase/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/gdax/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/kraken/websocket"
 
 	opengl_bookmap "github.com/lian/gdax-bookmap/opengl/bookmap"
+	"github.com/lian/gdax-bookmap/orderbook/analytics"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 	"github.com/lian/gdax-bookmap/util"
 )
@@ -186,6 +189,40 @@ func keyCallback(window *Window, key glfw.Key, action glfw.Action, mods glfw.Mod
 	} else if key == glfw.KeyR && action == glfw.Press {
 		bm := bookmaps[ActiveProduct]
 		bm.MaxSizeHisto = 0.0
+	} else if key == glfw.KeyI && action == glfw.Press {
+		bm := bookmaps[ActiveProduct]
+		bm.NextIndicator()
+
+		for _, info := range infos {
+			if info.BaseCurrency != ActiveBase || info.DatabaseKey == ActiveProduct {
+				continue
+			}
+			bookmap := bookmaps[info.DatabaseKey]
+			bookmap.Indicator = bm.Indicator
+			bookmap.Graph.ClearSlotRows()
+		}
+	} else if key == glfw.KeyV && action == glfw.Press {
+		bm := bookmaps[ActiveProduct]
+		bm.ToggleTradeFlow()
+
+		for _, info := range infos {
+			if info.BaseCurrency != ActiveBase || info.DatabaseKey == ActiveProduct {
+				continue
+			}
+			bookmap := bookmaps[info.DatabaseKey]
+			bookmap.ShowTradeFlow = bm.ShowTradeFlow
+			bookmap.Graph.ClearSlotRows()
+		}
+	} else if key == glfw.KeySpace && action == glfw.Press && replayClock != nil {
+		replayClock.TogglePause()
+	} else if key == glfw.KeyPeriod && action == glfw.Press && replayClock != nil {
+		if replayClock.Speed < 100 {
+			replayClock.SetSpeed(replayClock.Speed * 10)
+		}
+	} else if key == glfw.KeyComma && action == glfw.Press && replayClock != nil {
+		if replayClock.Speed > 1 {
+			replayClock.SetSpeed(replayClock.Speed / 10)
+		}
 	}
 }
 
@@ -200,72 +237,106 @@ var ActiveBase string
 var ActiveProduct string
 var ActivePlatform string
 var infos []*product_info.Info
+var replayClock *opengl_bookmap.ReplayClock
 
 func run() {
-	var db_path string
 	var windowWidth int
 	var windowHeight int
+	var replay bool
+	var replayFrom string
+	var replayTo string
+	var replaySpeed float64
+	var clockValue string
+	var depthBandValue string
 
 	fmt.Printf("Starting gdax-bookmap %s-%s\n", AppVersion, AppGitHash)
 	//flag.StringVar(&ActivePlatform, "platforms", "gdax-bitstamp-binance-bitfinex", "active platforms")
-	flag.StringVar(&ActivePlatform, "platforms", "coinbase-bitstamp-binance", "active platforms")
+	options := exchanges.AddFlags(flag.CommandLine, "coinbase-bitstamp-binance")
 	flag.StringVar(&ActiveBase, "base", "BTC", "active BaseCurrency")
-	flag.StringVar(&db_path, "db", "orderbooks.db", "database file")
 	flag.IntVar(&windowWidth, "w", 0, "window width")
 	flag.IntVar(&windowHeight, "h", 0, "window height")
+	flag.BoolVar(&replay, "replay", false, "replay recorded history from the database without live feeds")
+	flag.StringVar(&replayFrom, "from", "", "replay start time (2006-01-02 15:04:05)")
+	flag.StringVar(&replayTo, "to", "", "replay end time, defaults to no end")
+	flag.Float64Var(&replaySpeed, "speed", 1, "replay speed (1, 10 or 100)")
+	flag.StringVar(&clockValue, "clock", "local", "clock that places records on the timeline ("+strings.Join(util.TimeSources, ", ")+"), exchange uses the exchange timestamps where recorded")
+	flag.StringVar(&depthBandValue, "depth-band", analytics.DefaultConfig.Depth.String(), "levels counted by the depth indicators, a distance from the mid like 1% or a number of levels like 20")
 	flag.Parse()
+	ActivePlatform = options.Platforms
 
-	//runpprof()
+	cfg, err := options.Config()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(0)
+	}
 
-	db, err := util.OpenDB(db_path, []string{}, false)
+	timeSource, err := util.ParseTimeSource(clockValue)
 	if err != nil {
-		fmt.Println("OpenDB Error", err)
+		fmt.Println(err)
 		os.Exit(0)
 	}
 
-	infos = make([]*product_info.Info, 0)
+	depthBand, err := analytics.ParseBand(depthBandValue)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(0)
+	}
 
-	if strings.Contains(strings.ToLower(ActivePlatform), "coinbase") {
-		ws := coinbase_websocket.New(db, []string{"BTC-USD", "ETH-USD", "BCH-USD"})
-		go ws.Run()
-		for _, info := range ws.Infos {
-			infos = append(infos, info)
+	//runpprof()
+
+	if replay {
+		from, err := util.ParseTime(replayFrom)
+		if err != nil {
+			fmt.Println("replay needs a -from time:", err)
+			os.Exit(0)
 		}
-		ActiveProduct = infos[0].DatabaseKey
-	}
-	if strings.Contains(strings.ToLower(ActivePlatform), "gdax") {
-		ws := gdax_websocket.New(db, []string{"BTC-USD", "ETH-USD", "BCH-USD"})
-		go ws.Run()
-		for _, info := range ws.Infos {
-			infos = append(infos, info)
+		var to time.Time
+		if replayTo != "" {
+			if to, err = util.ParseTime(replayTo); err != nil {
+				fmt.Println(err)
+				os.Exit(0)
+			}
 		}
-		ActiveProduct = infos[0].DatabaseKey
+		replayClock = opengl_bookmap.NewReplayClock(from, to, replaySpeed)
 	}
-	if strings.Contains(strings.ToLower(ActivePlatform), "bitstamp") {
-		ws := bitstamp_websocket.New(db, []string{"BTC-USD", "ETH-USD", "BCH-USD"})
-		go ws.Run()
-		for _, info := range ws.Infos {
-			infos = append(infos, info)
-		}
-		ActiveProduct = infos[0].DatabaseKey
+
+	db, err := util.OpenStore(options.Store, options.DB, replay)
+	if err != nil {
+		fmt.Println("OpenStore Error", err)
+		os.Exit(0)
 	}
-	if strings.Contains(strings.ToLower(ActivePlatform), "binance") {
-		ws := binance_websocket.New(db, []string{"BTC-USDT", "ETH-USDT", "BCH-USDT"})
-		go ws.Run()
-		for _, info := range ws.Infos {
-			infos = append(infos, info)
+
+	infos = make([]*product_info.Info, 0)
+
+	ctx, stop := exchanges.SignalContext()
+	defer stop()
+
+	feeds := &exchanges.Feeds{}
+	if replay {
+		for _, name := range cfg.Platforms {
+			platformInfos, err := exchanges.StoredInfos(db, name, cfg.Products[strings.ToLower(name)])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			infos = append(infos, platformInfos...)
 		}
-		ActiveProduct = infos[0].DatabaseKey
-	}
-	if strings.Contains(strings.ToLower(ActivePlatform), "bitfinex") {
-		ws := bitfinex_websocket.New(db, []string{"BTC-USD", "ETH-USD", "BCH-USD"})
-		go ws.Run()
-		for _, info := range ws.Infos {
-			infos = append(infos, info)
+	} else {
+		if feeds, err = exchanges.StartAll(ctx, db, cfg); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+		for _, ws := range feeds.Clients {
+			infos = append(infos, ws.Infos()...)
 		}
-		ActiveProduct = infos[0].DatabaseKey
 	}
 
+	if len(infos) == 0 {
+		fmt.Println("no products for platforms", ActivePlatform)
+		os.Exit(0)
+	}
+	ActiveProduct = infos[0].DatabaseKey
+
 	var win *Window
 
 	mainthread.Call(func() {
@@ -282,10 +353,15 @@ func run() {
 	padding := 10.0
 	x := padding
 
-	count := len(infos) / 3
+	rows := opengl_bookmap.Rows(infos)
 	for _, info := range infos {
 		//mainthread.Call(func() {
-		bookmaps[info.DatabaseKey] = opengl_bookmap.New(win.Shader, float64(win.Width)-(padding*2), float64((win.Height-4)/count), x, *info, db)
+		bookmaps[info.DatabaseKey] = opengl_bookmap.New(win.Shader, float64(win.Width)-(padding*2), float64((win.Height-4)/rows), x, *info, db)
+		if replayClock != nil {
+			bookmaps[info.DatabaseKey].Clock = replayClock
+		}
+		bookmaps[info.DatabaseKey].TimeSource = timeSource
+		bookmaps[info.DatabaseKey].Analytics.Depth = depthBand
 		//})
 	}
 
@@ -294,8 +370,11 @@ func run() {
 	second := time.NewTicker(time.Second * 1)
 	//var wg sync.WaitGroup
 
+loop:
 	for !win.ShouldClose() {
 		select {
+		case <-ctx.Done():
+			break loop
 		case <-pollEventsTimer.C:
 			mainthread.Call(func() {
 				win.PollEvents()
@@ -304,6 +383,9 @@ func run() {
 		case <-win.redrawChan:
 			// force quick redraw (window resized/moved)
 		case <-second.C:
+			if replayClock != nil {
+				replayClock.Tick()
+			}
 			/*
 				start := time.Now()
 				wg.Add(len(infos))
@@ -334,11 +416,10 @@ func run() {
 		mainthread.Call(func() {
 			win.BeginFrame()
 
-			count := len(infos) / 3
 			n := 0
 			for _, info := range infos {
 				if info.BaseCurrency == ActiveBase {
-					bookmaps[info.DatabaseKey].Texture.DrawAt(float32(10), float32(win.Height)-float32(n*(win.Height/count)))
+					bookmaps[info.DatabaseKey].Texture.DrawAt(float32(10), float32(win.Height)-float32(n*(win.Height/rows)))
 					n += 1
 				}
 			}
@@ -347,6 +428,12 @@ func run() {
 		})
 	}
 	//})
+
+	// stop feeds and wait until they flushed their batches
+	stop()
+	fmt.Println("waiting for feeds to shut down")
+	feeds.Wait()
+	db.Close()
 }
 
 func main() {
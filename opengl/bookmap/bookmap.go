@@ -9,9 +9,11 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/faiface/mainthread"
+	"github.com/lian/gdax-bookmap/orderbook/analytics"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
 	font "github.com/lian/gonky/font/terminus"
 
 	"github.com/lian/gonky/shader"
@@ -28,7 +30,7 @@ type Bookmap struct {
 	PriceSteps          float64 // zoom
 	MaxSizeHisto        float64
 	RowHeight           float64
-	DB                  *bolt.DB
+	DB                  store.Store
 	ColumnWidth         float64
 	ViewportStep        int
 	Graph               *Graph
@@ -40,18 +42,30 @@ type Bookmap struct {
 	ShowDebug           bool
 	AutoHistoSize       bool
 	AutoScroll          bool
+	Clock               Clock
+	Indicator           int // index into analytics.Indicators, -1 hides the indicator strip
+	IndicatorHeight     float64
+	ShowTradeFlow       bool
+	TradeFlowHeight     float64
+	TimeSource          util.TimeSource // clock that drives the timeline of the graph
+	Analytics           analytics.Config
 }
 
-func New(program *shader.Program, width, height float64, x float64, info product_info.Info, db *bolt.DB) *Bookmap {
+func New(program *shader.Program, width, height float64, x float64, info product_info.Info, db store.Store) *Bookmap {
 	s := &Bookmap{
-		ID:           info.ID,
-		ProductInfo:  info,
-		DB:           db,
-		RowHeight:    14,
-		ColumnWidth:  4,
-		ViewportStep: 1,
-		ShowDebug:    true,
-		AutoScroll:   true,
+		ID:              info.ID,
+		ProductInfo:     info,
+		DB:              db,
+		RowHeight:       14,
+		ColumnWidth:     4,
+		ViewportStep:    1,
+		ShowDebug:       true,
+		AutoScroll:      true,
+		Clock:           WallClock{},
+		IndicatorHeight: 56,
+		ShowTradeFlow:   true,
+		TradeFlowHeight: 56,
+		Analytics:       analytics.DefaultConfig,
 		Texture: &texture.Texture{
 			X:      x,
 			Y:      height + 10,
@@ -75,6 +89,21 @@ func New(program *shader.Program, width, height float64, x float64, info product
 	return s
 }
 
+// Rows returns how many bookmaps the window stacks, the most products any
+// base currency has, at least one. The bookmaps of the active base currency
+// share the window height in a single column.
+func Rows(infos []*product_info.Info) int {
+	rows := 1
+	products := map[string]int{}
+	for _, info := range infos {
+		products[info.BaseCurrency]++
+		if products[info.BaseCurrency] > rows {
+			rows = products[info.BaseCurrency]
+		}
+	}
+	return rows
+}
+
 // ugly af
 func round(k float64, precision int) float64 {
 	format := fmt.Sprintf("%%.%df", precision)
@@ -83,6 +112,48 @@ func round(k float64, precision int) float64 {
 	return f
 }
 
+// indicatorStrip returns the height of the indicator strip between the
+// heatmap rows and the timeline, 0 if it is hidden.
+func (s *Bookmap) indicatorStrip() float64 {
+	if s.Indicator < 0 || s.Indicator >= len(analytics.Indicators) {
+		return 0
+	}
+	return s.IndicatorHeight
+}
+
+// tradeFlowPanel returns the height of the volume and CVD panel below the
+// indicator strip, 0 if it is hidden.
+func (s *Bookmap) tradeFlowPanel() float64 {
+	if !s.ShowTradeFlow {
+		return 0
+	}
+	return s.TradeFlowHeight
+}
+
+// heatmapRows is the number of price rows above the indicator strip.
+func (s *Bookmap) heatmapRows() float64 {
+	return (float64(s.Graph.Height) - s.RowHeight - s.indicatorStrip() - s.tradeFlowPanel()) / s.RowHeight
+}
+
+// ToggleTradeFlow shows or hides the volume and CVD panel.
+func (s *Bookmap) ToggleTradeFlow() {
+	s.ShowTradeFlow = !s.ShowTradeFlow
+	if s.Graph != nil {
+		s.Graph.ClearSlotRows()
+	}
+}
+
+// NextIndicator cycles through the indicators and hiding the strip.
+func (s *Bookmap) NextIndicator() {
+	s.Indicator += 1
+	if s.Indicator >= len(analytics.Indicators) {
+		s.Indicator = -1
+	}
+	if s.Graph != nil {
+		s.Graph.ClearSlotRows()
+	}
+}
+
 func (s *Bookmap) ForceAutoScroll() {
 	if s.Graph == nil {
 		return
@@ -93,7 +164,7 @@ func (s *Bookmap) ForceAutoScroll() {
 	last := s.PriceScrollPosition
 
 	//price := s.Graph.Book.Book.LastPrice()
-	price := s.Graph.Book.CenterPrice()
+	price := s.Graph.Book.Mid()
 	if price != 0.0 {
 		s.PriceScrollPosition = (price - math.Mod(price, s.PriceSteps)) + (float64(rowsCount/2) * s.PriceSteps)
 		if last != s.PriceScrollPosition {
@@ -124,10 +195,12 @@ func (s *Bookmap) DrawString(x, y int, text string, color color.RGBA) {
 }
 
 func (s *Bookmap) Progress() bool {
-	now := time.Now()
+	now := s.Clock.Now()
 
 	if s.Graph == nil {
 		graph := NewGraph(s.DB, s.ProductInfo.DatabaseKey, int(s.Texture.Width-145), int(s.Texture.Height-s.RowHeight), int(s.ColumnWidth), int(s.ViewportStep))
+		graph.TimeSource = s.TimeSource
+		graph.Analytics = s.Analytics
 		if graph.SetStart(now) {
 			s.Graph = graph
 		}
@@ -156,11 +229,18 @@ func (s *Bookmap) DrawGraph() {
 	gc.Fill()
 
 	x := float64(s.Graph.Width)
-	rowCount := ((float64(s.Graph.Height) - s.RowHeight) / s.RowHeight)
+	rowCount := s.heatmapRows()
 	s.Graph.DrawTimeslots(gc, x, rowCount, s.RowHeight, s.PriceScrollPosition, s.PriceSteps, s.MaxSizeHisto)
 	s.Graph.DrawTradeDots(gc, x, s.RowHeight, s.PriceScrollPosition, s.PriceSteps, s.MaxSizeHisto)
 	s.Graph.DrawBidAskLines(img, x, s.RowHeight, s.PriceScrollPosition, s.PriceSteps)
-	s.Graph.DrawTimeline(gc, img, x, rowCount*s.RowHeight)
+	if strip := s.indicatorStrip(); strip != 0 {
+		series := s.Graph.IndicatorSeries(analytics.Indicators[s.Indicator])
+		s.Graph.DrawIndicator(gc, x, rowCount*s.RowHeight, strip, series)
+	}
+	if panel := s.tradeFlowPanel(); panel != 0 {
+		s.Graph.DrawTradeFlow(gc, x, rowCount*s.RowHeight+s.indicatorStrip(), panel)
+	}
+	s.Graph.DrawTimeline(gc, img, x, float64(s.Graph.Height)-s.RowHeight)
 
 	b := image.Rect(0, int(s.RowHeight), int(s.Graph.Width), int(s.Graph.Height)+int(s.RowHeight))
 	draw.Draw(s.Image, b, img, img.Bounds().Min, draw.Src)
@@ -169,7 +249,7 @@ func (s *Bookmap) DrawGraph() {
 func (s *Bookmap) DrawGraphStats() {
 	zeroTime := time.Time{}
 	statsSlot := NewTimeSlot(zeroTime, zeroTime)
-	rows := ((float64(s.Graph.Height) - s.RowHeight) / s.RowHeight)
+	rows := s.heatmapRows()
 	statsSlot.GenerateRows(rows, s.PriceScrollPosition, s.PriceSteps)
 	stats := s.Graph.Book.StateAsStats()
 	statsSlot.Fill(stats)
@@ -255,6 +335,61 @@ func (s *Bookmap) DrawGraphStats() {
 		//}
 	}
 
+	// top of book, a tick at the microprice between the best bid and ask
+	book := s.Graph.Book
+	priceY := func(price float64) float64 {
+		return ((s.PriceScrollPosition - price) / s.PriceSteps) * s.RowHeight
+	}
+	gc.SetLineWidth(1.0)
+	if ask := book.BestAsk(); ask != nil {
+		y = priceY(book.PriceScale.Float(ask.Price))
+		gc.SetStrokeColor(red)
+		gc.MoveTo(x, y)
+		gc.LineTo(float64(width), y)
+		gc.Stroke()
+	}
+	if bid := book.BestBid(); bid != nil {
+		y = priceY(book.PriceScale.Float(bid.Price))
+		gc.SetStrokeColor(green)
+		gc.MoveTo(x, y)
+		gc.LineTo(float64(width), y)
+		gc.Stroke()
+	}
+	if micro := book.Microprice(); micro != 0 {
+		y = priceY(micro)
+		gc.SetStrokeColor(fg1)
+		gc.MoveTo(x, y)
+		gc.LineTo(x+xx, y)
+		gc.Stroke()
+	}
+
+	// name and newest value of the indicator strip
+	if strip := s.indicatorStrip(); strip != 0 {
+		y = rows * s.RowHeight
+		gc.SetFillColor(bg1)
+		draw2dkit.Rectangle(gc, 0, y, float64(width), y+strip)
+		gc.Fill()
+		indicator := analytics.Indicators[s.Indicator]
+		font.DrawString(img, int(x+4), int(y)+fontPad, indicator.Name, fg1)
+		if v, ok := s.Graph.IndicatorSeries(indicator).Last(); ok {
+			font.DrawString(img, int(x+4), int(y+s.RowHeight)+fontPad, strconv.FormatFloat(v, 'f', 4, 64), fg1)
+		}
+	}
+
+	// volume and cumulative volume delta of the newest slot
+	if panel := s.tradeFlowPanel(); panel != 0 && len(s.Graph.Timeslots) != 0 {
+		y = rows*s.RowHeight + s.indicatorStrip()
+		gc.SetFillColor(bg1)
+		draw2dkit.Rectangle(gc, 0, y, float64(width), y+panel)
+		gc.Fill()
+		slot := s.Graph.Timeslots[len(s.Graph.Timeslots)-1]
+		font.DrawString(img, int(x+4), int(y)+fontPad, "cvd "+strconv.FormatFloat(slot.CVD, 'f', 4, 64), fg1)
+		font.DrawString(img, int(x+4), int(y+s.RowHeight)+fontPad, "vol "+strconv.FormatFloat(slot.Flow.Volume(), 'f', 4, 64), fg1)
+		if slot.Flow.Count() != 0 {
+			font.DrawString(img, int(x+4), int(y+2*s.RowHeight)+fontPad, "vwap "+s.ProductInfo.FormatFloat(slot.Flow.VWAP()), fg1)
+		}
+	}
+
 	//b := image.Rect(0, 0, s.Graph.Width, int(height))
 	b := image.Rect(int(s.Graph.Width), int(s.RowHeight), int(s.Graph.Width+width), int(s.Graph.Height)+int(s.RowHeight))
 	draw.Draw(s.Image, b, img, img.Bounds().Min, draw.Src)
@@ -273,7 +408,7 @@ func (s *Bookmap) Render() {
 	s.DrawGraph()
 	s.DrawGraphStats()
 
-	now := time.Now()
+	now := s.Clock.Now()
 	s.DrawStatus(now)
 
 	s.WriteTexture()
@@ -291,16 +426,22 @@ func (s *Bookmap) DrawStatus(now time.Time) {
 	draw2dkit.Rectangle(gc, 0, 0, s.Texture.Width, s.RowHeight)
 	gc.Fill()
 
+	book := s.Graph.Book
 	text := fmt.Sprintf(
-		"%s %s   PriceSteps %s MaxSizeHisto %.2f ColumnWidth %.0f ViewportStep %d time-diff %s",
+		"%s %s   spread %s (%.1f bps)   PriceSteps %s MaxSizeHisto %.2f ColumnWidth %.0f ViewportStep %d time-diff %s",
 		s.ProductInfo.DatabaseKey,
-		s.ProductInfo.FormatFloat(s.Graph.Book.LastPrice()),
+		s.ProductInfo.FormatFloat(book.LastPrice()),
+		s.ProductInfo.FormatFloat(book.Spread()),
+		book.SpreadBps(),
 		s.ProductInfo.FormatFloat(s.PriceSteps),
 		s.MaxSizeHisto,
 		s.ColumnWidth,
 		s.ViewportStep,
 		now.Sub(s.Graph.CurrentTime),
 	)
+	if status := s.Clock.Status(); status != "" {
+		text += "   " + status
+	}
 
 	font.DrawString(img, 10, 2, text, fg1)
 	b := image.Rect(0, 0, int(s.Texture.Width), int(s.RowHeight))
@@ -0,0 +1,115 @@
+package bookmap
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges"
+	_ "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func products(keys ...string) []*product_info.Info {
+	infos := []*product_info.Info{}
+	for _, key := range keys {
+		info := product_info.ParseDatabaseKey(key)
+		infos = append(infos, &info)
+	}
+	return infos
+}
+
+func TestRows(t *testing.T) {
+	cases := []struct {
+		name  string
+		infos []*product_info.Info
+		rows  int
+	}{
+		{"no products", nil, 1},
+		{"one product", products("Binance-BTC-USDT"), 1},
+		{"two bases", products("Binance-BTC-USDT", "Binance-ETH-USDT"), 1},
+		{"two platforms", products("Binance-BTC-USDT", "Coinbase-BTC-USD"), 2},
+		{"three platforms of three bases", products(
+			"Binance-BTC-USDT", "Binance-ETH-USDT", "Binance-LTC-USDT",
+			"Coinbase-BTC-USD", "Coinbase-ETH-USD", "Coinbase-LTC-USD",
+			"Bitstamp-BTC-USD", "Bitstamp-ETH-USD", "Bitstamp-LTC-USD",
+		), 3},
+		{"uneven bases", products("Binance-BTC-USDT", "Coinbase-BTC-USD", "Binance-ETH-USDT"), 2},
+	}
+	for _, c := range cases {
+		if rows := Rows(c.infos); rows != c.rows {
+			t.Fatalf("%s: %d rows, want %d", c.name, rows, c.rows)
+		}
+	}
+
+	// a single product gets the whole window like main lays it out
+	infos := products("Binance-BTC-USDT")
+	height := 600
+	b := New(nil, 800, float64((height-4)/Rows(infos)), 10, *infos[0], nil)
+	if b.Texture.Height != 596 || len(b.GraphImage.Pix) == 0 {
+		t.Fatalf("one product bookmap is %.0f high", b.Texture.Height)
+	}
+}
+
+// replayClock stands still at a recorded time.
+type replayClock time.Time
+
+func (c replayClock) Now() time.Time { return time.Time(c) }
+func (c replayClock) Status() string { return "" }
+
+func TestReplayOneProduct(t *testing.T) {
+	f, err := loadFixture(filepath.Join("testdata", "common.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, checks, err := f.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Header.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a recording of a single book, replayed offline like main -replay
+	st := store.NewMemory()
+	defer st.Close()
+	if err := st.Append(f.Bucket(), records...); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.RecordProductInfo(st, info); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := exchanges.StoredInfos(st, info.Platform, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].DatabaseKey != f.Bucket() {
+		t.Fatalf("stored infos %v, want %s", infos, f.Bucket())
+	}
+
+	height := 600
+	b := New(nil, 800, float64((height-4)/Rows(infos)), 10, *infos[0], st)
+	last := checks[len(checks)-1]
+	b.Clock = replayClock(records[0].Time)
+	quiet(b.Render)
+	b.Clock = replayClock(last.Time)
+	quiet(b.Render)
+	if b.Graph == nil || b.Graph.Book == nil {
+		t.Fatalf("replay did not start")
+	}
+
+	levels := []string{}
+	for _, line := range snapshot(b.Graph.Book) {
+		if !strings.HasPrefix(line, "  trade") {
+			levels = append(levels, line)
+		}
+	}
+	want := last.lines()[:len(last.Bids)+len(last.Asks)]
+	if d := difference(want, levels); d != "" {
+		t.Fatalf("replayed book at %s differs\n%s", last.Time, d)
+	}
+}
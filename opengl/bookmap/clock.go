@@ -0,0 +1,81 @@
+package bookmap
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock drives the end of the graph. Live graphs follow the wall clock, replays
+// follow a ReplayClock over recorded history.
+type Clock interface {
+	Now() time.Time
+	Status() string
+}
+
+type WallClock struct{}
+
+func (WallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (WallClock) Status() string {
+	return ""
+}
+
+type ReplayClock struct {
+	Start    time.Time
+	End      time.Time
+	Speed    float64
+	Paused   bool
+	current  time.Time
+	lastTick time.Time
+}
+
+func NewReplayClock(start, end time.Time, speed float64) *ReplayClock {
+	return &ReplayClock{
+		Start:    start,
+		End:      end,
+		Speed:    speed,
+		current:  start,
+		lastTick: time.Now(),
+	}
+}
+
+// Tick advances the replay time by the wall time passed since the last tick
+// multiplied by Speed. Call it once per frame so all graphs share the same time.
+func (c *ReplayClock) Tick() {
+	now := time.Now()
+	elapsed := now.Sub(c.lastTick)
+	c.lastTick = now
+
+	if c.Paused {
+		return
+	}
+
+	c.current = c.current.Add(time.Duration(float64(elapsed) * c.Speed))
+	if !c.End.IsZero() && c.current.After(c.End) {
+		c.current = c.End
+	}
+}
+
+func (c *ReplayClock) Now() time.Time {
+	return c.current
+}
+
+func (c *ReplayClock) TogglePause() {
+	c.Paused = !c.Paused
+}
+
+func (c *ReplayClock) SetSpeed(speed float64) {
+	c.Speed = speed
+}
+
+func (c *ReplayClock) Status() string {
+	state := fmt.Sprintf("%.0fx", c.Speed)
+	if c.Paused {
+		state = "paused"
+	} else if !c.End.IsZero() && !c.current.Before(c.End) {
+		state = "finished"
+	}
+	return fmt.Sprintf("replay %s %s", state, c.current.Format("2006-01-02 15:04:05"))
+}
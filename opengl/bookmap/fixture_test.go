@@ -0,0 +1,315 @@
+package bookmap
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	common "github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	gdax_orderbook "github.com/lian/gdax-bookmap/exchanges/gdax/orderbook"
+	gdax_websocket "github.com/lian/gdax-bookmap/exchanges/gdax/websocket"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// fixtureHeader is the first line of a fixture.
+type fixtureHeader struct {
+	Platform       string `json:"platform"`
+	Product        string `json:"product"`
+	QuoteIncrement string `json:"quote_increment"`
+	BaseIncrement  string `json:"base_increment"`
+	// common or gdax, the book and packers the stream is recorded with
+	Encoder string `json:"encoder"`
+}
+
+// fixtureOp is one fixture line after the header. Common books take sync, update,
+// diff and trade, gdax books sync, open, done, match and diff; check
+// compares the replayed book at its time.
+type fixtureOp struct {
+	Time time.Time `json:"t"`
+	Op   string    `json:"op"`
+	Seq  uint64    `json:"seq"`
+	// first sequence of a diff, defaults to the one after the last record
+	First uint64     `json:"first"`
+	Bids  [][]string `json:"bids"`
+	Asks  [][]string `json:"asks"`
+	// gdax orders as id, side, price, size
+	Orders [][]string `json:"orders"`
+	// gdax order of done and the maker order of match
+	OrderID string `json:"order_id"`
+	// trades, Buy is the taker side of common trades and Side the maker
+	// side of gdax matches
+	Buy          bool      `json:"buy"`
+	Side         string    `json:"side"`
+	Guessed      bool      `json:"guessed"`
+	Price        string    `json:"price"`
+	Size         string    `json:"size"`
+	TradeID      uint64    `json:"trade_id"`
+	ExchangeTime time.Time `json:"exchange_time"`
+}
+
+type fixtureLevel struct {
+	Price fixed.Value
+	Size  fixed.Value
+}
+
+type fixtureTrade struct {
+	Side  uint8
+	Price fixed.Value
+	Size  fixed.Value
+}
+
+// fixtureEncoder is a recorder book with its packers.
+type fixtureEncoder interface {
+	// Apply runs op on the book and returns the record it writes, if any,
+	// and the trade it adds, if any.
+	Apply(op *fixtureOp) ([]byte, *fixtureTrade, error)
+	// Levels returns the bids and asks of the book, best first.
+	Levels() ([]fixtureLevel, []fixtureLevel)
+	Scales() (fixed.Scale, fixed.Scale)
+}
+
+func (h *fixtureHeader) Info() (product_info.Info, error) {
+	info := product_info.ParseDatabaseKey(h.Platform + "-" + h.Product)
+	var err error
+	if _, err = fmt.Sscan(h.QuoteIncrement, &info.QuoteIncrement); err != nil {
+		return info, fmt.Errorf("quote_increment: %s", err)
+	}
+	if _, err = fmt.Sscan(h.BaseIncrement, &info.BaseIncrement); err != nil {
+		return info, fmt.Errorf("base_increment: %s", err)
+	}
+	return info, nil
+}
+
+func newFixtureEncoder(h *fixtureHeader) (fixtureEncoder, error) {
+	info, err := h.Info()
+	if err != nil {
+		return nil, err
+	}
+	switch h.Encoder {
+	case "common", "":
+		book := common.New(info.ID)
+		book.SetProductInfo(info)
+		return &commonEncoder{book: book}, nil
+	case "gdax":
+		// preset the product so New does not fetch it
+		gdax_orderbook.CachedInfo = map[string]product_info.Info{info.ID: info}
+		return &gdaxEncoder{book: gdax_orderbook.New(info.ID)}, nil
+	}
+	return nil, fmt.Errorf("unknown encoder %q", h.Encoder)
+}
+
+type commonEncoder struct {
+	book        *common.Book
+	lastDiffSeq uint64
+}
+
+func (e *commonEncoder) update(op *fixtureOp) error {
+	b := e.book
+	for _, side := range []struct {
+		levels [][]string
+		update func(time.Time, fixed.Value, fixed.Value)
+	}{{op.Bids, b.UpdateBidLevel}, {op.Asks, b.UpdateAskLevel}} {
+		for _, level := range side.levels {
+			if len(level) != 2 {
+				return fmt.Errorf("level %v is not price, size", level)
+			}
+			price, err := b.ParsePrice(level[0])
+			if err != nil {
+				return err
+			}
+			size, err := b.ParseSize(level[1])
+			if err != nil {
+				return err
+			}
+			side.update(op.Time, price, size)
+		}
+	}
+	if op.Seq != 0 {
+		b.Sequence = op.Seq
+	}
+	if !op.ExchangeTime.IsZero() {
+		b.ExchangeTime = op.ExchangeTime
+	}
+	return nil
+}
+
+func (e *commonEncoder) Apply(op *fixtureOp) ([]byte, *fixtureTrade, error) {
+	b := e.book
+	switch op.Op {
+	case "sync":
+		b.Clear()
+		if err := e.update(op); err != nil {
+			return nil, nil, err
+		}
+		pkt := common.PackSync(b)
+		b.ResetDiff()
+		e.lastDiffSeq = b.Sequence + 1
+		return pkt, nil, nil
+	case "update":
+		return nil, nil, e.update(op)
+	case "diff":
+		if err := e.update(op); err != nil {
+			return nil, nil, err
+		}
+		first := e.lastDiffSeq
+		if op.First != 0 {
+			first = op.First
+		}
+		pkt := common.PackDiff(b, first, b.Sequence)
+		b.ResetDiff()
+		e.lastDiffSeq = b.Sequence + 1
+		return pkt, nil, nil
+	case "trade":
+		price, err := b.ParsePrice(op.Price)
+		if err != nil {
+			return nil, nil, err
+		}
+		size, err := b.ParseSize(op.Size)
+		if err != nil {
+			return nil, nil, err
+		}
+		if op.Guessed {
+			b.AddTrade(op.Time, b.GetSide(price), price, size)
+		} else {
+			b.AddTakerTrade(op.Time, common.TakerSide(op.Buy), price, size, op.TradeID, op.ExchangeTime)
+		}
+		trade := b.Trades[len(b.Trades)-1]
+		return common.PackTrade(b, trade), &fixtureTrade{Side: uint8(trade.Side), Price: trade.Price, Size: trade.Size}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown common op %q", op.Op)
+}
+
+func (e *commonEncoder) Levels() ([]fixtureLevel, []fixtureLevel) {
+	bids := make([]fixtureLevel, 0, len(e.book.Bid))
+	for i := len(e.book.Bid) - 1; i >= 0; i-- {
+		bids = append(bids, fixtureLevel{e.book.Bid[i].Price, e.book.Bid[i].Size})
+	}
+	asks := make([]fixtureLevel, 0, len(e.book.Ask))
+	for _, level := range e.book.Ask {
+		asks = append(asks, fixtureLevel{level.Price, level.Size})
+	}
+	return bids, asks
+}
+
+func (e *commonEncoder) Scales() (fixed.Scale, fixed.Scale) {
+	return e.book.PriceScale, e.book.SizeScale
+}
+
+type gdaxEncoder struct {
+	book        *gdax_orderbook.Book
+	lastDiffSeq uint64
+}
+
+func (e *gdaxEncoder) add(order []string) error {
+	if len(order) != 4 {
+		return fmt.Errorf("order %v is not id, side, price, size", order)
+	}
+	price, err := e.book.PriceScale.Parse(order[2])
+	if err != nil {
+		return err
+	}
+	size, err := e.book.SizeScale.Parse(order[3])
+	if err != nil {
+		return err
+	}
+	e.book.Add(map[string]interface{}{"id": order[0], "side": order[1], "price": price, "size": size})
+	return nil
+}
+
+func (e *gdaxEncoder) Apply(op *fixtureOp) ([]byte, *fixtureTrade, error) {
+	b := e.book
+	if !op.ExchangeTime.IsZero() {
+		b.ExchangeTime = op.ExchangeTime
+	}
+	if op.Seq != 0 {
+		b.Sequence = op.Seq
+	}
+
+	switch op.Op {
+	case "sync":
+		b.Clear()
+		for _, order := range op.Orders {
+			if err := e.add(order); err != nil {
+				return nil, nil, err
+			}
+		}
+		pkt := gdax_websocket.PackSync(b)
+		b.ResetDiff()
+		e.lastDiffSeq = b.Sequence + 1
+		return pkt, nil, nil
+	case "open":
+		for _, order := range op.Orders {
+			if err := e.add(order); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, nil
+	case "done":
+		b.Remove(op.OrderID)
+		return nil, nil, nil
+	case "match":
+		price, err := b.PriceScale.Parse(op.Price)
+		if err != nil {
+			return nil, nil, err
+		}
+		size, err := b.SizeScale.Parse(op.Size)
+		if err != nil {
+			return nil, nil, err
+		}
+		var previous *gdax_orderbook.Order
+		if len(b.Trades) != 0 {
+			previous = b.Trades[len(b.Trades)-1]
+		}
+		match := map[string]interface{}{
+			"size":           size,
+			"price":          price,
+			"side":           op.Side,
+			"maker_order_id": op.OrderID,
+			"taker_order_id": "",
+			"trade_id":       op.TradeID,
+		}
+		if !op.ExchangeTime.IsZero() {
+			match["time"] = op.ExchangeTime.UTC().Format(time.RFC3339Nano)
+		}
+		b.Match(match, false)
+		if len(b.Trades) == 0 || b.Trades[len(b.Trades)-1] == previous {
+			return nil, nil, fmt.Errorf("match of unknown order %s", op.OrderID)
+		}
+		trade := b.Trades[len(b.Trades)-1]
+		return gdax_websocket.PackTrade(b, trade), &fixtureTrade{Side: uint8(trade.Side), Price: trade.Price, Size: trade.Size}, nil
+	case "diff":
+		first := e.lastDiffSeq
+		if op.First != 0 {
+			first = op.First
+		}
+		pkt := gdax_websocket.PackDiff(b, first, b.Sequence)
+		b.ResetDiff()
+		e.lastDiffSeq = b.Sequence + 1
+		return pkt, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unknown gdax op %q", op.Op)
+}
+
+func gdaxLevels(levels map[fixed.Value]*gdax_orderbook.BookLevel) []fixtureLevel {
+	sorted := make([]fixtureLevel, 0, len(levels))
+	for _, level := range levels {
+		if size := level.Size(); size != 0 {
+			sorted = append(sorted, fixtureLevel{level.Price, size})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Price < sorted[j].Price })
+	return sorted
+}
+
+func (e *gdaxEncoder) Levels() ([]fixtureLevel, []fixtureLevel) {
+	bids := gdaxLevels(e.book.Bid)
+	for i, j := 0, len(bids)-1; i < j; i, j = i+1, j-1 {
+		bids[i], bids[j] = bids[j], bids[i]
+	}
+	return bids, gdaxLevels(e.book.Ask)
+}
+
+func (e *gdaxEncoder) Scales() (fixed.Scale, fixed.Scale) {
+	return e.book.PriceScale, e.book.SizeScale
+}
@@ -0,0 +1,398 @@
+package bookmap
+
+// TestGolden replays the fixture streams in testdata through the packers of
+// the common and gdax books, stores them in every backend, rebuilds the book
+// with Graph.FetchBook at each check and compares it against the recorder's
+// book and the golden file; -update rewrites the golden files.
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+const timeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// trades the replayed book keeps
+const bookTrades = 25
+
+type fixture struct {
+	Name   string
+	Header fixtureHeader
+	Ops    []*fixtureOp
+}
+
+func loadFixture(path string) (*fixture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseFixture(path, file)
+}
+
+// parseFixture reads the fixture lines of r, path names the fixture and its
+// errors.
+func parseFixture(path string, r io.Reader) (*fixture, error) {
+	f := &fixture{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	header := true
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if header {
+			if err := json.Unmarshal([]byte(line), &f.Header); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+			}
+			header = false
+			continue
+		}
+		op := &fixtureOp{}
+		if err := json.Unmarshal([]byte(line), op); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		if op.Time.IsZero() {
+			return nil, fmt.Errorf("%s:%d: op without time", path, n)
+		}
+		f.Ops = append(f.Ops, op)
+	}
+	return f, scanner.Err()
+}
+
+func (f *fixture) Bucket() string {
+	return f.Header.Platform + "-" + f.Header.Product
+}
+
+// fixtureCheck is the recorder's book at the time of a check op.
+type fixtureCheck struct {
+	Time       time.Time
+	PriceScale fixed.Scale
+	SizeScale  fixed.Scale
+	Bids       []fixtureLevel
+	Asks       []fixtureLevel
+	// trades since the last sync, the replay starts from it
+	Trades []fixtureTrade
+}
+
+// Encode runs the ops through the encoder and returns the records it writes
+// and the book at every check.
+func (f *fixture) Encode() ([]store.Event, []*fixtureCheck, error) {
+	enc, err := newFixtureEncoder(&f.Header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := []store.Event{}
+	checks := []*fixtureCheck{}
+	trades := []fixtureTrade{}
+	var last time.Time
+
+	for i, op := range f.Ops {
+		if op.Time.Before(last) {
+			return nil, nil, fmt.Errorf("op %d at %s is before the previous op", i+1, op.Time)
+		}
+		last = op.Time
+
+		if op.Op == "check" {
+			if len(records) != 0 && !records[len(records)-1].Time.Before(op.Time) {
+				return nil, nil, fmt.Errorf("check %d at %s is not after the previous record", i+1, op.Time)
+			}
+			check := &fixtureCheck{Time: op.Time}
+			check.PriceScale, check.SizeScale = enc.Scales()
+			check.Bids, check.Asks = enc.Levels()
+			check.Trades = append(check.Trades, trades...)
+			if len(check.Trades) > bookTrades {
+				check.Trades = check.Trades[len(check.Trades)-bookTrades:]
+			}
+			checks = append(checks, check)
+			continue
+		}
+
+		pkt, trade, err := enc.Apply(op)
+		if err != nil {
+			return nil, nil, fmt.Errorf("op %d %s: %s", i+1, op.Op, err)
+		}
+		if op.Op == "sync" {
+			trades = trades[:0]
+		}
+		if trade != nil {
+			trades = append(trades, *trade)
+		}
+		if pkt != nil {
+			if len(checks) != 0 && !checks[len(checks)-1].Time.Before(op.Time) {
+				return nil, nil, fmt.Errorf("record of op %d at %s is not after the previous check", i+1, op.Time)
+			}
+			records = append(records, store.Event{Time: op.Time, Data: pkt})
+		}
+	}
+	return records, checks, nil
+}
+
+type variant struct {
+	Backend  string
+	Compress bool
+}
+
+func (v variant) String() string {
+	if v.Compress {
+		return v.Backend + "+compress"
+	}
+	return v.Backend
+}
+
+// Replay stores records in the backend of v and renders every stored record
+// and the book FetchBook rebuilds at every check. full adds the envelope
+// version and bytes of each record.
+func (f *fixture) Replay(records []store.Event, checks []*fixtureCheck, v variant, dir string, full bool) ([]string, error) {
+	path := filepath.Join(dir, f.Name+"-"+strings.Replace(v.String(), "+", "-", -1))
+	if v.Backend == "bolt" {
+		path += ".db"
+	}
+	st, err := util.OpenStore(v.Backend, path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	events := make([]store.Event, len(records))
+	for i, record := range records {
+		events[i] = record
+		if v.Compress {
+			events[i].Data = orderbook.CompressPacket(record.Data)
+		}
+	}
+	if err := st.Append(f.Bucket(), events...); err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	c, err := st.Cursor(f.Bucket())
+	if err != nil {
+		return nil, err
+	}
+	stored := 0
+	for event, ok := c.First(); ok; event, ok = c.Next() {
+		pkt, err := orderbook.DecodePacket(event.Data)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("record at %s: %s", event.Time.UTC().Format(timeLayout), err)
+		}
+		lines = append(lines, "record "+event.Time.UTC().Format(timeLayout))
+		if full {
+			lines = append(lines, fmt.Sprintf("  v%d %x", pkt.Version, event.Data))
+		}
+		lines = append(lines, describe(pkt)...)
+		stored++
+	}
+	c.Close()
+	if stored != len(records) {
+		return nil, fmt.Errorf("stored %d records, read back %d", len(records), stored)
+	}
+
+	g := NewGraph(st, f.Bucket(), 1, 1, 1, 1)
+	for _, check := range checks {
+		var book *orderbook.Book
+		quiet(func() { _, book, err = g.FetchBook(check.Time) })
+		if err != nil {
+			return nil, fmt.Errorf("check at %s: %s", check.Time.UTC().Format(timeLayout), err)
+		}
+
+		got := snapshot(book)
+		want := check.lines()
+		if d := difference(want, got); d != "" {
+			return nil, fmt.Errorf("check at %s: replayed book differs from the recorder's book\n%s", check.Time.UTC().Format(timeLayout), d)
+		}
+
+		lines = append(lines, "check "+check.Time.UTC().Format(timeLayout))
+		lines = append(lines, fmt.Sprintf("  seq %d synced %t", book.Sequence, book.Synced))
+		lines = append(lines, got...)
+	}
+	return lines, nil
+}
+
+func sideName(side uint8) string {
+	if orderbook.Side(side) == orderbook.AskSide {
+		return "ask"
+	}
+	return "bid"
+}
+
+func formatLevel(name string, priceScale, sizeScale fixed.Scale, price, size fixed.Value) string {
+	return fmt.Sprintf("  %s %s %s", name, priceScale.Format(price), sizeScale.Format(size))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(timeLayout)
+}
+
+// describe renders a decoded record, levels in packet order.
+func describe(pkt *orderbook.Packet) []string {
+	scales := fmt.Sprintf("scales %d/%d", pkt.PriceScale, pkt.SizeScale)
+	lines := []string{}
+	switch pkt.Type {
+	case orderbook.SyncPacket:
+		lines = append(lines, fmt.Sprintf("  sync seq %d %s exchange_time %s", pkt.Sequence, scales, formatTime(pkt.ExchangeTime)))
+	case orderbook.DiffPacket:
+		lines = append(lines, fmt.Sprintf("  diff seq %d first %d last %d %s exchange_time %s", pkt.Sequence, pkt.First, pkt.Last, scales, formatTime(pkt.ExchangeTime)))
+	case orderbook.TradePacket:
+		return append(lines, fmt.Sprintf("  trade %s %s %s %s id %d aggressor %t exchange_time %s",
+			sideName(pkt.Side), pkt.PriceScale.Format(pkt.Price), pkt.SizeScale.Format(pkt.Size), scales,
+			pkt.TradeID, pkt.Aggressor, formatTime(pkt.ExchangeTime)))
+	}
+	for _, level := range pkt.Bid {
+		lines = append(lines, formatLevel("bid", pkt.PriceScale, pkt.SizeScale, level.Price, level.Size))
+	}
+	for _, level := range pkt.Ask {
+		lines = append(lines, formatLevel("ask", pkt.PriceScale, pkt.SizeScale, level.Price, level.Size))
+	}
+	return lines
+}
+
+// snapshot renders the levels of book best first and its trades, removed
+// levels the book still holds are skipped.
+func snapshot(book *orderbook.Book) []string {
+	lines := []string{}
+	for i := len(book.Bid) - 1; i >= 0; i-- {
+		if level := book.Bid[i]; level.Quantity > 0 {
+			lines = append(lines, formatLevel("bid", book.PriceScale, book.SizeScale, level.Price, level.Quantity))
+		}
+	}
+	for _, level := range book.Ask {
+		if level.Quantity > 0 {
+			lines = append(lines, formatLevel("ask", book.PriceScale, book.SizeScale, level.Price, level.Quantity))
+		}
+	}
+	for _, trade := range book.Trades {
+		lines = append(lines, formatLevel("trade "+sideName(uint8(trade.Side)), book.PriceScale, book.SizeScale, trade.Price, trade.Quantity))
+	}
+	return lines
+}
+
+func (c *fixtureCheck) lines() []string {
+	lines := []string{}
+	for _, level := range c.Bids {
+		lines = append(lines, formatLevel("bid", c.PriceScale, c.SizeScale, level.Price, level.Size))
+	}
+	for _, level := range c.Asks {
+		lines = append(lines, formatLevel("ask", c.PriceScale, c.SizeScale, level.Price, level.Size))
+	}
+	for _, trade := range c.Trades {
+		lines = append(lines, formatLevel("trade "+sideName(trade.Side), c.PriceScale, c.SizeScale, trade.Price, trade.Size))
+	}
+	return lines
+}
+
+// difference describes the first line where got differs from want.
+func difference(want, got []string) string {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return fmt.Sprintf("  line %d\n  want %q\n  got  %q", i+1, w, g)
+		}
+	}
+	return ""
+}
+
+// quiet runs fn with stdout discarded, the book and graph print every
+// resync and process error.
+func quiet(fn func()) {
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		fn()
+		return
+	}
+	stdout := os.Stdout
+	os.Stdout = devnull
+	defer func() {
+		os.Stdout = stdout
+		devnull.Close()
+	}()
+	fn()
+}
+
+// Run replays the fixture in every variant and compares the renderings with
+// each other and the full rendering with the golden file next to it.
+func (f *fixture) Run(path string, variants []variant, dir string, update bool) error {
+	records, checks, err := f.Encode()
+	if err != nil {
+		return err
+	}
+
+	full, err := f.Replay(records, checks, variant{Backend: "memory"}, dir, true)
+	if err != nil {
+		return fmt.Errorf("memory: %s", err)
+	}
+	plain, _ := f.Replay(records, checks, variant{Backend: "memory"}, dir, false)
+
+	for _, v := range variants {
+		lines, err := f.Replay(records, checks, v, dir, false)
+		if err != nil {
+			return fmt.Errorf("%s: %s", v, err)
+		}
+		if d := difference(plain, lines); d != "" {
+			return fmt.Errorf("%s differs from memory\n%s", v, d)
+		}
+	}
+
+	golden := strings.TrimSuffix(path, filepath.Ext(path)) + ".golden"
+	text := "# " + filepath.Base(path) + ", go test ./opengl/bookmap -run TestGolden -update rewrites this file\n" + strings.Join(full, "\n") + "\n"
+	if update {
+		return ioutil.WriteFile(golden, []byte(text), 0644)
+	}
+	buf, err := ioutil.ReadFile(golden)
+	if err != nil {
+		return err
+	}
+	if d := difference(strings.Split(string(buf), "\n"), strings.Split(text, "\n")); d != "" {
+		return fmt.Errorf("%s differs\n%s", filepath.Base(golden), d)
+	}
+	return nil
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestGolden(t *testing.T) {
+	variants := []variant{}
+	for _, backend := range store.Backends {
+		variants = append(variants, variant{Backend: backend}, variant{Backend: backend, Compress: true})
+	}
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.jsonl"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures in testdata")
+	}
+	for _, path := range paths {
+		f, err := loadFixture(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Run(path, variants, t.TempDir(), *update); err != nil {
+			t.Fatalf("%s: %s", f.Name, err)
+		}
+	}
+}
@@ -1,19 +1,21 @@
 package bookmap
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"image/color"
 	"math"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/analytics"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
 )
 
 type Graph struct {
 	CurrentTime time.Time
+	LastKey     store.Key // last processed packet, processing resumes after it
 	Book        *orderbook.Book
 	Timeslots   []*TimeSlot
 	Width       int
@@ -23,7 +25,7 @@ type Graph struct {
 	SlotSteps   int
 	Start       time.Time
 	End         time.Time
-	DB          *bolt.DB
+	DB          store.Store
 	ProductID   string
 	Red         color.RGBA
 	Green       color.RGBA
@@ -31,9 +33,12 @@ type Graph struct {
 	Fg1         color.RGBA
 	CurrentSlot *TimeSlot
 	NoTimeout   bool
+	Rollup      int // rollup tier in seconds used for complete slots, 0 replays every packet
+	Analytics   analytics.Config
+	TimeSource  util.TimeSource // clock that places packets into slots
 }
 
-func NewGraph(db *bolt.DB, productID string, width, height, slotWidth, slotSteps int) *Graph {
+func NewGraph(db store.Store, productID string, width, height, slotWidth, slotSteps int) *Graph {
 	g := &Graph{
 		ProductID: productID,
 		DB:        db,
@@ -47,10 +52,20 @@ func NewGraph(db *bolt.DB, productID string, width, height, slotWidth, slotSteps
 		Bg1:       color.RGBA{0x15, 0x23, 0x2c, 0xff},
 		Fg1:       color.RGBA{0xdd, 0xdf, 0xe1, 0xff},
 		Book:      orderbook.New(productID),
+		Analytics: analytics.DefaultConfig,
 	}
 	return g
 }
 
+// IndicatorSeries returns indicator over the timeslots, oldest first.
+func (g *Graph) IndicatorSeries(indicator analytics.Indicator) *analytics.Series {
+	snapshots := make([]*analytics.Snapshot, len(g.Timeslots))
+	for i, slot := range g.Timeslots {
+		snapshots[i] = slot.Analytics(g.Analytics)
+	}
+	return analytics.NewSeries(indicator, snapshots)
+}
+
 func (g *Graph) MaxHistoSize() float64 {
 	var max float64
 	for _, slot := range g.Timeslots {
@@ -70,8 +85,10 @@ func (g *Graph) ClearSlotRows() {
 func (g *Graph) SetStart(start time.Time) bool {
 	var err error
 	g.Start = RoundTime(start, g.SlotSteps)
+	g.Rollup = g.rollupTier()
 
-	g.CurrentTime, g.Book, err = g.FetchBook(g.Start)
+	g.LastKey, g.Book, err = g.FetchBook(g.Start)
+	g.CurrentTime = g.LastKey.Time
 	if err != nil {
 		g.Book = nil
 		fmt.Println("ERROR", "SetStart", err)
@@ -139,7 +156,81 @@ func (g *Graph) NextSlot(t time.Time) *TimeSlot {
 	return nil
 }
 
+// rollupTier picks the coarsest existing rollup tier that evenly divides SlotSteps.
+func (g *Graph) rollupTier() int {
+	// rollups are built on the local clock
+	if g.SlotSteps <= 1 || g.TimeSource != util.LocalTime {
+		return 0
+	}
+	tiers := util.RollupTiers(g.DB, g.ProductID)
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if g.SlotSteps%tiers[i] == 0 {
+			return tiers[i]
+		}
+	}
+	return 0
+}
+
+// processRollups fills the slots ahead of CurrentTime that are completely
+// covered by the rollup tier and moves the book to the end of them.
+func (g *Graph) processRollups() {
+	if g.Rollup == 0 || g.CurrentSlot == nil || g.CurrentSlot.Stats != nil || g.CurrentSlot.Rollup {
+		return
+	}
+
+	c, err := g.DB.Cursor(util.RollupBucket(g.ProductID, g.Rollup))
+	if err != nil {
+		return
+	}
+	end := util.RollupEnd(g.DB, g.ProductID, g.Rollup)
+
+	var last *TimeSlot
+	for _, slot := range g.Timeslots {
+		if slot.From.Before(g.CurrentSlot.From) || slot.Stats != nil || slot.Rollup {
+			continue
+		}
+		if slot.To.After(end) {
+			break
+		}
+
+		var stats *orderbook.BookMapStatsCopy
+		for event, ok := c.Seek(slot.From); ok && event.Time.Before(slot.To); event, ok = c.Next() {
+			tierStats, err := orderbook.UnpackStats(event.Data)
+			if err != nil {
+				fmt.Println(g.ProductID, "Rollup Error", event.Time, err)
+				continue
+			}
+			stats = orderbook.MergeStats(stats, tierStats)
+		}
+		// nil without rollup entries, drawn like a slot without packets
+		slot.Stats = stats
+		slot.Rollup = true
+		last = slot
+	}
+	c.Close()
+
+	if last == nil {
+		return
+	}
+
+	// continue with the recorded packets after the rolled up slots, the slot end is part of the slot
+	lastKey, book, err := g.FetchBook(last.To.Add(time.Nanosecond))
+	if err != nil {
+		fmt.Println("ERROR", "processRollups", err)
+		return
+	}
+	g.CurrentTime, g.LastKey, g.Book = lastKey.Time, lastKey, book
+	if next := g.NextSlot(last.To.Add(time.Nanosecond)); next != nil {
+		g.CurrentSlot = next
+	} else {
+		g.CurrentSlot = last
+	}
+}
+
 func (g *Graph) ProcessTimeslots() {
+	g.processRollups()
+	defer g.updateTradeFlow()
+
 	firstTime := g.Timeslots[0].From
 	lastTime := g.Timeslots[len(g.Timeslots)-1].To
 	//fmt.Println(g.ProductID, "ProcessTimeslots", firstTime, lastTime)
@@ -154,126 +245,164 @@ func (g *Graph) ProcessTimeslots() {
 
 	processingStart := time.Now()
 
-	g.DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(g.ProductID)).Cursor()
-
-		c.Seek(orderbook.PackTimeKey(g.CurrentTime))
-		for {
-			if !g.NoTimeout && time.Now().Sub(processingStart).Seconds() >= 1.0 {
-				fmt.Println(g.ProductID, "defer processing", g.CurrentTime)
-				break
-			}
+	c, err := g.DB.Cursor(g.ProductID)
+	if err != nil {
+		fmt.Println(g.ProductID, "ProcessTimeslots", err)
+		return
+	}
+	defer c.Close()
 
-			key, buf := c.Next()
-			if key == nil {
-				break
-			}
+	// packets can share a nanosecond, resume strictly after the last one
+	for event, ok := c.SeekAfter(g.LastKey); ok; event, ok = c.Next() {
+		if !g.NoTimeout && time.Now().Sub(processingStart).Seconds() >= 1.0 {
+			fmt.Println(g.ProductID, "defer processing", g.CurrentTime)
+			break
+		}
 
-			t := orderbook.UnpackTimeKey(key)
+		// the cursor walks the local receive times, slots follow TimeSource
+		key := event.Time
+		pkt, err := orderbook.DecodePacket(event.Data)
+		t := g.TimeSource.EventTime(key, pkt)
 
-			// after our wanted range
-			if t.After(lastTime) {
-				fmt.Println(g.ProductID, "after wanted range", t, lastTime)
-				break
-			}
+		// after our wanted range
+		if t.After(lastTime) {
+			fmt.Println(g.ProductID, "after wanted range", t, lastTime)
+			break
+		}
 
-			// before our wanted range, process it and move on
-			if t.Before(firstTime) {
-				//fmt.Println(g.ProductID, "before wanted range", t, firstTime)
-				g.CurrentTime = t
-				g.Book.Process(t, buf)
-				g.Book.ResetStats()
-				continue
-			}
+		// before our wanted range, process it and move on. On the exchange
+		// clock a record without timestamp can have opened the first slot
+		// already, the records after it stay in the current slot instead of
+		// resetting its stats
+		if t.Before(firstTime) && g.CurrentSlot.Stats == nil {
+			//fmt.Println(g.ProductID, "before wanted range", t, firstTime)
+			g.CurrentTime, g.LastKey = key, event.Key()
+			g.apply(g.Book, t, pkt, err)
+			g.Book.ResetStats()
+			continue
+		}
 
-			slot = g.CurrentSlot
+		slot = g.CurrentSlot
 
-			// move to next slow
-			if t.After(slot.To) {
+		// move to next slow
+		if t.After(slot.To) {
+			if !slot.Rollup {
 				slot.Stats = g.Book.StatsCopy()
-				g.CurrentSlot = g.NextSlot(t)
-				if g.NoTimeout {
-					fmt.Println("moved to next slot", g.CurrentSlot.From, g.CurrentSlot.To)
+			}
+			g.CurrentSlot = g.NextSlot(t)
+			if g.NoTimeout {
+				fmt.Println("moved to next slot", g.CurrentSlot.From, g.CurrentSlot.To)
+			}
+			/*
+				if g.CurrentSlot == nil {
+					fmt.Println(g.ProductID, "next slot nil", lastTime)
+					g.CurrentSlot = slot
+					break
 				}
-				/*
-					if g.CurrentSlot == nil {
-						fmt.Println(g.ProductID, "next slot nil", lastTime)
-						g.CurrentSlot = slot
-						break
-					}
-				*/
-				g.Book.ResetStats()
-				g.CurrentTime = t
-				g.Book.Process(t, buf)
-				g.CurrentSlot.Stats = g.Book.StatsCopy()
-			} else {
-				g.CurrentTime = t
-				g.Book.Process(t, buf)
+			*/
+			g.Book.ResetStats()
+			g.CurrentTime, g.LastKey = key, event.Key()
+			g.apply(g.Book, t, pkt, err)
+			g.CurrentSlot.Stats = g.Book.StatsCopy()
+		} else {
+			g.CurrentTime, g.LastKey = key, event.Key()
+			g.apply(g.Book, t, pkt, err)
 
-				if slot.Stats == nil {
-					slot.Stats = g.Book.StatsCopy()
-				} else {
-					updateStats = true
-				}
+			if slot.Stats == nil {
+				slot.Stats = g.Book.StatsCopy()
+			} else {
+				updateStats = true
 			}
-
 		}
-		return nil
-	})
+
+	}
 
 	if updateStats {
 		g.CurrentSlot.Stats = g.Book.StatsCopy()
 	}
 }
 
+// updateTradeFlow copies the trade flow of the slot stats and sums up the
+// cumulative volume delta from the first slot.
+func (g *Graph) updateTradeFlow() {
+	var cvd float64
+	for _, slot := range g.Timeslots {
+		slot.Flow = orderbook.TradeFlow{}
+		if slot.Stats != nil {
+			slot.Flow = slot.Stats.Flow
+		}
+		cvd += slot.Flow.Delta()
+		slot.CVD = cvd
+	}
+}
+
+// MaxTradeVolume returns the largest traded volume of a slot.
+func (g *Graph) MaxTradeVolume() float64 {
+	var max float64
+	for _, slot := range g.Timeslots {
+		if volume := slot.Flow.Volume(); volume > max {
+			max = volume
+		}
+	}
+	return max
+}
+
+func (g *Graph) apply(book *orderbook.Book, t time.Time, pkt *orderbook.Packet, err error) {
+	if err == nil {
+		err = book.Apply(t, pkt)
+	}
+	if err != nil {
+		fmt.Println(g.ProductID, "Process Error", t, err)
+	}
+}
+
+func (g *Graph) process(book *orderbook.Book, t time.Time, buf []byte) {
+	pkt, err := orderbook.DecodePacket(buf)
+	g.apply(book, t, pkt, err)
+}
+
 func RoundTime(t time.Time, steps int) time.Time {
 	tmp := t.Unix()
 	tmp += int64(steps) - int64(math.Mod(float64(tmp), float64(steps)))
 	return time.Unix(tmp, 0)
 }
 
-func (g *Graph) FetchBook(from time.Time) (time.Time, *orderbook.Book, error) {
+// FetchBook rebuilds the book from the last sync at or before from and returns
+// it with the key of the last packet applied.
+func (g *Graph) FetchBook(from time.Time) (store.Key, *orderbook.Book, error) {
 	//fmt.Println("Begin FetchBook")
-	var err error
 	book := orderbook.New(g.ProductID)
-	startKey := orderbook.PackTimeKey(from)
-
-	g.DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(g.ProductID)).Cursor()
 
-		first := true
-		var key, buf []byte
-		for key, buf = c.Seek(startKey); !bytes.HasPrefix(buf, []byte("\x00")); key, buf = c.Prev() {
-			if first == false && key == nil {
-				err = errors.New(fmt.Sprintf("FetchBook %s no sync key found", g.ProductID))
-				return nil
-			}
-			first = false
-		}
-
-		// apply sync packet
-		book.Process(orderbook.UnpackTimeKey(key), buf)
-		LastProcessedKey := []byte(string(key))
-
-		// walk and fill book until startKey
-		for key, buf = c.Next(); key != nil; key, buf = c.Next() {
-			if bytes.Compare(key, startKey) < 0 {
-				startKey = key
-				book.Process(orderbook.UnpackTimeKey(key), buf)
-				LastProcessedKey = []byte(string(key))
-			} else {
-				break
-			}
+	c, err := g.DB.Cursor(g.ProductID)
+	if err != nil {
+		return store.Key{Time: from}, book, fmt.Errorf("FetchBook %s %s", g.ProductID, err)
+	}
+	defer c.Close()
+
+	first := true
+	event, ok := c.Seek(from)
+	// Seek lands at or after from, a sync after from is too late
+	for ; !ok || event.Time.After(from) || !orderbook.IsSyncPacket(event.Data); event, ok = c.Prev() {
+		if first == false && !ok {
+			return store.Key{Time: from}, book, errors.New(fmt.Sprintf("FetchBook %s no sync key found", g.ProductID))
 		}
-		startKey = LastProcessedKey
+		first = false
+	}
 
-		return nil
-	})
+	// apply sync packet
+	if err := book.Process(event.Time, event.Data); err != nil {
+		return store.Key{Time: from}, book, fmt.Errorf("FetchBook %s corrupt sync packet at %s: %s", g.ProductID, event.Time, err)
+	}
+	lastProcessed := event.Key()
 
-	if err == nil {
-		fmt.Println(g.ProductID, "FetchBook", "found start", orderbook.UnpackTimeKey(startKey))
+	// walk and fill book until from
+	for event, ok = c.Next(); ok && event.Time.Before(from); event, ok = c.Next() {
+		g.process(book, event.Time, event.Data)
+		lastProcessed = event.Key()
 	}
+
+	fmt.Println(g.ProductID, "FetchBook", "found start", lastProcessed.Time)
 	book.ResetStats()
 
-	return orderbook.UnpackTimeKey(startKey), book, err
+	return lastProcessed, book, nil
 }
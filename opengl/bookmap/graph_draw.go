@@ -5,6 +5,7 @@ import (
 	"image/color"
 	"math"
 
+	"github.com/lian/gdax-bookmap/orderbook/analytics"
 	font "github.com/lian/gonky/font/terminus"
 	"github.com/llgcode/draw2d/draw2dimg"
 	"github.com/llgcode/draw2d/draw2dkit"
@@ -224,3 +225,121 @@ func (g *Graph) DrawTimeline(gc *draw2dimg.GraphicContext, image *image.RGBA, x,
 		}
 	}
 }
+
+// DrawIndicator draws series in the strip of height starting at top, one
+// value per timeslot like the heatmap above it.
+func (g *Graph) DrawIndicator(gc *draw2dimg.GraphicContext, x, top, height float64, series *analytics.Series) {
+	gc.SetFillColor(g.Bg1)
+	draw2dkit.Rectangle(gc, 0, top, float64(g.Width), top+height)
+	gc.Fill()
+
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(g.Fg1)
+	gc.MoveTo(0, top)
+	gc.LineTo(float64(g.Width), top)
+	gc.Stroke()
+
+	span := series.Max - series.Min
+	if span == 0 {
+		return
+	}
+	// keep a pixel of padding to the strip border
+	scale := (height - 2) / span
+	valueY := func(v float64) float64 {
+		return top + 1 + (series.Max-v)*scale
+	}
+	zero := valueY(0)
+
+	for idx := len(series.Values) - 1; idx > 0; idx-- {
+		x -= float64(g.SlotWidth)
+		if x < 0 {
+			break
+		}
+		if !series.Valid[idx] {
+			continue
+		}
+
+		v := series.Values[idx]
+		if series.Indicator.Centered {
+			y := valueY(v)
+			if v >= 0 {
+				gc.SetFillColor(g.Green)
+				draw2dkit.Rectangle(gc, x, y, x+float64(g.SlotWidth), zero)
+			} else {
+				gc.SetFillColor(g.Red)
+				draw2dkit.Rectangle(gc, x, zero, x+float64(g.SlotWidth), y)
+			}
+			gc.Fill()
+		} else {
+			y := valueY(v)
+			gc.SetFillColor(g.Fg1)
+			draw2dkit.Rectangle(gc, x, y, x+float64(g.SlotWidth), y+2)
+			gc.Fill()
+		}
+	}
+}
+
+// DrawTradeFlow draws the traded volume of every timeslot as a histogram,
+// sells below buys, and the cumulative volume delta as a line over it.
+func (g *Graph) DrawTradeFlow(gc *draw2dimg.GraphicContext, x, top, height float64) {
+	gc.SetFillColor(g.Bg1)
+	draw2dkit.Rectangle(gc, 0, top, float64(g.Width), top+height)
+	gc.Fill()
+
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(g.Fg1)
+	gc.MoveTo(0, top)
+	gc.LineTo(float64(g.Width), top)
+	gc.Stroke()
+
+	bottom := top + height
+	if maxVolume := g.MaxTradeVolume(); maxVolume > 0 {
+		scale := (height - 2) / maxVolume
+		xx := x
+		for idx := len(g.Timeslots) - 1; idx > 0; idx-- {
+			xx -= float64(g.SlotWidth)
+			if xx < 0 {
+				break
+			}
+			flow := g.Timeslots[idx].Flow
+			sell := flow.SellVolume * scale
+			buy := flow.BuyVolume * scale
+			if sell > 0 {
+				gc.SetFillColor(g.Red)
+				draw2dkit.Rectangle(gc, xx, bottom-sell, xx+float64(g.SlotWidth), bottom)
+				gc.Fill()
+			}
+			if buy > 0 {
+				gc.SetFillColor(g.Green)
+				draw2dkit.Rectangle(gc, xx, bottom-sell-buy, xx+float64(g.SlotWidth), bottom-sell)
+				gc.Fill()
+			}
+		}
+	}
+
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, slot := range g.Timeslots {
+		low, high = math.Min(low, slot.CVD), math.Max(high, slot.CVD)
+	}
+	if !(high > low) {
+		return
+	}
+	scale := (height - 2) / (high - low)
+
+	gc.SetLineWidth(2.0)
+	gc.SetStrokeColor(g.Fg1)
+	started := false
+	for idx := len(g.Timeslots) - 1; idx > 0; idx-- {
+		x -= float64(g.SlotWidth)
+		if x < 0 {
+			break
+		}
+		y := top + 1 + (high-g.Timeslots[idx].CVD)*scale
+		if !started {
+			gc.MoveTo(x+float64(g.SlotWidth), y)
+			started = true
+		}
+		gc.LineTo(x, y)
+	}
+	gc.Stroke()
+}
@@ -0,0 +1,151 @@
+package bookmap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// the guessed trade and the first diff have no exchange timestamp, the
+// records after them are received 1.5s after the exchange sent them
+const mixedClocks = `{"platform":"Binance","product":"BTC-USDT","quote_increment":"0.01","base_increment":"0.00000001","encoder":"common"}
+{"t":"2026-01-02T10:00:00Z","op":"sync","seq":100,"bids":[["100.00","1"]],"asks":[["101.00","1"]]}
+{"t":"2026-01-02T10:00:01Z","op":"trade","guessed":true,"price":"101.00","size":"0.5"}
+{"t":"2026-01-02T10:00:01.500Z","op":"diff","seq":101,"bids":[["99.00","3"]]}
+{"t":"2026-01-02T10:00:02Z","op":"diff","seq":102,"bids":[["100.00","2"]],"exchange_time":"2026-01-02T10:00:00.500Z"}
+{"t":"2026-01-02T10:00:02.200Z","op":"trade","buy":true,"price":"101.00","size":"0.25","trade_id":1,"exchange_time":"2026-01-02T10:00:00.700Z"}
+{"t":"2026-01-02T10:00:05Z","op":"diff","seq":103,"asks":[["101.00","0.25"]],"exchange_time":"2026-01-02T10:00:03.500Z"}
+{"t":"2026-01-02T10:00:06Z","op":"check"}
+`
+
+func mixedClocksStore(t *testing.T) (*fixture, []store.Event, *fixtureCheck, store.Store) {
+	f, err := parseFixture("mixed.jsonl", strings.NewReader(mixedClocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, checks, err := f.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	if err := st.Append(f.Bucket(), records...); err != nil {
+		t.Fatal(err)
+	}
+	return f, records, checks[0], st
+}
+
+func TestEventTimeMixedRecords(t *testing.T) {
+	f, records, _, st := mixedClocksStore(t)
+	defer st.Close()
+
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339Nano, "2026-01-02T"+s+"Z")
+		return t
+	}
+	want := map[util.TimeSource][]time.Time{
+		util.LocalTime: {at("10:00:00"), at("10:00:01"), at("10:00:01.5"), at("10:00:02"), at("10:00:02.2"), at("10:00:05")},
+		// records without a timestamp stay at their receive time
+		util.ExchangeTime: {at("10:00:00"), at("10:00:01"), at("10:00:01.5"), at("10:00:00.5"), at("10:00:00.7"), at("10:00:03.5")},
+	}
+	for source, times := range want {
+		got := []time.Time{}
+		err := util.ReplayBucket(st, f.Bucket(), records[0].Time, time.Time{}, orderbook.New(f.Bucket()), func(t time.Time, pkt *orderbook.Packet, err error) error {
+			if err == nil {
+				got = append(got, source.EventTime(t, pkt))
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(times) {
+			t.Fatalf("%s: %d records, want %d", source, len(got), len(times))
+		}
+		for i := range times {
+			if !got[i].Equal(times[i]) {
+				t.Fatalf("%s: record %d at %s, want %s", source, i, got[i], times[i])
+			}
+		}
+	}
+}
+
+func TestGraphExchangeClockMixedRecords(t *testing.T) {
+	f, records, check, st := mixedClocksStore(t)
+	defer st.Close()
+
+	g := NewGraph(st, f.Bucket(), 10, 100, 1, 1)
+	g.TimeSource = util.ExchangeTime
+	g.NoTimeout = true
+	quiet(func() {
+		if !g.SetStart(records[0].Time) || !g.SetEnd(check.Time) {
+			t.Fatalf("graph did not start")
+		}
+	})
+
+	// every record is applied once, whatever slot its timestamp picks
+	levels := []string{}
+	for _, line := range snapshot(g.Book) {
+		if !strings.HasPrefix(line, "  trade") {
+			levels = append(levels, line)
+		}
+	}
+	if d := difference(check.lines()[:len(check.Bids)+len(check.Asks)], levels); d != "" {
+		t.Fatalf("book differs from the recorder's book\n%s", d)
+	}
+
+	var flow orderbook.TradeFlow
+	for _, slot := range g.Timeslots {
+		flow.BuyVolume += slot.Flow.BuyVolume
+		flow.BuyCount += slot.Flow.BuyCount
+		flow.SellCount += slot.Flow.SellCount
+	}
+	if flow.BuyCount != 2 || flow.SellCount != 0 || flow.BuyVolume != 0.75 {
+		t.Fatalf("slots hold %+v, want both trades", flow)
+	}
+	// the guessed trade has no timestamp and opens the slot of its receive
+	// time, the trade reported earlier by the exchange is kept in it
+	if slot := g.Timeslots[0]; slot.Flow.BuyCount != 2 || records[1].Time.Before(slot.From) || records[1].Time.After(slot.To) {
+		t.Fatalf("first slot %s-%s holds %+v", slot.From, slot.To, slot.Flow)
+	}
+}
+
+func TestRollupTier(t *testing.T) {
+	bucket := "Binance-BTC-USDT"
+	st := store.NewMemory()
+	defer st.Close()
+	for _, tier := range []int{1, 8, 64} {
+		if err := st.Append(util.RollupBucket(bucket, tier), store.Event{Time: time.Unix(1550671200, 0), Data: []byte{0}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		steps  int
+		source util.TimeSource
+		tier   int
+	}{
+		{1, util.LocalTime, 0},
+		{3, util.LocalTime, 1},
+		{8, util.LocalTime, 8},
+		{16, util.LocalTime, 8},
+		{64, util.LocalTime, 64},
+		{128, util.LocalTime, 64},
+		{64, util.ExchangeTime, 0},
+	}
+	for _, c := range cases {
+		g := &Graph{DB: st, ProductID: bucket, SlotSteps: c.steps, TimeSource: c.source}
+		if tier := g.rollupTier(); tier != c.tier {
+			t.Fatalf("%d steps on the %s clock: tier %d, want %d", c.steps, c.source, tier, c.tier)
+		}
+	}
+
+	// without rollups every packet is replayed
+	g := &Graph{DB: store.NewMemory(), ProductID: bucket, SlotSteps: 8}
+	if tier := g.rollupTier(); tier != 0 {
+		t.Fatalf("tier %d without rollups", tier)
+	}
+}
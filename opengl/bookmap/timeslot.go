@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/analytics"
 )
 
 type TimeSlotRow struct {
@@ -30,6 +31,14 @@ type TimeSlot struct {
 	AskTradeSize float64
 	Stats        *orderbook.BookMapStatsCopy
 	Cleared      bool
+	Rollup       bool // Stats come from a rollup bucket
+	// trades of the slot and the cumulative volume delta of the graph up to
+	// its end, set by ProcessTimeslots
+	Flow orderbook.TradeFlow
+	CVD  float64
+	// indicators of Stats, recomputed when Stats is replaced
+	analytics      analytics.Snapshot
+	analyticsStats *orderbook.BookMapStatsCopy
 }
 
 func NewTimeSlot(from time.Time, to time.Time) *TimeSlot {
@@ -46,6 +55,18 @@ func (s *TimeSlot) noStats() bool {
 	return s.Stats == nil
 }
 
+// Analytics returns the indicators of the slot stats, nil without stats.
+func (s *TimeSlot) Analytics(cfg analytics.Config) *analytics.Snapshot {
+	if s.Stats == nil {
+		return nil
+	}
+	if s.analyticsStats != s.Stats {
+		s.analytics = analytics.Compute(s.Stats, cfg)
+		s.analyticsStats = s.Stats
+	}
+	return &s.analytics
+}
+
 func (s *TimeSlot) isEmpty() bool {
 	for _, row := range s.Rows {
 		if row.Size > 0 {
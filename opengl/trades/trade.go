@@ -64,14 +64,14 @@ func (s *Trades) Render() {
 	if s.bookmap.Graph == nil {
 		return
 	}
-	book := s.bookmap.Graph.Book.Book
+	book := s.bookmap.Graph.Book
 
 	sizePadding := font.Width * 15
 	pricePadding := sizePadding + (font.Width * 12)
 	timePadding := pricePadding + (font.Width * 12)
 	lineHeight := font.Height + 2
 
-	font.DrawString(data, 10, 5, fmt.Sprintf("%s  %.2f", book.ID, book.LastPrice()), fg1)
+	font.DrawString(data, 10, 5, fmt.Sprintf("%s  %s", book.ID, s.ProductInfo.FormatFloat(book.LastPrice())), fg1)
 
 	limit := (int(s.Texture.Height) / lineHeight) - 3
 
@@ -96,11 +96,12 @@ func (s *Trades) Render() {
 			fg = green
 		}
 
-		size := fmt.Sprintf("%.8f", trade.Quantity)
+		size := book.SizeScale.Format(trade.Quantity)
 		cx := x + (sizePadding - (len(size) * font.Width))
 		font.DrawString(data, cx, y, size, fg1)
 
-		price := s.ProductInfo.FormatFloat(trade.Price)
+		// prices are shown with the product precision like in the bookmap
+		price := s.ProductInfo.FormatFloat(book.PriceScale.Float(trade.Price))
 		cx = x + (pricePadding - (len(price) * font.Width))
 		font.DrawString(data, cx, y, price, fg)
 
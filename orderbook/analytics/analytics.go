@@ -0,0 +1,219 @@
+// Package analytics derives depth and imbalance indicators from the stats of
+// an order book, one Snapshot per graph timeslot, and turns them into series
+// the bookmap draws as an indicator strip.
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// Band selects the levels of each side that count towards depth, imbalance
+// and the depth-weighted price: the Levels best levels when Levels is set,
+// otherwise the levels within Distance of the mid, a fraction (0.01 = 1%).
+type Band struct {
+	Distance float64
+	Levels   int
+}
+
+// ParseBand parses a distance from the mid like "1%" or a number of levels like "20".
+func ParseBand(value string) (Band, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 {
+			return Band{}, fmt.Errorf("invalid depth band %q, want a distance like 1%% or a number of levels", value)
+		}
+		return Band{Distance: percent / 100}, nil
+	}
+	levels, err := strconv.Atoi(value)
+	if err != nil || levels <= 0 {
+		return Band{}, fmt.Errorf("invalid depth band %q, want a distance like 1%% or a number of levels", value)
+	}
+	return Band{Levels: levels}, nil
+}
+
+func (b Band) String() string {
+	if b.Levels > 0 {
+		return strconv.Itoa(b.Levels) + " levels"
+	}
+	return strconv.FormatFloat(b.Distance*100, 'f', -1, 64) + "%"
+}
+
+// levels returns the levels of a side with a size that are within the band,
+// best first.
+func (b Band) levels(states []orderbook.OrderState, bid bool, mid float64) []orderbook.OrderState {
+	levels := make([]orderbook.OrderState, 0, len(states))
+	for _, state := range states {
+		if state.Size <= 0 {
+			continue
+		}
+		if b.Levels == 0 && math.Abs(state.Price-mid) > mid*b.Distance {
+			continue
+		}
+		levels = append(levels, state)
+	}
+	if b.Levels > 0 && len(levels) > b.Levels {
+		sort.Slice(levels, func(i, j int) bool {
+			if bid {
+				return levels[i].Price > levels[j].Price
+			}
+			return levels[i].Price < levels[j].Price
+		})
+		levels = levels[:b.Levels]
+	}
+	return levels
+}
+
+// Config is chosen by the caller, DefaultConfig is used by the bookmap
+// unless it is changed.
+type Config struct {
+	// Depth limits depth, imbalance and depth-weighted price
+	Depth Band
+	// LiquidityBps limits liquidity to levels within this many basis points of the mid
+	LiquidityBps float64
+}
+
+var DefaultConfig = Config{Depth: Band{Distance: 0.01}, LiquidityBps: 10}
+
+// Snapshot holds the indicators of one book state. All values are 0 for a
+// book without a bid or an ask.
+type Snapshot struct {
+	Mid float64
+	// base size of the levels within Config.Depth. The sizes are those of the
+	// stats, for graph slots the largest quantity of a level during the slot.
+	BidDepth float64
+	AskDepth float64
+	// (BidDepth - AskDepth) / (BidDepth + AskDepth), from -1 (asks only) to 1 (bids only)
+	Imbalance float64
+	// size weighted average price of the levels within Config.Depth
+	DepthWeightedPrice float64
+	// quote notional within LiquidityBps of the mid
+	BidLiquidity float64
+	AskLiquidity float64
+}
+
+// Compute calculates the indicators of stats, levels without size are ignored.
+func Compute(stats *orderbook.BookMapStatsCopy, cfg Config) Snapshot {
+	var s Snapshot
+	if stats == nil {
+		return s
+	}
+
+	bid, ask := math.Inf(-1), math.Inf(1)
+	for _, state := range stats.Bid {
+		if state.Size > 0 && state.Price > bid {
+			bid = state.Price
+		}
+	}
+	for _, state := range stats.Ask {
+		if state.Size > 0 && state.Price < ask {
+			ask = state.Price
+		}
+	}
+	if math.IsInf(bid, 0) || math.IsInf(ask, 0) {
+		return s
+	}
+	s.Mid = (bid + ask) / 2
+
+	var weighted float64
+	for _, state := range cfg.Depth.levels(stats.Bid, true, s.Mid) {
+		s.BidDepth += state.Size
+		weighted += state.Price * state.Size
+	}
+	for _, state := range cfg.Depth.levels(stats.Ask, false, s.Mid) {
+		s.AskDepth += state.Size
+		weighted += state.Price * state.Size
+	}
+
+	liquidity := s.Mid * cfg.LiquidityBps / 10000
+	for _, state := range stats.Bid {
+		if state.Size > 0 && s.Mid-state.Price <= liquidity {
+			s.BidLiquidity += state.Price * state.Size
+		}
+	}
+	for _, state := range stats.Ask {
+		if state.Size > 0 && state.Price-s.Mid <= liquidity {
+			s.AskLiquidity += state.Price * state.Size
+		}
+	}
+
+	if depth := s.BidDepth + s.AskDepth; depth > 0 {
+		s.Imbalance = (s.BidDepth - s.AskDepth) / depth
+		s.DepthWeightedPrice = weighted / depth
+	}
+	return s
+}
+
+// ComputeBook calculates the indicators of the current levels of book.
+func ComputeBook(book *orderbook.Book, cfg Config) Snapshot {
+	return Compute(book.StateAsStats(), cfg)
+}
+
+// Indicator is a Snapshot value that can be drawn as a series.
+type Indicator struct {
+	Name  string
+	Value func(s *Snapshot) float64
+	// Centered series are drawn around a zero line
+	Centered bool
+}
+
+var Indicators = []Indicator{
+	{Name: "imbalance", Value: func(s *Snapshot) float64 { return s.Imbalance }, Centered: true},
+	{Name: "bid depth", Value: func(s *Snapshot) float64 { return s.BidDepth }},
+	{Name: "ask depth", Value: func(s *Snapshot) float64 { return s.AskDepth }},
+	{Name: "depth delta", Value: func(s *Snapshot) float64 { return s.BidDepth - s.AskDepth }, Centered: true},
+	{Name: "depth weighted price", Value: func(s *Snapshot) float64 { return s.DepthWeightedPrice }},
+	{Name: "liquidity", Value: func(s *Snapshot) float64 { return s.BidLiquidity + s.AskLiquidity }},
+}
+
+// Series is an Indicator over a row of snapshots, missing snapshots and
+// empty books have no value.
+type Series struct {
+	Indicator Indicator
+	Values    []float64
+	Valid     []bool
+	Min       float64
+	Max       float64
+}
+
+func NewSeries(indicator Indicator, snapshots []*Snapshot) *Series {
+	s := &Series{
+		Indicator: indicator,
+		Values:    make([]float64, len(snapshots)),
+		Valid:     make([]bool, len(snapshots)),
+		Min:       math.Inf(1),
+		Max:       math.Inf(-1),
+	}
+	for i, snapshot := range snapshots {
+		if snapshot == nil || snapshot.Mid == 0 {
+			continue
+		}
+		v := indicator.Value(snapshot)
+		s.Values[i], s.Valid[i] = v, true
+		s.Min, s.Max = math.Min(s.Min, v), math.Max(s.Max, v)
+	}
+	if math.IsInf(s.Min, 0) {
+		s.Min, s.Max = 0, 0
+	}
+	if indicator.Centered {
+		// symmetric around zero
+		m := math.Max(math.Abs(s.Min), math.Abs(s.Max))
+		s.Min, s.Max = -m, m
+	}
+	return s
+}
+
+// Last returns the newest value of the series.
+func (s *Series) Last() (float64, bool) {
+	for i := len(s.Values) - 1; i >= 0; i-- {
+		if s.Valid[i] {
+			return s.Values[i], true
+		}
+	}
+	return 0, false
+}
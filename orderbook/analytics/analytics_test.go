@@ -0,0 +1,48 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+func TestComputeBand(t *testing.T) {
+	// mid 100, the bids and asks are 1, 2 and 5 away from it
+	stats := &orderbook.BookMapStatsCopy{
+		Bid: []orderbook.OrderState{{Price: 95, Size: 4}, {Price: 98, Size: 2}, {Price: 99, Size: 1}, {Price: 99.5, Size: 0}},
+		Ask: []orderbook.OrderState{{Price: 101, Size: 1}, {Price: 102, Size: 3}, {Price: 105, Size: 8}},
+	}
+	cases := []struct {
+		band     string
+		bid, ask float64
+	}{
+		{"1%", 1, 1},
+		{"2%", 3, 4},
+		{"10%", 7, 12},
+		{"1", 1, 1},
+		{"2", 3, 4},
+		{"50", 7, 12},
+	}
+	for _, c := range cases {
+		band, err := ParseBand(c.band)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := Compute(stats, Config{Depth: band})
+		if s.Mid != 100 {
+			t.Fatalf("%s: mid %f", c.band, s.Mid)
+		}
+		if s.BidDepth != c.bid || s.AskDepth != c.ask {
+			t.Fatalf("%s: depth %f/%f, want %f/%f", c.band, s.BidDepth, s.AskDepth, c.bid, c.ask)
+		}
+		if imbalance := (c.bid - c.ask) / (c.bid + c.ask); s.Imbalance != imbalance {
+			t.Fatalf("%s: imbalance %f, want %f", c.band, s.Imbalance, imbalance)
+		}
+	}
+
+	for _, value := range []string{"", "0", "-1", "0%", "x%", "1.5"} {
+		if _, err := ParseBand(value); err == nil {
+			t.Fatalf("ParseBand(%q) accepted", value)
+		}
+	}
+}
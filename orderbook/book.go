@@ -4,15 +4,16 @@ import (
 	"sort"
 	"time"
 
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 )
 
 type BookLevel struct {
-	Price       float64
-	Quantity    float64
-	MaxQuantity float64
+	Price       fixed.Value
+	Quantity    fixed.Value
+	MaxQuantity fixed.Value
 	OrderCount  int
-	TradeSize   float64
+	TradeSize   fixed.Value
 }
 
 type Side uint8
@@ -21,18 +22,32 @@ const BidSide Side = 0
 const AskSide Side = 1
 
 type Trade struct {
-	Price    float64
-	Quantity float64
+	Price    fixed.Value
+	Quantity fixed.Value
 	Time     time.Time
 	Side     Side
 }
 
+// BookLevelList is a side of the book sorted by ascending price.
 type BookLevelList []*BookLevel
 
 func (a BookLevelList) Len() int           { return len(a) }
 func (a BookLevelList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a BookLevelList) Less(i, j int) bool { return a[i].Price < a[j].Price }
 
+// search returns the index of price or where it would be inserted.
+func (a BookLevelList) search(price fixed.Value) int {
+	return sort.Search(len(a), func(i int) bool { return a[i].Price >= price })
+}
+
+func (a BookLevelList) insert(level *BookLevel) BookLevelList {
+	i := a.search(level.Price)
+	a = append(a, nil)
+	copy(a[i+1:], a[i:])
+	a[i] = level
+	return a
+}
+
 type Book struct {
 	ID          string
 	Name        string
@@ -42,92 +57,76 @@ type Book struct {
 	Sequence    uint64
 	Synced      bool
 	ProductInfo product_info.Info
+	// scales of the level prices and quantities, taken from the last sync
+	PriceScale fixed.Scale
+	SizeScale  fixed.Scale
+	// levels of Bid and Ask by price
+	bidIndex map[fixed.Value]*BookLevel
+	askIndex map[fixed.Value]*BookLevel
+	// trades since the last ResetStats
+	flow TradeFlow
 }
 
 func New(name string) *Book {
 	return &Book{
-		ID:     name,
-		Name:   name,
-		Bid:    []*BookLevel{},
-		Ask:    []*BookLevel{},
-		Trades: []*Trade{},
+		ID:         name,
+		Name:       name,
+		Bid:        []*BookLevel{},
+		Ask:        []*BookLevel{},
+		Trades:     []*Trade{},
+		PriceScale: fixed.LegacyScale,
+		SizeScale:  fixed.LegacyScale,
+		bidIndex:   map[fixed.Value]*BookLevel{},
+		askIndex:   map[fixed.Value]*BookLevel{},
 	}
 }
 
-func (b *Book) GetSide(price float64) uint8 {
-	for _, level := range b.Bid {
-		if level.Price == price {
-			return uint8(BidSide)
-		}
+func (b *Book) GetSide(price fixed.Value) uint8 {
+	if _, ok := b.bidIndex[price]; ok {
+		return uint8(BidSide)
 	}
-	for _, level := range b.Ask {
-		if level.Price == price {
-			return uint8(AskSide)
-		}
+	if _, ok := b.askIndex[price]; ok {
+		return uint8(AskSide)
 	}
 	return uint8(BidSide)
 }
 
-func (b *Book) UpdateBidLevel(t time.Time, price, quantity float64) {
-	var found bool
-
-	for i, current := range b.Bid {
-		if current.Price == price {
-			if quantity == 0 {
-				// remove
-				b.Bid[i].Quantity = 0
-			} else {
-				// update
-				b.Bid[i].Quantity = quantity
-				if quantity > b.Bid[i].MaxQuantity {
-					b.Bid[i].MaxQuantity = quantity
-				}
-				b.Bid[i].OrderCount += 1 // remove?
-			}
-			found = true
-			break
-		}
-	}
+func (b *Book) UpdateBidLevel(t time.Time, price, quantity fixed.Value) {
+	b.Bid = updateLevel(b.Bid, b.bidIndex, price, quantity)
+}
 
-	if !found && quantity != 0 {
-		// add
-		b.Bid = append(b.Bid, &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1})
-	}
+func (b *Book) UpdateAskLevel(t time.Time, price, quantity fixed.Value) {
+	b.Ask = updateLevel(b.Ask, b.askIndex, price, quantity)
 }
 
-func (b *Book) UpdateAskLevel(t time.Time, price, quantity float64) {
-	var found bool
-
-	for i, current := range b.Ask {
-		if current.Price == price {
-			if quantity == 0 {
-				// remove
-				b.Ask[i].Quantity = 0
-			} else {
-				// update
-				b.Ask[i].Quantity = quantity
-				if quantity > b.Ask[i].MaxQuantity {
-					b.Ask[i].MaxQuantity = quantity
-				}
-				b.Ask[i].OrderCount += 1 // remove?
+// updateLevel sets the quantity of a price level. Removed levels stay in
+// the list with a zero quantity until ResetStats, so their stats survive.
+func updateLevel(levels BookLevelList, index map[fixed.Value]*BookLevel, price, quantity fixed.Value) BookLevelList {
+	if level, ok := index[price]; ok {
+		if quantity == 0 {
+			// remove
+			level.Quantity = 0
+		} else {
+			// update
+			level.Quantity = quantity
+			if quantity > level.MaxQuantity {
+				level.MaxQuantity = quantity
 			}
-			found = true
-			break
+			level.OrderCount += 1 // remove?
 		}
+		return levels
 	}
 
-	if !found && quantity != 0 {
+	if quantity != 0 {
 		// add
-		b.Ask = append(b.Ask, &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1})
+		level := &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1}
+		index[price] = level
+		levels = levels.insert(level)
 	}
+	return levels
 }
 
-func (b *Book) Sort() {
-	sort.Sort(b.Bid)
-	sort.Sort(b.Ask)
-}
-
-func (b *Book) AddTrade(t time.Time, side uint8, price, quantity float64) {
+func (b *Book) AddTrade(t time.Time, side uint8, price, quantity fixed.Value) {
 	if len(b.Trades) >= 25 {
 		// remove and free first item
 		copy(b.Trades[0:], b.Trades[1:])
@@ -136,6 +135,7 @@ func (b *Book) AddTrade(t time.Time, side uint8, price, quantity float64) {
 	}
 	trade := &Trade{Price: price, Side: Side(side), Quantity: quantity, Time: t}
 	b.Trades = append(b.Trades, trade)
+	b.flow.AddTrade(trade.Side, b.PriceScale.Float(price), b.SizeScale.Float(quantity))
 
 	if trade.Side == BidSide {
 		if len(b.Bid) != 0 {
@@ -148,45 +148,112 @@ func (b *Book) AddTrade(t time.Time, side uint8, price, quantity float64) {
 	}
 }
 
+// TradeFlow returns the trades since the last ResetStats.
+func (b *Book) TradeFlow() TradeFlow {
+	return b.flow
+}
+
 func (b *Book) LastPrice() float64 {
 	var lastPrice float64
 	i := len(b.Trades)
 	if i > 0 {
-		lastPrice = b.Trades[i-1].Price
+		lastPrice = b.PriceScale.Float(b.Trades[i-1].Price)
 	} else {
-		lastPrice = b.CenterPrice()
+		lastPrice = b.Mid()
 	}
 	return lastPrice
 }
 
+// BestBid returns the highest bid level with a quantity, nil if there is none.
+func (b *Book) BestBid() *BookLevel {
+	for i := len(b.Bid) - 1; i >= 0; i-- {
+		if b.Bid[i].Quantity != 0 {
+			return b.Bid[i]
+		}
+	}
+	return nil
+}
+
+// BestAsk returns the lowest ask level with a quantity, nil if there is none.
+func (b *Book) BestAsk() *BookLevel {
+	for _, level := range b.Ask {
+		if level.Quantity != 0 {
+			return level
+		}
+	}
+	return nil
+}
+
+// Empty reports whether neither side has a level with a quantity.
 func (b *Book) Empty() bool {
-	return len(b.Ask) == 0 && len(b.Ask) == 0
+	return b.BestBid() == nil && b.BestAsk() == nil
+}
+
+// top returns the best bid and ask, ok is false unless both sides have one.
+func (b *Book) top() (bid, ask *BookLevel, ok bool) {
+	bid, ask = b.BestBid(), b.BestAsk()
+	return bid, ask, bid != nil && ask != nil
+}
+
+// Mid is the price halfway between the best bid and ask, 0 if a side is empty.
+func (b *Book) Mid() float64 {
+	bid, ask, ok := b.top()
+	if !ok {
+		return 0
+	}
+	return b.PriceScale.Float(bid.Price+ask.Price) / 2
 }
 
-func (b *Book) CenterPrice() float64 {
-	if b.Empty() {
-		return 0.0
+// Microprice is the mid weighted by the quantity on the opposite side, it
+// leans towards the side that is more likely to be taken out next.
+func (b *Book) Microprice() float64 {
+	bid, ask, ok := b.top()
+	if !ok {
+		return 0
 	}
-	spread := b.Spread()
-	return b.Ask[0].Price + (spread / 2)
+	bidPrice, askPrice := b.PriceScale.Float(bid.Price), b.PriceScale.Float(ask.Price)
+	bidQty, askQty := b.SizeScale.Float(bid.Quantity), b.SizeScale.Float(ask.Quantity)
+	return (bidPrice*askQty + askPrice*bidQty) / (bidQty + askQty)
 }
 
+// Spread is the best ask minus the best bid, 0 if a side is empty.
 func (b *Book) Spread() float64 {
-	var spread float64
-	if len(b.Bid) > 0 && len(b.Ask) > 0 {
-		spread = b.Ask[0].Price - b.Bid[len(b.Bid)-1].Price
+	bid, ask, ok := b.top()
+	if !ok {
+		return 0
 	}
-	return spread
+	return b.PriceScale.Float(ask.Price - bid.Price)
+}
+
+// SpreadBps is the spread in basis points of the mid.
+func (b *Book) SpreadBps() float64 {
+	mid := b.Mid()
+	if mid == 0 {
+		return 0
+	}
+	return b.Spread() / mid * 10000
 }
 
 func (b *Book) Clear() {
 	b.Bid = []*BookLevel{}
 	b.Ask = []*BookLevel{}
+	b.bidIndex = map[fixed.Value]*BookLevel{}
+	b.askIndex = map[fixed.Value]*BookLevel{}
 }
 
-func (b *Book) StateAsStats() *BookMapStatsCopy {
-	//b.Sort() // called by dbBook
+// reindex rebuilds the price index after the levels were replaced.
+func (b *Book) reindex() {
+	b.bidIndex = make(map[fixed.Value]*BookLevel, len(b.Bid))
+	for _, level := range b.Bid {
+		b.bidIndex[level.Price] = level
+	}
+	b.askIndex = make(map[fixed.Value]*BookLevel, len(b.Ask))
+	for _, level := range b.Ask {
+		b.askIndex[level.Price] = level
+	}
+}
 
+func (b *Book) StateAsStats() *BookMapStatsCopy {
 	stats := &BookMapStatsCopy{
 		Bid: make([]OrderState, 0, len(b.Bid)),
 		Ask: make([]OrderState, 0, len(b.Ask)),
@@ -196,7 +263,7 @@ func (b *Book) StateAsStats() *BookMapStatsCopy {
 		if level.Quantity == 0 {
 			continue
 		}
-		bid := OrderState{Price: level.Price, Size: level.Quantity, OrderCount: level.OrderCount}
+		bid := OrderState{Price: b.PriceScale.Float(level.Price), Size: b.SizeScale.Float(level.Quantity), OrderCount: level.OrderCount}
 		stats.Bid = append(stats.Bid, bid)
 	}
 
@@ -204,7 +271,7 @@ func (b *Book) StateAsStats() *BookMapStatsCopy {
 		if level.Quantity == 0 {
 			continue
 		}
-		ask := OrderState{Price: level.Price, Size: level.Quantity, OrderCount: level.OrderCount}
+		ask := OrderState{Price: b.PriceScale.Float(level.Price), Size: b.SizeScale.Float(level.Quantity), OrderCount: level.OrderCount}
 		stats.Ask = append(stats.Ask, ask)
 	}
 
@@ -233,6 +300,8 @@ func (b *Book) ResetStats() {
 
 	b.Bid = bid
 	b.Ask = ask
+	b.flow = TradeFlow{}
+	b.reindex()
 }
 
 func (b *Book) StatsCopy() *BookMapStatsCopy {
@@ -242,18 +311,29 @@ func (b *Book) StatsCopy() *BookMapStatsCopy {
 	}
 
 	for _, level := range b.Bid {
-		bid := OrderState{Price: level.Price, Size: level.MaxQuantity, OrderCount: level.OrderCount, TradeSize: level.TradeSize}
+		bid := b.orderState(level)
 		stats.Bid = append(stats.Bid, bid)
 	}
 
 	for _, level := range b.Ask {
-		ask := OrderState{Price: level.Price, Size: level.MaxQuantity, OrderCount: level.OrderCount, TradeSize: level.TradeSize}
+		ask := b.orderState(level)
 		stats.Ask = append(stats.Ask, ask)
 	}
 
+	stats.Flow = b.flow
 	return stats
 }
 
+func (b *Book) orderState(level *BookLevel) OrderState {
+	return OrderState{
+		Price:      b.PriceScale.Float(level.Price),
+		Size:       b.SizeScale.Float(level.MaxQuantity),
+		OrderCount: level.OrderCount,
+		TradeSize:  b.SizeScale.Float(level.TradeSize),
+	}
+}
+
+// OrderState is a level in float64 for the graphs and stats.
 type OrderState struct {
 	Price      float64
 	Size       float64
@@ -262,6 +342,7 @@ type OrderState struct {
 }
 
 type BookMapStatsCopy struct {
-	Bid []OrderState
-	Ask []OrderState
+	Bid  []OrderState
+	Ask  []OrderState
+	Flow TradeFlow
 }
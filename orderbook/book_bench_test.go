@@ -0,0 +1,200 @@
+package orderbook
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// replayBook is the part of Book used by the replay of the graph.
+type replayBook interface {
+	Clear()
+	UpdateBidLevel(t time.Time, price, quantity fixed.Value)
+	UpdateAskLevel(t time.Time, price, quantity fixed.Value)
+	AddTrade(t time.Time, side uint8, price, quantity fixed.Value)
+	ResetStats()
+}
+
+// recording is a Binance BTC-USDT feed with a book of 500 levels per side,
+// see the book benchmarks of the README for how it is rebuilt.
+const recording = "Binance-BTC-USDT"
+
+// loadRecording returns the packets of the recording in testdata/binance.
+func loadRecording(tb testing.TB) []Record {
+	tb.Helper()
+	st, err := store.OpenSegment(filepath.Join("testdata", "binance"), true)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer st.Close()
+	records, err := ReadRecords(st, recording)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return records
+}
+
+// replay applies records like the graph does, ResetStats is called every
+// resetEvery records.
+func replay(book replayBook, records []Record, resetEvery int) {
+	for i, record := range records {
+		if resetEvery > 0 && i%resetEvery == 0 {
+			book.ResetStats()
+		}
+		applyRecord(book, record)
+	}
+}
+
+func applyRecord(book replayBook, record Record) {
+	pkt := record.Pkt
+	switch pkt.Type {
+	case SyncPacket, DiffPacket:
+		if pkt.Type == SyncPacket {
+			book.Clear()
+		}
+		for _, level := range pkt.Bid {
+			book.UpdateBidLevel(record.Time, level.Price, level.Size)
+		}
+		for _, level := range pkt.Ask {
+			book.UpdateAskLevel(record.Time, level.Price, level.Size)
+		}
+		if sorter, ok := book.(interface{ Sort() }); ok {
+			sorter.Sort()
+		}
+	case TradePacket:
+		book.AddTrade(record.Time, pkt.Side, pkt.Price, pkt.Size)
+	}
+}
+
+// compareLegacy returns an error for the first level of book that differs
+// from legacy.
+func compareLegacy(book *Book, legacy *legacyBook) error {
+	for _, side := range []struct {
+		name string
+		a, b BookLevelList
+	}{{"bid", book.Bid, legacy.Bid}, {"ask", book.Ask, legacy.Ask}} {
+		if len(side.a) != len(side.b) {
+			return fmt.Errorf("%s levels %d != %d", side.name, len(side.a), len(side.b))
+		}
+		for i := range side.a {
+			if *side.a[i] != *side.b[i] {
+				return fmt.Errorf("%s level %d %+v != %+v", side.name, i, *side.a[i], *side.b[i])
+			}
+		}
+	}
+	return nil
+}
+
+func TestBookMatchesLegacy(t *testing.T) {
+	now := time.Unix(1550671200, 0)
+	book, legacy := New("BTC-USD"), newLegacyBook()
+
+	steps := []struct {
+		name  string
+		apply func(b replayBook)
+	}{
+		{"sync", func(b replayBook) {
+			for price := fixed.Value(95); price < 100; price++ {
+				b.UpdateBidLevel(now, price, 10)
+				b.UpdateAskLevel(now, price+10, 10)
+			}
+		}},
+		{"insert below the lowest bid and above the highest ask", func(b replayBook) {
+			b.UpdateBidLevel(now, 90, 5)
+			b.UpdateAskLevel(now, 115, 5)
+		}},
+		{"insert above the best bid and below the best ask", func(b replayBook) {
+			b.UpdateBidLevel(now, 100, 7)
+			b.UpdateAskLevel(now, 104, 7)
+		}},
+		{"insert in the middle", func(b replayBook) {
+			b.UpdateBidLevel(now, 92, 3)
+			b.UpdateAskLevel(now, 112, 3)
+		}},
+		{"update", func(b replayBook) {
+			b.UpdateBidLevel(now, 97, 20)
+			b.UpdateAskLevel(now, 107, 2)
+		}},
+		{"trades", func(b replayBook) {
+			b.AddTrade(now, uint8(BidSide), 100, 1)
+			b.AddTrade(now, uint8(AskSide), 104, 2)
+		}},
+		{"remove both ends of each side", func(b replayBook) {
+			b.UpdateBidLevel(now, 90, 0)
+			b.UpdateBidLevel(now, 100, 0)
+			b.UpdateAskLevel(now, 104, 0)
+			b.UpdateAskLevel(now, 115, 0)
+		}},
+		{"remove an unknown level", func(b replayBook) {
+			b.UpdateBidLevel(now, 50, 0)
+			b.UpdateAskLevel(now, 150, 0)
+		}},
+		{"add a removed level again", func(b replayBook) {
+			b.UpdateBidLevel(now, 100, 4)
+			b.UpdateAskLevel(now, 115, 4)
+		}},
+		{"reset stats", func(b replayBook) {
+			b.ResetStats()
+		}},
+		{"remove after the reset", func(b replayBook) {
+			b.UpdateBidLevel(now, 100, 0)
+			b.UpdateAskLevel(now, 105, 0)
+		}},
+		{"insert at both ends after the reset", func(b replayBook) {
+			b.UpdateBidLevel(now, 80, 1)
+			b.UpdateBidLevel(now, 101, 1)
+			b.UpdateAskLevel(now, 102, 1)
+			b.UpdateAskLevel(now, 130, 1)
+		}},
+		{"clear", func(b replayBook) {
+			b.Clear()
+			b.UpdateAskLevel(now, 110, 1)
+		}},
+	}
+	for _, step := range steps {
+		step.apply(book)
+		step.apply(legacy)
+		legacy.Sort()
+		if err := compareLegacy(book, legacy); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+	}
+}
+
+func TestBookReplayMatchesLegacy(t *testing.T) {
+	records := loadRecording(t)
+	book, legacy := New("BTC-USD"), newLegacyBook()
+	for i, record := range records {
+		if i%100 == 0 {
+			book.ResetStats()
+			legacy.ResetStats()
+		}
+		applyRecord(book, record)
+		applyRecord(legacy, record)
+		if err := compareLegacy(book, legacy); err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+	}
+}
+
+func BenchmarkBookReplayOld(b *testing.B) {
+	records := loadRecording(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		replay(newLegacyBook(), records, 100)
+	}
+}
+
+func BenchmarkBookReplayNew(b *testing.B) {
+	records := loadRecording(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		replay(New("BTC-USD"), records, 100)
+	}
+}
@@ -0,0 +1,157 @@
+package orderbook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+// level is a price and quantity in cents and whole units, a zero quantity
+// removes the level but keeps it in the list until ResetStats.
+type level struct {
+	price, quantity fixed.Value
+}
+
+func TestBookTop(t *testing.T) {
+	now := time.Unix(1550671200, 0)
+	cases := []struct {
+		name     string
+		bid, ask []level // applied in order
+		empty    bool
+		bestBid  fixed.Value // 0 for none
+		bestAsk  fixed.Value
+		mid      float64
+		spread   float64
+		micro    float64
+	}{
+		{
+			name:  "empty book",
+			empty: true,
+		},
+		{
+			name:    "no asks",
+			bid:     []level{{9900, 1}, {10000, 2}},
+			bestBid: 10000,
+		},
+		{
+			name:    "no bids",
+			ask:     []level{{10100, 1}, {10200, 2}},
+			bestAsk: 10100,
+		},
+		{
+			name:    "both sides",
+			bid:     []level{{9900, 1}, {10000, 3}},
+			ask:     []level{{10100, 1}, {10200, 2}},
+			bestBid: 10000,
+			bestAsk: 10100,
+			mid:     100.5,
+			spread:  1,
+			micro:   (100*1 + 101*3) / 4.0,
+		},
+		{
+			name:    "zero quantity levels at the top are skipped",
+			bid:     []level{{9900, 1}, {10000, 3}, {10000, 0}, {9950, 2}, {9950, 0}},
+			ask:     []level{{10200, 1}, {10100, 1}, {10100, 0}, {10150, 2}, {10150, 0}},
+			bestBid: 9900,
+			bestAsk: 10200,
+			mid:     100.5,
+			spread:  3,
+			micro:   (99*1 + 102*1) / 2.0,
+		},
+		{
+			name:  "only zero quantity levels",
+			bid:   []level{{10000, 3}, {10000, 0}},
+			ask:   []level{{10100, 1}, {10100, 0}},
+			empty: true,
+		},
+		{
+			name:    "removed bids and a live ask",
+			bid:     []level{{10000, 3}, {10000, 0}},
+			ask:     []level{{10100, 1}},
+			bestAsk: 10100,
+		},
+		{
+			name:    "locked book",
+			bid:     []level{{10000, 1}},
+			ask:     []level{{10000, 3}},
+			bestBid: 10000,
+			bestAsk: 10000,
+			mid:     100,
+			spread:  0,
+			micro:   100,
+		},
+		{
+			name:    "crossed book",
+			bid:     []level{{10100, 1}},
+			ask:     []level{{10000, 1}},
+			bestBid: 10100,
+			bestAsk: 10000,
+			mid:     100.5,
+			spread:  -1,
+			micro:   100.5,
+		},
+	}
+
+	for _, c := range cases {
+		book := New("BTC-USD")
+		book.PriceScale, book.SizeScale = 2, 0
+		for _, l := range c.bid {
+			book.UpdateBidLevel(now, l.price, l.quantity)
+		}
+		for _, l := range c.ask {
+			book.UpdateAskLevel(now, l.price, l.quantity)
+		}
+
+		if empty := book.Empty(); empty != c.empty {
+			t.Fatalf("%s: Empty %v, want %v", c.name, empty, c.empty)
+		}
+		var bestBid, bestAsk fixed.Value
+		if bid := book.BestBid(); bid != nil {
+			bestBid = bid.Price
+		}
+		if ask := book.BestAsk(); ask != nil {
+			bestAsk = ask.Price
+		}
+		if bestBid != c.bestBid || bestAsk != c.bestAsk {
+			t.Fatalf("%s: best bid %d ask %d, want %d %d", c.name, bestBid, bestAsk, c.bestBid, c.bestAsk)
+		}
+		if mid := book.Mid(); mid != c.mid {
+			t.Fatalf("%s: Mid %f, want %f", c.name, mid, c.mid)
+		}
+		// without trades the last price is the mid
+		if last := book.LastPrice(); last != c.mid {
+			t.Fatalf("%s: LastPrice %f, want %f", c.name, last, c.mid)
+		}
+		if spread := book.Spread(); spread != c.spread {
+			t.Fatalf("%s: Spread %f, want %f", c.name, spread, c.spread)
+		}
+		if micro := book.Microprice(); micro != c.micro {
+			t.Fatalf("%s: Microprice %f, want %f", c.name, micro, c.micro)
+		}
+		if bps := book.SpreadBps(); c.mid != 0 && bps != c.spread/c.mid*10000 {
+			t.Fatalf("%s: SpreadBps %f", c.name, bps)
+		}
+	}
+}
+
+func TestBookZeroLevelsUntilResetStats(t *testing.T) {
+	now := time.Unix(1550671200, 0)
+	book := New("BTC-USD")
+	book.UpdateBidLevel(now, 100, 1)
+	book.UpdateBidLevel(now, 101, 1)
+	book.UpdateBidLevel(now, 101, 0)
+
+	if len(book.Bid) != 2 || book.BestBid().Price != 100 {
+		t.Fatalf("removed level should stay until ResetStats, bids %d best %d", len(book.Bid), book.BestBid().Price)
+	}
+	book.ResetStats()
+	if len(book.Bid) != 1 || book.BestBid().Price != 100 {
+		t.Fatalf("ResetStats kept %d bids", len(book.Bid))
+	}
+	// the index was rebuilt, the level can be added again
+	book.UpdateBidLevel(now, 101, 2)
+	if len(book.Bid) != 2 || book.BestBid().Price != 101 {
+		t.Fatalf("level added after ResetStats missing, bids %d", len(book.Bid))
+	}
+}
@@ -0,0 +1,170 @@
+// Package fixed implements the fixed-point prices and sizes of the order
+// books. A Value counts units of 10^-decimals of its Scale, so levels can be
+// matched with == without float rounding surprises.
+package fixed
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Value int64
+
+// ErrRange is returned for values that do not fit a Value at their scale,
+// above about 9.2e10 at LegacyScale.
+var ErrRange = errors.New("fixed: value out of range")
+
+// Scale is the number of decimals of a Value.
+type Scale uint8
+
+const MaxScale Scale = 12
+
+// LegacyScale is used for prices and sizes recorded as float64 and for
+// products without a known increment.
+const LegacyScale Scale = 8
+
+var pow10 [MaxScale + 1]int64
+
+func init() {
+	pow10[0] = 1
+	for i := 1; i < len(pow10); i++ {
+		pow10[i] = pow10[i-1] * 10
+	}
+}
+
+// ScaleOf returns the decimals needed to represent multiples of increment,
+// LegacyScale for a zero increment.
+func ScaleOf(increment float64) Scale {
+	if increment <= 0 {
+		return LegacyScale
+	}
+	s := strconv.FormatFloat(increment, 'f', -1, 64)
+	i := strings.IndexByte(s, '.')
+	if i == -1 {
+		return 0
+	}
+	if Scale(len(s)-i-1) > MaxScale {
+		return MaxScale
+	}
+	return Scale(len(s) - i - 1)
+}
+
+// Parse reads a decimal string like "0.03100000" exactly, digits beyond the
+// scale are rounded half away from zero. Values that do not fit return
+// ErrRange.
+func (s Scale) Parse(value string) (Value, error) {
+	if strings.ContainsAny(value, "eE") {
+		// out of float range is +-Inf or +-0, both handled by FromFloat
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("invalid decimal %q", value)
+		}
+		v, err := s.FromFloat(f)
+		if err != nil {
+			return 0, fmt.Errorf("decimal %q: %w", value, err)
+		}
+		return v, nil
+	}
+
+	str := value
+	neg := strings.HasPrefix(str, "-")
+	if neg || strings.HasPrefix(str, "+") {
+		str = str[1:]
+	}
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i != -1 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("invalid decimal %q", value)
+	}
+
+	var round bool
+	if len(fracPart) > int(s) {
+		round = fracPart[s] >= '5'
+		for _, c := range fracPart[s:] {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("invalid decimal %q", value)
+			}
+		}
+		fracPart = fracPart[:s]
+	}
+	fracPart += strings.Repeat("0", int(s)-len(fracPart))
+
+	digits := intPart + fracPart
+	if digits == "" {
+		digits = "0"
+	}
+	if strings.ContainsAny(digits, "+-") {
+		return 0, fmt.Errorf("invalid decimal %q", value)
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("decimal %q: %w", value, ErrRange)
+	} else if err != nil {
+		return 0, fmt.Errorf("invalid decimal %q", value)
+	}
+	if round {
+		if n == math.MaxInt64 {
+			return 0, fmt.Errorf("decimal %q: %w", value, ErrRange)
+		}
+		n += 1
+	}
+	if neg {
+		n = -n
+	}
+	return Value(n), nil
+}
+
+// FromFloat rounds f to the scale, NaN, infinities and values that do not
+// fit return ErrRange.
+func (s Scale) FromFloat(f float64) (Value, error) {
+	n := math.Round(f * float64(pow10[s]))
+	// float64(math.MaxInt64) rounds up to 2^63, which does not fit anymore
+	if math.IsNaN(n) || n >= math.MaxInt64 || n < math.MinInt64 {
+		return 0, ErrRange
+	}
+	return Value(n), nil
+}
+
+func (s Scale) Float(v Value) float64 {
+	return float64(v) / float64(pow10[s])
+}
+
+// Format renders v with exactly s decimals.
+func (s Scale) Format(v Value) string {
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	str := strconv.FormatInt(int64(v), 10)
+	if s > 0 {
+		if len(str) <= int(s) {
+			str = strings.Repeat("0", int(s)-len(str)+1) + str
+		}
+		str = str[:len(str)-int(s)] + "." + str[len(str)-int(s):]
+	}
+	if neg {
+		str = "-" + str
+	}
+	return str
+}
+
+// Rescale converts v from scale s to scale to, rounding half away from zero
+// when to has fewer decimals.
+func (s Scale) Rescale(v Value, to Scale) Value {
+	switch {
+	case to > s:
+		return v * Value(pow10[to-s])
+	case to < s:
+		div := Value(pow10[s-to])
+		if v < 0 {
+			return -((-v + div/2) / div)
+		}
+		return (v + div/2) / div
+	}
+	return v
+}
@@ -0,0 +1,165 @@
+package fixed
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name   string
+		scale  Scale
+		value  string
+		want   Value
+		format string
+		err    error
+	}{
+		{"integer", 2, "42", 4200, "42.00", nil},
+		{"exact decimals", 8, "0.03100000", 3100000, "0.03100000", nil},
+		{"fewer decimals", 8, "6543.2", 654320000000, "6543.20000000", nil},
+		{"no integer part", 3, ".5", 500, "0.500", nil},
+		{"no fraction", 3, "7.", 7000, "7.000", nil},
+		{"plus sign", 2, "+1.25", 125, "1.25", nil},
+		{"zero", 8, "0", 0, "0.00000000", nil},
+		{"negative", 2, "-1.25", -125, "-1.25", nil},
+		{"negative below one", 4, "-0.0001", -1, "-0.0001", nil},
+		{"too many decimals round down", 2, "1.234", 123, "1.23", nil},
+		{"too many decimals round up", 2, "1.235", 124, "1.24", nil},
+		{"too many decimals carry", 2, "9.999", 1000, "10.00", nil},
+		{"negative rounds away from zero", 2, "-1.235", -124, "-1.24", nil},
+		{"exponent", 8, "1e-8", 1, "0.00000001", nil},
+		{"exponent rounds", 2, "1.2345e2", 12345, "123.45", nil},
+		{"scale zero", 0, "12.5", 13, "13", nil},
+		{"max scale", MaxScale, "0.000000000001", 1, "0.000000000001", nil},
+		{"max scale rounds", MaxScale, "0.0000000000015", 2, "0.000000000002", nil},
+		{"largest at legacy scale", LegacyScale, "92233720368.54775807", math.MaxInt64, "92233720368.54775807", nil},
+		{"smallest at legacy scale", LegacyScale, "-92233720368.54775807", -math.MaxInt64, "-92233720368.54775807", nil},
+
+		{"overflow at legacy scale", LegacyScale, "92233720369", 0, "", ErrRange},
+		{"negative overflow", LegacyScale, "-92233720369", 0, "", ErrRange},
+		{"overflow by rounding", LegacyScale, "92233720368.547758075", 0, "", ErrRange},
+		{"overflow at max scale", MaxScale, "9223373", 0, "", ErrRange},
+		{"exponent overflow", LegacyScale, "1e11", 0, "", ErrRange},
+		{"exponent infinity", LegacyScale, "1e400", 0, "", ErrRange},
+		{"exponent underflow", LegacyScale, "1e-400", 0, "0.00000000", nil},
+
+		{"empty", 2, "", 0, "", errInvalid},
+		{"sign only", 2, "-", 0, "", errInvalid},
+		{"dot only", 2, ".", 0, "", errInvalid},
+		{"letters", 2, "abc", 0, "", errInvalid},
+		{"two dots", 2, "1.2.3", 0, "", errInvalid},
+		{"double sign", 2, "--1", 0, "", errInvalid},
+		{"sign in fraction", 2, "1.-5", 0, "", errInvalid},
+		{"letters beyond the scale", 2, "1.23x", 0, "", errInvalid},
+		{"bad exponent", 2, "1e", 0, "", errInvalid},
+	}
+	for _, c := range cases {
+		v, err := c.scale.Parse(c.value)
+		switch {
+		case c.err == errInvalid:
+			if err == nil || errors.Is(err, ErrRange) {
+				t.Fatalf("%s: Parse(%q) = %d, %v, want invalid decimal", c.name, c.value, v, err)
+			}
+			continue
+		case c.err != nil:
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: Parse(%q) = %d, %v, want %v", c.name, c.value, v, err, c.err)
+			}
+			continue
+		case err != nil:
+			t.Fatalf("%s: Parse(%q): %s", c.name, c.value, err)
+		}
+		if v != c.want {
+			t.Fatalf("%s: Parse(%q) = %d, want %d", c.name, c.value, v, c.want)
+		}
+		if s := c.scale.Format(v); s != c.format {
+			t.Fatalf("%s: Format(%d) = %q, want %q", c.name, v, s, c.format)
+		}
+		// what Format renders parses back to the same value
+		if back, err := c.scale.Parse(c.format); err != nil || back != v {
+			t.Fatalf("%s: Parse(%q) = %d, %v, want %d", c.name, c.format, back, err, v)
+		}
+	}
+}
+
+// errInvalid marks cases expecting a parse error other than ErrRange.
+var errInvalid = errors.New("invalid")
+
+func TestFromFloat(t *testing.T) {
+	cases := []struct {
+		name  string
+		scale Scale
+		f     float64
+		want  Value
+		err   bool
+	}{
+		{"price", 2, 6543.21, 654321, false},
+		{"rounds", 2, 0.125, 13, false},
+		{"negative rounds away from zero", 2, -0.125, -13, false},
+		{"float noise", 8, 0.1 + 0.2, 30000000, false},
+		{"zero", 8, 0, 0, false},
+		{"large at legacy scale", LegacyScale, 9.2e10, 9200000000000000000, false},
+		{"overflow at legacy scale", LegacyScale, 9.3e10, 0, true},
+		{"negative overflow", LegacyScale, -9.3e10, 0, true},
+		{"2^63 does not fit", 0, math.Exp2(63), 0, true},
+		{"-2^63 fits", 0, -math.Exp2(63), math.MinInt64, false},
+		{"infinity", 2, math.Inf(1), 0, true},
+		{"nan", 2, math.NaN(), 0, true},
+	}
+	for _, c := range cases {
+		v, err := c.scale.FromFloat(c.f)
+		if c.err {
+			if !errors.Is(err, ErrRange) {
+				t.Fatalf("%s: FromFloat(%v) = %d, %v, want ErrRange", c.name, c.f, v, err)
+			}
+			continue
+		}
+		if err != nil || v != c.want {
+			t.Fatalf("%s: FromFloat(%v) = %d, %v, want %d", c.name, c.f, v, err, c.want)
+		}
+	}
+}
+
+func TestScaleOf(t *testing.T) {
+	cases := []struct {
+		increment float64
+		want      Scale
+	}{
+		{0, LegacyScale},
+		{-1, LegacyScale},
+		{1, 0},
+		{10, 0},
+		{0.01, 2},
+		{0.00000001, 8},
+		{0.5, 1},
+		{1e-12, MaxScale},
+		{1e-15, MaxScale},
+	}
+	for _, c := range cases {
+		if s := ScaleOf(c.increment); s != c.want {
+			t.Fatalf("ScaleOf(%v) = %d, want %d", c.increment, s, c.want)
+		}
+	}
+}
+
+func TestRescale(t *testing.T) {
+	cases := []struct {
+		from, to Scale
+		v, want  Value
+	}{
+		{2, 8, 125, 125000000},
+		{8, 8, 125, 125},
+		{8, 2, 125000000, 125},
+		{8, 2, 125500000, 126},
+		{8, 2, 125499999, 125},
+		{8, 2, -125500000, -126},
+		{8, 0, 49999999, 0},
+		{0, MaxScale, 7, 7000000000000},
+	}
+	for _, c := range cases {
+		if v := c.from.Rescale(c.v, c.to); v != c.want {
+			t.Fatalf("Rescale(%d, %d -> %d) = %d, want %d", c.v, c.from, c.to, v, c.want)
+		}
+	}
+}
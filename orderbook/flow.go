@@ -0,0 +1,52 @@
+package orderbook
+
+// TradeFlow sums up the trades of a slot by aggressor side. Trades on the ask
+// side are buys, trades on the bid side are sells.
+type TradeFlow struct {
+	BuyVolume  float64
+	SellVolume float64
+	BuyCount   int
+	SellCount  int
+	// sum of price * size of all trades, for the VWAP
+	Notional float64
+}
+
+func (f *TradeFlow) AddTrade(side Side, price, size float64) {
+	if side == AskSide {
+		f.BuyVolume += size
+		f.BuyCount += 1
+	} else {
+		f.SellVolume += size
+		f.SellCount += 1
+	}
+	f.Notional += price * size
+}
+
+func (f *TradeFlow) Add(o TradeFlow) {
+	f.BuyVolume += o.BuyVolume
+	f.SellVolume += o.SellVolume
+	f.BuyCount += o.BuyCount
+	f.SellCount += o.SellCount
+	f.Notional += o.Notional
+}
+
+func (f TradeFlow) Volume() float64 {
+	return f.BuyVolume + f.SellVolume
+}
+
+// Delta is the buy volume minus the sell volume.
+func (f TradeFlow) Delta() float64 {
+	return f.BuyVolume - f.SellVolume
+}
+
+func (f TradeFlow) Count() int {
+	return f.BuyCount + f.SellCount
+}
+
+// VWAP is the volume weighted average trade price, 0 without trades.
+func (f TradeFlow) VWAP() float64 {
+	if volume := f.Volume(); volume > 0 {
+		return f.Notional / volume
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package orderbook
+
+// the linear scan book replaced by the price index of Book, kept to compare
+// against and to benchmark
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+type legacyBook struct {
+	Bid BookLevelList
+	Ask BookLevelList
+}
+
+func newLegacyBook() *legacyBook {
+	return &legacyBook{Bid: []*BookLevel{}, Ask: []*BookLevel{}}
+}
+
+func (b *legacyBook) Clear() {
+	b.Bid = []*BookLevel{}
+	b.Ask = []*BookLevel{}
+}
+
+func (b *legacyBook) UpdateBidLevel(t time.Time, price, quantity fixed.Value) {
+	b.Bid = legacyUpdateLevel(b.Bid, price, quantity)
+}
+
+func (b *legacyBook) UpdateAskLevel(t time.Time, price, quantity fixed.Value) {
+	b.Ask = legacyUpdateLevel(b.Ask, price, quantity)
+}
+
+func legacyUpdateLevel(levels BookLevelList, price, quantity fixed.Value) BookLevelList {
+	for _, current := range levels {
+		if current.Price == price {
+			if quantity == 0 {
+				current.Quantity = 0
+			} else {
+				current.Quantity = quantity
+				if quantity > current.MaxQuantity {
+					current.MaxQuantity = quantity
+				}
+				current.OrderCount += 1
+			}
+			return levels
+		}
+	}
+	if quantity != 0 {
+		levels = append(levels, &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1})
+	}
+	return levels
+}
+
+func (b *legacyBook) Sort() {
+	sort.Sort(b.Bid)
+	sort.Sort(b.Ask)
+}
+
+func (b *legacyBook) AddTrade(t time.Time, side uint8, price, quantity fixed.Value) {
+	if Side(side) == BidSide {
+		if len(b.Bid) != 0 {
+			b.Bid[0].TradeSize += quantity
+		}
+	} else {
+		if len(b.Ask) != 0 {
+			b.Ask[0].TradeSize += quantity
+		}
+	}
+}
+
+func (b *legacyBook) ResetStats() {
+	b.Bid = legacyResetStats(b.Bid)
+	b.Ask = legacyResetStats(b.Ask)
+}
+
+func legacyResetStats(levels BookLevelList) BookLevelList {
+	kept := make([]*BookLevel, 0, len(levels))
+	for _, level := range levels {
+		level.MaxQuantity = level.Quantity
+		level.TradeSize = 0
+		if level.Quantity != 0 {
+			kept = append(kept, level)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,405 @@
+package orderbook
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+// Stored records are wrapped in a versioned envelope:
+//
+//	magic   uint8  (0xfe, never a valid v0 packet type)
+//	version uint8
+//	type    uint8
+//	length  uint32 payload length
+//	payload [length]byte
+//	crc     uint32 IEEE checksum of everything before it
+//
+// Records written before the envelope existed (v0) are the packet type
+// followed directly by the payload. v0 to v2 payloads hold float64 prices and
+// sizes, v3 and later payloads start with the price and size scale (uint8
+// each) followed by int64 fixed-point values. Even versions are the flate
+// compressed payload of the version before them.
+//
+// v5 and later trade payloads end with the exchange's trade details:
+//
+//	id       uint64 exchange trade ID, 0 if unknown
+//	time     int64  exchange timestamp in unix nanoseconds, 0 if unknown
+//	flags    uint8  TradeAggressor if the side comes from the exchange
+//
+// Older trades have a side guessed from the resting levels. v7 and later
+// sync and diff payloads end with the exchange timestamp (int64 unix
+// nanoseconds, 0 if unknown) of the last update they contain. Records are
+// keyed by the local receive time.
+const (
+	PacketMagic                  uint8 = 0xfe
+	PacketVersionFloat           uint8 = 1
+	PacketVersionFloatCompressed uint8 = 2
+	PacketVersionFixed           uint8 = 3
+	PacketVersionFixedCompressed uint8 = 4
+	// trades with the exchange's trade details
+	PacketVersionTradeInfo           uint8 = 5
+	PacketVersionTradeInfoCompressed uint8 = 6
+	// sync and diff packets with the exchange timestamp
+	PacketVersionExchangeTime           uint8 = 7
+	PacketVersionExchangeTimeCompressed uint8 = 8
+
+	// PacketVersion is written by PackPacket
+	PacketVersion = PacketVersionExchangeTime
+
+	maxPacketVersion = PacketVersionExchangeTimeCompressed
+
+	packetHeaderSize = 7
+	packetCRCSize    = 4
+
+	// PacketOverhead is the envelope size around a payload
+	PacketOverhead = packetHeaderSize + packetCRCSize
+
+	// TradeAggressor flags trades whose side is the exchange's taker side
+	TradeAggressor uint8 = 1
+)
+
+var (
+	ErrTruncatedPacket = errors.New("truncated packet")
+	ErrPacketChecksum  = errors.New("packet checksum mismatch")
+)
+
+type PacketLevel struct {
+	Price fixed.Value
+	Size  fixed.Value
+}
+
+// Packet is a decoded Sync, Diff or Trade record. Float records are decoded
+// with fixed.LegacyScale.
+type Packet struct {
+	Version    uint8
+	Type       uint8
+	Sequence   uint64
+	First      uint64
+	Last       uint64
+	Bid        []PacketLevel
+	Ask        []PacketLevel
+	Side       uint8
+	Price      fixed.Value
+	Size       fixed.Value
+	PriceScale fixed.Scale
+	SizeScale  fixed.Scale
+	// exchange timestamp, zero for venues and records without one
+	ExchangeTime time.Time
+	// trade details, zero for trades recorded without them
+	TradeID   uint64
+	Aggressor bool
+}
+
+func exchangeNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// WriteExchangeTime appends the exchange timestamp to a fixed-point sync or
+// diff payload.
+func WriteExchangeTime(buf *bytes.Buffer, exchangeTime time.Time) {
+	binary.Write(buf, binary.LittleEndian, exchangeNano(exchangeTime))
+}
+
+// WriteTradeInfo appends the trade details to a fixed-point trade payload.
+func WriteTradeInfo(buf *bytes.Buffer, id uint64, exchangeTime time.Time, aggressor bool) {
+	nano := exchangeNano(exchangeTime)
+	var flags uint8
+	if aggressor {
+		flags |= TradeAggressor
+	}
+	binary.Write(buf, binary.LittleEndian, id)
+	binary.Write(buf, binary.LittleEndian, nano)
+	binary.Write(buf, binary.LittleEndian, flags)
+}
+
+// PackPacket wraps a fixed-point packet payload into the current envelope version.
+func PackPacket(packetType uint8, payload []byte) []byte {
+	return packPacket(PacketVersion, packetType, payload)
+}
+
+func packPacket(version, packetType uint8, payload []byte) []byte {
+	buf := make([]byte, packetHeaderSize, packetHeaderSize+len(payload)+packetCRCSize)
+	buf[0] = PacketMagic
+	buf[1] = version
+	buf[2] = packetType
+	binary.LittleEndian.PutUint32(buf[3:], uint32(len(payload)))
+	buf = append(buf, payload...)
+	crc := make([]byte, packetCRCSize)
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(buf))
+	return append(buf, crc...)
+}
+
+// UnpackPacket returns the envelope version, packet type and payload of a record.
+func UnpackPacket(data []byte) (uint8, uint8, []byte, error) {
+	if len(data) == 0 {
+		return 0, 0, nil, ErrTruncatedPacket
+	}
+
+	if data[0] != PacketMagic {
+		// v0 record without envelope
+		return 0, data[0], data[1:], nil
+	}
+
+	if len(data) < packetHeaderSize+packetCRCSize {
+		return 0, 0, nil, ErrTruncatedPacket
+	}
+
+	version, packetType := data[1], data[2]
+	if version < PacketVersionFloat || version > maxPacketVersion {
+		return version, packetType, nil, fmt.Errorf("unknown packet version %d", version)
+	}
+
+	length := int(binary.LittleEndian.Uint32(data[3:]))
+	end := packetHeaderSize + length
+	if len(data) < end+packetCRCSize {
+		return version, packetType, nil, ErrTruncatedPacket
+	}
+	if len(data) > end+packetCRCSize {
+		return version, packetType, nil, fmt.Errorf("packet has %d trailing bytes", len(data)-end-packetCRCSize)
+	}
+
+	if crc32.ChecksumIEEE(data[:end]) != binary.LittleEndian.Uint32(data[end:]) {
+		return version, packetType, nil, ErrPacketChecksum
+	}
+
+	payload := data[packetHeaderSize:end]
+	if CompressedVersion(version) {
+		var err error
+		if payload, err = inflate(payload); err != nil {
+			return version, packetType, nil, fmt.Errorf("corrupt compressed payload: %s", err)
+		}
+	}
+	return version, packetType, payload, nil
+}
+
+var (
+	deflaters sync.Pool
+	inflaters sync.Pool
+)
+
+// CompressedVersion reports whether records of version hold a compressed payload.
+func CompressedVersion(version uint8) bool {
+	return version >= PacketVersionFloat && version <= maxPacketVersion && version%2 == 0
+}
+
+// payloadVersion returns the uncompressed version of the same payload layout.
+func payloadVersion(version uint8) uint8 {
+	if CompressedVersion(version) {
+		return version - 1
+	}
+	return version
+}
+
+// CompressPacket turns a record of an uncompressed version into a record of
+// the compressed version after it. Other records and records that would not
+// get smaller are returned unchanged.
+func CompressPacket(data []byte) []byte {
+	if len(data) < packetHeaderSize+packetCRCSize || data[0] != PacketMagic {
+		return data
+	}
+	if data[1] < PacketVersionFloat || data[1] > maxPacketVersion || CompressedVersion(data[1]) {
+		return data
+	}
+	payload := data[packetHeaderSize : len(data)-packetCRCSize]
+
+	buf := new(bytes.Buffer)
+	w, _ := deflaters.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(buf, flate.BestSpeed)
+	} else {
+		w.Reset(buf)
+	}
+	w.Write(payload)
+	w.Close()
+	deflaters.Put(w)
+
+	if buf.Len() >= len(payload) {
+		return data
+	}
+	return packPacket(data[1]+1, data[2], buf.Bytes())
+}
+
+func inflate(payload []byte) ([]byte, error) {
+	r, _ := inflaters.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(bytes.NewReader(payload))
+	} else {
+		r.(flate.Resetter).Reset(bytes.NewReader(payload), nil)
+	}
+	defer inflaters.Put(r)
+	return ioutil.ReadAll(r)
+}
+
+// PacketType returns the packet type of a record without verifying its payload.
+func PacketType(data []byte) (uint8, error) {
+	if len(data) == 0 {
+		return 0, ErrTruncatedPacket
+	}
+	if data[0] != PacketMagic {
+		return data[0], nil
+	}
+	if len(data) < packetHeaderSize {
+		return 0, ErrTruncatedPacket
+	}
+	return data[2], nil
+}
+
+func IsSyncPacket(data []byte) bool {
+	packetType, err := PacketType(data)
+	return err == nil && packetType == SyncPacket
+}
+
+type packetReader struct {
+	buf *bytes.Reader
+	err error
+	// fixed-point values instead of float64
+	fixed      bool
+	priceScale fixed.Scale
+	sizeScale  fixed.Scale
+}
+
+func (r *packetReader) read(v interface{}) {
+	if r.err != nil {
+		return
+	}
+	if err := binary.Read(r.buf, binary.LittleEndian, v); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedPacket
+		}
+		r.err = err
+	}
+}
+
+func (r *packetReader) readValue(scale fixed.Scale) fixed.Value {
+	if r.fixed {
+		var v fixed.Value
+		r.read(&v)
+		return v
+	}
+	var f float64
+	r.read(&f)
+	v, err := scale.FromFloat(f)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return v
+}
+
+// readExchangeTime reads the exchange timestamp at the end of a payload.
+func (r *packetReader) readExchangeTime() time.Time {
+	var nano int64
+	r.read(&nano)
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+func (r *packetReader) readLevels() []PacketLevel {
+	var count uint64
+	r.read(&count)
+	if r.err != nil {
+		return nil
+	}
+
+	// each level is two float64 or int64
+	if count > uint64(r.buf.Len())/16 {
+		r.err = ErrTruncatedPacket
+		return nil
+	}
+
+	levels := make([]PacketLevel, count)
+	for i := range levels {
+		levels[i].Price = r.readValue(r.priceScale)
+		levels[i].Size = r.readValue(r.sizeScale)
+	}
+	return levels
+}
+
+// DecodePacket decodes a stored record of any known envelope version.
+func DecodePacket(data []byte) (*Packet, error) {
+	version, packetType, payload, err := UnpackPacket(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pkt, err := decodePayload(packetType, payload, payloadVersion(version))
+	if err != nil {
+		return nil, err
+	}
+	pkt.Version = version
+	return pkt, nil
+}
+
+// decodePayload decodes the payload of an uncompressed version.
+func decodePayload(packetType uint8, payload []byte, version uint8) (*Packet, error) {
+	fixedPoint := version >= PacketVersionFixed
+	r := &packetReader{buf: bytes.NewReader(payload), fixed: fixedPoint, priceScale: fixed.LegacyScale, sizeScale: fixed.LegacyScale}
+	if fixedPoint {
+		r.read(&r.priceScale)
+		r.read(&r.sizeScale)
+		if r.err == nil && (r.priceScale > fixed.MaxScale || r.sizeScale > fixed.MaxScale) {
+			return nil, fmt.Errorf("invalid packet scale %d/%d", r.priceScale, r.sizeScale)
+		}
+	}
+	pkt := &Packet{Type: packetType, PriceScale: r.priceScale, SizeScale: r.sizeScale}
+
+	switch packetType {
+	case SyncPacket:
+		r.read(&pkt.Sequence)
+		pkt.Bid = r.readLevels()
+		pkt.Ask = r.readLevels()
+		if version >= PacketVersionExchangeTime {
+			pkt.ExchangeTime = r.readExchangeTime()
+		}
+	case DiffPacket:
+		r.read(&pkt.Sequence)
+		r.read(&pkt.First)
+		r.read(&pkt.Last)
+		pkt.Bid = r.readLevels()
+		pkt.Ask = r.readLevels()
+		if version >= PacketVersionExchangeTime {
+			pkt.ExchangeTime = r.readExchangeTime()
+		}
+	case TradePacket:
+		r.read(&pkt.Sequence)
+		r.read(&pkt.Side)
+		pkt.Price = r.readValue(r.priceScale)
+		pkt.Size = r.readValue(r.sizeScale)
+		if version >= PacketVersionTradeInfo {
+			var nano int64
+			var flags uint8
+			r.read(&pkt.TradeID)
+			r.read(&nano)
+			r.read(&flags)
+			if nano != 0 {
+				pkt.ExchangeTime = time.Unix(0, nano)
+			}
+			pkt.Aggressor = flags&TradeAggressor != 0
+		}
+	default:
+		return nil, fmt.Errorf("unknown packet type %d", packetType)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.buf.Len() != 0 {
+		return nil, fmt.Errorf("packet has %d trailing bytes", r.buf.Len())
+	}
+
+	return pkt, nil
+}
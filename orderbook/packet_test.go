@@ -0,0 +1,395 @@
+package orderbook
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
+
+// goldenRecords returns the records of the golden files of the bookmap
+// replay, the "  v<version> <hex>" line below each record.
+func goldenRecords(t testing.TB) [][]byte {
+	paths, err := filepath.Glob(filepath.Join("..", "opengl", "bookmap", "testdata", "*.golden"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no golden files")
+	}
+	records := [][]byte{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 || !strings.HasPrefix(scanner.Text(), "  v") {
+				continue
+			}
+			data, err := hex.DecodeString(fields[1])
+			if err != nil {
+				t.Fatalf("%s: %s", path, err)
+			}
+			records = append(records, data)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return records
+}
+
+// envelope wraps payload like PackPacket for any version, version 0 is the
+// record without envelope.
+func envelope(version, packetType uint8, payload []byte) []byte {
+	if version == 0 {
+		return append([]byte{packetType}, payload...)
+	}
+	if CompressedVersion(version) {
+		buf := new(bytes.Buffer)
+		w, _ := flate.NewWriter(buf, flate.BestSpeed)
+		w.Write(payload)
+		w.Close()
+		payload = buf.Bytes()
+	}
+	return packPacket(version, packetType, payload)
+}
+
+// tradePayload returns a fixed-point trade payload at scales 2/8, with the
+// exchange's trade details if withInfo is set.
+func tradePayload(withInfo bool, id uint64, exchangeTime time.Time, aggressor bool) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint8(2))
+	binary.Write(buf, binary.LittleEndian, uint8(8))
+	binary.Write(buf, binary.LittleEndian, uint64(0))
+	binary.Write(buf, binary.LittleEndian, uint8(AskSide))
+	binary.Write(buf, binary.LittleEndian, fixed.Value(10050))
+	binary.Write(buf, binary.LittleEndian, fixed.Value(25000000))
+	if withInfo {
+		WriteTradeInfo(buf, id, exchangeTime, aggressor)
+	}
+	return buf.Bytes()
+}
+
+func TestTradePacketInfo(t *testing.T) {
+	exchangeTime := time.Unix(1550671200, 123000000)
+	cases := []struct {
+		name      string
+		version   uint8
+		withInfo  bool
+		id        uint64
+		time      time.Time
+		aggressor bool
+		err       bool
+	}{
+		{name: "v3 without details", version: PacketVersionFixed},
+		{name: "v4 without details", version: PacketVersionFixedCompressed},
+		{name: "v5 with details", version: PacketVersionTradeInfo, withInfo: true, id: 42, time: exchangeTime, aggressor: true},
+		{name: "v6 with details", version: PacketVersionTradeInfoCompressed, withInfo: true, id: 42, time: exchangeTime, aggressor: true},
+		{name: "v5 with unknown details", version: PacketVersionTradeInfo, withInfo: true},
+		{name: "v5 with a guessed side", version: PacketVersionTradeInfo, withInfo: true, id: 7, time: exchangeTime},
+		{name: "v7 with details", version: PacketVersionExchangeTime, withInfo: true, id: 42, time: exchangeTime, aggressor: true},
+		{name: "v3 with details", version: PacketVersionFixed, withInfo: true, id: 42, err: true},
+		{name: "v5 without details", version: PacketVersionTradeInfo, err: true},
+	}
+	for _, c := range cases {
+		data := envelope(c.version, TradePacket, tradePayload(c.withInfo, c.id, c.time, c.aggressor))
+		pkt, err := DecodePacket(data)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%s: decoded", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if pkt.Version != c.version || pkt.Side != uint8(AskSide) || pkt.Price != 10050 || pkt.Size != 25000000 {
+			t.Fatalf("%s: decoded %+v", c.name, pkt)
+		}
+		if pkt.TradeID != c.id || !pkt.ExchangeTime.Equal(c.time) || pkt.Aggressor != c.aggressor {
+			t.Fatalf("%s: trade details %d %s %t, want %d %s %t", c.name, pkt.TradeID, pkt.ExchangeTime, pkt.Aggressor, c.id, c.time, c.aggressor)
+		}
+	}
+
+	// PackPacket writes the version with details, CompressPacket keeps them
+	data := PackPacket(TradePacket, tradePayload(true, 42, exchangeTime, true))
+	for _, record := range [][]byte{data, CompressPacket(data)} {
+		pkt, err := DecodePacket(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.TradeID != 42 || !pkt.Aggressor {
+			t.Fatalf("v%d trade details %d %t", pkt.Version, pkt.TradeID, pkt.Aggressor)
+		}
+	}
+}
+
+// diffPayload returns a fixed-point diff payload with a bid and an ask at
+// scales 2/8, ending with the exchange timestamp if withTime is set.
+func diffPayload(withTime bool, exchangeTime time.Time) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint8(2))
+	binary.Write(buf, binary.LittleEndian, uint8(8))
+	for _, seq := range []uint64{11, 10, 11} {
+		binary.Write(buf, binary.LittleEndian, seq)
+	}
+	for _, price := range []fixed.Value{10000, 10100} {
+		binary.Write(buf, binary.LittleEndian, uint64(1))
+		binary.Write(buf, binary.LittleEndian, price)
+		binary.Write(buf, binary.LittleEndian, fixed.Value(100000000))
+	}
+	if withTime {
+		WriteExchangeTime(buf, exchangeTime)
+	}
+	return buf.Bytes()
+}
+
+func TestDiffPacketExchangeTime(t *testing.T) {
+	exchangeTime := time.Unix(1550671200, 987000000)
+	cases := []struct {
+		name     string
+		version  uint8
+		withTime bool
+		time     time.Time
+		err      bool
+	}{
+		{name: "v3 without timestamp", version: PacketVersionFixed},
+		{name: "v5 without timestamp", version: PacketVersionTradeInfo},
+		{name: "v6 without timestamp", version: PacketVersionTradeInfoCompressed},
+		{name: "v7 with timestamp", version: PacketVersionExchangeTime, withTime: true, time: exchangeTime},
+		{name: "v8 with timestamp", version: PacketVersionExchangeTimeCompressed, withTime: true, time: exchangeTime},
+		{name: "v7 with unknown timestamp", version: PacketVersionExchangeTime, withTime: true},
+		{name: "v5 with timestamp", version: PacketVersionTradeInfo, withTime: true, err: true},
+		{name: "v7 without timestamp", version: PacketVersionExchangeTime, err: true},
+	}
+	for _, c := range cases {
+		data := envelope(c.version, DiffPacket, diffPayload(c.withTime, c.time))
+		pkt, err := DecodePacket(data)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%s: decoded", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if pkt.Sequence != 11 || pkt.First != 10 || pkt.Last != 11 || len(pkt.Bid) != 1 || len(pkt.Ask) != 1 || pkt.Ask[0].Price != 10100 {
+			t.Fatalf("%s: decoded %+v", c.name, pkt)
+		}
+		if !pkt.ExchangeTime.Equal(c.time) {
+			t.Fatalf("%s: exchange time %s, want %s", c.name, pkt.ExchangeTime, c.time)
+		}
+	}
+}
+
+// floatSyncPayload returns a v0 to v2 sync payload with one bid and one ask
+// as float64.
+func floatSyncPayload() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint64(42))
+	for _, price := range []float64{100, 101.5} {
+		binary.Write(buf, binary.LittleEndian, uint64(1))
+		binary.Write(buf, binary.LittleEndian, price)
+		binary.Write(buf, binary.LittleEndian, float64(0.25))
+	}
+	return buf.Bytes()
+}
+
+func TestPacketEnvelope(t *testing.T) {
+	payload := diffPayload(true, time.Unix(1550671200, 0))
+	valid := envelope(PacketVersionExchangeTime, DiffPacket, payload)
+	compressed := envelope(PacketVersionExchangeTimeCompressed, DiffPacket, payload)
+	flip := func(record []byte, i int) []byte {
+		record = append([]byte{}, record...)
+		record[i] ^= 0x01
+		return record
+	}
+
+	cases := []struct {
+		name   string
+		record []byte
+		err    error
+	}{
+		{"empty record", []byte{}, ErrTruncatedPacket},
+		{"header only", valid[:packetHeaderSize], ErrTruncatedPacket},
+		{"cut payload", valid[:len(valid)-packetCRCSize-1], ErrTruncatedPacket},
+		{"cut checksum", valid[:len(valid)-1], ErrTruncatedPacket},
+		{"cut compressed record", compressed[:len(compressed)-2], ErrTruncatedPacket},
+		{"flipped payload byte", flip(valid, packetHeaderSize+3), ErrPacketChecksum},
+		{"flipped type", flip(valid, 2), ErrPacketChecksum},
+		{"flipped checksum", flip(valid, len(valid)-1), ErrPacketChecksum},
+		{"flipped compressed byte", flip(compressed, packetHeaderSize), ErrPacketChecksum},
+	}
+	for _, c := range cases {
+		if _, err := DecodePacket(c.record); err != c.err {
+			t.Fatalf("%s: %v, want %v", c.name, err, c.err)
+		}
+	}
+
+	for _, record := range [][]byte{valid, compressed} {
+		if _, err := DecodePacket(record); err != nil {
+			t.Fatalf("v%d: %s", record[1], err)
+		}
+	}
+	if _, err := DecodePacket(append(append([]byte{}, valid...), 0)); err == nil {
+		t.Fatalf("decoded a record with a trailing byte")
+	}
+	unknown := packPacket(maxPacketVersion+1, DiffPacket, diffPayload(true, time.Time{}))
+	if _, err := DecodePacket(unknown); err == nil {
+		t.Fatalf("decoded v%d", maxPacketVersion+1)
+	}
+
+	// a v0 record is the type and the float payload without envelope
+	legacy := envelope(0, SyncPacket, floatSyncPayload())
+	for _, record := range [][]byte{legacy, envelope(PacketVersionFloat, SyncPacket, floatSyncPayload())} {
+		pkt, err := DecodePacket(record)
+		if err != nil {
+			t.Fatalf("float sync %x: %s", record, err)
+		}
+		if pkt.Sequence != 42 || pkt.PriceScale != fixed.LegacyScale || len(pkt.Bid) != 1 || len(pkt.Ask) != 1 {
+			t.Fatalf("v%d sync decoded %+v", pkt.Version, pkt)
+		}
+		price, _ := fixed.LegacyScale.FromFloat(101.5)
+		size, _ := fixed.LegacyScale.FromFloat(0.25)
+		if pkt.Ask[0].Price != price || pkt.Ask[0].Size != size {
+			t.Fatalf("v%d ask %+v, want %d %d", pkt.Version, pkt.Ask[0], price, size)
+		}
+	}
+	if pkt, _ := DecodePacket(legacy); pkt.Version != 0 {
+		t.Fatalf("legacy record decoded as v%d", pkt.Version)
+	}
+	if _, err := DecodePacket(legacy[:len(legacy)-3]); err != ErrTruncatedPacket {
+		t.Fatalf("cut legacy record: %v, want %v", err, ErrTruncatedPacket)
+	}
+
+	book := New("BTC-USD")
+	if err := book.Process(time.Unix(1550671200, 0), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Bid) != 1 || len(book.Ask) != 1 {
+		t.Fatalf("book of a legacy sync has %d bids %d asks", len(book.Bid), len(book.Ask))
+	}
+}
+
+func TestCompressPacket(t *testing.T) {
+	// every golden record decodes the same after compressing it
+	compressed := 0
+	for _, record := range goldenRecords(t) {
+		want, err := DecodePacket(record)
+		if err != nil {
+			t.Fatalf("golden record %x: %s", record, err)
+		}
+		data := CompressPacket(record)
+		if len(data) > len(record) {
+			t.Fatalf("v%d record grew from %d to %d bytes", want.Version, len(record), len(data))
+		}
+		got, err := DecodePacket(data)
+		if err != nil {
+			t.Fatalf("compressed v%d record %x: %s", want.Version, data, err)
+		}
+		if CompressedVersion(got.Version) && !CompressedVersion(want.Version) {
+			compressed += 1
+			if got.Version != want.Version+1 {
+				t.Fatalf("v%d record compressed to v%d", want.Version, got.Version)
+			}
+		}
+		got.Version = want.Version
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("v%d record decoded to\n%+v\nafter compressing it, want\n%+v", want.Version, got, want)
+		}
+	}
+	if compressed == 0 {
+		t.Fatalf("no golden record was compressed")
+	}
+
+	levels := make([]byte, 4096)
+	cases := []struct {
+		name       string
+		record     []byte
+		compressed bool
+	}{
+		{"v1 float", envelope(PacketVersionFloat, SyncPacket, levels), true},
+		{"v3 fixed", envelope(PacketVersionFixed, SyncPacket, levels), true},
+		{"v7 exchange time", PackPacket(DiffPacket, levels), true},
+		{"already compressed", envelope(PacketVersionExchangeTimeCompressed, DiffPacket, levels), false},
+		{"legacy record", envelope(0, SyncPacket, levels), false},
+		{"incompressible", PackPacket(DiffPacket, diffPayload(true, time.Unix(1550671200, 0))), false},
+		{"truncated", PackPacket(DiffPacket, levels)[:packetHeaderSize], false},
+	}
+	for _, c := range cases {
+		data := CompressPacket(c.record)
+		if c.compressed != !bytes.Equal(data, c.record) {
+			t.Fatalf("%s: compressed %t, want %t", c.name, !bytes.Equal(data, c.record), c.compressed)
+		}
+		if !c.compressed {
+			continue
+		}
+		version, packetType, payload, err := UnpackPacket(data)
+		if err != nil || version != c.record[1]+1 || packetType != c.record[2] || !bytes.Equal(payload, levels) {
+			t.Fatalf("%s: unpacked v%d type %d %d bytes %v", c.name, version, packetType, len(payload), err)
+		}
+	}
+}
+
+// checkLevels verifies the levels are strictly ascending by price.
+func checkLevels(book *Book) error {
+	for _, levels := range []BookLevelList{book.Bid, book.Ask} {
+		for i := 1; i < len(levels); i++ {
+			if levels[i-1].Price >= levels[i].Price {
+				return fmt.Errorf("levels out of order at %d: %d >= %d", i, levels[i-1].Price, levels[i].Price)
+			}
+		}
+	}
+	return nil
+}
+
+// FuzzProcess feeds records built from a version, type and payload to a book
+// synced from a golden sync record, the envelope is rebuilt around the
+// payload so mutations reach the payload decoder. Records that apply have to
+// leave the book consistent.
+func FuzzProcess(f *testing.F) {
+	syncs := [][]byte{}
+	for _, record := range goldenRecords(f) {
+		version, packetType, payload, err := UnpackPacket(record)
+		if err != nil {
+			f.Fatalf("golden record %x: %s", record, err)
+		}
+		if IsSyncPacket(record) {
+			syncs = append(syncs, record)
+		}
+		f.Add(version, packetType, payload)
+		f.Add(uint8(0), packetType, payload)
+	}
+	if len(syncs) == 0 {
+		f.Fatalf("no golden sync records")
+	}
+
+	t0 := time.Unix(1500000000, 0)
+	f.Fuzz(func(t *testing.T, version, packetType uint8, payload []byte) {
+		data := envelope(version%(maxPacketVersion+1), packetType, payload)
+		book := New("fuzz")
+		if err := book.Process(t0, syncs[len(payload)%len(syncs)]); err != nil {
+			t.Fatalf("golden sync: %s", err)
+		}
+		if book.Process(t0.Add(time.Millisecond), data) != nil {
+			return
+		}
+		if err := checkLevels(book); err != nil {
+			t.Fatalf("%s\n  record %x", err, data)
+		}
+	})
+}
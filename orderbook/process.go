@@ -1,11 +1,10 @@
 package orderbook
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
-	"strconv"
 	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
 )
 
 const (
@@ -14,19 +13,6 @@ const (
 	TradePacket uint8 = iota
 )
 
-func UnpackTimeKey(key []byte) time.Time {
-	i, _ := strconv.ParseInt(string(key), 10, 64)
-	return time.Unix(0, i)
-}
-
-func PackTimeKey(t time.Time) []byte {
-	return []byte(fmt.Sprintf("%d", t.UnixNano()))
-}
-
-func PackUnixNanoKey(nano int64) []byte {
-	return []byte(fmt.Sprintf("%d", nano))
-}
-
 func (book *Book) UpdateSync(first, last uint64) error {
 	seq := book.Sequence
 	next := seq + 1
@@ -52,86 +38,81 @@ func (book *Book) UpdateSync(first, last uint64) error {
 	return nil
 }
 
-func (book *Book) Process(t time.Time, data []byte) bool {
-	buf := bytes.NewBuffer(data)
-
-	var packetType uint8
-	var sequence uint64
-	var first uint64
-	var last uint64
-	var bidsCount uint64
-	var asksCount uint64
-	var price float64
-	var size float64
-	var side uint8
-
-	binary.Read(buf, binary.LittleEndian, &packetType)
+// Process decodes a stored record and applies it to the book.
+func (book *Book) Process(t time.Time, data []byte) error {
+	pkt, err := DecodePacket(data)
+	if err != nil {
+		return err
+	}
+	return book.Apply(t, pkt)
+}
 
-	switch packetType {
+func (book *Book) Apply(t time.Time, pkt *Packet) error {
+	switch pkt.Type {
 	case DiffPacket:
-		binary.Read(buf, binary.LittleEndian, &sequence)
-		binary.Read(buf, binary.LittleEndian, &first)
-		binary.Read(buf, binary.LittleEndian, &last)
-
-		if err := book.UpdateSync(first, last); err != nil {
-			fmt.Println(book.ProductInfo.DatabaseKey, "UpdateSync Error", err)
-			return false
+		if err := book.UpdateSync(pkt.First, pkt.Last); err != nil {
+			return err
 		}
 
-		binary.Read(buf, binary.LittleEndian, &bidsCount)
-		for i := uint64(0); i < bidsCount; i += 1 {
-			binary.Read(buf, binary.LittleEndian, &price)
-			binary.Read(buf, binary.LittleEndian, &size)
-
-			book.UpdateBidLevel(t, price, size)
+		for _, level := range pkt.Bid {
+			book.UpdateBidLevel(t, book.price(pkt, level.Price), book.size(pkt, level.Size))
 		}
 
-		binary.Read(buf, binary.LittleEndian, &asksCount)
-		for i := uint64(0); i < asksCount; i += 1 {
-			binary.Read(buf, binary.LittleEndian, &price)
-			binary.Read(buf, binary.LittleEndian, &size)
-
-			book.UpdateAskLevel(t, price, size)
+		for _, level := range pkt.Ask {
+			book.UpdateAskLevel(t, book.price(pkt, level.Price), book.size(pkt, level.Size))
 		}
 
-		book.Sort()
-
 	case SyncPacket:
-		binary.Read(buf, binary.LittleEndian, &sequence)
-
 		book.Clear()
-		book.Sequence = sequence
+		book.SetScales(pkt.PriceScale, pkt.SizeScale)
+		book.Sequence = pkt.Sequence
 
-		binary.Read(buf, binary.LittleEndian, &bidsCount)
-		for i := uint64(0); i < bidsCount; i += 1 {
-			binary.Read(buf, binary.LittleEndian, &price)
-			binary.Read(buf, binary.LittleEndian, &size)
-
-			book.UpdateBidLevel(t, price, size)
+		for _, level := range pkt.Bid {
+			book.UpdateBidLevel(t, book.price(pkt, level.Price), book.size(pkt, level.Size))
 		}
 
-		binary.Read(buf, binary.LittleEndian, &asksCount)
-		for i := uint64(0); i < asksCount; i += 1 {
-			binary.Read(buf, binary.LittleEndian, &price)
-			binary.Read(buf, binary.LittleEndian, &size)
-
-			book.UpdateAskLevel(t, price, size)
+		for _, level := range pkt.Ask {
+			book.UpdateAskLevel(t, book.price(pkt, level.Price), book.size(pkt, level.Size))
 		}
 
-		book.Sort()
-
 	case TradePacket:
-		binary.Read(buf, binary.LittleEndian, &sequence)
-		binary.Read(buf, binary.LittleEndian, &side)
-		binary.Read(buf, binary.LittleEndian, &price)
-		binary.Read(buf, binary.LittleEndian, &size)
-
-		book.AddTrade(t, side, price, size)
+		book.AddTrade(t, pkt.Side, book.price(pkt, pkt.Price), book.size(pkt, pkt.Size))
 
 	default:
-		fmt.Println(book.ProductInfo.DatabaseKey, "unkown packetType", packetType)
-		return false
+		return fmt.Errorf("unkown packetType %d", pkt.Type)
 	}
 
-	return true
+	return nil
+}
+
+// SetScales switches the book to new price and size scales, rescaling the
+// levels and trades it already holds.
+func (book *Book) SetScales(priceScale, sizeScale fixed.Scale) {
+	if priceScale == book.PriceScale && sizeScale == book.SizeScale {
+		return
+	}
+	for _, levels := range []BookLevelList{book.Bid, book.Ask} {
+		for _, level := range levels {
+			level.Price = book.PriceScale.Rescale(level.Price, priceScale)
+			level.Quantity = book.SizeScale.Rescale(level.Quantity, sizeScale)
+			level.MaxQuantity = book.SizeScale.Rescale(level.MaxQuantity, sizeScale)
+			level.TradeSize = book.SizeScale.Rescale(level.TradeSize, sizeScale)
+		}
+	}
+	for _, trade := range book.Trades {
+		trade.Price = book.PriceScale.Rescale(trade.Price, priceScale)
+		trade.Quantity = book.SizeScale.Rescale(trade.Quantity, sizeScale)
+	}
+	book.PriceScale = priceScale
+	book.SizeScale = sizeScale
+	book.reindex()
+}
+
+// price converts a packet price to the scale of the book.
+func (book *Book) price(pkt *Packet, v fixed.Value) fixed.Value {
+	return pkt.PriceScale.Rescale(v, book.PriceScale)
+}
+
+func (book *Book) size(pkt *Packet, v fixed.Value) fixed.Value {
+	return pkt.SizeScale.Rescale(v, book.SizeScale)
 }
@@ -1,6 +1,11 @@
 package product_info
 
-import "fmt"
+import (
+	"strconv"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+)
 
 type Info struct {
 	DatabaseKey    string
@@ -12,9 +17,38 @@ type Info struct {
 	BaseMinSize    float64 `json:"base_min_size,string"`
 	BaseMaxSize    float64 `json:"base_max_size,string"`
 	QuoteIncrement float64 `json:"quote_increment,string"`
-	FloatFormat    string
+	BaseIncrement  float64 `json:"base_increment,string"`
+}
+
+// PriceScale is the fixed-point scale of prices, derived from QuoteIncrement.
+func (i Info) PriceScale() fixed.Scale {
+	return fixed.ScaleOf(i.QuoteIncrement)
+}
+
+// SizeScale is the fixed-point scale of sizes, derived from BaseIncrement.
+func (i Info) SizeScale() fixed.Scale {
+	return fixed.ScaleOf(i.BaseIncrement)
 }
 
 func (i Info) FormatFloat(v float64) string {
-	return fmt.Sprintf(i.FloatFormat, v)
+	scale := i.PriceScale()
+	value, err := scale.FromFloat(v)
+	if err != nil {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return scale.Format(value)
+}
+
+// ParseDatabaseKey splits a bucket name like "Binance-BTC-USDT" into platform and currencies.
+func ParseDatabaseKey(key string) Info {
+	info := Info{DatabaseKey: key, ID: key}
+	parts := strings.SplitN(key, "-", 3)
+	if len(parts) == 3 {
+		info.Platform = parts[0]
+		info.BaseCurrency = parts[1]
+		info.QuoteCurrency = parts[2]
+		info.ID = parts[1] + "-" + parts[2]
+		info.DisplayName = parts[1] + "/" + parts[2]
+	}
+	return info
 }
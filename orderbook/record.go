@@ -0,0 +1,34 @@
+package orderbook
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// Record is a decoded packet of a product bucket with its store time.
+type Record struct {
+	Time time.Time
+	Pkt  *Packet
+}
+
+// ReadRecords decodes all packets of bucket in store order, like the
+// recordings in testdata the book tests and benchmarks replay.
+func ReadRecords(st store.Store, bucket string) ([]Record, error) {
+	c, err := st.Cursor(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("bucket %s: %s", bucket, err)
+	}
+	defer c.Close()
+
+	records := []Record{}
+	for event, ok := c.First(); ok; event, ok = c.Next() {
+		pkt, err := DecodePacket(event.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s at %s: %s", bucket, event.Time, err)
+		}
+		records = append(records, Record{Time: event.Time, Pkt: pkt})
+	}
+	return records, nil
+}
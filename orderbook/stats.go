@@ -0,0 +1,175 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Rollup slots are encoded as:
+//
+//	marker  uint32 (0xffffffff, never a valid level count)
+//	version uint8
+//	bids    count uint32, then per level price float64, size float64, orders uint32, trade float64
+//	asks    the same as bids
+//	flow    buy float64, sell float64, buys uint32, sells uint32, notional float64
+//
+// Slots rolled up before the encoding was versioned (v0) start directly with
+// the bids and end after the asks, without trade flow.
+const (
+	statsMarker      uint32 = math.MaxUint32
+	StatsVersionFlow uint8  = 1
+	StatsVersion            = StatsVersionFlow
+	statsHeaderSize         = 4 + 1
+	packedStateSize         = 8 + 8 + 4 + 8
+	packedFlowSize          = 8 + 8 + 4 + 4 + 8
+)
+
+// PackStats encodes a slot of stats for the rollup buckets in the current
+// version.
+func PackStats(stats *BookMapStatsCopy) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, statsMarker)
+	binary.Write(buf, binary.LittleEndian, StatsVersion)
+	for _, side := range [][]OrderState{stats.Bid, stats.Ask} {
+		binary.Write(buf, binary.LittleEndian, uint32(len(side)))
+		for _, state := range side {
+			binary.Write(buf, binary.LittleEndian, state.Price)
+			binary.Write(buf, binary.LittleEndian, state.Size)
+			binary.Write(buf, binary.LittleEndian, uint32(state.OrderCount))
+			binary.Write(buf, binary.LittleEndian, state.TradeSize)
+		}
+	}
+	flow := stats.Flow
+	binary.Write(buf, binary.LittleEndian, flow.BuyVolume)
+	binary.Write(buf, binary.LittleEndian, flow.SellVolume)
+	binary.Write(buf, binary.LittleEndian, uint32(flow.BuyCount))
+	binary.Write(buf, binary.LittleEndian, uint32(flow.SellCount))
+	binary.Write(buf, binary.LittleEndian, flow.Notional)
+	return buf.Bytes()
+}
+
+// UnpackStats decodes a rollup slot of any known stats version.
+func UnpackStats(data []byte) (*BookMapStatsCopy, error) {
+	var version uint8
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == statsMarker {
+		if len(data) < statsHeaderSize {
+			return nil, ErrTruncatedPacket
+		}
+		version = data[4]
+		if version > StatsVersion {
+			return nil, fmt.Errorf("unknown stats version %d", version)
+		}
+		data = data[statsHeaderSize:]
+	}
+
+	stats := &BookMapStatsCopy{}
+	sides := []*[]OrderState{&stats.Bid, &stats.Ask}
+	for _, side := range sides {
+		if len(data) < 4 {
+			return nil, ErrTruncatedPacket
+		}
+		count := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if count > len(data)/packedStateSize {
+			return nil, ErrTruncatedPacket
+		}
+		*side = make([]OrderState, count)
+		for i := range *side {
+			(*side)[i] = OrderState{
+				Price:      math.Float64frombits(binary.LittleEndian.Uint64(data)),
+				Size:       math.Float64frombits(binary.LittleEndian.Uint64(data[8:])),
+				OrderCount: int(binary.LittleEndian.Uint32(data[16:])),
+				TradeSize:  math.Float64frombits(binary.LittleEndian.Uint64(data[20:])),
+			}
+			data = data[packedStateSize:]
+		}
+	}
+	if version >= StatsVersionFlow {
+		if len(data) < packedFlowSize {
+			return nil, ErrTruncatedPacket
+		}
+		stats.Flow = TradeFlow{
+			BuyVolume:  math.Float64frombits(binary.LittleEndian.Uint64(data)),
+			SellVolume: math.Float64frombits(binary.LittleEndian.Uint64(data[8:])),
+			BuyCount:   int(binary.LittleEndian.Uint32(data[16:])),
+			SellCount:  int(binary.LittleEndian.Uint32(data[20:])),
+			Notional:   math.Float64frombits(binary.LittleEndian.Uint64(data[24:])),
+		}
+		data = data[packedFlowSize:]
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after stats", len(data))
+	}
+	return stats, nil
+}
+
+// MergeStats combines the stats of consecutive slots into one: the max size a
+// level had, the traded size and trade flow summed up and the order count of
+// the later slot.
+func MergeStats(a, b *BookMapStatsCopy) *BookMapStatsCopy {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	merged := &BookMapStatsCopy{
+		Bid:  mergeStates(a.Bid, b.Bid),
+		Ask:  mergeStates(a.Ask, b.Ask),
+		Flow: a.Flow,
+	}
+	merged.Flow.Add(b.Flow)
+	return merged
+}
+
+func mergeStates(a, b []OrderState) []OrderState {
+	levels := make(map[float64]int, len(a)+len(b))
+	merged := make([]OrderState, 0, len(a)+len(b))
+
+	for _, state := range a {
+		levels[state.Price] = len(merged)
+		merged = append(merged, state)
+	}
+
+	for _, state := range b {
+		i, ok := levels[state.Price]
+		if !ok {
+			levels[state.Price] = len(merged)
+			merged = append(merged, state)
+			continue
+		}
+		level := &merged[i]
+		if state.Size > level.Size {
+			level.Size = state.Size
+		}
+		level.OrderCount = state.OrderCount
+		level.TradeSize += state.TradeSize
+	}
+
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Price < merged[j].Price })
+	return merged
+}
+
+// BandStats drops levels further than band (0.05 = 5%) from center and levels
+// that were neither in the book nor traded during the slot.
+func BandStats(stats *BookMapStatsCopy, center, band float64) *BookMapStatsCopy {
+	low := center * (1 - band)
+	high := center * (1 + band)
+	filter := func(states []OrderState) []OrderState {
+		filtered := make([]OrderState, 0, len(states))
+		for _, state := range states {
+			if state.Size == 0 && state.TradeSize == 0 {
+				continue
+			}
+			if center != 0 && band != 0 && (state.Price < low || state.Price > high) {
+				continue
+			}
+			filtered = append(filtered, state)
+		}
+		return filtered
+	}
+	return &BookMapStatsCopy{Bid: filter(stats.Bid), Ask: filter(stats.Ask), Flow: stats.Flow}
+}
@@ -0,0 +1,102 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestTradeFlow(t *testing.T) {
+	var flow TradeFlow
+	if flow.VWAP() != 0 || flow.Delta() != 0 || flow.Count() != 0 {
+		t.Fatalf("empty flow %+v", flow)
+	}
+
+	flow.AddTrade(AskSide, 100, 2)
+	flow.AddTrade(AskSide, 104, 1)
+	flow.AddTrade(BidSide, 98, 1)
+	want := TradeFlow{BuyVolume: 3, SellVolume: 1, BuyCount: 2, SellCount: 1, Notional: 200 + 104 + 98}
+	if flow != want {
+		t.Fatalf("flow %+v, want %+v", flow, want)
+	}
+	if flow.Volume() != 4 || flow.Delta() != 2 || flow.Count() != 3 || flow.VWAP() != 100.5 {
+		t.Fatalf("volume %g delta %g count %d vwap %g", flow.Volume(), flow.Delta(), flow.Count(), flow.VWAP())
+	}
+
+	// more sells than buys turn the delta negative
+	flow.Add(TradeFlow{SellVolume: 5, SellCount: 2, Notional: 500})
+	if flow.Delta() != -3 || flow.Count() != 5 || flow.VWAP() != 902.0/9 {
+		t.Fatalf("added flow delta %g count %d vwap %g", flow.Delta(), flow.Count(), flow.VWAP())
+	}
+
+	merged := MergeStats(&BookMapStatsCopy{Flow: want}, &BookMapStatsCopy{Flow: TradeFlow{SellVolume: 5, SellCount: 2, Notional: 500}})
+	if merged.Flow != flow {
+		t.Fatalf("merged flow %+v, want %+v", merged.Flow, flow)
+	}
+}
+
+// packStatsV0 encodes stats the way rollups were written before the stats
+// encoding was versioned.
+func packStatsV0(stats *BookMapStatsCopy) []byte {
+	buf := new(bytes.Buffer)
+	for _, side := range [][]OrderState{stats.Bid, stats.Ask} {
+		binary.Write(buf, binary.LittleEndian, uint32(len(side)))
+		for _, state := range side {
+			binary.Write(buf, binary.LittleEndian, state.Price)
+			binary.Write(buf, binary.LittleEndian, state.Size)
+			binary.Write(buf, binary.LittleEndian, uint32(state.OrderCount))
+			binary.Write(buf, binary.LittleEndian, state.TradeSize)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestPackStats(t *testing.T) {
+	stats := &BookMapStatsCopy{
+		Bid:  []OrderState{{Price: 99, Size: 2, OrderCount: 3}, {Price: 100, Size: 1, OrderCount: 1, TradeSize: 0.5}},
+		Ask:  []OrderState{{Price: 101, Size: 4, OrderCount: 2, TradeSize: 1.5}},
+		Flow: TradeFlow{BuyVolume: 1.5, SellVolume: 0.5, BuyCount: 2, SellCount: 1, Notional: 201.5},
+	}
+	data := PackStats(stats)
+	if binary.LittleEndian.Uint32(data) != statsMarker || data[4] != StatsVersion {
+		t.Fatalf("stats header %x", data[:statsHeaderSize])
+	}
+	if got, err := UnpackStats(data); err != nil || !reflect.DeepEqual(got, stats) {
+		t.Fatalf("unpacked %+v %v, want %+v", got, err, stats)
+	}
+
+	empty := &BookMapStatsCopy{Bid: []OrderState{}, Ask: []OrderState{}}
+	if got, err := UnpackStats(PackStats(empty)); err != nil || !reflect.DeepEqual(got, empty) {
+		t.Fatalf("unpacked empty stats %+v %v", got, err)
+	}
+
+	// v0 slots have no flow
+	v0, err := UnpackStats(packStatsV0(stats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v0.Bid, stats.Bid) || !reflect.DeepEqual(v0.Ask, stats.Ask) || v0.Flow != (TradeFlow{}) {
+		t.Fatalf("unpacked v0 %+v", v0)
+	}
+
+	unknown := append([]byte{}, data...)
+	unknown[4] = StatsVersion + 1
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"marker only", data[:4]},
+		{"cut levels", data[:statsHeaderSize+10]},
+		{"cut flow", data[:len(data)-1]},
+		{"trailing byte", append(append([]byte{}, data...), 0)},
+		{"v0 with trailing flow", append(packStatsV0(stats), data[len(data)-packedFlowSize:]...)},
+		{"unknown version", unknown},
+	}
+	for _, c := range cases {
+		if got, err := UnpackStats(c.data); err == nil {
+			t.Fatalf("%s: unpacked %+v", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Bolt keeps one bucket per product. Keys are the unix nano time followed by a
+// sub-sequence for events of the same nanosecond, both big endian, so they sort
+// by time regardless of the number of digits.
+type Bolt struct {
+	DB *bolt.DB
+}
+
+// MetaBucket holds string metadata about the recording, it is not a product.
+const MetaBucket = "_meta"
+
+func NewBolt(db *bolt.DB) *Bolt {
+	return &Bolt{DB: db}
+}
+
+const KeySize = 12
+
+var ErrLegacyKeys = errors.New("database uses decimal time keys, convert it with cmd/migrate")
+
+// PackKey packs t as big-endian unix nanoseconds followed by seq. Times
+// before the unix epoch, like the zero time, pack as 0 so they sort first.
+func PackKey(t time.Time, seq uint32) []byte {
+	key := make([]byte, KeySize)
+	var nano int64
+	if t.After(time.Unix(0, 0)) {
+		nano = t.UnixNano()
+	}
+	binary.BigEndian.PutUint64(key, uint64(nano))
+	binary.BigEndian.PutUint32(key[8:], seq)
+	return key
+}
+
+func UnpackKey(key []byte) (time.Time, uint32) {
+	if len(key) != KeySize {
+		return time.Time{}, 0
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key))), binary.BigEndian.Uint32(key[8:])
+}
+
+// PackTimeKey is the key prefix of all events at t, it sorts before them.
+func PackTimeKey(t time.Time) []byte {
+	return PackKey(t, 0)[:8]
+}
+
+// ParseLegacyKey parses the decimal unix nano keys of older databases.
+func ParseLegacyKey(key []byte) (time.Time, error) {
+	nano, err := strconv.ParseInt(string(key), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time key %q", key)
+	}
+	return time.Unix(0, nano), nil
+}
+
+// CheckKeys returns ErrLegacyKeys if any product still uses decimal keys.
+func CheckKeys(db *bolt.DB) error {
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if string(name) == MetaBucket {
+				return nil
+			}
+			if key, _ := b.Cursor().First(); key != nil && len(key) != KeySize {
+				return ErrLegacyKeys
+			}
+			return nil
+		})
+	})
+}
+
+// nextKey returns the key for an event at t after all events already stored
+// in the same nanosecond.
+func nextKey(b *bolt.Bucket, t time.Time) []byte {
+	c := b.Cursor()
+	key, _ := c.Last()
+	if key == nil {
+		return PackKey(t, 0)
+	}
+	// older than the last event, find the last key of the same nanosecond
+	if last, _ := UnpackKey(key); last.UnixNano() > t.UnixNano() {
+		c.Seek(PackTimeKey(time.Unix(0, t.UnixNano()+1)))
+		key, _ = c.Prev()
+	}
+	if last, seq := UnpackKey(key); key != nil && last.UnixNano() == t.UnixNano() {
+		return PackKey(t, seq+1)
+	}
+	return PackKey(t, 0)
+}
+
+func (s *Bolt) Append(product string, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(product))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s %s", product, err)
+		}
+		b.FillPercent = 0.9
+		for _, event := range events {
+			// windows system clock resolution https://github.com/golang/go/issues/8687
+			if err := b.Put(nextKey(b, event.Time), event.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Bolt) Products() ([]string, error) {
+	products := []string{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if string(name) != MetaBucket {
+				products = append(products, string(name))
+			}
+			return nil
+		})
+	})
+	return products, err
+}
+
+func (s *Bolt) Cursor(product string) (Cursor, error) {
+	tx, err := s.DB.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	b := tx.Bucket([]byte(product))
+	if b == nil {
+		tx.Rollback()
+		return nil, ErrNotFound
+	}
+	return &boltCursor{tx: tx, c: b.Cursor()}, nil
+}
+
+const boltDeleteBatchSize = 10000
+
+// DeleteBefore deletes in batches so writers are not blocked by one huge transaction.
+func (s *Bolt) DeleteBefore(product string, before Key) (int, error) {
+	stop := PackKey(before.Time, before.Seq)
+	var total int
+	for {
+		var n int
+		err := s.DB.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(product))
+			if b == nil {
+				return nil
+			}
+			keys := [][]byte{}
+			c := b.Cursor()
+			for key, _ := c.First(); key != nil && len(keys) < boltDeleteBatchSize; key, _ = c.Next() {
+				if bytes.Compare(key, stop) >= 0 {
+					break
+				}
+				keys = append(keys, append([]byte{}, key...))
+			}
+			for _, key := range keys {
+				if err := b.Delete(key); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		total += n
+		if err != nil || n < boltDeleteBatchSize {
+			return total, err
+		}
+	}
+}
+
+func (s *Bolt) SetMeta(key, value string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(MetaBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), []byte(value))
+	})
+}
+
+func (s *Bolt) Meta(key string) (string, error) {
+	var value string
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(MetaBucket)); b != nil {
+			value = string(b.Get([]byte(key)))
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *Bolt) Close() error {
+	return s.DB.Close()
+}
+
+// boltCursor holds a read transaction until it is closed.
+type boltCursor struct {
+	tx *bolt.Tx
+	c  *bolt.Cursor
+}
+
+func boltEvent(key, value []byte) (Event, bool) {
+	if key == nil {
+		return Event{}, false
+	}
+	t, seq := UnpackKey(key)
+	return Event{Time: t, Seq: seq, Data: value}, true
+}
+
+func (c *boltCursor) First() (Event, bool) { return boltEvent(c.c.First()) }
+func (c *boltCursor) Last() (Event, bool)  { return boltEvent(c.c.Last()) }
+func (c *boltCursor) Next() (Event, bool)  { return boltEvent(c.c.Next()) }
+func (c *boltCursor) Prev() (Event, bool)  { return boltEvent(c.c.Prev()) }
+
+func (c *boltCursor) Seek(t time.Time) (Event, bool) {
+	return boltEvent(c.c.Seek(PackTimeKey(t)))
+}
+
+func (c *boltCursor) SeekAfter(key Key) (Event, bool) {
+	packed := PackKey(key.Time, key.Seq)
+	k, v := c.c.Seek(packed)
+	if bytes.Equal(k, packed) {
+		k, v = c.c.Next()
+	}
+	return boltEvent(k, v)
+}
+
+func (c *boltCursor) Close() error {
+	return c.tx.Rollback()
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPackKeyOrder(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	// ascending times, decimal keys of these would sort 999 after 1000
+	times := []time.Time{
+		epoch.Add(time.Nanosecond),
+		epoch.Add(999),
+		epoch.Add(1000),
+		time.Unix(999999999, 999999999),
+		time.Unix(1000000000, 0),
+		time.Unix(1550671200, 0),
+		time.Unix(1550671200, 1),
+		time.Unix(1550671201, 0),
+	}
+	for i := 1; i < len(times); i++ {
+		a, b := PackKey(times[i-1], math.MaxUint32), PackKey(times[i], 0)
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("key of %s does not sort before %s", times[i-1], times[i])
+		}
+		if tk := PackTimeKey(times[i]); bytes.Compare(a, tk) >= 0 || bytes.Compare(tk, b) > 0 {
+			t.Fatalf("time key of %s does not sort between the events", times[i])
+		}
+	}
+
+	// events of the same nanosecond sort by sub-sequence
+	now := times[5]
+	if bytes.Compare(PackKey(now, 1), PackKey(now, 2)) >= 0 || bytes.Compare(PackKey(now, 0xff), PackKey(now, 0x100)) >= 0 {
+		t.Fatalf("sub-sequences of %s do not sort", now)
+	}
+
+	for _, tm := range times {
+		if back, seq := UnpackKey(PackKey(tm, 7)); !back.Equal(tm) || seq != 7 {
+			t.Fatalf("unpacked %s %d, want %s 7", back, seq, tm)
+		}
+	}
+
+	// the zero time and times before the epoch all sort first
+	first := PackKey(times[0], 0)
+	for _, tm := range []time.Time{{}, epoch, epoch.Add(-time.Nanosecond), time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC)} {
+		if !bytes.Equal(PackTimeKey(tm), make([]byte, 8)) {
+			t.Fatalf("time key of %s is %x, want zero", tm, PackTimeKey(tm))
+		}
+		if bytes.Compare(PackKey(tm, math.MaxUint32), first) >= 0 {
+			t.Fatalf("key of %s does not sort before the epoch", tm)
+		}
+	}
+}
+
+func TestBoltSeekBeforeEpoch(t *testing.T) {
+	st := testStores(t)["bolt"]
+	defer st.Close()
+	now := time.Unix(1550671200, 0)
+	if err := st.Append("GDAX-BTC-USD", Event{Time: now, Data: []byte{0}}, Event{Time: now.Add(time.Second), Data: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := st.Cursor("GDAX-BTC-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tm := range []time.Time{{}, time.Unix(-1, 0)} {
+		if event, ok := c.Seek(tm); !ok || event.Data[0] != 0 {
+			t.Fatalf("seek to %s got %v %v, want the first event", tm, ok, event.Data)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory keeps all events in sorted slices, for replays and tools that do not
+// need to persist anything.
+type Memory struct {
+	mu       sync.RWMutex
+	products map[string][]Event
+	meta     map[string]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		products: map[string][]Event{},
+		meta:     map[string]string{},
+	}
+}
+
+func (s *Memory) Append(product string, events ...Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.products[product]
+	for _, event := range events {
+		i := sort.Search(len(list), func(i int) bool { return list[i].Time.After(event.Time) })
+		event.Data = append([]byte{}, event.Data...)
+		event.Seq = 0
+		if i > 0 && list[i-1].Time.UnixNano() == event.Time.UnixNano() {
+			event.Seq = list[i-1].Seq + 1
+		}
+		list = append(list, Event{})
+		copy(list[i+1:], list[i:])
+		list[i] = event
+	}
+	s.products[product] = list
+	return nil
+}
+
+func (s *Memory) Products() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	products := make([]string, 0, len(s.products))
+	for name := range s.products {
+		products = append(products, name)
+	}
+	sort.Strings(products)
+	return products, nil
+}
+
+// Cursor works on a snapshot, events appended later are not visible. Events
+// it returns hold a copy of their Data.
+func (s *Memory) Cursor(product string) (Cursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list, ok := s.products[product]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &sliceCursor{events: list[:len(list):len(list)], i: -1}, nil
+}
+
+func (s *Memory) DeleteBefore(product string, key Key) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.products[product]
+	i := sort.Search(len(list), func(i int) bool { return !list[i].Key().Before(key) })
+	if i > 0 {
+		s.products[product] = append([]Event{}, list[i:]...)
+	}
+	return i, nil
+}
+
+func (s *Memory) SetMeta(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta[key] = value
+	return nil
+}
+
+func (s *Memory) Meta(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.meta[key], nil
+}
+
+func (s *Memory) Close() error {
+	return nil
+}
+
+// sliceCursor iterates a sorted slice of events.
+type sliceCursor struct {
+	events []Event
+	i      int
+}
+
+func (c *sliceCursor) at(i int) (Event, bool) {
+	if i < 0 {
+		c.i = -1
+		return Event{}, false
+	}
+	if i >= len(c.events) {
+		c.i = len(c.events)
+		return Event{}, false
+	}
+	c.i = i
+	// callers may modify Data, the events are shared with the store
+	event := c.events[i]
+	event.Data = append([]byte{}, event.Data...)
+	return event, true
+}
+
+func (c *sliceCursor) First() (Event, bool) { return c.at(0) }
+func (c *sliceCursor) Last() (Event, bool)  { return c.at(len(c.events) - 1) }
+func (c *sliceCursor) Next() (Event, bool)  { return c.at(c.i + 1) }
+func (c *sliceCursor) Prev() (Event, bool)  { return c.at(c.i - 1) }
+
+func (c *sliceCursor) Seek(t time.Time) (Event, bool) {
+	return c.at(sort.Search(len(c.events), func(i int) bool { return !c.events[i].Time.Before(t) }))
+}
+
+func (c *sliceCursor) SeekAfter(key Key) (Event, bool) {
+	return c.at(sort.Search(len(c.events), func(i int) bool { return key.Before(c.events[i].Key()) }))
+}
+
+func (c *sliceCursor) Close() error {
+	return nil
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCursorCopiesData(t *testing.T) {
+	s := NewMemory()
+	now := time.Unix(1550671200, 0)
+	if err := s.Append("GDAX-BTC-USD", Event{Time: now, Data: []byte{1, 2, 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := s.Cursor("GDAX-BTC-USD")
+	event, _ := c.First()
+	event.Data[0] = 9
+	c.Close()
+
+	c, _ = s.Cursor("GDAX-BTC-USD")
+	defer c.Close()
+	if event, _ := c.First(); event.Data[0] != 1 {
+		t.Fatalf("changing the Data of a read event changed the store, %v", event.Data)
+	}
+}
@@ -0,0 +1,492 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Segment is an append-only file store, a directory per product holding
+// segment files named after the time of their first event. Each record is
+//
+//	time   int64  unix nano, big endian
+//	length uint32 big endian
+//	data   [length]byte
+//
+// Segments are closed once they reach SegmentSize. Events not after the last
+// event of a product are stored one nanosecond after it, so files stay sorted.
+// Events that were older are logged and counted by Adjusted.
+// Only one process may write a segment store, readers can open it at any time
+// and ignore a partially written last record.
+type Segment struct {
+	Path        string
+	SegmentSize int64
+	ReadOnly    bool
+
+	mu      sync.Mutex
+	writers map[string]*segmentWriter
+}
+
+const (
+	DefaultSegmentSize = 16 << 20
+	segmentExt         = ".seg"
+	segmentRecordSize  = 8 + 4
+	segmentMetaFile    = "meta.json"
+)
+
+type segmentWriter struct {
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+	last time.Time
+	// events stored after the last event because they were older
+	adjusted int
+}
+
+func OpenSegment(path string, readOnly bool) (*Segment, error) {
+	if readOnly {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &Segment{
+		Path:        path,
+		SegmentSize: DefaultSegmentSize,
+		ReadOnly:    readOnly,
+		writers:     map[string]*segmentWriter{},
+	}, nil
+}
+
+func segmentName(t time.Time) string {
+	return fmt.Sprintf("%020d%s", t.UnixNano(), segmentExt)
+}
+
+// segments lists the segment files of product sorted by time.
+func (s *Segment) segments(product string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.Path, product))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), segmentExt) {
+			names = append(names, filepath.Join(s.Path, product, file.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func segmentStart(path string) time.Time {
+	nano, _ := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+	return time.Unix(0, nano)
+}
+
+// readSegment parses all complete records of a segment file.
+func readSegment(path string) ([]Event, int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	events := []Event{}
+	var offset int64
+	for len(data) >= segmentRecordSize {
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) < segmentRecordSize+n {
+			break
+		}
+		events = append(events, Event{
+			Time: time.Unix(0, int64(binary.BigEndian.Uint64(data))),
+			Data: data[segmentRecordSize : segmentRecordSize+n : segmentRecordSize+n],
+		})
+		data = data[segmentRecordSize+n:]
+		offset += int64(segmentRecordSize + n)
+	}
+	return events, offset, nil
+}
+
+func (s *Segment) writer(product string) (*segmentWriter, error) {
+	if w, ok := s.writers[product]; ok {
+		return w, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.Path, product), 0755); err != nil {
+		return nil, err
+	}
+
+	w := &segmentWriter{}
+	segments, err := s.segments(product)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		// continue the last segment after its last complete record
+		path := segments[len(segments)-1]
+		events, size, err := readSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			w.last = events[len(events)-1].Time
+		}
+		if w.file, err = os.OpenFile(path, os.O_WRONLY, 0644); err != nil {
+			return nil, err
+		}
+		if err := w.file.Truncate(size); err != nil {
+			return nil, err
+		}
+		if _, err := w.file.Seek(size, 0); err != nil {
+			return nil, err
+		}
+		w.buf = bufio.NewWriter(w.file)
+		w.size = size
+	}
+
+	s.writers[product] = w
+	return w, nil
+}
+
+func (w *segmentWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.buf.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	w.buf = nil
+	w.size = 0
+	return err
+}
+
+func (s *Segment) Append(product string, events ...Event) error {
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, err := s.writer(product)
+	if err != nil {
+		return err
+	}
+
+	var header [segmentRecordSize]byte
+	var adjusted int
+	var shift time.Duration
+	for _, event := range events {
+		t := event.Time
+		if !w.last.IsZero() && !t.After(w.last) {
+			if t.Before(w.last) {
+				adjusted += 1
+				if d := w.last.Sub(t); d > shift {
+					shift = d
+				}
+			}
+			t = w.last.Add(time.Nanosecond)
+		}
+
+		if w.file == nil || w.size >= s.SegmentSize {
+			if err := w.close(); err != nil {
+				return err
+			}
+			path := filepath.Join(s.Path, product, segmentName(t))
+			if w.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err != nil {
+				return err
+			}
+			w.buf = bufio.NewWriter(w.file)
+		}
+
+		binary.BigEndian.PutUint64(header[:], uint64(t.UnixNano()))
+		binary.BigEndian.PutUint32(header[8:], uint32(len(event.Data)))
+		w.buf.Write(header[:])
+		if _, err := w.buf.Write(event.Data); err != nil {
+			return err
+		}
+		w.size += int64(segmentRecordSize + len(event.Data))
+		w.last = t
+	}
+	if adjusted > 0 {
+		w.adjusted += adjusted
+		log.Printf("segment %s: moved %d events after the last event, up to %s older than it", product, adjusted, shift)
+	}
+
+	// readers only see flushed records
+	return w.buf.Flush()
+}
+
+// Adjusted returns how many events of product were stored with a later time
+// since the store was opened, because they were older than the last event.
+func (s *Segment) Adjusted(product string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.writers[product]; ok {
+		return w.adjusted
+	}
+	return 0
+}
+
+func (s *Segment) Products() ([]string, error) {
+	files, err := ioutil.ReadDir(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	products := []string{}
+	for _, file := range files {
+		if file.IsDir() {
+			products = append(products, file.Name())
+		}
+	}
+	return products, nil
+}
+
+func (s *Segment) Cursor(product string) (Cursor, error) {
+	segments, err := s.segments(product)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentCursor{paths: segments, seg: -1}, nil
+}
+
+// DeleteBefore removes whole segments before key and rewrites the segment
+// containing key without its older events.
+func (s *Segment) DeleteBefore(product string, key Key) (int, error) {
+	if s.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments, err := s.segments(product)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for i, path := range segments {
+		if !(Key{Time: segmentStart(path)}).Before(key) {
+			break
+		}
+
+		events, _, err := readSegment(path)
+		if err != nil {
+			return deleted, err
+		}
+		keep := sort.Search(len(events), func(i int) bool { return !events[i].Key().Before(key) })
+		deleted += keep
+
+		// the last segment may be open for writing
+		if i == len(segments)-1 {
+			if w, ok := s.writers[product]; ok {
+				if err := w.close(); err != nil {
+					return deleted, err
+				}
+			}
+		}
+
+		if keep < len(events) {
+			if err := writeSegment(filepath.Join(s.Path, product, segmentName(events[keep].Time)), events[keep:]); err != nil {
+				return deleted, err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return deleted, err
+		}
+		if keep < len(events) {
+			break
+		}
+	}
+	return deleted, nil
+}
+
+func writeSegment(path string, events []Event) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(file)
+	var header [segmentRecordSize]byte
+	for _, event := range events {
+		binary.BigEndian.PutUint64(header[:], uint64(event.Time.UnixNano()))
+		binary.BigEndian.PutUint32(header[8:], uint32(len(event.Data)))
+		buf.Write(header[:])
+		buf.Write(event.Data)
+	}
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Segment) readMeta() (map[string]string, error) {
+	meta := map[string]string{}
+	data, err := ioutil.ReadFile(filepath.Join(s.Path, segmentMetaFile))
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta, json.Unmarshal(data, &meta)
+}
+
+func (s *Segment) SetMeta(key, value string) error {
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta()
+	if err != nil {
+		return err
+	}
+	meta[key] = value
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.Path, segmentMetaFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *Segment) Meta(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, err := s.readMeta()
+	return meta[key], err
+}
+
+func (s *Segment) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, w := range s.writers {
+		if werr := w.close(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// segmentCursor loads one segment at a time.
+type segmentCursor struct {
+	paths  []string
+	seg    int
+	events []Event
+	i      int
+}
+
+func (c *segmentCursor) load(seg int) bool {
+	if seg < 0 || seg >= len(c.paths) {
+		return false
+	}
+	if seg != c.seg {
+		events, _, err := readSegment(c.paths[seg])
+		if err != nil {
+			// removed by a retention since the cursor was opened
+			events = []Event{}
+		}
+		c.seg = seg
+		c.events = events
+	}
+	return true
+}
+
+// at moves to event i of segment seg, skipping empty segments in direction dir.
+func (c *segmentCursor) at(seg, i, dir int) (Event, bool) {
+	for c.load(seg) {
+		if i < 0 && dir < 0 {
+			i = len(c.events) - 1
+		}
+		if i >= 0 && i < len(c.events) {
+			c.i = i
+			return c.events[i], true
+		}
+		seg += dir
+		i = 0
+		if dir < 0 {
+			i = -1
+		}
+	}
+	// park before the first or after the last event
+	if dir < 0 {
+		c.seg, c.i, c.events = -1, -1, nil
+	} else {
+		c.seg, c.i, c.events = len(c.paths), 0, nil
+	}
+	return Event{}, false
+}
+
+func (c *segmentCursor) First() (Event, bool) { return c.at(0, 0, 1) }
+func (c *segmentCursor) Last() (Event, bool)  { return c.at(len(c.paths)-1, -1, -1) }
+
+func (c *segmentCursor) Next() (Event, bool) {
+	if c.seg < 0 {
+		return c.First()
+	}
+	return c.at(c.seg, c.i+1, 1)
+}
+
+func (c *segmentCursor) Prev() (Event, bool) {
+	if c.seg >= len(c.paths) {
+		return c.Last()
+	}
+	if c.i == 0 {
+		return c.at(c.seg-1, -1, -1)
+	}
+	return c.at(c.seg, c.i-1, -1)
+}
+
+func (c *segmentCursor) Seek(t time.Time) (Event, bool) {
+	// last segment starting at or before t, its events may reach past t
+	seg := sort.Search(len(c.paths), func(i int) bool { return segmentStart(c.paths[i]).After(t) }) - 1
+	if seg < 0 {
+		return c.First()
+	}
+	if !c.load(seg) {
+		return c.First()
+	}
+	i := sort.Search(len(c.events), func(i int) bool { return !c.events[i].Time.Before(t) })
+	return c.at(seg, i, 1)
+}
+
+func (c *segmentCursor) SeekAfter(key Key) (Event, bool) {
+	event, ok := c.Seek(key.Time)
+	for ok && !key.Before(event.Key()) {
+		event, ok = c.Next()
+	}
+	return event, ok
+}
+
+func (c *segmentCursor) Close() error {
+	c.events = nil
+	return nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// segmentEvents reads all events of product through a cursor.
+func segmentEvents(t *testing.T, s *Segment, product string) []Event {
+	t.Helper()
+	c, err := s.Cursor(product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	events := []Event{}
+	for event, ok := c.First(); ok; event, ok = c.Next() {
+		events = append(events, event)
+	}
+	return events
+}
+
+func checkSegmentEvents(t *testing.T, name string, got []Event, times []time.Time) {
+	t.Helper()
+	if len(got) != len(times) {
+		t.Fatalf("%s: %d events, want %d", name, len(got), len(times))
+	}
+	for i, event := range got {
+		if !event.Time.Equal(times[i]) || len(event.Data) != 1 || event.Data[0] != byte(i) {
+			t.Fatalf("%s: event %d at %s %v, want %s [%d]", name, i, event.Time, event.Data, times[i], i)
+		}
+	}
+}
+
+func TestSegmentOutOfOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments")
+	s, err := OpenSegment(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1550671200, 0)
+	err = s.Append("GDAX-BTC-USD",
+		Event{Time: now.Add(2 * time.Second), Data: []byte{0}},
+		// older, stored after the last event
+		Event{Time: now.Add(time.Second), Data: []byte{1}},
+		// at the time of the last stored event, moved without counting it
+		Event{Time: now.Add(2*time.Second + time.Nanosecond), Data: []byte{2}},
+		Event{Time: now.Add(3 * time.Second), Data: []byte{3}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := []time.Time{now.Add(2 * time.Second), now.Add(2*time.Second + time.Nanosecond), now.Add(2*time.Second + 2*time.Nanosecond), now.Add(3 * time.Second)}
+	checkSegmentEvents(t, "out of order", segmentEvents(t, s, "GDAX-BTC-USD"), times)
+	if n := s.Adjusted("GDAX-BTC-USD"); n != 1 {
+		t.Fatalf("%d adjusted events, want 1", n)
+	}
+	if n := s.Adjusted("GDAX-ETH-USD"); n != 0 {
+		t.Fatalf("%d adjusted events of an unknown product", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the last event is read back from the segment after a restart
+	if s, err = OpenSegment(path, false); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Append("GDAX-BTC-USD", Event{Time: now, Data: []byte{4}}); err != nil {
+		t.Fatal(err)
+	}
+	times = append(times, now.Add(3*time.Second+time.Nanosecond))
+	checkSegmentEvents(t, "after a restart", segmentEvents(t, s, "GDAX-BTC-USD"), times)
+	if n := s.Adjusted("GDAX-BTC-USD"); n != 1 {
+		t.Fatalf("%d adjusted events after a restart, want 1", n)
+	}
+}
+
+func TestSegmentPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments")
+	s, err := OpenSegment(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1550671200, 0)
+	times := []time.Time{now, now.Add(time.Second)}
+	if err := s.Append("GDAX-BTC-USD", Event{Time: times[0], Data: []byte{0}}, Event{Time: times[1], Data: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a record cut off by a crash, its header promises more data than written
+	segments, err := s.segments("GDAX-BTC-USD")
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("segments %v %v", segments, err)
+	}
+	info, err := os.Stat(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header [segmentRecordSize]byte
+	binary.BigEndian.PutUint64(header[:], uint64(now.Add(2*time.Second).UnixNano()))
+	binary.BigEndian.PutUint32(header[8:], 100)
+	file.Write(header[:])
+	file.Write([]byte{9, 9, 9})
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers ignore the partial record
+	reader, err := OpenSegment(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSegmentEvents(t, "read only", segmentEvents(t, reader, "GDAX-BTC-USD"), times)
+	if err := reader.Append("GDAX-BTC-USD", Event{Time: now, Data: []byte{2}}); err != ErrReadOnly {
+		t.Fatalf("append to a read only store: %v", err)
+	}
+
+	// the writer truncates it and continues after the last complete record
+	if s, err = OpenSegment(path, false); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Append("GDAX-BTC-USD", Event{Time: now.Add(3 * time.Second), Data: []byte{2}}); err != nil {
+		t.Fatal(err)
+	}
+	times = append(times, now.Add(3*time.Second))
+	checkSegmentEvents(t, "after truncating", segmentEvents(t, s, "GDAX-BTC-USD"), times)
+	if after, err := os.Stat(segments[0]); err != nil || after.Size() != info.Size()+segmentRecordSize+1 {
+		t.Fatalf("segment of %d bytes after appending to %d, %v", after.Size(), info.Size(), err)
+	}
+	if n := s.Adjusted("GDAX-BTC-USD"); n != 0 {
+		t.Fatalf("%d adjusted events", n)
+	}
+}
@@ -0,0 +1,71 @@
+// Package store persists the recorded packets of each product as a time
+// ordered log of events.
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("product not found")
+	ErrReadOnly = errors.New("store is read-only")
+)
+
+// Event is one stored record, Data is an orderbook packet or rollup stats.
+// Seq orders events of the same nanosecond, it is set by the store.
+type Event struct {
+	Time time.Time
+	Seq  uint32
+	Data []byte
+}
+
+// Key is the position of an event, events are ordered by Time and then Seq.
+type Key struct {
+	Time time.Time
+	Seq  uint32
+}
+
+func (e Event) Key() Key {
+	return Key{Time: e.Time, Seq: e.Seq}
+}
+
+// Before compares by nanosecond and then Seq, like the keys of the bolt store.
+func (k Key) Before(o Key) bool {
+	if k.Time.UnixNano() != o.Time.UnixNano() {
+		return k.Time.UnixNano() < o.Time.UnixNano()
+	}
+	return k.Seq < o.Seq
+}
+
+// Store is implemented by every storage backend. Events of a product are
+// ordered by time, events with the same time keep their append order.
+type Store interface {
+	// Append adds events to product, creating it if needed.
+	Append(product string, events ...Event) error
+	// Cursor iterates the events of product, it has to be closed.
+	Cursor(product string) (Cursor, error)
+	Products() ([]string, error)
+	// DeleteBefore removes all events of product before key, Key{Time: t}
+	// removes all events before t.
+	DeleteBefore(product string, key Key) (int, error)
+	SetMeta(key, value string) error
+	Meta(key string) (string, error)
+	Close() error
+}
+
+// Cursor walks the events of one product. All methods return false once
+// they move past the first or last event.
+type Cursor interface {
+	First() (Event, bool)
+	Last() (Event, bool)
+	// Seek moves to the first event at or after t.
+	Seek(t time.Time) (Event, bool)
+	// SeekAfter moves to the first event after key.
+	SeekAfter(key Key) (Event, bool)
+	Next() (Event, bool)
+	Prev() (Event, bool)
+	Close() error
+}
+
+var Backends = []string{"bolt", "segment", "memory"}
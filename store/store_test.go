@@ -0,0 +1,73 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// testStores returns an empty store of every backend.
+func testStores(t *testing.T) map[string]Store {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "store.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg, err := OpenSegment(filepath.Join(t.TempDir(), "segments"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{"bolt": NewBolt(db), "segment": seg, "memory": NewMemory()}
+}
+
+func TestCursorSeekAfter(t *testing.T) {
+	now := time.Unix(1550671200, 0)
+	for name, st := range testStores(t) {
+		// three events in the same nanosecond
+		err := st.Append("GDAX-BTC-USD",
+			Event{Time: now, Data: []byte{0}},
+			Event{Time: now.Add(time.Second), Data: []byte{1}},
+			Event{Time: now.Add(time.Second), Data: []byte{2}},
+			Event{Time: now.Add(time.Second), Data: []byte{3}},
+			Event{Time: now.Add(2 * time.Second), Data: []byte{4}},
+		)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		c, err := st.Cursor("GDAX-BTC-USD")
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		keys := []Key{}
+		for event, ok := c.First(); ok; event, ok = c.Next() {
+			keys = append(keys, event.Key())
+		}
+		if len(keys) != 5 {
+			t.Fatalf("%s: %d events, want 5", name, len(keys))
+		}
+
+		for i, key := range keys {
+			event, ok := c.SeekAfter(key)
+			if i == len(keys)-1 {
+				if ok {
+					t.Fatalf("%s: event %v after the last key", name, event.Data)
+				}
+				continue
+			}
+			if !ok || event.Data[0] != byte(i+1) {
+				t.Fatalf("%s: after event %d got %v %v, want %d", name, i, ok, event.Data, i+1)
+			}
+			// the cursor continues from there
+			if event, ok = c.Next(); i+2 < len(keys) && (!ok || event.Data[0] != byte(i+2)) {
+				t.Fatalf("%s: next after event %d got %v %v, want %d", name, i+1, ok, event.Data, i+2)
+			}
+		}
+		if event, ok := c.SeekAfter(Key{}); !ok || event.Data[0] != 0 {
+			t.Fatalf("%s: after the zero key got %v %v, want the first event", name, ok, event.Data)
+		}
+		c.Close()
+		st.Close()
+	}
+}
@@ -2,11 +2,10 @@ package util
 
 import (
 	"fmt"
-	"math"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
 )
 
 type BatchChunk struct {
@@ -21,15 +20,33 @@ type BookBatchWrite struct {
 	LastDiffSeq uint64
 	Count       int
 	Batch       []*BatchChunk
+	Policy      CheckpointPolicy
+	Compress    bool
+	// diffs written since the last sync and their stored size
+	Diffs     int
+	DiffBytes int
 }
 
+func NewBookBatchWrite(policy CheckpointPolicy) *BookBatchWrite {
+	return &BookBatchWrite{Count: 0, Batch: []*BatchChunk{}, Policy: policy}
+}
+
+// NextSync reports whether the checkpoint policy wants a sync packet, the
+// first write is always a sync.
 func (p *BookBatchWrite) NextSync(now time.Time) bool {
-	return math.Mod(float64(p.Count), 600) == 0
-	/*
-		if now.Sub(p.LastSync).Seconds() >= 60.0 {
-			p.LastSync = now
-		}
-	*/
+	if p.LastSync.IsZero() {
+		return true
+	}
+	if p.Policy.Interval > 0 && now.Sub(p.LastSync) >= p.Policy.Interval {
+		return true
+	}
+	if p.Policy.Diffs > 0 && p.Diffs >= p.Policy.Diffs {
+		return true
+	}
+	if p.Policy.Bytes > 0 && p.DiffBytes >= p.Policy.Bytes {
+		return true
+	}
+	return false
 }
 
 func (p *BookBatchWrite) NextDiff(now time.Time) bool {
@@ -57,34 +74,46 @@ func (p *BookBatchWrite) Clear() {
 	p.Batch = []*BatchChunk{}
 }
 
-func (p *BookBatchWrite) Write(db *bolt.DB, now time.Time, bucket string, buf []byte) {
-	p.AddChunk(&BatchChunk{Time: now, Data: buf})
+func (p *BookBatchWrite) Write(st store.Store, now time.Time, bucket string, buf []byte) {
+	data := buf
+	if p.Compress {
+		data = orderbook.CompressPacket(buf)
+	}
+	p.AddChunk(&BatchChunk{Time: now, Data: data})
+
+	packetType, _ := orderbook.PacketType(buf)
+	switch packetType {
+	case orderbook.SyncPacket:
+		p.LastSync = now
+		p.Diffs = 0
+		p.DiffBytes = 0
+	case orderbook.DiffPacket:
+		p.Diffs += 1
+		// the bytes limit is about the stored size
+		p.DiffBytes += len(data)
+	}
 
 	if p.FlushBatch(now) {
-		db.Update(func(tx *bolt.Tx) error {
-			var err error
-			var key []byte
-			b := tx.Bucket([]byte(bucket))
-			b.FillPercent = 0.9
-			for _, chunk := range p.Batch {
-				nano := chunk.Time.UnixNano()
-				// windows system clock resolution https://github.com/golang/go/issues/8687
-				for {
-					key = orderbook.PackUnixNanoKey(nano)
-					if b.Get(key) == nil {
-						break
-					} else {
-						nano += 1
-					}
-				}
-				err = b.Put(key, chunk.Data)
-				if err != nil {
-					fmt.Println("HandleMessage DB Error", err)
-				}
-			}
-			return err
-		})
-		//fmt.Println("flush batch chunks", len(p.Batch))
-		p.Clear()
+		p.Flush(st, bucket)
+	}
+}
+
+// Flush writes all pending chunks to bucket, regardless of the batch interval.
+func (p *BookBatchWrite) Flush(st store.Store, bucket string) error {
+	if len(p.Batch) == 0 {
+		return nil
+	}
+
+	events := make([]store.Event, 0, len(p.Batch))
+	for _, chunk := range p.Batch {
+		events = append(events, store.Event{Time: chunk.Time, Data: chunk.Data})
 	}
+	err := st.Append(bucket, events...)
+	if err != nil {
+		fmt.Println("HandleMessage DB Error", err)
+	}
+	//fmt.Println("flush batch chunks", len(p.Batch))
+	p.Clear()
+
+	return err
 }
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func TestBatchWriteCountsStoredBytes(t *testing.T) {
+	st := store.NewMemory()
+	batch := NewBookBatchWrite(CheckpointPolicy{Bytes: 1024})
+	batch.Compress = true
+
+	now := time.Unix(1550671200, 0)
+	batch.Write(st, now, "GDAX-BTC-USD", orderbook.PackPacket(orderbook.SyncPacket, make([]byte, 64)))
+	// diffs of 4kb each that compress to a few bytes
+	raw := orderbook.PackPacket(orderbook.DiffPacket, make([]byte, 4096))
+	for i := 1; i <= 3; i++ {
+		batch.Write(st, now.Add(time.Duration(i)*time.Millisecond), "GDAX-BTC-USD", raw)
+	}
+
+	// the sync was flushed right away, the diffs are still buffered
+	stored := 0
+	for _, chunk := range batch.Batch {
+		stored += len(chunk.Data)
+	}
+	if stored >= len(raw) {
+		t.Fatalf("diffs were not compressed, %d bytes stored", stored)
+	}
+	if batch.DiffBytes != stored {
+		t.Fatalf("DiffBytes %d, stored %d", batch.DiffBytes, stored)
+	}
+	if batch.NextSync(now.Add(time.Second)) {
+		t.Fatalf("sync due after %d stored bytes with a limit of 1024", stored)
+	}
+}
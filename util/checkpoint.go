@@ -0,0 +1,105 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// CheckpointPolicy decides when a full sync packet is written instead of a
+// diff. A sync is due when any of the enabled limits since the last sync is
+// reached, zero disables a limit. Bytes is the stored size of the diffs, after
+// compression.
+type CheckpointPolicy struct {
+	Interval time.Duration
+	Diffs    int
+	Bytes    int
+}
+
+var DefaultCheckpointPolicy = CheckpointPolicy{Interval: time.Minute, Diffs: 600}
+
+func (p CheckpointPolicy) String() string {
+	parts := []string{}
+	if p.Interval != 0 {
+		parts = append(parts, "time="+p.Interval.String())
+	}
+	if p.Diffs != 0 {
+		parts = append(parts, "diffs="+strconv.Itoa(p.Diffs))
+	}
+	if p.Bytes != 0 {
+		parts = append(parts, "bytes="+strconv.Itoa(p.Bytes))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseCheckpointPolicy parses "time=60s,diffs=600,bytes=1048576", any subset.
+func ParseCheckpointPolicy(value string) (CheckpointPolicy, error) {
+	var p CheckpointPolicy
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid checkpoint policy %q", part)
+		}
+		var err error
+		switch kv[0] {
+		case "time":
+			p.Interval, err = time.ParseDuration(kv[1])
+		case "diffs":
+			p.Diffs, err = strconv.Atoi(kv[1])
+		case "bytes":
+			p.Bytes, err = strconv.Atoi(kv[1])
+		default:
+			err = fmt.Errorf("unknown limit %s", kv[0])
+		}
+		if err != nil {
+			return p, fmt.Errorf("invalid checkpoint policy %q: %s", part, err)
+		}
+	}
+	if p.Interval <= 0 && p.Diffs <= 0 && p.Bytes <= 0 {
+		return p, fmt.Errorf("checkpoint policy %q has no limit", value)
+	}
+	return p, nil
+}
+
+// ParseCheckpointPolicies parses a default policy and per exchange overrides,
+// "time=60s;binance:diffs=300;gdax:time=30s,bytes=65536". Exchange names are lowercased.
+func ParseCheckpointPolicies(value string) (CheckpointPolicy, map[string]CheckpointPolicy, error) {
+	def := DefaultCheckpointPolicy
+	policies := map[string]CheckpointPolicy{}
+	if value == "" {
+		return def, policies, nil
+	}
+	for _, part := range strings.Split(value, ";") {
+		name := ""
+		if i := strings.Index(part, ":"); i != -1 {
+			name, part = strings.ToLower(part[:i]), part[i+1:]
+		}
+		p, err := ParseCheckpointPolicy(part)
+		if err != nil {
+			return def, nil, err
+		}
+		if name == "" {
+			def = p
+		} else {
+			policies[name] = p
+		}
+	}
+	return def, policies, nil
+}
+
+func checkpointMetaKey(bucket string) string {
+	return "checkpoint/" + bucket
+}
+
+// RecordCheckpointPolicy stores the policy a bucket is recorded with in the store metadata.
+func RecordCheckpointPolicy(st store.Store, bucket string, p CheckpointPolicy) error {
+	return st.SetMeta(checkpointMetaKey(bucket), p.String())
+}
+
+func CheckpointPolicyOf(st store.Store, bucket string) string {
+	value, _ := st.Meta(checkpointMetaKey(bucket))
+	return value
+}
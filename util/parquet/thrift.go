@@ -0,0 +1,98 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// minimal thrift compact protocol encoder, only what the parquet footer needs
+
+const (
+	compactBoolTrue = 1
+	compactI32      = 5
+	compactI64      = 6
+	compactBinary   = 8
+	compactList     = 9
+	compactStruct   = 12
+)
+
+type compactWriter struct {
+	buf    bytes.Buffer
+	fields []int16 // last field id per open struct
+}
+
+func (w *compactWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	w.buf.Write(tmp[:n])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (w *compactWriter) structBegin() {
+	w.fields = append(w.fields, 0)
+}
+
+func (w *compactWriter) structEnd() {
+	w.buf.WriteByte(0) // stop
+	w.fields = w.fields[:len(w.fields)-1]
+}
+
+func (w *compactWriter) field(id int16, fieldType byte) {
+	last := &w.fields[len(w.fields)-1]
+	delta := id - *last
+	if delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buf.WriteByte(fieldType)
+		w.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.field(id, compactI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.field(id, compactI64)
+	w.varint(zigzag(v))
+}
+
+func (w *compactWriter) str(id int16, v string) {
+	w.field(id, compactBinary)
+	w.varint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *compactWriter) boolTrue(id int16) {
+	w.field(id, compactBoolTrue)
+}
+
+func (w *compactWriter) listBegin(id int16, elemType byte, size int) {
+	w.field(id, compactList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.varint(uint64(size))
+	}
+}
+
+func (w *compactWriter) listI32(v int32) {
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) listStr(v string) {
+	w.varint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+// structField begins a nested struct as field id, close it with structEnd.
+func (w *compactWriter) structField(id int16) {
+	w.field(id, compactStruct)
+	w.structBegin()
+}
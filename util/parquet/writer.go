@@ -0,0 +1,349 @@
+// Package parquet writes flat tables of required columns as Apache Parquet files,
+// with PLAIN encoding, gzip compressed pages and one data page per column chunk.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+type ColumnType int
+
+const (
+	Int64 ColumnType = iota
+	Double
+	String
+	Timestamp // stored as microseconds since epoch, UTC
+)
+
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Codec is the compression of the data pages, the values of parquet.thrift.
+type Codec int32
+
+const (
+	Uncompressed Codec = 0
+	Gzip         Codec = 2
+)
+
+// parquet.thrift enums
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionRequired = 0
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageTypeData = 0
+)
+
+const DefaultRowGroupSize = 100000
+
+type columnChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+	values           int64
+	codec            Codec
+}
+
+type rowGroup struct {
+	rows    int64
+	size    int64
+	columns []columnChunk
+}
+
+type Writer struct {
+	RowGroupSize int
+	// Compression of the pages written from now on, Gzip by default
+	Compression Codec
+
+	w         io.Writer
+	offset    int64
+	columns   []Column
+	buffers   []*bytes.Buffer
+	rows      int64
+	totalRows int64
+	groups    []rowGroup
+	closed    bool
+}
+
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	p := &Writer{
+		RowGroupSize: DefaultRowGroupSize,
+		Compression:  Gzip,
+		w:            w,
+		columns:      columns,
+		buffers:      make([]*bytes.Buffer, len(columns)),
+	}
+	for i := range p.buffers {
+		p.buffers[i] = new(bytes.Buffer)
+	}
+	if err := p.write([]byte("PAR1")); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Writer) write(buf []byte) error {
+	n, err := p.w.Write(buf)
+	p.offset += int64(n)
+	return err
+}
+
+// check returns an error if v can not be stored in the column.
+func (c Column) check(v interface{}) error {
+	var ok bool
+	var want string
+	switch c.Type {
+	case Int64:
+		_, ok = v.(int64)
+		want = "int64"
+	case Timestamp:
+		_, ok = v.(time.Time)
+		want = "time.Time"
+	case Double:
+		_, ok = v.(float64)
+		want = "float64"
+	case String:
+		_, ok = v.(string)
+		want = "string"
+	}
+	if !ok {
+		return fmt.Errorf("parquet: column %s wants %s, got %T", c.Name, want, v)
+	}
+	return nil
+}
+
+// Write appends one row, values must match the column types:
+// int64, float64, string or time.Time. A row with a wrong value is not
+// written at all, so all columns keep the same number of values.
+func (p *Writer) Write(values ...interface{}) error {
+	if len(values) != len(p.columns) {
+		return fmt.Errorf("parquet: row has %d values, want %d", len(values), len(p.columns))
+	}
+	for i, column := range p.columns {
+		if err := column.check(values[i]); err != nil {
+			return err
+		}
+	}
+
+	var tmp [8]byte
+	for i, column := range p.columns {
+		buf := p.buffers[i]
+		switch column.Type {
+		case Int64:
+			binary.LittleEndian.PutUint64(tmp[:], uint64(values[i].(int64)))
+			buf.Write(tmp[:])
+		case Timestamp:
+			v := values[i].(time.Time)
+			binary.LittleEndian.PutUint64(tmp[:], uint64(v.UnixNano()/int64(time.Microsecond)))
+			buf.Write(tmp[:])
+		case Double:
+			binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(values[i].(float64)))
+			buf.Write(tmp[:])
+		case String:
+			v := values[i].(string)
+			binary.LittleEndian.PutUint32(tmp[:4], uint32(len(v)))
+			buf.Write(tmp[:4])
+			buf.WriteString(v)
+		}
+	}
+
+	p.rows += 1
+	if p.rows >= int64(p.RowGroupSize) {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+func (p *Writer) flushRowGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+
+	group := rowGroup{rows: p.rows}
+	for _, buf := range p.buffers {
+		page, err := p.compress(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		header := pageHeader(buf.Len(), len(page), p.rows)
+		chunk := columnChunk{
+			offset:           p.offset,
+			uncompressedSize: int64(len(header) + buf.Len()),
+			compressedSize:   int64(len(header) + len(page)),
+			values:           p.rows,
+			codec:            p.Compression,
+		}
+		if err := p.write(header); err != nil {
+			return err
+		}
+		if err := p.write(page); err != nil {
+			return err
+		}
+		buf.Reset()
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.uncompressedSize
+	}
+
+	p.groups = append(p.groups, group)
+	p.totalRows += p.rows
+	p.rows = 0
+	return nil
+}
+
+// compress returns the page data in the codec of the writer.
+func (p *Writer) compress(data []byte) ([]byte, error) {
+	switch p.Compression {
+	case Uncompressed:
+		return data, nil
+	case Gzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("parquet: unsupported codec %d", p.Compression)
+}
+
+func pageHeader(size, compressedSize int, rows int64) []byte {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32(1, pageTypeData)
+	w.i32(2, int32(size))           // uncompressed_page_size
+	w.i32(3, int32(compressedSize)) // compressed_page_size
+	w.structField(5)                // data_page_header
+	w.i32(1, int32(rows))
+	w.i32(2, encodingPlain)
+	w.i32(3, encodingRLE) // definition levels, none for required columns
+	w.i32(4, encodingRLE) // repetition levels
+	w.structEnd()
+	w.structEnd()
+	return w.buf.Bytes()
+}
+
+func (p *Writer) physicalType(column Column) int32 {
+	switch column.Type {
+	case Double:
+		return typeDouble
+	case String:
+		return typeByteArray
+	}
+	return typeInt64
+}
+
+func (p *Writer) footer() []byte {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32(1, 1) // version
+
+	// schema, root element followed by the columns
+	w.listBegin(2, compactStruct, len(p.columns)+1)
+	w.structBegin()
+	w.str(4, "schema")
+	w.i32(5, int32(len(p.columns)))
+	w.structEnd()
+	for _, column := range p.columns {
+		w.structBegin()
+		w.i32(1, p.physicalType(column))
+		w.i32(3, repetitionRequired)
+		w.str(4, column.Name)
+		switch column.Type {
+		case String:
+			w.i32(6, convertedUTF8)
+			w.structField(10) // logicalType
+			w.structField(1)  // STRING
+			w.structEnd()
+			w.structEnd()
+		case Timestamp:
+			w.i32(6, convertedTimestampMicros)
+			w.structField(10) // logicalType
+			w.structField(8)  // TIMESTAMP
+			w.boolTrue(1)     // isAdjustedToUTC
+			w.structField(2)  // unit
+			w.structField(2)  // MICROS
+			w.structEnd()
+			w.structEnd()
+			w.structEnd()
+			w.structEnd()
+		}
+		w.structEnd()
+	}
+
+	w.i64(3, p.totalRows)
+
+	w.listBegin(4, compactStruct, len(p.groups))
+	for _, group := range p.groups {
+		w.structBegin()
+		w.listBegin(1, compactStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			w.structBegin()
+			w.i64(2, chunk.offset) // file_offset
+			w.structField(3)       // meta_data
+			w.i32(1, p.physicalType(p.columns[i]))
+			w.listBegin(2, compactI32, 2)
+			w.listI32(encodingPlain)
+			w.listI32(encodingRLE)
+			w.listBegin(3, compactBinary, 1)
+			w.listStr(p.columns[i].Name)
+			w.i32(4, int32(chunk.codec))
+			w.i64(5, chunk.values)
+			w.i64(6, chunk.uncompressedSize)
+			w.i64(7, chunk.compressedSize)
+			w.i64(9, chunk.offset) // data_page_offset
+			w.structEnd()
+			w.structEnd()
+		}
+		w.i64(2, group.size)
+		w.i64(3, group.rows)
+		w.structEnd()
+	}
+
+	w.str(6, "gdax-bookmap")
+	w.structEnd()
+	return w.buf.Bytes()
+}
+
+// Close writes the pending row group and the file footer. It does not close
+// the underlying writer.
+func (p *Writer) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+
+	footer := p.footer()
+	if err := p.write(footer); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	if err := p.write(size[:]); err != nil {
+		return err
+	}
+	return p.write([]byte("PAR1"))
+}
@@ -0,0 +1,421 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// compactReader decodes thrift compact structs into maps of field id to value.
+type compactReader struct {
+	buf []byte
+	pos int
+	err bool
+}
+
+func (r *compactReader) byte() byte {
+	if r.pos >= len(r.buf) {
+		r.err = true
+		return 0
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.err = true
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) zigzag() int64 {
+	u := r.uvarint()
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *compactReader) value(t byte) interface{} {
+	switch t {
+	case compactBoolTrue:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, compactI32, compactI64:
+		return r.zigzag()
+	case compactBinary:
+		n := int(r.uvarint())
+		if r.pos+n > len(r.buf) {
+			r.err = true
+			return ""
+		}
+		v := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return v
+	case compactList, 10:
+		h := r.byte()
+		size, elem := int(h>>4), h&0x0f
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, 0, size)
+		for i := 0; i < size && !r.err; i++ {
+			list = append(list, r.value(elem))
+		}
+		return list
+	case compactStruct:
+		return r.structure()
+	}
+	r.err = true
+	return nil
+}
+
+func (r *compactReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for !r.err {
+		h := r.byte()
+		if h == 0 {
+			break
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		last = id
+		fields[id] = r.value(h & 0x0f)
+	}
+	return fields
+}
+
+func decode(t *testing.T, buf []byte) (map[int16]interface{}, int) {
+	t.Helper()
+	r := &compactReader{buf: buf}
+	s := r.structure()
+	if r.err {
+		t.Fatalf("invalid thrift struct at %d", r.pos)
+	}
+	return s, r.pos
+}
+
+func field(t *testing.T, s map[int16]interface{}, id int16) interface{} {
+	t.Helper()
+	v, ok := s[id]
+	if !ok {
+		t.Fatalf("field %d missing in %v", id, s)
+	}
+	return v
+}
+
+func list(t *testing.T, s map[int16]interface{}, id int16) []map[int16]interface{} {
+	t.Helper()
+	items := []map[int16]interface{}{}
+	for _, item := range field(t, s, id).([]interface{}) {
+		items = append(items, item.(map[int16]interface{}))
+	}
+	return items
+}
+
+var testColumns = []Column{
+	{Name: "time", Type: Timestamp},
+	{Name: "id", Type: Int64},
+	{Name: "price", Type: Double},
+	{Name: "side", Type: String},
+}
+
+// readFile checks the magic bytes and returns the decoded footer.
+func readFile(t *testing.T, file []byte) map[int16]interface{} {
+	t.Helper()
+	if len(file) < 12 || string(file[:4]) != "PAR1" || string(file[len(file)-4:]) != "PAR1" {
+		t.Fatalf("file does not start and end with PAR1")
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	start := len(file) - 8 - size
+	if start < 4 {
+		t.Fatalf("footer length %d exceeds the file", size)
+	}
+	footer, n := decode(t, file[start:len(file)-8])
+	if n != size {
+		t.Fatalf("footer decodes %d bytes, length says %d", n, size)
+	}
+	return footer
+}
+
+// columnValues reads the values of every row group of a column chunk list,
+// checking the chunk offsets and page headers on the way.
+func columnValues(t *testing.T, file []byte, footer map[int16]interface{}, column int) [][]byte {
+	t.Helper()
+	values := [][]byte{}
+	for _, group := range list(t, footer, 4) {
+		rows := field(t, group, 3).(int64)
+		chunk := list(t, group, 1)[column]
+		offset := field(t, chunk, 2).(int64)
+		meta := field(t, chunk, 3).(map[int16]interface{})
+		if pageOffset := field(t, meta, 9).(int64); pageOffset != offset {
+			t.Fatalf("column %d data page offset %d, chunk offset %d", column, pageOffset, offset)
+		}
+		if n := field(t, meta, 5).(int64); n != rows {
+			t.Fatalf("column %d has %d values in a group of %d rows", column, n, rows)
+		}
+		if name := field(t, meta, 3).([]interface{})[0].(string); name != testColumns[column].Name {
+			t.Fatalf("column %d path %s, want %s", column, name, testColumns[column].Name)
+		}
+		encodings := field(t, meta, 2).([]interface{})
+		if len(encodings) != 2 || encodings[0].(int64) != encodingPlain || encodings[1].(int64) != encodingRLE {
+			t.Fatalf("column %d encodings %v, want PLAIN and RLE", column, encodings)
+		}
+
+		header, n := decode(t, file[offset:])
+		if pageType := field(t, header, 1).(int64); pageType != pageTypeData {
+			t.Fatalf("column %d page type %d", column, pageType)
+		}
+		page := field(t, header, 5).(map[int16]interface{})
+		if n := field(t, page, 1).(int64); n != rows {
+			t.Fatalf("column %d page has %d values, group %d rows", column, n, rows)
+		}
+		if field(t, page, 2).(int64) != encodingPlain || field(t, page, 3).(int64) != encodingRLE || field(t, page, 4).(int64) != encodingRLE {
+			t.Fatalf("column %d page encodings %v", column, page)
+		}
+		size := int(field(t, header, 2).(int64))
+		compressedSize := int(field(t, header, 3).(int64))
+		if total := field(t, meta, 6).(int64); total != int64(n+size) {
+			t.Fatalf("column %d uncompressed chunk size %d, header %d + page %d", column, total, n, size)
+		}
+		if total := field(t, meta, 7).(int64); total != int64(n+compressedSize) {
+			t.Fatalf("column %d compressed chunk size %d, header %d + page %d", column, total, n, compressedSize)
+		}
+
+		data := file[int(offset)+n : int(offset)+n+compressedSize]
+		switch codec := Codec(field(t, meta, 4).(int64)); codec {
+		case Uncompressed:
+		case Gzip:
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("column %d page: %s", column, err)
+			}
+			if data, err = io.ReadAll(zr); err != nil {
+				t.Fatalf("column %d page: %s", column, err)
+			}
+		default:
+			t.Fatalf("column %d codec %d", column, codec)
+		}
+		if len(data) != size {
+			t.Fatalf("column %d page has %d bytes, header says %d", column, len(data), size)
+		}
+		for i := int64(0); i < rows; i++ {
+			width := 8
+			if testColumns[column].Type == String {
+				width = 4 + int(binary.LittleEndian.Uint32(data))
+			}
+			values = append(values, data[:width])
+			data = data[width:]
+		}
+		if len(data) != 0 {
+			t.Fatalf("column %d page has %d bytes left after %d values", column, len(data), rows)
+		}
+	}
+	return values
+}
+
+// checkSchema compares the schema of the footer with testColumns, the root
+// element followed by one required element per column.
+func checkSchema(t *testing.T, footer map[int16]interface{}) {
+	t.Helper()
+	schema := list(t, footer, 2)
+	if len(schema) != len(testColumns)+1 {
+		t.Fatalf("schema has %d elements, want %d", len(schema), len(testColumns)+1)
+	}
+	if children := field(t, schema[0], 5).(int64); children != int64(len(testColumns)) {
+		t.Fatalf("schema root has %d children", children)
+	}
+	want := []struct {
+		physical  int64
+		converted int64
+	}{
+		{typeInt64, convertedTimestampMicros},
+		{typeInt64, -1},
+		{typeDouble, -1},
+		{typeByteArray, convertedUTF8},
+	}
+	for i, element := range schema[1:] {
+		if name := field(t, element, 4).(string); name != testColumns[i].Name {
+			t.Fatalf("schema element %d is %s, want %s", i, name, testColumns[i].Name)
+		}
+		if physical := field(t, element, 1).(int64); physical != want[i].physical {
+			t.Fatalf("column %s type %d, want %d", testColumns[i].Name, physical, want[i].physical)
+		}
+		if repetition := field(t, element, 3).(int64); repetition != repetitionRequired {
+			t.Fatalf("column %s repetition %d", testColumns[i].Name, repetition)
+		}
+		converted, ok := element[6].(int64)
+		if !ok {
+			converted = -1
+		}
+		if converted != want[i].converted {
+			t.Fatalf("column %s converted type %d, want %d", testColumns[i].Name, converted, want[i].converted)
+		}
+	}
+}
+
+func TestWriterFile(t *testing.T) {
+	for _, codec := range []Codec{Uncompressed, Gzip} {
+		testWriterFile(t, codec)
+	}
+}
+
+func testWriterFile(t *testing.T, codec Codec) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RowGroupSize = 2
+	w.Compression = codec
+
+	start := time.Date(2019, 2, 20, 14, 0, 0, 0, time.UTC)
+	sides := []string{"buy", "sell", "buy"}
+	for i, side := range sides {
+		if err := w.Write(start.Add(time.Duration(i)*time.Second), int64(i), 100.5+float64(i), side); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := buf.Bytes()
+	footer := readFile(t, file)
+	if version := field(t, footer, 1).(int64); version != 1 {
+		t.Fatalf("version %d, want 1", version)
+	}
+	if rows := field(t, footer, 3).(int64); rows != 3 {
+		t.Fatalf("num_rows %d, want 3", rows)
+	}
+	checkSchema(t, footer)
+	groups := list(t, footer, 4)
+	if len(groups) != 2 {
+		t.Fatalf("%d row groups, want 2", len(groups))
+	}
+	for i, want := range []int64{2, 1} {
+		if rows := field(t, groups[i], 3).(int64); rows != want {
+			t.Fatalf("row group %d has %d rows, want %d", i, rows, want)
+		}
+		for _, chunk := range list(t, groups[i], 1) {
+			meta := field(t, chunk, 3).(map[int16]interface{})
+			if c := Codec(field(t, meta, 4).(int64)); c != codec {
+				t.Fatalf("row group %d codec %d, want %d", i, c, codec)
+			}
+		}
+	}
+
+	for i, v := range columnValues(t, file, footer, 0) {
+		usec := int64(binary.LittleEndian.Uint64(v))
+		if want := start.Add(time.Duration(i)*time.Second).UnixNano() / 1000; usec != want {
+			t.Fatalf("time %d is %d, want %d", i, usec, want)
+		}
+	}
+	for i, v := range columnValues(t, file, footer, 1) {
+		if id := int64(binary.LittleEndian.Uint64(v)); id != int64(i) {
+			t.Fatalf("id %d is %d", i, id)
+		}
+	}
+	for i, v := range columnValues(t, file, footer, 2) {
+		if price := math.Float64frombits(binary.LittleEndian.Uint64(v)); price != 100.5+float64(i) {
+			t.Fatalf("price %d is %f", i, price)
+		}
+	}
+	for i, v := range columnValues(t, file, footer, 3) {
+		if side := string(v[4:]); side != sides[i] {
+			t.Fatalf("side %d is %s, want %s", i, side, sides[i])
+		}
+	}
+}
+
+func TestWriterRejectsRow(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1550671200, 0)
+	if err := w.Write(now, int64(1), 1.0, "buy"); err != nil {
+		t.Fatal(err)
+	}
+	// the last value is wrong, the ones before must not be written either
+	if err := w.Write(now, int64(2), 2.0, 3); err == nil {
+		t.Fatalf("row with an int in a string column was written")
+	}
+	if err := w.Write(now, int64(2)); err == nil {
+		t.Fatalf("row with missing values was written")
+	}
+	if err := w.Write(now, int64(3), 3.0, "sell"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := buf.Bytes()
+	footer := readFile(t, file)
+	if rows := field(t, footer, 3).(int64); rows != 2 {
+		t.Fatalf("num_rows %d, want 2", rows)
+	}
+	for column := range testColumns {
+		if n := len(columnValues(t, file, footer, column)); n != 2 {
+			t.Fatalf("column %d has %d values, want 2", column, n)
+		}
+	}
+}
+
+func TestWriterCompression(t *testing.T) {
+	sizes := map[Codec]int{}
+	for _, codec := range []Codec{Uncompressed, Gzip} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, testColumns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Compression = codec
+		now := time.Unix(1550671200, 0)
+		for i := 0; i < 1000; i++ {
+			if err := w.Write(now.Add(time.Duration(i)*time.Millisecond), int64(i), 6543.21, "buy"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for column := range testColumns {
+			if n := len(columnValues(t, buf.Bytes(), readFile(t, buf.Bytes()), column)); n != 1000 {
+				t.Fatalf("codec %d column %d has %d values, want 1000", codec, column, n)
+			}
+		}
+		sizes[codec] = buf.Len()
+	}
+	if sizes[Gzip]*4 > sizes[Uncompressed] {
+		t.Fatalf("gzip file is %d bytes, uncompressed %d", sizes[Gzip], sizes[Uncompressed])
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Compression = 1 // snappy
+	if err := w.Write(time.Unix(1550671200, 0), int64(1), 1.0, "buy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatalf("unsupported codec was written")
+	}
+}
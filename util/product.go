@@ -0,0 +1,36 @@
+package util
+
+import (
+	"encoding/json"
+
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func productInfoMetaKey(bucket string) string {
+	return "product_info/" + bucket
+}
+
+// RecordProductInfo stores the product info of a bucket in the store
+// metadata, a replay reads it instead of asking the exchange.
+func RecordProductInfo(st store.Store, info product_info.Info) error {
+	buf, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return st.SetMeta(productInfoMetaKey(info.DatabaseKey), string(buf))
+}
+
+// ProductInfoOf returns the product info recorded with a bucket, false for
+// buckets recorded before product infos were stored.
+func ProductInfoOf(st store.Store, bucket string) (product_info.Info, bool) {
+	var info product_info.Info
+	value, err := st.Meta(productInfoMetaKey(bucket))
+	if err != nil || value == "" {
+		return info, false
+	}
+	if err := json.Unmarshal([]byte(value), &info); err != nil {
+		return info, false
+	}
+	return info, true
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// ReplayBucket rebuilds book from the last sync packet before from and walks all
+// records of bucket until to (zero means until the end). fn is called for every
+// record at or after from, before it is applied to book. err is set if the
+// record could not be decoded, pkt is nil in that case.
+func ReplayBucket(st store.Store, bucket string, from, to time.Time, book *orderbook.Book, fn func(t time.Time, pkt *orderbook.Packet, err error) error) error {
+	c, err := st.Cursor(bucket)
+	if err != nil {
+		return fmt.Errorf("bucket %s: %s", bucket, err)
+	}
+	defer c.Close()
+
+	event, ok := c.Seek(from)
+	if !ok {
+		event, ok = c.Last()
+	}
+	for ok && !orderbook.IsSyncPacket(event.Data) {
+		event, ok = c.Prev()
+	}
+
+	if !ok {
+		// nothing to start from before from, use the first sync after it
+		event, ok = c.Seek(from)
+		for ok && !orderbook.IsSyncPacket(event.Data) {
+			event, ok = c.Next()
+		}
+		if !ok {
+			return fmt.Errorf("bucket %s has no sync packet", bucket)
+		}
+	}
+
+	for ; ok; event, ok = c.Next() {
+		t := event.Time
+		if !to.IsZero() && t.After(to) {
+			break
+		}
+
+		pkt, err := orderbook.DecodePacket(event.Data)
+
+		if !t.Before(from) {
+			if ferr := fn(t, pkt, err); ferr != nil {
+				return ferr
+			}
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if err := book.Apply(t, pkt); err != nil {
+			log.Println(bucket, "Process Error", t, err)
+		}
+	}
+
+	return nil
+}
+
+// ListBuckets returns the product buckets of st, rollup buckets are skipped.
+func ListBuckets(st store.Store) ([]string, error) {
+	products, err := st.Products()
+	if err != nil {
+		return nil, err
+	}
+	buckets := []string{}
+	for _, name := range products {
+		if !IsRollupBucket(name) {
+			buckets = append(buckets, name)
+		}
+	}
+	return buckets, nil
+}
+
+// TimeSource selects the clock that places replayed records on a timeline.
+type TimeSource uint8
+
+const (
+	// LocalTime is the receive time the record is keyed by
+	LocalTime TimeSource = iota
+	// ExchangeTime is the exchange timestamp of the record, records without
+	// one fall back to their local time
+	ExchangeTime
+)
+
+var TimeSources = []string{"local", "exchange"}
+
+func ParseTimeSource(value string) (TimeSource, error) {
+	for i, name := range TimeSources {
+		if value == name {
+			return TimeSource(i), nil
+		}
+	}
+	return LocalTime, fmt.Errorf("unknown clock %q", value)
+}
+
+func (s TimeSource) String() string {
+	if int(s) < len(TimeSources) {
+		return TimeSources[s]
+	}
+	return fmt.Sprintf("TimeSource(%d)", s)
+}
+
+// EventTime returns the time of the record keyed by t on the timeline of s.
+func (s TimeSource) EventTime(t time.Time, pkt *orderbook.Packet) time.Time {
+	if s == ExchangeTime && pkt != nil && !pkt.ExchangeTime.IsZero() {
+		return pkt.ExchangeTime
+	}
+	return t
+}
@@ -0,0 +1,143 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	exchange "github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func TestParseTimeSource(t *testing.T) {
+	cases := []struct {
+		value  string
+		source TimeSource
+		err    bool
+	}{
+		{value: "local", source: LocalTime},
+		{value: "exchange", source: ExchangeTime},
+		{value: "", err: true},
+		{value: "Exchange", err: true},
+		{value: "utc", err: true},
+	}
+	for _, c := range cases {
+		source, err := ParseTimeSource(c.value)
+		if (err != nil) != c.err {
+			t.Fatalf("%q: error %v", c.value, err)
+		}
+		if !c.err && (source != c.source || source.String() != c.value) {
+			t.Fatalf("%q: parsed %s", c.value, source)
+		}
+	}
+}
+
+func TestEventTime(t *testing.T) {
+	local := time.Unix(1550671200, 0)
+	exchange := local.Add(-250 * time.Millisecond)
+	cases := []struct {
+		name   string
+		source TimeSource
+		pkt    *orderbook.Packet
+		want   time.Time
+	}{
+		{"local clock", LocalTime, &orderbook.Packet{ExchangeTime: exchange}, local},
+		{"exchange clock", ExchangeTime, &orderbook.Packet{ExchangeTime: exchange}, exchange},
+		{"exchange clock without timestamp", ExchangeTime, &orderbook.Packet{}, local},
+		{"exchange clock with undecodable record", ExchangeTime, nil, local},
+		{"exchange timestamp after the receive time", ExchangeTime, &orderbook.Packet{ExchangeTime: local.Add(time.Second)}, local.Add(time.Second)},
+	}
+	for _, c := range cases {
+		if got := c.source.EventTime(local, c.pkt); !got.Equal(c.want) {
+			t.Fatalf("%s: %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+// replayState is the book and packet a ReplayBucket callback sees.
+type replayState struct {
+	Time  time.Time
+	Pkt   *orderbook.Packet
+	Stats *orderbook.BookMapStatsCopy
+}
+
+func replayStates(t *testing.T, st store.Store, bucket string) []replayState {
+	t.Helper()
+	book := orderbook.New(bucket)
+	states := []replayState{}
+	err := ReplayBucket(st, bucket, time.Time{}, time.Time{}, book, func(tm time.Time, pkt *orderbook.Packet, err error) error {
+		if err != nil {
+			t.Fatalf("%s at %s: %s", bucket, tm, err)
+		}
+		pkt.Version = 0
+		states = append(states, replayState{Time: tm, Pkt: pkt, Stats: book.StateAsStats()})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(states, replayState{Stats: book.StateAsStats()})
+}
+
+func TestReplayMixedCompression(t *testing.T) {
+	book := exchange.New("BTC-USDT")
+	book.PriceScale, book.SizeScale = 2, 8
+	start := time.Unix(1550671200, 0)
+	records := []store.Event{}
+	for i := 0; i < 50; i++ {
+		book.UpdateBidLevel(start, fixed.Value(10000-i), fixed.Value(100000000+i))
+		book.UpdateAskLevel(start, fixed.Value(10001+i), fixed.Value(100000000+i))
+	}
+	records = append(records, store.Event{Time: start, Data: exchange.PackSync(book)})
+	for i := 1; i <= 20; i++ {
+		now := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		book.ResetDiff()
+		for j := 0; j < i; j++ {
+			book.UpdateBidLevel(now, fixed.Value(10000-j), fixed.Value(200000000+i))
+		}
+		records = append(records, store.Event{Time: now, Data: exchange.PackDiff(book, uint64(i), uint64(i))})
+		book.AddTakerTrade(now, exchange.TakerSide(i%2 == 0), 10001, 1000000, uint64(i), now)
+		records = append(records, store.Event{Time: now, Data: exchange.PackTrade(book, book.Trades[len(book.Trades)-1])})
+	}
+
+	// every other record compressed, records that do not get smaller stay
+	// uncompressed
+	mixed := make([]store.Event, len(records))
+	compressed := 0
+	for i, record := range records {
+		mixed[i] = record
+		if i%2 == 0 {
+			mixed[i].Data = orderbook.CompressPacket(record.Data)
+		}
+		if version, _, _, _ := orderbook.UnpackPacket(mixed[i].Data); orderbook.CompressedVersion(version) {
+			compressed += 1
+		}
+	}
+	if compressed == 0 || compressed == len(mixed) {
+		t.Fatalf("%d of %d records compressed", compressed, len(mixed))
+	}
+
+	st := store.NewMemory()
+	defer st.Close()
+	if err := st.Append("Binance-BTC-USDT", records...); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Append("Binance-BTC-USDT-mixed", mixed...); err != nil {
+		t.Fatal(err)
+	}
+	want := replayStates(t, st, "Binance-BTC-USDT")
+	got := replayStates(t, st, "Binance-BTC-USDT-mixed")
+	if len(want) != len(records)+1 || len(got) != len(want) {
+		t.Fatalf("replayed %d mixed and %d uncompressed records, want %d", len(got)-1, len(want)-1, len(records))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("record %d replayed to\n%+v\nwant\n%+v", i, got[i], want[i])
+		}
+	}
+	if last := got[len(got)-1].Stats; len(last.Bid) != 50 || len(last.Ask) != 50 {
+		t.Fatalf("replayed book has %d bids %d asks", len(last.Bid), len(last.Ask))
+	}
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// Retention is the max age of records per bucket, zero keeps everything.
+type Retention struct {
+	Default time.Duration
+	Buckets map[string]time.Duration
+}
+
+// ParseRetention parses "168h" or "168h,Binance-BTC-USDT=24h,GDAX-BTC-USD=0".
+func ParseRetention(value string) (*Retention, error) {
+	r := &Retention{Buckets: map[string]time.Duration{}}
+	if value == "" {
+		return r, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		bucket := ""
+		age := part
+		if i := strings.Index(part, "="); i != -1 {
+			bucket, age = part[:i], part[i+1:]
+		}
+		d, err := time.ParseDuration(age)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention %q: %s", part, err)
+		}
+		if bucket == "" {
+			r.Default = d
+		} else {
+			r.Buckets[bucket] = d
+		}
+	}
+	return r, nil
+}
+
+func (r *Retention) MaxAge(bucket string) time.Duration {
+	if d, ok := r.Buckets[bucket]; ok {
+		return d
+	}
+	return r.Default
+}
+
+func (r *Retention) Enabled() bool {
+	if r.Default != 0 {
+		return true
+	}
+	for _, d := range r.Buckets {
+		if d != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune applies the retention to all buckets of st.
+func (r *Retention) Prune(st store.Store, now time.Time) (int, error) {
+	buckets, err := ListBuckets(st)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, bucket := range buckets {
+		age := r.MaxAge(bucket)
+		if age == 0 {
+			continue
+		}
+		n, err := PruneBucket(st, bucket, now.Add(-age))
+		total += n
+		if err != nil {
+			return total, err
+		}
+		for _, tier := range RollupTiers(st, bucket) {
+			n, err := st.DeleteBefore(RollupBucket(bucket, tier), store.Key{Time: now.Add(-age)})
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// Run prunes st every interval until ctx is done.
+func (r *Retention) Run(ctx context.Context, st store.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := r.Prune(st, time.Now())
+		if err != nil {
+			log.Println("Retention Error", err)
+		} else if n > 0 {
+			log.Println("retention removed", n, "records")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneBucket deletes all records of bucket before the last sync packet at or
+// before cutoff, so the oldest remaining record is always a sync packet, even
+// when other records share its nanosecond.
+// Buckets without such a sync packet are left untouched.
+func PruneBucket(st store.Store, bucket string, cutoff time.Time) (int, error) {
+	c, err := st.Cursor(bucket)
+	if err != nil {
+		return 0, fmt.Errorf("bucket %s: %s", bucket, err)
+	}
+
+	event, ok := c.Seek(cutoff)
+	if !ok {
+		event, ok = c.Last()
+	} else if event.Time.After(cutoff) {
+		event, ok = c.Prev()
+	}
+	for ok && !orderbook.IsSyncPacket(event.Data) {
+		event, ok = c.Prev()
+	}
+	// release the cursor before deleting, bolt can not write while it is open
+	c.Close()
+
+	if !ok {
+		return 0, nil
+	}
+	return st.DeleteBefore(bucket, event.Key())
+}
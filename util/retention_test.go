@@ -0,0 +1,56 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func TestPruneBucketSharedNanosecond(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "retention.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]store.Store{
+		"bolt":   store.NewBolt(db),
+		"memory": store.NewMemory(),
+	}
+
+	start := time.Unix(1550671200, 0)
+	for name, st := range stores {
+		// a trade and the following sync are stored in the same nanosecond
+		err := st.Append("GDAX-BTC-USD",
+			store.Event{Time: start, Data: []byte{orderbook.SyncPacket}},
+			store.Event{Time: start.Add(time.Second), Data: []byte{orderbook.TradePacket}},
+			store.Event{Time: start.Add(time.Second), Data: []byte{orderbook.SyncPacket}},
+			store.Event{Time: start.Add(2 * time.Second), Data: []byte{orderbook.DiffPacket}},
+		)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		n, err := PruneBucket(st, "GDAX-BTC-USD", start.Add(3*time.Second))
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if n != 2 {
+			t.Fatalf("%s: pruned %d records, want 2", name, n)
+		}
+
+		c, err := st.Cursor("GDAX-BTC-USD")
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		event, ok := c.First()
+		c.Close()
+		if !ok || !orderbook.IsSyncPacket(event.Data) || !event.Time.Equal(start.Add(time.Second)) {
+			t.Fatalf("%s: first record after pruning is %v %v, want the sync", name, event.Time, event.Data)
+		}
+		st.Close()
+	}
+}
@@ -0,0 +1,278 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+// Rollup buckets hold precomputed per slot stats of a product bucket, keyed by
+// the slot start. A slot of tier n seconds covers (start, start+n] like the
+// timeslots of the graph. The smallest tier is built from the recorded
+// packets, larger tiers are merged from it, so every tier must be a multiple
+// of the smallest one. A band limits the levels of the smallest tier to that
+// fraction around the center price, 0 keeps all levels.
+var DefaultRollupTiers = []int{1, 8, 64}
+
+const rollupBatchSlots = 3600
+
+var errRollupBatchFull = errors.New("rollup batch full")
+
+func RollupBucket(bucket string, tier int) string {
+	return fmt.Sprintf("%s@%ds", bucket, tier)
+}
+
+func IsRollupBucket(name string) bool {
+	return strings.Contains(name, "@")
+}
+
+// ParseRollupBucket returns the product bucket and tier of a rollup bucket name.
+func ParseRollupBucket(name string) (string, int, bool) {
+	i := strings.LastIndex(name, "@")
+	if i == -1 || !strings.HasSuffix(name, "s") {
+		return "", 0, false
+	}
+	tier, err := strconv.Atoi(name[i+1 : len(name)-1])
+	if err != nil || tier <= 0 {
+		return "", 0, false
+	}
+	return name[:i], tier, true
+}
+
+// ParseRollupTiers parses "1,8,64", empty disables rollups.
+func ParseRollupTiers(value string) ([]int, error) {
+	tiers := []int{}
+	if value == "" {
+		return tiers, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		tier, err := strconv.Atoi(strings.TrimSuffix(part, "s"))
+		if err != nil || tier <= 0 {
+			return nil, fmt.Errorf("invalid rollup tier %q", part)
+		}
+		tiers = append(tiers, tier)
+	}
+	sort.Ints(tiers)
+	for _, tier := range tiers {
+		if tier%tiers[0] != 0 {
+			return nil, fmt.Errorf("rollup tier %ds is not a multiple of %ds", tier, tiers[0])
+		}
+	}
+	return tiers, nil
+}
+
+// RollupTiers lists the existing rollup tiers of bucket, smallest first.
+func RollupTiers(st store.Store, bucket string) []int {
+	tiers := []int{}
+	products, _ := st.Products()
+	for _, name := range products {
+		if parent, tier, ok := ParseRollupBucket(name); ok && parent == bucket {
+			tiers = append(tiers, tier)
+		}
+	}
+	sort.Ints(tiers)
+	return tiers
+}
+
+// tierStart rounds t down to a multiple of tier seconds since the epoch.
+func tierStart(t time.Time, tier int) time.Time {
+	step := int64(tier) * int64(time.Second)
+	nano := t.UnixNano()
+	return time.Unix(0, nano-nano%step)
+}
+
+func slotStart(t time.Time, tier int) time.Time {
+	step := int64(tier) * int64(time.Second)
+	nano := t.UnixNano()
+	start := nano - nano%step
+	if start == nano {
+		// slots include their end, a record exactly on a boundary belongs to the previous one
+		start -= step
+	}
+	return time.Unix(0, start)
+}
+
+// RollupEnd returns the end of the last complete slot of a rollup bucket.
+func RollupEnd(st store.Store, bucket string, tier int) time.Time {
+	c, err := st.Cursor(RollupBucket(bucket, tier))
+	if err != nil {
+		return time.Time{}
+	}
+	defer c.Close()
+	event, ok := c.Last()
+	if !ok {
+		return time.Time{}
+	}
+	return event.Time.Add(time.Duration(tier) * time.Second)
+}
+
+type rollupSlot struct {
+	start time.Time
+	stats *orderbook.BookMapStatsCopy
+}
+
+// BuildRollups extends all tiers of bucket up to the last complete slot.
+func BuildRollups(st store.Store, bucket string, tiers []int, band float64) error {
+	if len(tiers) == 0 {
+		return nil
+	}
+	for {
+		n, err := buildBaseRollup(st, bucket, tiers[0], band)
+		if err != nil {
+			return err
+		}
+		if n < rollupBatchSlots {
+			break
+		}
+	}
+	for _, tier := range tiers[1:] {
+		for {
+			n, err := mergeRollup(st, bucket, tiers[0], tier)
+			if err != nil {
+				return err
+			}
+			if n < rollupBatchSlots {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func writeRollups(st store.Store, bucket string, tier int, slots []rollupSlot) error {
+	if len(slots) == 0 {
+		return nil
+	}
+	events := make([]store.Event, 0, len(slots))
+	for _, slot := range slots {
+		events = append(events, store.Event{Time: slot.start, Data: orderbook.PackStats(slot.stats)})
+	}
+	return st.Append(RollupBucket(bucket, tier), events...)
+}
+
+// buildBaseRollup replays the recorded packets after the last rollup slot.
+func buildBaseRollup(st store.Store, bucket string, tier int, band float64) (int, error) {
+	from := RollupEnd(st, bucket, tier)
+	if !from.IsZero() {
+		from = from.Add(time.Nanosecond)
+	}
+
+	book := orderbook.New(bucket)
+	slots := []rollupSlot{}
+	var current time.Time
+
+	// bolt can not write while the replay holds its read transaction, collect first
+	err := ReplayBucket(st, bucket, from, time.Time{}, book, func(t time.Time, pkt *orderbook.Packet, err error) error {
+		start := slotStart(t, tier)
+		if current.IsZero() {
+			current = start
+			book.ResetStats()
+			return nil
+		}
+		if start.Equal(current) {
+			return nil
+		}
+
+		stats := orderbook.BandStats(book.StatsCopy(), book.Mid(), band)
+		slots = append(slots, rollupSlot{start: current, stats: stats})
+		current = start
+		book.ResetStats()
+
+		if len(slots) >= rollupBatchSlots {
+			return errRollupBatchFull
+		}
+		return nil
+	})
+	if err != nil && err != errRollupBatchFull {
+		return 0, err
+	}
+
+	return len(slots), writeRollups(st, bucket, tier, slots)
+}
+
+// mergeRollup builds tier from the complete slots of the base tier.
+func mergeRollup(st store.Store, bucket string, base, tier int) (int, error) {
+	slots := []rollupSlot{}
+
+	c, err := st.Cursor(RollupBucket(bucket, base))
+	if err == store.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	from := RollupEnd(st, bucket, tier)
+	event, ok := c.First()
+	if !from.IsZero() {
+		event, ok = c.Seek(from)
+	}
+
+	var current time.Time
+	var stats *orderbook.BookMapStatsCopy
+	for ; ok; event, ok = c.Next() {
+		// base slot (s, s+base] lies in the tier slot starting at s rounded down
+		start := tierStart(event.Time, tier)
+
+		if !current.IsZero() && !start.Equal(current) {
+			slots = append(slots, rollupSlot{start: current, stats: stats})
+			stats = nil
+			if len(slots) >= rollupBatchSlots {
+				break
+			}
+		}
+		current = start
+
+		slot, err := orderbook.UnpackStats(event.Data)
+		if err != nil {
+			log.Println(RollupBucket(bucket, base), "skip undecodable slot", event.Time, err)
+			continue
+		}
+		stats = orderbook.MergeStats(stats, slot)
+	}
+	c.Close()
+
+	// the last tier slot is only complete once its last base slot exists
+	if !ok && stats != nil {
+		end := current.Add(time.Duration(tier) * time.Second)
+		if !RollupEnd(st, bucket, base).Before(end) {
+			slots = append(slots, rollupSlot{start: current, stats: stats})
+		}
+	}
+
+	return len(slots), writeRollups(st, bucket, tier, slots)
+}
+
+// RunRollups keeps the rollups of all product buckets up to date until ctx is done.
+func RunRollups(ctx context.Context, st store.Store, tiers []int, band float64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		buckets, err := ListBuckets(st)
+		if err != nil {
+			log.Println("Rollup Error", err)
+		}
+		for _, bucket := range buckets {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := BuildRollups(st, bucket, tiers, band); err != nil {
+				log.Println("Rollup Error", bucket, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	exchange "github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/fixed"
+	"github.com/lian/gdax-bookmap/store"
+)
+
+func TestParseRollupTiers(t *testing.T) {
+	cases := []struct {
+		value string
+		tiers []int
+		err   bool
+	}{
+		{"", []int{}, false},
+		{"1,8,64", []int{1, 8, 64}, false},
+		{"64s,1s,8s", []int{1, 8, 64}, false},
+		{"2,6", []int{2, 6}, false},
+		{"2,5", nil, true},
+		{"0", nil, true},
+		{"-1", nil, true},
+		{"1,x", nil, true},
+	}
+	for _, c := range cases {
+		tiers, err := ParseRollupTiers(c.value)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%q: tiers %v, want an error", c.value, tiers)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(tiers, c.tiers) {
+			t.Fatalf("%q: %v %v, want %v", c.value, tiers, err, c.tiers)
+		}
+	}
+
+	for _, name := range []string{"Binance-BTC-USDT@8s", RollupBucket("Binance-BTC-USDT", 8)} {
+		if bucket, tier, ok := ParseRollupBucket(name); !ok || bucket != "Binance-BTC-USDT" || tier != 8 {
+			t.Fatalf("%s: %s %d %v", name, bucket, tier, ok)
+		}
+	}
+	for _, name := range []string{"Binance-BTC-USDT", "Binance-BTC-USDT@8", "Binance-BTC-USDT@0s", "Binance-BTC-USDT@xs"} {
+		if _, _, ok := ParseRollupBucket(name); ok {
+			t.Fatalf("%s is no rollup bucket", name)
+		}
+	}
+}
+
+// rollupRecording appends a sync with levels 50% away from the mid, a trade
+// and diffs at the middle of the seconds after start to st.
+func rollupRecording(t *testing.T, st store.Store, bucket string, start time.Time) {
+	t.Helper()
+	book := exchange.New("BTC-USDT")
+	book.PriceScale, book.SizeScale = 2, 8
+	at := func(n int) time.Time { return start.Add(time.Duration(n)*time.Second + 500*time.Millisecond) }
+	var events []store.Event
+
+	for _, level := range [][2]fixed.Value{{10000, 100000000}, {9900, 200000000}, {5000, 300000000}} {
+		book.UpdateBidLevel(at(0), level[0], level[1])
+	}
+	for _, level := range [][2]fixed.Value{{10100, 100000000}, {10200, 200000000}, {20000, 300000000}} {
+		book.UpdateAskLevel(at(0), level[0], level[1])
+	}
+	events = append(events, store.Event{Time: at(0), Data: exchange.PackSync(book)})
+
+	book.AddTakerTrade(at(1), exchange.TakerSide(true), 10100, 50000000, 1, at(1))
+	events = append(events, store.Event{Time: at(1), Data: exchange.PackTrade(book, book.Trades[len(book.Trades)-1])})
+
+	diff := func(n int, update func()) {
+		book.ResetDiff()
+		update()
+		events = append(events, store.Event{Time: at(n), Data: exchange.PackDiff(book, uint64(n), uint64(n))})
+	}
+	diff(2, func() { book.UpdateBidLevel(at(2), 10000, 400000000) })
+	diff(3, func() { book.UpdateAskLevel(at(3), 10200, 0) })
+	diff(4, func() { book.UpdateBidLevel(at(4), 9800, 100000000) })
+
+	if err := st.Append(bucket, events...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rollupSlots returns the slots of a rollup bucket by their start.
+func rollupSlots(t *testing.T, st store.Store, bucket string, tier int) map[time.Time]*orderbook.BookMapStatsCopy {
+	t.Helper()
+	c, err := st.Cursor(RollupBucket(bucket, tier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	slots := map[time.Time]*orderbook.BookMapStatsCopy{}
+	for event, ok := c.First(); ok; event, ok = c.Next() {
+		stats, err := orderbook.UnpackStats(event.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slots[event.Time] = stats
+	}
+	return slots
+}
+
+func prices(states []orderbook.OrderState) []float64 {
+	list := []float64{}
+	for _, state := range states {
+		list = append(list, state.Price)
+	}
+	return list
+}
+
+func TestBuildRollups(t *testing.T) {
+	bucket := "Binance-BTC-USDT"
+	start := time.Unix(1550671200, 0)
+
+	cases := []struct {
+		name     string
+		band     float64
+		bids     []float64
+		asks     []float64
+		rollups  int
+		lastBids []float64
+	}{
+		{"all levels", 0, []float64{50, 99, 100}, []float64{101, 102, 200}, 4, []float64{50, 99, 100}},
+		{"band", 0.05, []float64{99, 100}, []float64{101, 102}, 4, []float64{99, 100}},
+	}
+	for _, c := range cases {
+		st := store.NewMemory()
+		rollupRecording(t, st, bucket, start)
+		if err := BuildRollups(st, bucket, []int{1, 2}, c.band); err != nil {
+			t.Fatal(err)
+		}
+
+		// the slot of the last packet is not complete yet
+		base := rollupSlots(t, st, bucket, 1)
+		if len(base) != c.rollups {
+			t.Fatalf("%s: %d slots of 1s, want %d", c.name, len(base), c.rollups)
+		}
+		first := base[start]
+		if first == nil {
+			t.Fatalf("%s: no slot at %s", c.name, start)
+		}
+		if bids, asks := prices(first.Bid), prices(first.Ask); !reflect.DeepEqual(bids, c.bids) || !reflect.DeepEqual(asks, c.asks) {
+			t.Fatalf("%s: first slot levels %v %v, want %v %v", c.name, bids, asks, c.bids, c.asks)
+		}
+		if last := base[start.Add(3*time.Second)]; last == nil || !reflect.DeepEqual(prices(last.Bid), c.lastBids) {
+			t.Fatalf("%s: last slot %+v, want bids %v", c.name, last, c.lastBids)
+		}
+
+		trade := base[start.Add(time.Second)]
+		if trade == nil || trade.Flow.BuyVolume != 0.5 || trade.Flow.BuyCount != 1 || trade.Flow.SellCount != 0 {
+			t.Fatalf("%s: trade slot flow %+v", c.name, trade)
+		}
+		if first.Flow.BuyCount != 0 {
+			t.Fatalf("%s: trade counted in the slot before it", c.name)
+		}
+
+		// tier 2 merges two complete base slots each
+		merged := rollupSlots(t, st, bucket, 2)
+		if len(merged) != 2 {
+			t.Fatalf("%s: %d slots of 2s, want 2", c.name, len(merged))
+		}
+		for _, s := range []time.Time{start, start.Add(2 * time.Second)} {
+			want := orderbook.MergeStats(base[s], base[s.Add(time.Second)])
+			if !reflect.DeepEqual(merged[s], want) {
+				t.Fatalf("%s: 2s slot at %s is %+v, want %+v", c.name, s, merged[s], want)
+			}
+		}
+
+		// nothing new until the next packet completes a slot
+		if err := BuildRollups(st, bucket, []int{1, 2}, c.band); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(rollupSlots(t, st, bucket, 1)); n != c.rollups {
+			t.Fatalf("%s: rebuild added slots, %d", c.name, n)
+		}
+		book := exchange.New("BTC-USDT")
+		book.PriceScale, book.SizeScale = 2, 8
+		if err := st.Append(bucket, store.Event{Time: start.Add(5500 * time.Millisecond), Data: exchange.PackDiff(book, 5, 5)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := BuildRollups(st, bucket, []int{1, 2}, c.band); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(rollupSlots(t, st, bucket, 1)); n != c.rollups+1 {
+			t.Fatalf("%s: %d slots after the next packet, want %d", c.name, n, c.rollups+1)
+		}
+		if tiers := RollupTiers(st, bucket); !reflect.DeepEqual(tiers, []int{1, 2}) {
+			t.Fatalf("%s: rollup tiers %v", c.name, tiers)
+		}
+		st.Close()
+	}
+}
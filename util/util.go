@@ -5,10 +5,10 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/store"
 )
 
 func OpenDB(path string, buckets []string, readOnly bool) (*bolt.DB, error) {
@@ -33,6 +33,28 @@ func OpenDB(path string, buckets []string, readOnly bool) (*bolt.DB, error) {
 	return db, nil
 }
 
+// OpenStore opens the storage backend named by backend ("bolt", "segment" or
+// "memory"), path is the bolt file or the segment directory.
+func OpenStore(backend, path string, readOnly bool) (store.Store, error) {
+	switch backend {
+	case "bolt", "":
+		db, err := OpenDB(path, []string{}, readOnly)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.CheckKeys(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return store.NewBolt(db), nil
+	case "segment":
+		return store.OpenSegment(path, readOnly)
+	case "memory":
+		return store.NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown store %q, one of %s", backend, strings.Join(store.Backends, ", "))
+}
+
 func CreateBucketsDB(db *bolt.DB, buckets []string) {
 	db.Update(func(tx *bolt.Tx) error {
 		for _, name := range buckets {
@@ -65,12 +87,3 @@ func osxBundlePath(db_path string) (string, error) {
 
 	return filepath.Join(path, db_path), nil
 }
-
-func NumDecPlaces(v float64) int {
-	s := strconv.FormatFloat(v, 'f', -1, 64)
-	i := strings.IndexByte(s, '.')
-	if i > -1 {
-		return len(s) - i - 1
-	}
-	return 0
-}